@@ -0,0 +1,68 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-haaereum.
+//
+// go-haaereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-haaereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-haaereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/haachain/go-haachain/cmd/evm/internal/debugger"
+	"github.com/haachain/go-haachain/core/vm"
+	"github.com/haachain/go-haachain/log"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+var debugCommand = cli.Command{
+	Action:    debugCmd,
+	Name:      "debug",
+	Usage:     "interactively step through the execution of evm code",
+	ArgsUsage: "<code>",
+	Description: `The debug command runs arbitrary EVM code like the run command, pausing
+on the first instruction with a prompt to step through the execution, set
+breakpoints on program counters, opcodes or call depths, inspect the stack,
+memory, storage and call stack, and step back through the recorded steps.
+Type "help" at the prompt for the list of commands.`,
+}
+
+func debugCmd(ctx *cli.Context) error {
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(ctx.GlobalInt(VerbosityFlag.Name)))
+	log.Root().SetHandler(glogger)
+
+	if ctx.GlobalString(CodeFileFlag.Name) == "-" {
+		return fmt.Errorf("the debugger reads commands from stdin, use --code or --codefile with a file")
+	}
+	runtimeConfig, code, receiver, err := prepareRun(ctx)
+	if err != nil {
+		return err
+	}
+	tracer := debugger.NewDebugger(os.Stdin, os.Stdout)
+	runtimeConfig.EVMConfig = vm.Config{
+		Debug:  true,
+		Tracer: tracer,
+	}
+	ret, _, err := execute(ctx, runtimeConfig, code, receiver)
+
+	// Allow stepping back through the execution once it finished
+	tracer.Inspect()
+
+	fmt.Printf("0x%x\n", ret)
+	if err != nil {
+		fmt.Printf(" error: %v\n", err)
+	}
+	return nil
+}
@@ -0,0 +1,481 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-haaereum.
+//
+// go-haaereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-haaereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-haaereum. If not, see <http://www.gnu.org/licenses/>.
+
+// Package debugger implements an EVM tracer that pauses the execution at every
+// step or breakpoint and lets the user inspect it through a command prompt.
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/vm"
+)
+
+// maxSnapshots is the number of most recent steps recorded, older ones are
+// dropped and can't be stepped back to anymore.
+const maxSnapshots = 100000
+
+// Frame is a single entry of the call stack of the execution.
+type Frame struct {
+	Type string         // Type of the call (CALL, CREATE, DELEGATECALL, ...)
+	From common.Address // Address of the caller
+	To   common.Address // Address of the code being executed
+	Gas  uint64         // Gas available to the frame when entered
+}
+
+// Snapshot is the recorded state of the EVM right before executing a single
+// instruction, used to inspect and step back through the execution.
+type Snapshot struct {
+	Pc       uint64
+	Op       vm.OpCode
+	Gas      uint64
+	Cost     uint64
+	Depth    int
+	Contract common.Address
+	Stack    []*big.Int
+	Memory   []byte                      // Shared with the previous snapshot if unchanged, read only
+	Storage  map[common.Hash]common.Hash // Slots of the contract accessed so far
+	Calls    []Frame
+	Err      error
+}
+
+// breakpoint pauses the execution when a snapshot matches its condition.
+type breakpoint struct {
+	kind  string // Condition type: "pc", "op" or "depth"
+	value uint64 // Program counter, opcode or call depth to break on
+}
+
+// matches returns whhaaer the snapshot triggers the breakpoint.
+func (b breakpoint) matches(snap *Snapshot) bool {
+	switch b.kind {
+	case "pc":
+		return snap.Pc == b.value
+	case "op":
+		return uint64(snap.Op) == b.value
+	case "depth":
+		return uint64(snap.Depth) == b.value
+	}
+	return false
+}
+
+func (b breakpoint) String() string {
+	if b.kind == "op" {
+		return fmt.Sprintf("op %v", vm.OpCode(b.value))
+	}
+	return fmt.Sprintf("%s %d", b.kind, b.value)
+}
+
+// Debugger is a vm.Tracer running a command prompt on every step of the
+// execution it should pause at. Each step is recorded as a snapshot, so the
+// execution already performed can be revisited; stepping forward again first
+// replays the recorded snapshots before resuming the execution. Only the most
+// recent steps are kept to bound the memory used by long executions.
+type Debugger struct {
+	in  *bufio.Scanner
+	out io.Writer
+
+	snapshots   []*Snapshot
+	limit       int                                         // Maximum number of snapshots recorded
+	dropped     int                                         // Number of old snapshots dropped over the limit
+	cursor      int                                         // Index of the snapshot being inspected
+	calls       []Frame                                     // Current call stack of the execution
+	accessed    map[common.Address]map[common.Hash]struct{} // Storage slots accessed per contract
+	breakpoints []breakpoint
+
+	steps    int  // Number of steps to execute before pausing, 0 to run to a breakpoint
+	detached bool // Whhaaer the user stopped interacting with the execution
+	finished bool // Whhaaer the execution already ended
+}
+
+// NewDebugger creates a debugger reading commands from the given input and
+// writing its output into the given writer. It pauses on the first instruction.
+func NewDebugger(in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		in:       bufio.NewScanner(in),
+		out:      out,
+		limit:    maxSnapshots,
+		accessed: make(map[common.Address]map[common.Hash]struct{}),
+		steps:    1,
+	}
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (d *Debugger) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	typ := "CALL"
+	if create {
+		typ = "CREATE"
+	}
+	d.calls = append(d.calls, Frame{Type: typ, From: from, To: to, Gas: gas})
+	return nil
+}
+
+// CaptureState implements the Tracer interface, recording the step and pausing
+// the execution if requested.
+func (d *Debugger) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	// Track the storage slots accessed by the contract
+	addr := contract.Address()
+	if (op == vm.SLOAD || op == vm.SSTORE) && len(stack.Data()) >= 1 {
+		if d.accessed[addr] == nil {
+			d.accessed[addr] = make(map[common.Hash]struct{})
+		}
+		d.accessed[addr][common.BigToHash(stack.Back(0))] = struct{}{}
+	}
+	// Record the snapshot of the current step
+	snap := &Snapshot{
+		Pc:       pc,
+		Op:       op,
+		Gas:      gas,
+		Cost:     cost,
+		Depth:    depth,
+		Contract: addr,
+		Stack:    make([]*big.Int, len(stack.Data())),
+		Storage:  make(map[common.Hash]common.Hash),
+		Calls:    append([]Frame{}, d.calls...),
+		Err:      err,
+	}
+	for i, value := range stack.Data() {
+		snap.Stack[i] = new(big.Int).Set(value)
+	}
+	for slot := range d.accessed[addr] {
+		snap.Storage[slot] = env.StateDB.Gehaaate(addr, slot)
+	}
+	// Most instructions don't touch the memory, only copy it if it changed
+	if n := len(d.snapshots); n > 0 && bytes.Equal(d.snapshots[n-1].Memory, memory.Data()) {
+		snap.Memory = d.snapshots[n-1].Memory
+	} else {
+		snap.Memory = common.CopyBytes(memory.Data())
+	}
+	// Drop the oldest snapshot if the history is full
+	if len(d.snapshots) >= d.limit {
+		d.snapshots[0] = nil
+		d.snapshots = d.snapshots[1:]
+		d.dropped++
+	}
+	d.snapshots = append(d.snapshots, snap)
+	d.cursor = len(d.snapshots) - 1
+
+	if d.detached || !d.shouldPause(snap) {
+		return nil
+	}
+	if !d.prompt() {
+		// The user quit, abort the execution
+		env.Cancel()
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (d *Debugger) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if !d.detached {
+		fmt.Fprintf(d.out, "fault at pc %d (%v): %v\n", pc, op, err)
+	}
+	return nil
+}
+
+// CaptureEnter is called when the EVM enters a new internal call frame.
+func (d *Debugger) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) error {
+	d.calls = append(d.calls, Frame{Type: typ.String(), From: from, To: to, Gas: gas})
+	return nil
+}
+
+// CaptureExit is called when the EVM returns from an internal call frame.
+func (d *Debugger) CaptureExit(output []byte, gasUsed uint64, err error) error {
+	if len(d.calls) > 0 {
+		d.calls = d.calls[:len(d.calls)-1]
+	}
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (d *Debugger) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	if len(d.calls) > 0 {
+		d.calls = d.calls[:len(d.calls)-1]
+	}
+	d.finished = true
+	if !d.detached {
+		fmt.Fprintf(d.out, "execution finished: output 0x%x, gas used %d", output, gasUsed)
+		if err != nil {
+			fmt.Fprintf(d.out, ", error: %v", err)
+		}
+		fmt.Fprintln(d.out)
+	}
+	return nil
+}
+
+// Inspect runs the command prompt on the recorded snapshots once the execution
+// finished, allowing to step back through it.
+func (d *Debugger) Inspect() {
+	if d.detached || len(d.snapshots) == 0 {
+		return
+	}
+	d.prompt()
+}
+
+// Snapshots returns the most recent steps recorded so far.
+func (d *Debugger) Snapshots() []*Snapshot {
+	return d.snapshots
+}
+
+// shouldPause determines whhaaer the execution needs to pause at a new step.
+func (d *Debugger) shouldPause(snap *Snapshot) bool {
+	if d.steps > 0 {
+		d.steps--
+		if d.steps == 0 {
+			return true
+		}
+	}
+	for _, bp := range d.breakpoints {
+		if bp.matches(snap) {
+			fmt.Fprintf(d.out, "breakpoint hit: %v\n", bp)
+			return true
+		}
+	}
+	return false
+}
+
+// prompt reads and executes commands until the execution should resume. It
+// returns false if the user asked to abort the execution.
+func (d *Debugger) prompt() bool {
+	d.printStep()
+	for {
+		fmt.Fprint(d.out, "> ")
+		if !d.in.Scan() {
+			// Input exhausted, let the execution run to completion
+			fmt.Fprintln(d.out)
+			d.detached = true
+			return true
+		}
+		fields := strings.Fields(d.in.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		cmd, args := fields[0], fields[1:]
+
+		switch cmd {
+		case "step", "s":
+			n, err := parseCount(args)
+			if err != nil {
+				fmt.Fprintln(d.out, err)
+				continue
+			}
+			// Replay the recorded steps first, resuming the execution if more are needed
+			for ; n > 0 && d.cursor < len(d.snapshots)-1; n-- {
+				d.cursor++
+			}
+			if n == 0 {
+				d.printStep()
+				continue
+			}
+			if d.finished {
+				fmt.Fprintln(d.out, "execution finished")
+				continue
+			}
+			d.steps = n
+			return true
+
+		case "back", "b":
+			n, err := parseCount(args)
+			if err != nil {
+				fmt.Fprintln(d.out, err)
+				continue
+			}
+			if d.cursor -= n; d.cursor < 0 {
+				d.cursor = 0
+				if d.dropped > 0 {
+					fmt.Fprintf(d.out, "%d older steps not recorded\n", d.dropped)
+				}
+			}
+			d.printStep()
+
+		case "continue", "c":
+			if d.finished {
+				fmt.Fprintln(d.out, "execution finished")
+				continue
+			}
+			d.steps = 0
+			return true
+
+		case "break":
+			if err := d.addBreakpoint(args); err != nil {
+				fmt.Fprintln(d.out, err)
+			}
+
+		case "breakpoints":
+			for i, bp := range d.breakpoints {
+				fmt.Fprintf(d.out, "%d: %v\n", i, bp)
+			}
+
+		case "delete":
+			if len(args) != 1 {
+				fmt.Fprintln(d.out, "usage: delete <index>")
+				continue
+			}
+			i, err := strconv.Atoi(args[0])
+			if err != nil || i < 0 || i >= len(d.breakpoints) {
+				fmt.Fprintf(d.out, "invalid breakpoint index %q\n", args[0])
+				continue
+			}
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+
+		case "info", "i":
+			d.printStep()
+
+		case "stack":
+			d.printStack()
+
+		case "memory", "mem":
+			d.printMemory()
+
+		case "storage":
+			d.printStorage()
+
+		case "calls":
+			d.printCalls()
+
+		case "quit", "q":
+			d.detached = true
+			return false
+
+		case "help", "h":
+			fmt.Fprint(d.out, helpText)
+
+		default:
+			fmt.Fprintf(d.out, "unknown command %q, try \"help\"\n", cmd)
+		}
+	}
+}
+
+const helpText = `Commands:
+  step, s [n]          execute the next n instructions (default 1)
+  back, b [n]          step back n instructions through the recorded snapshots
+  continue, c          run until the next breakpoint or the end of the execution
+  break pc <n>         pause before executing the instruction at program counter n
+  break op <name>      pause before executing the given opcode
+  break depth <n>      pause on every instruction executed at call depth n
+  breakpoints          list the breakpoints set
+  delete <index>       remove a breakpoint
+  info, i              show the current instruction
+  stack                show the stack, top first
+  memory, mem          show the memory
+  storage              show the storage slots of the contract accessed so far
+  calls                show the call stack
+  quit, q              abort the execution
+`
+
+// addBreakpoint parses and registers a breakpoint command.
+func (d *Debugger) addBreakpoint(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: break pc|op|depth <value>")
+	}
+	bp := breakpoint{kind: args[0]}
+	switch args[0] {
+	case "pc", "depth":
+		n, err := strconv.ParseUint(args[1], 0, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", args[0], args[1])
+		}
+		bp.value = n
+	case "op":
+		op := vm.StringToOp(strings.ToUpper(args[1]))
+		if op.String() != strings.ToUpper(args[1]) {
+			return fmt.Errorf("unknown opcode %q", args[1])
+		}
+		bp.value = uint64(op)
+	default:
+		return fmt.Errorf("unknown breakpoint type %q, want pc, op or depth", args[0])
+	}
+	d.breakpoints = append(d.breakpoints, bp)
+	fmt.Fprintf(d.out, "breakpoint %d: %v\n", len(d.breakpoints)-1, bp)
+	return nil
+}
+
+// parseCount parses the optional step count argument of a command.
+func parseCount(args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid count %q", args[0])
+	}
+	return n, nil
+}
+
+// printStep writes a summary line of the snapshot being inspected.
+func (d *Debugger) printStep() {
+	snap := d.snapshots[d.cursor]
+	fmt.Fprintf(d.out, "[%d/%d] %x depth %d pc %05d %-14v gas %d cost %d", d.dropped+d.cursor+1, d.dropped+len(d.snapshots), snap.Contract, snap.Depth, snap.Pc, snap.Op, snap.Gas, snap.Cost)
+	if snap.Err != nil {
+		fmt.Fprintf(d.out, " error: %v", snap.Err)
+	}
+	fmt.Fprintln(d.out)
+}
+
+// printStack writes the stack of the inspected snapshot, top item first.
+func (d *Debugger) printStack() {
+	stack := d.snapshots[d.cursor].Stack
+	if len(stack) == 0 {
+		fmt.Fprintln(d.out, "stack empty")
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		fmt.Fprintf(d.out, "%04d: %x\n", len(stack)-1-i, common.BigToHash(stack[i]))
+	}
+}
+
+// printMemory writes the memory of the inspected snapshot in 32 byte words.
+func (d *Debugger) printMemory() {
+	memory := d.snapshots[d.cursor].Memory
+	if len(memory) == 0 {
+		fmt.Fprintln(d.out, "memory empty")
+	}
+	for i := 0; i+32 <= len(memory); i += 32 {
+		fmt.Fprintf(d.out, "%04x: %x\n", i, memory[i:i+32])
+	}
+}
+
+// printStorage writes the accessed storage slots of the inspected snapshot.
+func (d *Debugger) printStorage() {
+	storage := d.snapshots[d.cursor].Storage
+	if len(storage) == 0 {
+		fmt.Fprintln(d.out, "no storage accessed")
+	}
+	slots := make([]common.Hash, 0, len(storage))
+	for slot := range storage {
+		slots = append(slots, slot)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Big().Cmp(slots[j].Big()) < 0 })
+	for _, slot := range slots {
+		fmt.Fprintf(d.out, "%x: %x\n", slot, storage[slot])
+	}
+}
+
+// printCalls writes the call stack of the inspected snapshot, innermost first.
+func (d *Debugger) printCalls() {
+	calls := d.snapshots[d.cursor].Calls
+	for i := len(calls) - 1; i >= 0; i-- {
+		fmt.Fprintf(d.out, "%d: %-12s %x -> %x gas %d\n", i, calls[i].Type, calls[i].From, calls[i].To, calls[i].Gas)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-haaereum.
+//
+// go-haaereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-haaereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-haaereum. If not, see <http://www.gnu.org/licenses/>.
+
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/state"
+	"github.com/haachain/go-haachain/core/vm"
+	"github.com/haachain/go-haachain/core/vm/runtime"
+	"github.com/haachain/go-haachain/haadb"
+)
+
+// runScript executes the given code with a debugger driven by the commands.
+func runScript(t *testing.T, code string, script ...string) (*Debugger, string, error) {
+	return runScriptWithLimit(t, maxSnapshots, code, script...)
+}
+
+// runScriptWithLimit executes the given code with a debugger driven by the
+// commands, recording at most limit snapshots. The commands left over once the
+// execution finished inspect the recorded snapshots.
+func runScriptWithLimit(t *testing.T, limit int, code string, script ...string) (*Debugger, string, error) {
+	db, _ := haadb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+
+	addr := common.HexToAddress("0x1000")
+	statedb.SetCode(addr, common.Hex2Bytes(code))
+
+	out := new(bytes.Buffer)
+	debugger := NewDebugger(strings.NewReader(strings.Join(script, "\n")+"\n"), out)
+	debugger.limit = limit
+	cfg := &runtime.Config{
+		State:     statedb,
+		GasLimit:  1000000,
+		EVMConfig: vm.Config{Debug: true, Tracer: debugger},
+	}
+	_, _, err := runtime.Call(addr, nil, cfg)
+	debugger.Inspect()
+
+	return debugger, out.String(), err
+}
+
+// Tests that breakpoints pause the execution at the right instructions and the
+// recorded snapshots can be stepped back and forth through.
+func TestBreakpointsAndStepping(t *testing.T) {
+	// PUSH1 1, PUSH1 0, SSTORE, PUSH1 2, PUSH1 0, MSTORE, STOP
+	debugger, out, err := runScript(t, "60016000556002600052"+"00",
+		"break op sstore",
+		"break pc 7",
+		"continue", // Pause on SSTORE
+		"stack",
+		"continue", // Pause on PUSH1 0 at pc 7
+		"storage",
+		"back 2",
+		"info",
+		"step 3", // Replays 2 recorded steps, executes PUSH1 0
+		"step",   // Executes MSTORE
+		"memory",
+		"continue",
+	)
+	if err != nil {
+		t.Fatalf("execution failed: %v\n%s", err, out)
+	}
+	if n := len(debugger.Snapshots()); n != 7 {
+		t.Fatalf("snapshot count mismatch: have %d, want 7\n%s", n, out)
+	}
+	for _, want := range []string{
+		"breakpoint hit: op SSTORE",
+		"breakpoint hit: pc 7",
+		"[3/3] 0000000000000000000000000000000000001000 depth 1 pc 00004 SSTORE",
+		"0000: 0000000000000000000000000000000000000000000000000000000000000000\n0001: 0000000000000000000000000000000000000000000000000000000000000001",
+		"0000000000000000000000000000000000000000000000000000000000000000: 0000000000000000000000000000000000000000000000000000000000000001",
+		"[3/5] 0000000000000000000000000000000000001000 depth 1 pc 00004 SSTORE",
+		"[6/6] 0000000000000000000000000000000000001000 depth 1 pc 00009 MSTORE",
+		"[7/7] 0000000000000000000000000000000000001000 depth 1 pc 00010 STOP",
+		"0000: 0000000000000000000000000000000000000000000000000000000000000002",
+		"execution finished",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+// Tests that quitting the debugger aborts the execution.
+func TestQuit(t *testing.T) {
+	debugger, out, _ := runScript(t, "60016000556002600052"+"00", "step", "quit")
+	if n := len(debugger.Snapshots()); n != 2 {
+		t.Fatalf("snapshot count mismatch: have %d, want 2\n%s", n, out)
+	}
+}
+
+// Tests that only the most recent snapshots are kept and that the memory is
+// only copied into the snapshots of steps modifying it.
+func TestHistoryLimit(t *testing.T) {
+	// PUSH1 1, PUSH1 0, SSTORE, PUSH1 2, PUSH1 0, MSTORE, STOP
+	debugger, out, err := runScriptWithLimit(t, 4, "60016000556002600052"+"00",
+		"continue",
+		"back 10",
+		"memory",
+	)
+	if err != nil {
+		t.Fatalf("execution failed: %v\n%s", err, out)
+	}
+	snaps := debugger.Snapshots()
+	if len(snaps) != 4 {
+		t.Fatalf("snapshot count mismatch: have %d, want 4\n%s", len(snaps), out)
+	}
+	if snaps[0].Pc != 5 {
+		t.Errorf("oldest snapshot pc mismatch: have %d, want 5", snaps[0].Pc)
+	}
+	for _, want := range []string{
+		"3 older steps not recorded",
+		"[4/7] 0000000000000000000000000000000000001000 depth 1 pc 00005 PUSH1",
+		"memory empty",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	// PUSH1 2, PUSH1 0, MSTORE, PUSH1 1, PUSH1 1, POP, STOP
+	debugger, out, err = runScript(t, "6002600052"+"600160015000", "continue")
+	if err != nil {
+		t.Fatalf("execution failed: %v\n%s", err, out)
+	}
+	snaps = debugger.Snapshots()
+	if len(snaps) != 7 {
+		t.Fatalf("snapshot count mismatch: have %d, want 7\n%s", len(snaps), out)
+	}
+	// The steps after the MSTORE leave the memory untouched and must share it
+	if len(snaps[3].Memory) != 32 {
+		t.Fatalf("memory size mismatch: have %d, want 32", len(snaps[3].Memory))
+	}
+	for i := 4; i < len(snaps); i++ {
+		if &snaps[i].Memory[0] != &snaps[3].Memory[0] {
+			t.Errorf("step %d: memory copied although unchanged", i)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-haaereum.
+//
+// go-haaereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-haaereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-haaereum. If not, see <http://www.gnu.org/licenses/>.
+
+// Package gasprofile implements an EVM tracer aggregating the gas spent by an
+// execution per instruction, opcode and call frame.
+package gasprofile
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/vm"
+)
+
+// Stats is the aggregated execution count and gas usage of some code. Gas is
+// the gas spent by the code itself, excluding any gas used by inner calls.
+type Stats struct {
+	Count uint64
+	Gas   uint64
+}
+
+// PCKey identifies a single instruction of a contract.
+type PCKey struct {
+	Contract string // Label of the contract code, see Profiler
+	PC       uint64 // Program counter of the instruction
+}
+
+// frame is a single call frame of the execution being profiled.
+type frame struct {
+	label string // Label of the code executed in the frame
+	stack string // Collapsed call stack up to and including this frame
+
+	startGas uint64 // Gas available to the frame when entered
+
+	pending  bool      // Whhaaer an instruction's gas usage is still to be measured
+	pc       uint64    // Program counter of the pending instruction
+	op       vm.OpCode // Opcode of the pending instruction
+	gas      uint64    // Gas available before the pending instruction
+	childGas uint64    // Gas used by inner calls made by the pending instruction
+}
+
+// Profiler is a vm.Tracer that measures the gas spent by each executed
+// instruction and aggregates it per program counter, per opcode and per
+// contract, as well as per call stack in collapsed format for flamegraphs.
+//
+// Contracts are labelled by their code address, suffixed with " (init)" for
+// contract creation code.
+type Profiler struct {
+	ByPC       map[PCKey]*Stats
+	ByOp       map[vm.OpCode]*Stats
+	ByContract map[string]*Stats
+
+	srcmap *SourceMap        // Optional source map of the outer contract
+	root   string            // Label of the outer contract
+	stacks map[string]uint64 // Gas spent per collapsed call stack
+	frames []*frame          // Current call stack of the execution
+}
+
+// NewProfiler creates a gas profiler. If a source map is given, the gas spent
+// in the outermost contract is attributed to its source lines in the collapsed
+// stacks instead of to opcodes.
+func NewProfiler(srcmap *SourceMap) *Profiler {
+	return &Profiler{
+		ByPC:       make(map[PCKey]*Stats),
+		ByOp:       make(map[vm.OpCode]*Stats),
+		ByContract: make(map[string]*Stats),
+		srcmap:     srcmap,
+		stacks:     make(map[string]uint64),
+	}
+}
+
+// enter pushes a new call frame onto the stack.
+func (p *Profiler) enter(typ string, to common.Address, create bool, gas uint64) {
+	label := to.Hex()
+	if create {
+		label += " (init)"
+	}
+	stack := label
+	if len(p.frames) > 0 {
+		stack = p.frames[len(p.frames)-1].stack + ";" + typ + " " + label
+	} else {
+		p.root = label
+	}
+	p.frames = append(p.frames, &frame{label: label, stack: stack, startGas: gas})
+}
+
+// exit pops the topmost call frame off the stack, accounting the gas used by
+// its last instruction and crediting its total usage to the parent.
+func (p *Profiler) exit(gasUsed uint64) {
+	if len(p.frames) == 0 {
+		return
+	}
+	f := p.frames[len(p.frames)-1]
+	p.frames = p.frames[:len(p.frames)-1]
+
+	if f.pending {
+		left := uint64(0)
+		if gasUsed < f.startGas {
+			left = f.startGas - gasUsed
+		}
+		used := uint64(0)
+		if f.gas > left {
+			used = f.gas - left
+		}
+		p.account(f, used)
+	}
+	if len(p.frames) > 0 {
+		p.frames[len(p.frames)-1].childGas += gasUsed
+	}
+}
+
+// account attributes the gas used by the pending instruction of a frame,
+// excluding the gas used by any inner calls it made.
+func (p *Profiler) account(f *frame, used uint64) {
+	if used > f.childGas {
+		used -= f.childGas
+	} else {
+		used = 0
+	}
+	f.pending, f.childGas = false, 0
+
+	add := func(stats *Stats) {
+		stats.Count++
+		stats.Gas += used
+	}
+	key := PCKey{Contract: f.label, PC: f.pc}
+	if p.ByPC[key] == nil {
+		p.ByPC[key] = new(Stats)
+	}
+	add(p.ByPC[key])
+
+	if p.ByOp[f.op] == nil {
+		p.ByOp[f.op] = new(Stats)
+	}
+	add(p.ByOp[f.op])
+
+	if p.ByContract[f.label] == nil {
+		p.ByContract[f.label] = new(Stats)
+	}
+	add(p.ByContract[f.label])
+
+	leaf := f.op.String()
+	if p.srcmap != nil && f.stack == p.root {
+		if loc, ok := p.srcmap.Lookup(f.pc); ok {
+			leaf = loc
+		}
+	}
+	p.stacks[f.stack+";"+leaf] += used
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (p *Profiler) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	p.enter("", to, create, gas)
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (p *Profiler) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if len(p.frames) == 0 {
+		return nil
+	}
+	// The gas used by the previous instruction is only known now, as it may
+	// have been refunded some gas by an inner call
+	f := p.frames[len(p.frames)-1]
+	if f.pending {
+		used := uint64(0)
+		if f.gas > gas {
+			used = f.gas - gas
+		}
+		p.account(f, used)
+	}
+	f.pending, f.pc, f.op, f.gas = true, pc, op, gas
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (p *Profiler) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnter is called when the EVM enters a new internal call frame.
+func (p *Profiler) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) error {
+	p.enter(typ.String(), to, typ == vm.CREATE, gas)
+	return nil
+}
+
+// CaptureExit is called when the EVM returns from an internal call frame.
+func (p *Profiler) CaptureExit(output []byte, gasUsed uint64, err error) error {
+	p.exit(gasUsed)
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (p *Profiler) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
+	p.exit(gasUsed)
+	return nil
+}
+
+// WriteCollapsed writes the gas spent per call stack in the collapsed format
+// consumed by flamegraph tools: one "frame;frame;leaf gas" line per stack,
+// where the leaves are opcodes or source lines.
+func (p *Profiler) WriteCollapsed(w io.Writer) error {
+	stacks := make([]string, 0, len(p.stacks))
+	for stack, gas := range p.stacks {
+		if gas > 0 {
+			stacks = append(stacks, stack)
+		}
+	}
+	sort.Strings(stacks)
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(w, "%s %d\n", stack, p.stacks[stack]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSummary writes a human readable report of the gas spent per contract,
+// per opcode and by the most expensive instructions.
+func (p *Profiler) WriteSummary(w io.Writer, top int) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "CONTRACT\tCOUNT\tGAS")
+	contracts := make([]string, 0, len(p.ByContract))
+	for contract := range p.ByContract {
+		contracts = append(contracts, contract)
+	}
+	sort.Slice(contracts, func(i, j int) bool {
+		return p.ByContract[contracts[i]].Gas > p.ByContract[contracts[j]].Gas
+	})
+	for _, contract := range contracts {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", contract, p.ByContract[contract].Count, p.ByContract[contract].Gas)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "OPCODE\tCOUNT\tGAS")
+	ops := make([]vm.OpCode, 0, len(p.ByOp))
+	for op := range p.ByOp {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if p.ByOp[ops[i]].Gas != p.ByOp[ops[j]].Gas {
+			return p.ByOp[ops[i]].Gas > p.ByOp[ops[j]].Gas
+		}
+		return ops[i] < ops[j]
+	})
+	for _, op := range ops {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", op, p.ByOp[op].Count, p.ByOp[op].Gas)
+	}
+	fmt.Fprintln(tw)
+
+	fmt.Fprintln(tw, "CONTRACT\tPC\tSOURCE\tCOUNT\tGAS")
+	pcs := make([]PCKey, 0, len(p.ByPC))
+	for key := range p.ByPC {
+		pcs = append(pcs, key)
+	}
+	sort.Slice(pcs, func(i, j int) bool {
+		if p.ByPC[pcs[i]].Gas != p.ByPC[pcs[j]].Gas {
+			return p.ByPC[pcs[i]].Gas > p.ByPC[pcs[j]].Gas
+		}
+		if pcs[i].Contract != pcs[j].Contract {
+			return pcs[i].Contract < pcs[j].Contract
+		}
+		return pcs[i].PC < pcs[j].PC
+	})
+	if top > 0 && len(pcs) > top {
+		pcs = pcs[:top]
+	}
+	for _, key := range pcs {
+		source := "-"
+		if p.srcmap != nil && key.Contract == p.root {
+			if loc, ok := p.srcmap.Lookup(key.PC); ok {
+				source = loc
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%d\n", key.Contract, key.PC, source, p.ByPC[key].Count, p.ByPC[key].Gas)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-haaereum.
+//
+// go-haaereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-haaereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-haaereum. If not, see <http://www.gnu.org/licenses/>.
+
+package gasprofile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/state"
+	"github.com/haachain/go-haachain/core/vm"
+	"github.com/haachain/go-haachain/core/vm/runtime"
+	"github.com/haachain/go-haachain/haadb"
+)
+
+// Tests that the gas spent by a contract and the one it calls into is
+// attributed to the correct instructions, opcodes and call frames.
+func TestProfiler(t *testing.T) {
+	var (
+		outer = common.HexToAddress("0x1000")
+		inner = common.HexToAddress("0x2000")
+	)
+	db, _ := haadb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+
+	// The outer contract calls into the inner one, which stores a single slot
+	statedb.SetCode(outer, common.Hex2Bytes("60006000600060006000"+"73"+common.Bytes2Hex(inner.Bytes())+"61fffff100"))
+	statedb.SetCode(inner, common.Hex2Bytes("600160005500"))
+
+	profiler := NewProfiler(nil)
+	cfg := &runtime.Config{
+		State:     statedb,
+		GasLimit:  1000000,
+		EVMConfig: vm.Config{Debug: true, Tracer: profiler},
+	}
+	_, left, err := runtime.Call(outer, nil, cfg)
+	if err != nil {
+		t.Fatalf("failed to execute call: %v", err)
+	}
+	used := cfg.GasLimit - left
+
+	if stats := profiler.ByOp[vm.SSTORE]; stats == nil || stats.Count != 1 || stats.Gas != 20000 {
+		t.Errorf("SSTORE stats mismatch: have %+v, want {Count:1 Gas:20000}", stats)
+	}
+	if stats := profiler.ByContract[inner.Hex()]; stats == nil || stats.Count != 4 || stats.Gas != 20006 {
+		t.Errorf("inner contract stats mismatch: have %+v, want {Count:4 Gas:20006}", stats)
+	}
+	if stats := profiler.ByPC[PCKey{Contract: inner.Hex(), PC: 4}]; stats == nil || stats.Gas != 20000 {
+		t.Errorf("inner contract SSTORE stats mismatch: have %+v, want {Count:1 Gas:20000}", stats)
+	}
+	var total uint64
+	for _, stats := range profiler.ByContract {
+		total += stats.Gas
+	}
+	if total != used {
+		t.Errorf("total gas mismatch: have %d, want %d", total, used)
+	}
+	// The call frames should show up in the collapsed stacks
+	buf := new(bytes.Buffer)
+	if err := profiler.WriteCollapsed(buf); err != nil {
+		t.Fatalf("failed to write collapsed stacks: %v", err)
+	}
+	if want := outer.Hex() + ";CALL " + inner.Hex() + ";SSTORE 20000\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("collapsed stacks missing %q:\n%s", want, buf.String())
+	}
+}
+
+// Tests that solc source maps are decompressed and resolved to source lines.
+func TestSourceMap(t *testing.T) {
+	code := common.Hex2Bytes("600160005500") // PUSH1 1, PUSH1 0, SSTORE, STOP
+	source := []byte("contract C {\nuint x = 1;\n}\n")
+
+	srcmap, err := NewSourceMap("0:27:0;13:10;;-1:-1:-1", code, "C.sol", source)
+	if err != nil {
+		t.Fatalf("failed to parse source map: %v", err)
+	}
+	tests := []struct {
+		pc  uint64
+		loc string
+		ok  bool
+	}{
+		{0, "C.sol:1", true},
+		{2, "C.sol:2", true},
+		{4, "C.sol:2", true},
+		{5, "", false},
+		{1, "", false},
+	}
+	for _, tt := range tests {
+		loc, ok := srcmap.Lookup(tt.pc)
+		if loc != tt.loc || ok != tt.ok {
+			t.Errorf("pc %d: location mismatch: have %q/%v, want %q/%v", tt.pc, loc, ok, tt.loc, tt.ok)
+		}
+	}
+}
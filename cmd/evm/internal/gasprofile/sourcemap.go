@@ -0,0 +1,109 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-haaereum.
+//
+// go-haaereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-haaereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-haaereum. If not, see <http://www.gnu.org/licenses/>.
+
+package gasprofile
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/haachain/go-haachain/core/asm"
+)
+
+// srcRange is a single decompressed entry of a solc source map.
+type srcRange struct {
+	start  int // Byte offset of the range in the source file
+	length int // Length of the range in bytes
+	file   int // Index of the source file, -1 for compiler generated code
+}
+
+// SourceMap maps the program counters of a contract's code to the lines of
+// its Solidity source, using the source map emitted by solc. Only ranges in
+// the file with index 0 can be resolved to lines, the rest are reported by
+// their byte offsets.
+type SourceMap struct {
+	name   string              // Name of the source file to report lines with
+	lines  []int               // Byte offsets of the line starts in the source file
+	ranges map[uint64]srcRange // Source ranges indexed by program counter
+}
+
+// NewSourceMap decompresses a solc source map (the "s:l:f:j;..." format) and
+// binds its entries to the instructions of the given code. The source may be
+// nil, in which case ranges are reported by their byte offsets.
+func NewSourceMap(srcmap string, code []byte, name string, source []byte) (*SourceMap, error) {
+	// Decompress the source map, missing fields are inherited from the
+	// previous entry
+	var (
+		entries []srcRange
+		prev    srcRange
+	)
+	for i, entry := range strings.Split(strings.TrimSpace(srcmap), ";") {
+		cur := prev
+		for j, field := range strings.Split(entry, ":") {
+			if field == "" || j > 2 {
+				continue
+			}
+			n, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid source map entry %d: %v", i, err)
+			}
+			switch j {
+			case 0:
+				cur.start = n
+			case 1:
+				cur.length = n
+			case 2:
+				cur.file = n
+			}
+		}
+		entries = append(entries, cur)
+		prev = cur
+	}
+	// Entries are per instruction, map them to the program counters
+	ranges := make(map[uint64]srcRange)
+	for i, it := 0, asm.NewInstructionIterator(code); it.Next() && i < len(entries); i++ {
+		ranges[it.PC()] = entries[i]
+	}
+	// Index the line starts of the source to resolve offsets
+	lines := []int{0}
+	for i, b := range source {
+		if b == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	if source == nil || len(bytes.TrimSpace(source)) == 0 {
+		lines = nil
+	}
+	return &SourceMap{name: name, lines: lines, ranges: ranges}, nil
+}
+
+// Lookup returns the source location of the instruction at the given program
+// counter, or false if it is not covered by the source map or was generated
+// by the compiler.
+func (m *SourceMap) Lookup(pc uint64) (string, bool) {
+	r, ok := m.ranges[pc]
+	if !ok || r.file < 0 {
+		return "", false
+	}
+	if r.file != 0 || m.lines == nil {
+		return fmt.Sprintf("#%d:%d", r.file, r.start), true
+	}
+	line := sort.Search(len(m.lines), func(i int) bool { return m.lines[i] > r.start })
+	return fmt.Sprintf("%s:%d", m.name, line), true
+}
@@ -110,6 +110,18 @@ var (
 		Name:  "nostack",
 		Usage: "disable stack output",
 	}
+	GasProfileFlag = cli.StringFlag{
+		Name:  "gasprofile",
+		Usage: "creates a collapsed stack gas profile at the given path and prints a gas summary",
+	}
+	SourceMapFlag = cli.StringFlag{
+		Name:  "srcmap",
+		Usage: "File containing the solc source map of the code, for the gas profile",
+	}
+	SourceFlag = cli.StringFlag{
+		Name:  "source",
+		Usage: "Solidity source file the source map refers to",
+	}
 )
 
 func init() {
@@ -133,12 +145,17 @@ func init() {
 		ReceiverFlag,
 		DisableMemoryFlag,
 		DisableStackFlag,
+		GasProfileFlag,
+		SourceMapFlag,
+		SourceFlag,
 	}
 	app.Commands = []cli.Command{
 		compileCommand,
+		debugCommand,
 		disasmCommand,
 		runCommand,
 		stateTestCommand,
+		transitionCommand,
 	}
 }
 
@@ -28,6 +28,7 @@ import (
 	goruntime "runtime"
 
 	"github.com/haachain/go-haachain/cmd/evm/internal/compiler"
+	"github.com/haachain/go-haachain/cmd/evm/internal/gasprofile"
 	"github.com/haachain/go-haachain/cmd/utils"
 	"github.com/haachain/go-haachain/common"
 	"github.com/haachain/go-haachain/core"
@@ -69,31 +70,16 @@ func readGenesis(genesisPath string) *core.Genesis {
 	return genesis
 }
 
-func runCmd(ctx *cli.Context) error {
-	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
-	glogger.Verbosity(log.Lvl(ctx.GlobalInt(VerbosityFlag.Name)))
-	log.Root().SetHandler(glogger)
-	logconfig := &vm.LogConfig{
-		DisableMemory: ctx.GlobalBool(DisableMemoryFlag.Name),
-		DisableStack:  ctx.GlobalBool(DisableStackFlag.Name),
-	}
-
+// prepareRun assembles the state, the code to execute and the runtime
+// configuration requested by the command line flags. Tracing is left to the
+// caller to configure.
+func prepareRun(ctx *cli.Context) (*runtime.Config, []byte, common.Address, error) {
 	var (
-		tracer      vm.Tracer
-		debugLogger *vm.StructLogger
 		statedb     *state.StateDB
 		chainConfig *params.ChainConfig
 		sender      = common.StringToAddress("sender")
 		receiver    = common.StringToAddress("receiver")
 	)
-	if ctx.GlobalBool(MachineFlag.Name) {
-		tracer = NewJSONLogger(logconfig, os.Stdout)
-	} else if ctx.GlobalBool(DebugFlag.Name) {
-		debugLogger = vm.NewStructLogger(logconfig)
-		tracer = debugLogger
-	} else {
-		debugLogger = vm.NewStructLogger(logconfig)
-	}
 	if ctx.GlobalString(GenesisFlag.Name) != "" {
 		gen := readGenesis(ctx.GlobalString(GenesisFlag.Name))
 		db, _ := haadb.NewMemDatabase()
@@ -113,11 +99,7 @@ func runCmd(ctx *cli.Context) error {
 		receiver = common.HexToAddress(ctx.GlobalString(ReceiverFlag.Name))
 	}
 
-	var (
-		code []byte
-		ret  []byte
-		err  error
-	)
+	var code []byte
 	// The '--code' or '--codefile' flag overrides code in state
 	if ctx.GlobalString(CodeFileFlag.Name) != "" {
 		var hexcode []byte
@@ -144,26 +126,98 @@ func runCmd(ctx *cli.Context) error {
 		// EASM-file to compile
 		src, err := ioutil.ReadFile(fn)
 		if err != nil {
-			return err
+			return nil, nil, common.Address{}, err
 		}
 		bin, err := compiler.Compile(fn, src, false)
 		if err != nil {
-			return err
+			return nil, nil, common.Address{}, err
 		}
 		code = common.Hex2Bytes(bin)
 	}
-
-	initialGas := ctx.GlobalUint64(GasFlag.Name)
-	runtimeConfig := runtime.Config{
+	runtimeConfig := &runtime.Config{
 		Origin:   sender,
 		State:    statedb,
-		GasLimit: initialGas,
+		GasLimit: ctx.GlobalUint64(GasFlag.Name),
 		GasPrice: utils.GlobalBig(ctx, PriceFlag.Name),
 		Value:    utils.GlobalBig(ctx, ValueFlag.Name),
-		EVMConfig: vm.Config{
-			Tracer: tracer,
-			Debug:  ctx.GlobalBool(DebugFlag.Name) || ctx.GlobalBool(MachineFlag.Name),
-		},
+	}
+	if chainConfig != nil {
+		runtimeConfig.ChainConfig = chainConfig
+	}
+	return runtimeConfig, code, receiver, nil
+}
+
+// execute runs the code prepared by prepareRun, either as contract creation
+// or by calling into the receiver.
+func execute(ctx *cli.Context, runtimeConfig *runtime.Config, code []byte, receiver common.Address) ([]byte, uint64, error) {
+	if ctx.GlobalBool(CreateFlag.Name) {
+		input := append(code, common.Hex2Bytes(ctx.GlobalString(InputFlag.Name))...)
+		ret, _, leftOverGas, err := runtime.Create(input, runtimeConfig)
+		return ret, leftOverGas, err
+	}
+	if len(code) > 0 {
+		runtimeConfig.State.SetCode(receiver, code)
+	}
+	return runtime.Call(receiver, common.Hex2Bytes(ctx.GlobalString(InputFlag.Name)), runtimeConfig)
+}
+
+func runCmd(ctx *cli.Context) error {
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(ctx.GlobalInt(VerbosityFlag.Name)))
+	log.Root().SetHandler(glogger)
+	logconfig := &vm.LogConfig{
+		DisableMemory: ctx.GlobalBool(DisableMemoryFlag.Name),
+		DisableStack:  ctx.GlobalBool(DisableStackFlag.Name),
+	}
+
+	var (
+		tracer      vm.Tracer
+		debugLogger *vm.StructLogger
+		profiler    *gasprofile.Profiler
+	)
+	if ctx.GlobalBool(MachineFlag.Name) {
+		tracer = vm.NewJSONLogger(logconfig, os.Stdout)
+	} else if ctx.GlobalBool(DebugFlag.Name) {
+		debugLogger = vm.NewStructLogger(logconfig)
+		tracer = debugLogger
+	} else {
+		debugLogger = vm.NewStructLogger(logconfig)
+	}
+	runtimeConfig, code, receiver, err := prepareRun(ctx)
+	if err != nil {
+		return err
+	}
+	statedb := runtimeConfig.State
+
+	// The gas profiler replaces any other tracer, as the source map needs the code
+	if ctx.GlobalString(GasProfileFlag.Name) != "" {
+		var srcmap *gasprofile.SourceMap
+		if path := ctx.GlobalString(SourceMapFlag.Name); path != "" {
+			blob, err := ioutil.ReadFile(path)
+			if err != nil {
+				utils.Fatalf("Could not load source map: %v", err)
+			}
+			var source []byte
+			if path := ctx.GlobalString(SourceFlag.Name); path != "" {
+				if source, err = ioutil.ReadFile(path); err != nil {
+					utils.Fatalf("Could not load source: %v", err)
+				}
+			}
+			mapped := code
+			if len(mapped) == 0 && !ctx.GlobalBool(CreateFlag.Name) {
+				mapped = statedb.GetCode(receiver)
+			}
+			if srcmap, err = gasprofile.NewSourceMap(string(blob), mapped, ctx.GlobalString(SourceFlag.Name), source); err != nil {
+				utils.Fatalf("Invalid source map: %v", err)
+			}
+		}
+		profiler = gasprofile.NewProfiler(srcmap)
+		tracer = profiler
+	}
+	initialGas := runtimeConfig.GasLimit
+	runtimeConfig.EVMConfig = vm.Config{
+		Tracer: tracer,
+		Debug:  ctx.GlobalBool(DebugFlag.Name) || ctx.GlobalBool(MachineFlag.Name) || profiler != nil,
 	}
 
 	if cpuProfilePath := ctx.GlobalString(CPUProfileFlag.Name); cpuProfilePath != "" {
@@ -179,20 +233,8 @@ func runCmd(ctx *cli.Context) error {
 		defer pprof.StopCPUProfile()
 	}
 
-	if chainConfig != nil {
-		runtimeConfig.ChainConfig = chainConfig
-	}
 	haaart := time.Now()
-	var leftOverGas uint64
-	if ctx.GlobalBool(CreateFlag.Name) {
-		input := append(code, common.Hex2Bytes(ctx.GlobalString(InputFlag.Name))...)
-		ret, _, leftOverGas, err = runtime.Create(input, &runtimeConfig)
-	} else {
-		if len(code) > 0 {
-			statedb.SetCode(receiver, code)
-		}
-		ret, leftOverGas, err = runtime.Call(receiver, common.Hex2Bytes(ctx.GlobalString(InputFlag.Name)), &runtimeConfig)
-	}
+	ret, leftOverGas, err := execute(ctx, runtimeConfig, code, receiver)
 	execTime := time.Since(haaart)
 
 	if ctx.GlobalBool(DumpFlag.Name) {
@@ -234,9 +276,22 @@ Gas used:           %d
 
 `, execTime, mem.HeapObjects, mem.Alloc, mem.TotalAlloc, mem.NumGC, initialGas-leftOverGas)
 	}
-	if tracer != nil {
-		tracer.CaptureEnd(ret, initialGas-leftOverGas, execTime, err)
-	} else {
+	if profiler != nil {
+		f, err := os.Create(ctx.GlobalString(GasProfileFlag.Name))
+		if err != nil {
+			utils.Fatalf("Could not create gas profile: %v", err)
+		}
+		if err := profiler.WriteCollapsed(f); err != nil {
+			utils.Fatalf("Could not write gas profile: %v", err)
+		}
+		f.Close()
+
+		fmt.Fprintln(os.Stderr, "#### GAS PROFILE ####")
+		profiler.WriteSummary(os.Stderr, 20)
+	}
+	// The tracers already captured the end of the execution, including the
+	// summary line of the JSON logger
+	if tracer == nil || profiler != nil {
 		fmt.Printf("0x%x\n", ret)
 		if err != nil {
 			fmt.Printf(" error: %v\n", err)
@@ -0,0 +1,337 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-haaereum.
+//
+// go-haaereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-haaereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-haaereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/common/math"
+	"github.com/haachain/go-haachain/core"
+	"github.com/haachain/go-haachain/core/state"
+	"github.com/haachain/go-haachain/core/types"
+	"github.com/haachain/go-haachain/core/vm"
+	"github.com/haachain/go-haachain/crypto"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/log"
+	"github.com/haachain/go-haachain/params"
+	"github.com/haachain/go-haachain/rlp"
+	"github.com/haachain/go-haachain/tests"
+
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+var (
+	InputAllocFlag = cli.StringFlag{
+		Name:  "input.alloc",
+		Usage: "`stdin` or file name of where to find the prestate alloc to use",
+		Value: "alloc.json",
+	}
+	InputEnvFlag = cli.StringFlag{
+		Name:  "input.env",
+		Usage: "`stdin` or file name of where to find the prestate env to use",
+		Value: "env.json",
+	}
+	InputTxsFlag = cli.StringFlag{
+		Name:  "input.txs",
+		Usage: "`stdin` or file name of where to find the transactions to apply",
+		Value: "txs.json",
+	}
+	OutputAllocFlag = cli.StringFlag{
+		Name:  "output.alloc",
+		Usage: "Determines where to put the post-state alloc (`stdout`, `stderr` or file name)",
+		Value: "alloc.json",
+	}
+	OutputResultFlag = cli.StringFlag{
+		Name:  "output.result",
+		Usage: "Determines where to put the execution result (`stdout`, `stderr` or file name)",
+		Value: "result.json",
+	}
+	ForkFlag = cli.StringFlag{
+		Name:  "state.fork",
+		Usage: "Name of the ruleset to use",
+		Value: "Byzantium",
+	}
+)
+
+var transitionCommand = cli.Command{
+	Action:    transitionCmd,
+	Name:      "transition",
+	Aliases:   []string{"t8n"},
+	Usage:     "executes a full state transition",
+	ArgsUsage: " ",
+	Description: `The transition command applies a list of transactions on top of a prestate
+alloc, in the block environment given, and outputs the post-state alloc along
+with the receipts, the state root and the transactions that were rejected.
+Inputs may be read from stdin as a single {"alloc", "env", "txs"} object.
+No block or uncle rewards are applied.`,
+	Flags: []cli.Flag{
+		InputAllocFlag,
+		InputEnvFlag,
+		InputTxsFlag,
+		OutputAllocFlag,
+		OutputResultFlag,
+		ForkFlag,
+	},
+}
+
+// stEnv is the block environment the transactions are applied in.
+type stEnv struct {
+	Coinbase    common.Address                      `json:"currentCoinbase"`
+	Difficulty  *math.HexOrDecimal256               `json:"currentDifficulty"`
+	GasLimit    math.HexOrDecimal64                 `json:"currentGasLimit"`
+	Number      math.HexOrDecimal64                 `json:"currentNumber"`
+	Timestamp   math.HexOrDecimal64                 `json:"currentTimestamp"`
+	BlockHashes map[math.HexOrDecimal64]common.Hash `json:"blockHashes,omitempty"`
+}
+
+// transitionInput is the combined input of the transition when read from stdin.
+type transitionInput struct {
+	Alloc core.GenesisAlloc    `json:"alloc"`
+	Env   *stEnv               `json:"env"`
+	Txs   []*types.Transaction `json:"txs"`
+}
+
+// rejectedTx is a transaction that could not be applied to the state.
+type rejectedTx struct {
+	Index int    `json:"index"`
+	Err   string `json:"error"`
+}
+
+// transitionResult is the outcome of a state transition.
+type transitionResult struct {
+	StateRoot   common.Hash         `json:"stateRoot"`
+	TxRoot      common.Hash         `json:"txRoot"`
+	ReceiptRoot common.Hash         `json:"receiptRoot"`
+	LogsHash    common.Hash         `json:"logsHash"`
+	Bloom       types.Bloom         `json:"logsBloom"`
+	Receipts    types.Receipts      `json:"receipts"`
+	Rejected    []*rejectedTx       `json:"rejected,omitempty"`
+	GasUsed     math.HexOrDecimal64 `json:"gasUsed"`
+}
+
+func transitionCmd(ctx *cli.Context) error {
+	// Configure the go-haaereum logger
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(false)))
+	glogger.Verbosity(log.Lvl(ctx.GlobalInt(VerbosityFlag.Name)))
+	log.Root().SetHandler(glogger)
+
+	config, ok := tests.Forks[ctx.String(ForkFlag.Name)]
+	if !ok {
+		forks := make([]string, 0, len(tests.Forks))
+		for fork := range tests.Forks {
+			forks = append(forks, fork)
+		}
+		sort.Strings(forks)
+		return fmt.Errorf("unsupported fork %q, available: %s", ctx.String(ForkFlag.Name), strings.Join(forks, ", "))
+	}
+	input, err := readTransitionInput(ctx)
+	if err != nil {
+		return err
+	}
+	if input.Env == nil || input.Env.Difficulty == nil {
+		return fmt.Errorf("incomplete env: currentDifficulty required")
+	}
+	db, _ := haadb.NewMemDatabase()
+	statedb := tests.MakePreState(db, input.Alloc)
+
+	result, err := applyTransition(config, statedb, input.Env, input.Txs)
+	if err != nil {
+		return err
+	}
+	if err := writeTransitionOutput(ctx.String(OutputAllocFlag.Name), dumpAlloc(statedb)); err != nil {
+		return err
+	}
+	return writeTransitionOutput(ctx.String(OutputResultFlag.Name), result)
+}
+
+// readTransitionInput loads the alloc, env and txs from the files specified,
+// or from a single combined object on stdin.
+func readTransitionInput(ctx *cli.Context) (*transitionInput, error) {
+	return decodeTransitionInput(os.Stdin, ctx.String(InputAllocFlag.Name), ctx.String(InputEnvFlag.Name), ctx.String(InputTxsFlag.Name))
+}
+
+// decodeTransitionInput assembles the transition input out of the given sources,
+// each of which is either a file name or "stdin", decoding them all the same way.
+// Fields sourced from stdin are picked from a single combined object.
+func decodeTransitionInput(stdin io.Reader, allocPath, envPath, txsPath string) (*transitionInput, error) {
+	var (
+		input    = new(transitionInput)
+		combined *transitionInput
+	)
+	for _, path := range []string{allocPath, envPath, txsPath} {
+		if path == "stdin" {
+			combined = new(transitionInput)
+			if err := decodeJSON(stdin, combined); err != nil {
+				return nil, fmt.Errorf("failed unmarshaling stdin: %v", err)
+			}
+			break
+		}
+	}
+	sources := []struct {
+		path   string
+		target interface{}
+		pick   func()
+	}{
+		{allocPath, &input.Alloc, func() { input.Alloc = combined.Alloc }},
+		{envPath, &input.Env, func() { input.Env = combined.Env }},
+		{txsPath, &input.Txs, func() { input.Txs = combined.Txs }},
+	}
+	for _, source := range sources {
+		if source.path == "stdin" {
+			source.pick()
+			continue
+		}
+		file, err := os.Open(source.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading %s: %v", source.path, err)
+		}
+		err = decodeJSON(file, source.target)
+		file.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed unmarshaling %s: %v", source.path, err)
+		}
+	}
+	return input, nil
+}
+
+// decodeJSON decodes a single JSON value from the given source.
+func decodeJSON(r io.Reader, target interface{}) error {
+	return json.NewDecoder(r).Decode(target)
+}
+
+// applyTransition applies the transactions on top of the state in the given
+// environment, rejecting the ones that cannot be applied.
+func applyTransition(config *params.ChainConfig, statedb *state.StateDB, env *stEnv, txs []*types.Transaction) (*transitionResult, error) {
+	var (
+		header = &types.Header{
+			Coinbase:   env.Coinbase,
+			Difficulty: (*big.Int)(env.Difficulty),
+			GasLimit:   uint64(env.GasLimit),
+			Number:     new(big.Int).SetUint64(uint64(env.Number)),
+			Time:       new(big.Int).SetUint64(uint64(env.Timestamp)),
+		}
+		signer   = types.MakeSigner(config, header.Number)
+		gp       = new(core.GasPool).AddGas(header.GasLimit)
+		gasUsed  uint64
+		included types.Transactions
+		receipts = types.Receipts{}
+		allLogs  []*types.Log
+		rejected []*rejectedTx
+	)
+	getHash := func(n uint64) common.Hash {
+		return env.BlockHashes[math.HexOrDecimal64(n)]
+	}
+	for i, tx := range txs {
+		msg, err := tx.AsMessage(signer)
+		if err != nil {
+			log.Info("Rejected transaction", "index", i, "hash", tx.Hash(), "err", err)
+			rejected = append(rejected, &rejectedTx{i, err.Error()})
+			continue
+		}
+		vmctx := core.NewEVMContext(msg, header, nil, &env.Coinbase)
+		vmctx.GetHash = getHash
+		evm := vm.NewEVM(vmctx, statedb, config, vm.Config{})
+
+		var (
+			snapshot = statedb.Snapshot()
+			gas      = gp.Gas()
+		)
+		statedb.Prepare(tx.Hash(), common.Hash{}, len(included))
+		receipt, _, err := core.ApplyTransactionWithEVM(msg, config, gp, statedb, header, tx, &gasUsed, evm)
+		if err != nil {
+			statedb.RevertToSnapshot(snapshot)
+			gp = new(core.GasPool).AddGas(gas)
+
+			log.Info("Rejected transaction", "index", i, "hash", tx.Hash(), "from", msg.From(), "err", err)
+			rejected = append(rejected, &rejectedTx{i, err.Error()})
+			continue
+		}
+		included = append(included, tx)
+		receipts = append(receipts, receipt)
+		allLogs = append(allLogs, receipt.Logs...)
+	}
+	root, err := statedb.Commit(config.IsEIP158(header.Number))
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit state: %v", err)
+	}
+	logs, err := rlp.EncodeToBytes(allLogs)
+	if err != nil {
+		return nil, err
+	}
+	return &transitionResult{
+		StateRoot:   root,
+		TxRoot:      types.DeriveSha(included),
+		ReceiptRoot: types.DeriveSha(receipts),
+		LogsHash:    crypto.Keccak256Hash(logs),
+		Bloom:       types.CreateBloom(receipts),
+		Receipts:    receipts,
+		Rejected:    rejected,
+		GasUsed:     math.HexOrDecimal64(gasUsed),
+	}, nil
+}
+
+// dumpAlloc converts the committed state into a genesis alloc.
+func dumpAlloc(statedb *state.StateDB) core.GenesisAlloc {
+	alloc := make(core.GenesisAlloc)
+	for addr, account := range statedb.RawDump().Accounts {
+		balance, _ := new(big.Int).SetString(account.Balance, 10)
+		genesis := core.GenesisAccount{
+			Balance: balance,
+			Nonce:   account.Nonce,
+			Code:    common.FromHex(account.Code),
+		}
+		if len(account.Storage) > 0 {
+			genesis.Storage = make(map[common.Hash]common.Hash)
+			for key, val := range account.Storage {
+				var value []byte
+				if err := rlp.DecodeBytes(common.FromHex(val), &value); err != nil {
+					log.Error("Failed to decode storage slot", "address", addr, "key", key, "err", err)
+					continue
+				}
+				genesis.Storage[common.HexToHash(key)] = common.BytesToHash(value)
+			}
+		}
+		alloc[common.HexToAddress(addr)] = genesis
+	}
+	return alloc
+}
+
+// writeTransitionOutput writes the JSON encoded output to the given location.
+func writeTransitionOutput(location string, output interface{}) error {
+	blob, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return err
+	}
+	switch location {
+	case "stdout":
+		_, err = fmt.Fprintln(os.Stdout, string(blob))
+	case "stderr":
+		_, err = fmt.Fprintln(os.Stderr, string(blob))
+	default:
+		err = ioutil.WriteFile(location, blob, 0644)
+	}
+	return err
+}
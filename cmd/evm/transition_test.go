@@ -0,0 +1,217 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-haaereum.
+//
+// go-haaereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-haaereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-haaereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/common/math"
+	"github.com/haachain/go-haachain/core"
+	"github.com/haachain/go-haachain/core/types"
+	"github.com/haachain/go-haachain/crypto"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/params"
+	"github.com/haachain/go-haachain/tests"
+)
+
+var (
+	transitionKey, _    = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	transitionSender    = crypto.PubkeyToAddress(transitionKey.PublicKey)
+	transitionCoinbase  = common.HexToAddress("0xc0")
+	transitionRecipient = common.HexToAddress("0xb0")
+)
+
+// newTransitionInput creates a transition input with a funded sender, applying
+// a valid transfer, a transfer with a too low nonce, one exceeding the gas left
+// in the block and a final valid one.
+func newTransitionInput(t *testing.T) *transitionInput {
+	signer := types.NewEIP155Signer(tests.Forks["Byzantium"].ChainId)
+
+	var txs []*types.Transaction
+	for _, tx := range []*types.Transaction{
+		types.NewTransaction(1, transitionRecipient, big.NewInt(1000), 21000, big.NewInt(1), nil),
+		types.NewTransaction(0, transitionRecipient, big.NewInt(1000), 21000, big.NewInt(1), nil),
+		types.NewTransaction(2, transitionRecipient, big.NewInt(1000), 40000, big.NewInt(1), nil),
+		types.NewTransaction(2, transitionRecipient, big.NewInt(1000), 21000, big.NewInt(1), nil),
+	} {
+		signed, err := types.SignTx(tx, signer, transitionKey)
+		if err != nil {
+			t.Fatalf("failed to sign transaction: %v", err)
+		}
+		txs = append(txs, signed)
+	}
+	return &transitionInput{
+		Alloc: core.GenesisAlloc{
+			transitionSender: {Balance: big.NewInt(params.Haaer), Nonce: 1},
+		},
+		Env: &stEnv{
+			Coinbase:   transitionCoinbase,
+			Difficulty: (*math.HexOrDecimal256)(big.NewInt(0x20000)),
+			GasLimit:   50000,
+			Number:     5000000,
+			Timestamp:  1000,
+		},
+		Txs: txs,
+	}
+}
+
+// Tests that the transition applies the valid transactions, rejects the invalid
+// ones without touching the state and reports the resulting roots.
+func TestTransition(t *testing.T) {
+	input := newTransitionInput(t)
+
+	db, _ := haadb.NewMemDatabase()
+	statedb := tests.MakePreState(db, input.Alloc)
+
+	result, err := applyTransition(tests.Forks["Byzantium"], statedb, input.Env, input.Txs)
+	if err != nil {
+		t.Fatalf("failed to apply transition: %v", err)
+	}
+	// Ensure the invalid transactions were rejected with the correct errors
+	rejected := []*rejectedTx{
+		{1, core.ErrNonceTooLow.Error()},
+		{2, core.ErrGasLimitReached.Error()},
+	}
+	if len(result.Rejected) != len(rejected) {
+		t.Fatalf("rejected count mismatch: have %d, want %d", len(result.Rejected), len(rejected))
+	}
+	for i, want := range rejected {
+		if have := result.Rejected[i]; *have != *want {
+			t.Errorf("rejection %d mismatch: have %+v, want %+v", i, have, want)
+		}
+	}
+	// Ensure the post state contains the effects of the valid transactions only
+	alloc := core.GenesisAlloc{
+		transitionSender:    {Balance: new(big.Int).Sub(big.NewInt(params.Haaer), big.NewInt(2*(1000+21000))), Nonce: 3},
+		transitionRecipient: {Balance: big.NewInt(2000)},
+		transitionCoinbase:  {Balance: big.NewInt(2 * 21000)},
+	}
+	post := dumpAlloc(statedb)
+	if len(post) != len(alloc) {
+		t.Fatalf("post-alloc size mismatch: have %d, want %d", len(post), len(alloc))
+	}
+	for addr, want := range alloc {
+		have, ok := post[addr]
+		if !ok {
+			t.Errorf("account %x missing from post-alloc", addr)
+			continue
+		}
+		if have.Balance.Cmp(want.Balance) != 0 || have.Nonce != want.Nonce || len(have.Code) != 0 || len(have.Storage) != 0 {
+			t.Errorf("account %x mismatch: have %+v, want %+v", addr, have, want)
+		}
+	}
+	if root := tests.MakePreState(db, alloc).IntermediateRoot(false); result.StateRoot != root {
+		t.Errorf("state root mismatch: have %x, want %x", result.StateRoot, root)
+	}
+	// Ensure the receipts of the included transactions are reported
+	receipts := types.Receipts{
+		types.NewReceipt(nil, false, 21000),
+		types.NewReceipt(nil, false, 42000),
+	}
+	if root := types.DeriveSha(receipts); result.ReceiptRoot != root {
+		t.Errorf("receipt root mismatch: have %x, want %x", result.ReceiptRoot, root)
+	}
+	if root := types.DeriveSha(types.Transactions{input.Txs[0], input.Txs[3]}); result.TxRoot != root {
+		t.Errorf("transaction root mismatch: have %x, want %x", result.TxRoot, root)
+	}
+	if result.GasUsed != 42000 {
+		t.Errorf("gas used mismatch: have %d, want %d", result.GasUsed, 42000)
+	}
+}
+
+// Tests that the transition input is decoded the same way from a combined
+// object on stdin and from individual files.
+func TestTransitionInput(t *testing.T) {
+	input := newTransitionInput(t)
+
+	dir, err := ioutil.TempDir("", "evm-transition-test")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, v interface{}) string {
+		blob, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("failed to encode %s: %v", name, err)
+		}
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, blob, 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		return path
+	}
+	var (
+		allocPath = write("alloc.json", input.Alloc)
+		envPath   = write("env.json", input.Env)
+		txsPath   = write("txs.json", input.Txs)
+	)
+	stdin, err := json.Marshal(input)
+	if err != nil {
+		t.Fatalf("failed to encode combined input: %v", err)
+	}
+	tests := []struct {
+		alloc, env, txs string
+	}{
+		{allocPath, envPath, txsPath},
+		{"stdin", "stdin", "stdin"},
+		{allocPath, "stdin", txsPath},
+	}
+	for i, tt := range tests {
+		have, err := decodeTransitionInput(bytes.NewReader(stdin), tt.alloc, tt.env, tt.txs)
+		if err != nil {
+			t.Fatalf("test %d: failed to decode input: %v", i, err)
+		}
+		if len(have.Alloc) != 1 || have.Alloc[transitionSender].Balance.Cmp(input.Alloc[transitionSender].Balance) != 0 {
+			t.Errorf("test %d: alloc mismatch: have %v, want %v", i, have.Alloc, input.Alloc)
+		}
+		if have.Env == nil || have.Env.Coinbase != input.Env.Coinbase || have.Env.GasLimit != input.Env.GasLimit {
+			t.Errorf("test %d: env mismatch: have %+v, want %+v", i, have.Env, input.Env)
+		}
+		if len(have.Txs) != len(input.Txs) {
+			t.Fatalf("test %d: transaction count mismatch: have %d, want %d", i, len(have.Txs), len(input.Txs))
+		}
+		for j, tx := range have.Txs {
+			if tx.Hash() != input.Txs[j].Hash() {
+				t.Errorf("test %d, tx %d: hash mismatch: have %x, want %x", i, j, tx.Hash(), input.Txs[j].Hash())
+			}
+		}
+	}
+	// Fields read from files must not be merged with the combined stdin object
+	override := write("override.json", core.GenesisAlloc{transitionRecipient: {Balance: big.NewInt(1)}})
+	have, err := decodeTransitionInput(bytes.NewReader(stdin), override, "stdin", "stdin")
+	if err != nil {
+		t.Fatalf("failed to decode overridden input: %v", err)
+	}
+	if _, ok := have.Alloc[transitionRecipient]; !ok || len(have.Alloc) != 1 {
+		t.Errorf("alloc not overridden by file: %v", have.Alloc)
+	}
+	// Missing files and malformed stdin must be reported
+	if _, err := decodeTransitionInput(bytes.NewReader(stdin), filepath.Join(dir, "missing.json"), envPath, txsPath); err == nil {
+		t.Errorf("missing input file accepted")
+	}
+	if _, err := decodeTransitionInput(bytes.NewReader([]byte("{")), "stdin", envPath, txsPath); err == nil {
+		t.Errorf("malformed stdin accepted")
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-haaereum.
+//
+// go-haaereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-haaereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-haaereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/haachain/go-haachain/cmd/utils"
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core"
+	"github.com/olekukonko/tablewriter"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	inspectRangeFlag = cli.Uint64Flag{
+		Name:  "range",
+		Usage: "Break down the block data into ranges of this many blocks (0 = disabled)",
+	}
+
+	dbCommand = cli.Command{
+		Name:     "db",
+		Usage:    "Low level database operations",
+		Category: "DATABASE COMMANDS",
+		Subcommands: []cli.Command{
+			{
+				Name:      "inspect",
+				Usage:     "Inspect the storage size for each type of data in the database",
+				ArgsUsage: "[<prefix> [<start>]]",
+				Action:    utils.MigrateFlags(inspectDB),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.AncientFlag,
+					utils.NoFreezerFlag,
+					utils.DBEngineFlag,
+					utils.LightModeFlag,
+					utils.TestnetFlag,
+					utils.RinkebyFlag,
+					inspectRangeFlag,
+				},
+				Description: `
+gtst db inspect [<prefix> [<start>]]
+
+iterates over the entire chain database and reports the number of entries and
+their total size for each category of data (headers, bodies, receipts, lookups,
+bloombits, trie nodes, preimages, etc). The optional hex encoded prefix and start
+key restrict the inspection to a subset of the database.
+
+With --range, the data keyed by block number is also broken down into ranges of
+the given number of blocks, showing how the chain data grew over time.`,
+			},
+		},
+	}
+)
+
+// inspectDB iterates over the chain database and prints the size of each data
+// category in a table.
+func inspectDB(ctx *cli.Context) error {
+	var prefix, start []byte
+	if len(ctx.Args()) > 2 {
+		utils.Fatalf("Too many arguments, expected [<prefix> [<start>]]")
+	}
+	if len(ctx.Args()) > 0 {
+		prefix = common.FromHex(ctx.Args().Get(0))
+	}
+	if len(ctx.Args()) > 1 {
+		start = common.FromHex(ctx.Args().Get(1))
+	}
+	stack, _ := makeConfigNode(ctx)
+	chaindb := utils.MakeChainDatabase(ctx, stack)
+	defer chaindb.Close()
+
+	stats, err := core.InspectDatabase(chaindb, prefix, start, ctx.Uint64(inspectRangeFlag.Name))
+	if err != nil {
+		utils.Fatalf("Failed to inspect database: %v", err)
+	}
+	var (
+		count uint64
+		size  common.StorageSize
+		table = tablewriter.NewWriter(os.Stdout)
+	)
+	table.SetHeader([]string{"Category", "Items", "Size"})
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+
+	for _, stat := range stats {
+		if !stat.Ranged {
+			count += stat.Count
+			size += stat.Size
+		}
+		name := stat.String()
+		if stat.Ranged {
+			name = "  " + name
+		}
+		table.Append([]string{name, fmt.Sprintf("%d", stat.Count), stat.Size.String()})
+	}
+	table.SetFooter([]string{"Total", fmt.Sprintf("%d", count), size.String()})
+	table.Render()
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-haaereum.
+//
+// go-haaereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-haaereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-haaereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+
+	"github.com/haachain/go-haachain/cmd/utils"
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core"
+	"github.com/haachain/go-haachain/core/state/pruner"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	errNoHeadBlock   = errors.New("head block not found")
+	errNoRecentState = errors.New("no recent state found on disk")
+)
+
+var (
+	pruneBloomSizeFlag = cli.Uint64Flag{
+		Name:  "bloomfilter.size",
+		Usage: "Megabytes of memory allocated to the bloom filter marking the live state",
+		Value: 2048,
+	}
+	pruneRecentFlag = cli.Uint64Flag{
+		Name:  "prune.recent",
+		Usage: "Number of recent blocks whose state to retain (if present on disk)",
+		Value: 128,
+	}
+
+	snapshotCommand = cli.Command{
+		Name:     "snapshot",
+		Usage:    "A set of commands based on the persisted state",
+		Category: "MISCELLANEOUS COMMANDS",
+		Description: `
+Manage the state persisted in the chain database.`,
+		Subcommands: []cli.Command{
+			{
+				Name:   "prune-state",
+				Usage:  "Prune stale state data from the database",
+				Action: utils.MigrateFlags(pruneState),
+				Flags: []cli.Flag{
+					utils.DataDirFlag,
+					utils.DBEngineFlag,
+					utils.TestnetFlag,
+					utils.RinkebyFlag,
+					pruneBloomSizeFlag,
+					pruneRecentFlag,
+				},
+				Description: `
+gtst snapshot prune-state
+
+will delete every state trie node and contract code from the chain database
+which is not reachable from the state of the most recent blocks (configurable
+via --prune.recent) or the genesis block. The live state is marked in a bloom
+filter of bounded size (configurable via --bloomfilter.size), so the pruning
+runs in bounded memory regardless of the size of the state. A small amount of
+stale data may survive the pruning due to the false positives of the filter.
+
+The node must not be running while pruning. Since the historical states are
+deleted, the node won't be able to serve state queries for old blocks anymore.`,
+			},
+		},
+	}
+)
+
+// pruneState deletes all the state data not reachable from the retained roots.
+func pruneState(ctx *cli.Context) error {
+	stack, _ := makeConfigNode(ctx)
+	chaindb := utils.MakeChainDatabase(ctx, stack)
+	defer chaindb.Close()
+
+	roots, err := retainedRoots(chaindb, ctx.Uint64(pruneRecentFlag.Name))
+	if err != nil {
+		utils.Fatalf("Failed to collect state roots to retain: %v", err)
+	}
+	p, err := pruner.NewPruner(chaindb, ctx.Uint64(pruneBloomSizeFlag.Name))
+	if err != nil {
+		utils.Fatalf("Failed to create state pruner: %v", err)
+	}
+	if err := p.Prune(roots); err != nil {
+		utils.Fatalf("Failed to prune state: %v", err)
+	}
+	return nil
+}
+
+// retainedRoots collects the state roots of the given number of most recent
+// blocks which are present on disk, along with the genesis state root. The
+// in-memory garbage collector only flushes a subset of the recent states, so
+// missing roots are silently skipped, but at least one recent state must exist.
+func retainedRoots(db haadb.Database, recent uint64) ([]common.Hash, error) {
+	hash := core.GetHeadBlockHash(db)
+	if hash == (common.Hash{}) {
+		return nil, errNoHeadBlock
+	}
+	var (
+		roots []common.Hash
+		seen  = make(map[common.Hash]struct{})
+	)
+	for i := uint64(0); i < recent; i++ {
+		number := core.GetBlockNumber(db, hash)
+		header := core.GetHeader(db, hash, number)
+		if header == nil {
+			break
+		}
+		if _, ok := seen[header.Root]; !ok {
+			if ok, _ := db.Has(header.Root[:]); ok {
+				roots = append(roots, header.Root)
+				seen[header.Root] = struct{}{}
+			}
+		}
+		if number == 0 {
+			break
+		}
+		hash = header.ParentHash
+	}
+	if len(roots) == 0 {
+		return nil, errNoRecentState
+	}
+	log.Info("Retaining recent states", "count", len(roots), "head", roots[0])
+
+	// Always retain the genesis state, the chain may be rewound to it
+	if genesis := core.GetHeader(db, core.GetCanonicalHash(db, 0), 0); genesis != nil {
+		if _, ok := seen[genesis.Root]; !ok {
+			if ok, _ := db.Has(genesis.Root[:]); ok {
+				roots = append(roots, genesis.Root)
+			}
+		}
+	}
+	return roots, nil
+}
@@ -48,6 +48,7 @@ import (
 	"github.com/haachain/go-haachain/les"
 	"github.com/haachain/go-haachain/log"
 	"github.com/haachain/go-haachain/metrics"
+	"github.com/haachain/go-haachain/miner"
 	"github.com/haachain/go-haachain/node"
 	"github.com/haachain/go-haachain/p2p"
 	"github.com/haachain/go-haachain/p2p/discover"
@@ -118,6 +119,18 @@ var (
 		Usage: "Data directory for the databases and keystore",
 		Value: DirectoryString{node.DefaultDataDir()},
 	}
+	AncientFlag = DirectoryFlag{
+		Name:  "datadir.ancient",
+		Usage: "Data directory for ancient chain segments (default = inside chaindata)",
+	}
+	NoFreezerFlag = cli.BoolFlag{
+		Name:  "nofreezer",
+		Usage: "Disables moving ancient chain segments out of the chain database",
+	}
+	DBEngineFlag = cli.StringFlag{
+		Name:  "db.engine",
+		Usage: "Backing database implementation to use ('leveldb' or 'logdb', default = existing or leveldb)",
+	}
 	KeyStoreDirFlag = DirectoryFlag{
 		Name:  "keystore",
 		Usage: "Directory for the keystore (default = inside the datadir)",
@@ -167,7 +180,7 @@ var (
 	defaultSyncMode = haa.DefaultConfig.SyncMode
 	SyncModeFlag    = TextMarshalerFlag{
 		Name:  "syncmode",
-		Usage: `Blockchain sync mode ("fast", "full", or "light")`,
+		Usage: `Blockchain sync mode ("fast", "snap", "full", or "light")`,
 		Value: &defaultSyncMode,
 	}
 	GCModeFlag = cli.StringFlag{
@@ -259,6 +272,16 @@ var (
 		Usage: "Time interval to regenerate the local transaction journal",
 		Value: core.DefaultTxPoolConfig.Rejournal,
 	}
+	TxPoolRemoteJournalFlag = cli.StringFlag{
+		Name:  "txpool.remotejournal",
+		Usage: "Disk snapshot of remote transactions to survive node restarts (disabled if empty)",
+		Value: core.DefaultTxPoolConfig.RemoteJournal,
+	}
+	TxPoolRemoteJournalLimitFlag = cli.Uint64Flag{
+		Name:  "txpool.remotejournallimit",
+		Usage: "Maximum number of remote transactions to keep in the disk snapshot",
+		Value: core.DefaultTxPoolConfig.RemoteJournalLimit,
+	}
 	TxPoolPriceLimitFlag = cli.Uint64Flag{
 		Name:  "txpool.pricelimit",
 		Usage: "Minimum gas price limit to enforce for acceptance into the pool",
@@ -294,6 +317,11 @@ var (
 		Usage: "Maximum amount of time non-executable transaction are queued",
 		Value: haa.DefaultConfig.TxPool.Lifetime,
 	}
+	TxPoolPrivatePeersFlag = cli.StringFlag{
+		Name:  "txpool.privatepeers",
+		Usage: "Comma separated node IDs of trusted peers allowed to receive private transactions",
+		Value: "",
+	}
 	// Performance tuning settings
 	CacheFlag = cli.IntFlag{
 		Name:  "cache",
@@ -315,6 +343,10 @@ var (
 		Usage: "Number of trie node generations to keep in memory",
 		Value: int(state.MaxTrieCacheGen),
 	}
+	SnapshotFlag = cli.BoolFlag{
+		Name:  "snapshot",
+		Usage: "Maintain a flat state snapshot to accelerate state reads (generated in the background)",
+	}
 	// Miner settings
 	MiningEnabledFlag = cli.BoolFlag{
 		Name:  "mine",
@@ -344,6 +376,15 @@ var (
 		Name:  "extradata",
 		Usage: "Block extra data set by the miner (default = client version)",
 	}
+	MinerOrderingFlag = cli.StringFlag{
+		Name:  "minerordering",
+		Usage: `Transaction ordering policy used by the miner ("price", "fifo" or "priority")`,
+		Value: miner.OrderingPrice,
+	}
+	MinerPriorityFlag = cli.StringFlag{
+		Name:  "minerpriority",
+		Usage: "Comma separated list of senders committed first by the priority ordering",
+	}
 	// Account settings
 	UnlockedAccountFlag = cli.StringFlag{
 		Name:  "unlock",
@@ -889,6 +930,13 @@ func SetNodeConfig(ctx *cli.Context, cfg *node.Config) {
 		cfg.DataDir = filepath.Join(node.DefaultDataDir(), "rinkeby")
 	}
 
+	if ctx.GlobalIsSet(DBEngineFlag.Name) {
+		engine := ctx.GlobalString(DBEngineFlag.Name)
+		if engine != haadb.EngineLevelDB && engine != haadb.EngineLogDB {
+			Fatalf("Invalid choice for db.engine '%s', allowed 'leveldb' or 'logdb'", engine)
+		}
+		cfg.DBEngine = engine
+	}
 	if ctx.GlobalIsSet(KeyStoreDirFlag.Name) {
 		cfg.KeyStoreDir = ctx.GlobalString(KeyStoreDirFlag.Name)
 	}
@@ -919,6 +967,12 @@ func setTxPool(ctx *cli.Context, cfg *core.TxPoolConfig) {
 	if ctx.GlobalIsSet(TxPoolRejournalFlag.Name) {
 		cfg.Rejournal = ctx.GlobalDuration(TxPoolRejournalFlag.Name)
 	}
+	if ctx.GlobalIsSet(TxPoolRemoteJournalFlag.Name) {
+		cfg.RemoteJournal = ctx.GlobalString(TxPoolRemoteJournalFlag.Name)
+	}
+	if ctx.GlobalIsSet(TxPoolRemoteJournalLimitFlag.Name) {
+		cfg.RemoteJournalLimit = ctx.GlobalUint64(TxPoolRemoteJournalLimitFlag.Name)
+	}
 	if ctx.GlobalIsSet(TxPoolPriceLimitFlag.Name) {
 		cfg.PriceLimit = ctx.GlobalUint64(TxPoolPriceLimitFlag.Name)
 	}
@@ -1018,6 +1072,7 @@ func SethaaConfig(ctx *cli.Context, stack *node.Node, cfg *haa.Config) {
 	checkExclusive(ctx, FastSyncFlag, LightModeFlag, SyncModeFlag)
 	checkExclusive(ctx, LightServFlag, LightModeFlag)
 	checkExclusive(ctx, LightServFlag, SyncModeFlag, "light")
+	checkExclusive(ctx, AncientFlag, NoFreezerFlag)
 
 	ks := stack.AccountManager().Backends(keystore.KeyStoreType)[0].(*keystore.KeyStore)
 	sethaaerbase(ctx, ks, cfg)
@@ -1025,6 +1080,17 @@ func SethaaConfig(ctx *cli.Context, stack *node.Node, cfg *haa.Config) {
 	setTxPool(ctx, &cfg.TxPool)
 	sethaaash(ctx, cfg)
 
+	if ctx.GlobalIsSet(TxPoolPrivatePeersFlag.Name) {
+		cfg.PrivateTxPeers = nil
+		for _, id := range strings.Split(ctx.GlobalString(TxPoolPrivatePeersFlag.Name), ",") {
+			node, err := discover.HexID(strings.TrimSpace(id))
+			if err != nil {
+				Fatalf("Option %q: invalid node ID %q: %v", TxPoolPrivatePeersFlag.Name, id, err)
+			}
+			cfg.PrivateTxPeers = append(cfg.PrivateTxPeers, node)
+		}
+	}
+
 	switch {
 	case ctx.GlobalIsSet(SyncModeFlag.Name):
 		cfg.SyncMode = *GlobalTextMarshaler(ctx, SyncModeFlag.Name).(*downloader.SyncMode)
@@ -1047,6 +1113,12 @@ func SethaaConfig(ctx *cli.Context, stack *node.Node, cfg *haa.Config) {
 		cfg.DatabaseCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheDatabaseFlag.Name) / 100
 	}
 	cfg.DatabaseHandles = makeDatabaseHandles()
+	if ctx.GlobalIsSet(AncientFlag.Name) {
+		cfg.DatabaseFreezer = ctx.GlobalString(AncientFlag.Name)
+	}
+	if ctx.GlobalIsSet(NoFreezerFlag.Name) {
+		cfg.NoFreezer = ctx.GlobalBool(NoFreezerFlag.Name)
+	}
 
 	if gcmode := ctx.GlobalString(GCModeFlag.Name); gcmode != "full" && gcmode != "archive" {
 		Fatalf("--%s must be either 'full' or 'archive'", GCModeFlag.Name)
@@ -1056,6 +1128,8 @@ func SethaaConfig(ctx *cli.Context, stack *node.Node, cfg *haa.Config) {
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheGCFlag.Name) {
 		cfg.TrieCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheGCFlag.Name) / 100
 	}
+	cfg.Snapshot = ctx.GlobalBool(SnapshotFlag.Name)
+
 	if ctx.GlobalIsSet(MinerThreadsFlag.Name) {
 		cfg.MinerThreads = ctx.GlobalInt(MinerThreadsFlag.Name)
 	}
@@ -1068,6 +1142,18 @@ func SethaaConfig(ctx *cli.Context, stack *node.Node, cfg *haa.Config) {
 	if ctx.GlobalIsSet(GasPriceFlag.Name) {
 		cfg.GasPrice = GlobalBig(ctx, GasPriceFlag.Name)
 	}
+	if ctx.GlobalIsSet(MinerOrderingFlag.Name) {
+		cfg.MinerOrdering = ctx.GlobalString(MinerOrderingFlag.Name)
+	}
+	if ctx.GlobalIsSet(MinerPriorityFlag.Name) {
+		cfg.MinerPriority = nil
+		for _, account := range strings.Split(ctx.GlobalString(MinerPriorityFlag.Name), ",") {
+			if account = strings.TrimSpace(account); !common.IsHexAddress(account) {
+				Fatalf("Option %q: invalid sender address %q", MinerPriorityFlag.Name, account)
+			}
+			cfg.MinerPriority = append(cfg.MinerPriority, common.HexToAddress(account))
+		}
+	}
 	if ctx.GlobalIsSet(VMEnableDebugFlag.Name) {
 		// TODO(fjl): force-enable this in --dev mode
 		cfg.EnablePreimageRecording = ctx.GlobalBool(VMEnableDebugFlag.Name)
@@ -1184,17 +1270,27 @@ func SetupNetwork(ctx *cli.Context) {
 	params.TargetGasLimit = ctx.GlobalUint64(TargetGasLimitFlag.Name)
 }
 
-// MakeChainDatabase open an LevelDB using the flags passed to the client and will hard crash if it fails.
+// MakeChainDatabase open a database using the flags passed to the client and will hard crash if it fails.
 func MakeChainDatabase(ctx *cli.Context, stack *node.Node) haadb.Database {
 	var (
 		cache   = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheDatabaseFlag.Name) / 100
 		handles = makeDatabaseHandles()
 	)
-	name := "chaindata"
-	if ctx.GlobalBool(LightModeFlag.Name) {
-		name = "lightchaindata"
+	var (
+		chainDb haadb.Database
+		err     error
+	)
+	switch {
+	case ctx.GlobalBool(LightModeFlag.Name):
+		chainDb, err = stack.OpenDatabase("lightchaindata", cache, handles)
+	case ctx.GlobalBool(NoFreezerFlag.Name):
+		if frozen, _ := haadb.HasAncients(stack.ResolvePath(filepath.Join("chaindata", "ancient"))); frozen {
+			Fatalf("Ancient chain segments found, freezer can't be disabled")
+		}
+		chainDb, err = stack.OpenDatabase("chaindata", cache, handles)
+	default:
+		chainDb, err = stack.OpenDatabaseWithFreezer("chaindata", cache, handles, ctx.GlobalString(AncientFlag.Name))
 	}
-	chainDb, err := stack.OpenDatabase(name, cache, handles)
 	if err != nil {
 		Fatalf("Could not open database: %v", err)
 	}
@@ -1246,6 +1342,7 @@ func MakeChain(ctx *cli.Context, stack *node.Node) (chain *core.BlockChain, chai
 		Disabled:      ctx.GlobalString(GCModeFlag.Name) == "archive",
 		TrieNodeLimit: haa.DefaultConfig.TrieCache,
 		TrieTimeLimit: haa.DefaultConfig.TrieTimeout,
+		Snapshot:      ctx.GlobalBool(SnapshotFlag.Name),
 	}
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheGCFlag.Name) {
 		cache.TrieNodeLimit = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheGCFlag.Name) / 100
@@ -31,6 +31,7 @@ import (
 	"github.com/haachain/go-haachain/common/mclock"
 	"github.com/haachain/go-haachain/consensus"
 	"github.com/haachain/go-haachain/core/state"
+	"github.com/haachain/go-haachain/core/state/snapshot"
 	"github.com/haachain/go-haachain/core/types"
 	"github.com/haachain/go-haachain/core/vm"
 	"github.com/haachain/go-haachain/crypto"
@@ -58,6 +59,7 @@ const (
 	maxTimeFutureBlocks = 30
 	badBlockLimit       = 10
 	triesInMemory       = 128
+	snapshotCacheLimit  = 100000
 
 	// BlockChainVersion ensures that an incompatible database forces a resync from scratch.
 	BlockChainVersion = 3
@@ -69,6 +71,7 @@ type CacheConfig struct {
 	Disabled      bool          // Whhaaer to disable trie write caching (archive node)
 	TrieNodeLimit int           // Memory limit (MB) at which to flush the current in-memory trie to disk
 	TrieTimeLimit time.Duration // Time limit after which to flush the current in-memory trie to disk
+	Snapshot      bool          // Whether to maintain a flat state snapshot to accelerate state reads
 }
 
 // BlockChain represents the canonical chain given a database with a genesis
@@ -111,6 +114,7 @@ type BlockChain struct {
 	currentFastBlock atomic.Value // Current head of the fast-sync chain (may be above the block chain!)
 
 	stateCache   state.Database // State database to reuse between imports (contains state cache)
+	snaps        *snapshot.Tree // Flat state snapshot tree (nil if disabled)
 	bodyCache    *lru.Cache     // Cache for the most recent block bodies
 	bodyRLPCache *lru.Cache     // Cache for the most recent block bodies in RLP encoded format
 	blockCache   *lru.Cache     // Cache for the most recent entire blocks
@@ -176,6 +180,11 @@ func NewBlockChain(db haadb.Database, cacheConfig *CacheConfig, chainConfig *par
 	if err := bc.loadLashaaate(); err != nil {
 		return nil, err
 	}
+	// Load any existing state snapshot, regenerating it if stale
+	if cacheConfig.Snapshot {
+		bc.snaps = snapshot.New(db, bc.stateCache.TrieDB(), snapshotCacheLimit, bc.CurrentBlock().Root())
+		bc.stateCache = state.NewDatabaseWithSnapshots(bc.stateCache, bc.snaps)
+	}
 	// Check the current state of the block hashes and make sure that we do not have any of the bad blocks in our chain
 	for hash := range BadHashes {
 		if header := bc.GetHeaderByHash(hash); header != nil {
@@ -189,6 +198,13 @@ func NewBlockChain(db haadb.Database, cacheConfig *CacheConfig, chainConfig *par
 			}
 		}
 	}
+	// Start migrating the immutable chain segment if an ancient store is present
+	if store, ok := db.(haadb.AncientStore); ok {
+		if _, err := store.Ancients(); err == nil {
+			bc.wg.Add(1)
+			go bc.freeze()
+		}
+	}
 	// Take ownership of this particular state
 	go bc.update()
 	return bc, nil
@@ -274,6 +290,15 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	bc.hc.SetHead(head, delFn)
 	currentHeader := bc.hc.CurrentHeader()
 
+	// Drop any ancient chain data above the new head too
+	if store, ok := bc.db.(haadb.AncientStore); ok {
+		if frozen, err := store.Ancients(); err == nil && frozen > head+1 {
+			if err := store.TruncateAncients(head + 1); err != nil {
+				log.Crit("Failed to truncate ancient store", "err", err)
+			}
+		}
+	}
+
 	// Clear out any stale content from the caches
 	bc.bodyCache.Purge()
 	bc.bodyRLPCache.Purge()
@@ -309,6 +334,10 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	if err := WriteHeadFastBlockHash(bc.db, currentFastBlock.Hash()); err != nil {
 		log.Crit("Failed to reset head fast block", "err", err)
 	}
+	// Rebuild the state snapshot if the rewound head is below its disk layer
+	if bc.snaps != nil && bc.snaps.Snapshot(currentBlock.Root()) == nil {
+		bc.snaps.Rebuild(currentBlock.Root())
+	}
 	return bc.loadLashaaate()
 }
 
@@ -328,6 +357,11 @@ func (bc *BlockChain) FastSyncCommitHead(hash common.Hash) error {
 	bc.currentBlock.Store(block)
 	bc.mu.Unlock()
 
+	// The state was synced without maintaining the snapshot, regenerate it
+	if bc.snaps != nil {
+		bc.snaps.Rebuild(block.Root())
+	}
+
 	log.Info("Committed new head block", "number", block.Number(), "hash", hash)
 	return nil
 }
@@ -416,6 +450,9 @@ func (bc *BlockChain) ResetWithGenesisBlock(genesis *types.Block) error {
 	bc.hc.SetCurrentHeader(bc.genesisBlock.Header())
 	bc.currentFastBlock.Store(bc.genesisBlock)
 
+	if bc.snaps != nil && bc.snaps.Snapshot(genesis.Root()) == nil {
+		bc.snaps.Rebuild(genesis.Root())
+	}
 	return nil
 }
 
@@ -539,8 +576,10 @@ func (bc *BlockChain) HasBlock(hash common.Hash, number uint64) bool {
 	if bc.blockCache.Contains(hash) {
 		return true
 	}
-	ok, _ := bc.db.Has(blockBodyKey(hash, number))
-	return ok
+	if ok, _ := bc.db.Has(blockBodyKey(hash, number)); ok {
+		return true
+	}
+	return hasAncientBlock(bc.db, haadb.FreezerBodiesTable, hash, number)
 }
 
 // HasState checks if state trie is fully present in the database or not.
@@ -629,6 +668,11 @@ func (bc *BlockChain) TrieNode(hash common.Hash) ([]byte, error) {
 	return bc.stateCache.TrieDB().Node(hash)
 }
 
+// StateCache returns the caching database underpinning the blockchain instance.
+func (bc *BlockChain) StateCache() state.Database {
+	return bc.stateCache
+}
+
 // Stop stops the blockchain service. If any imports are currently in progress
 // it will abort them using the procInterrupt.
 func (bc *BlockChain) Stop() {
@@ -642,6 +686,12 @@ func (bc *BlockChain) Stop() {
 
 	bc.wg.Wait()
 
+	// Flatten the state snapshot into the disk so it matches the head on restart
+	if bc.snaps != nil {
+		if err := bc.snaps.Persist(bc.CurrentBlock().Root()); err != nil {
+			log.Error("Failed to persist state snapshot", "err", err)
+		}
+	}
 	// Ensure the state of a recent block is also stored to disk before exiting.
 	// We're writing three different states to catch different restart scenarios:
 	//  - HEAD:     So we don't need to reprocess any blocks in the general case
@@ -985,6 +1035,7 @@ func (bc *BlockChain) WriteBlockWithState(block *types.Block, receipts []*types.
 	// Set new head.
 	if status == CanonStatTy {
 		bc.insert(block)
+		bc.capSnapshot(root)
 	}
 	bc.futureBlocks.Remove(block.Hash())
 	return status, nil
@@ -1237,6 +1288,26 @@ func countTransactions(chain []*types.Block) (c int) {
 	return c
 }
 
+// capSnapshot keeps the in-memory diffs of the state snapshot belonging to the
+// new canonical head within the trie retention window. If the head could not be
+// linked to the snapshot tree (e.g. after a reorg deeper than its disk layer),
+// the snapshot is regenerated from scratch instead.
+//
+// Note, side chain roots must never be capped, as flattening them would drop
+// the layers of the canonical chain.
+func (bc *BlockChain) capSnapshot(root common.Hash) {
+	if bc.snaps == nil {
+		return
+	}
+	if bc.snaps.Snapshot(root) == nil {
+		bc.snaps.Rebuild(root)
+		return
+	}
+	if err := bc.snaps.Cap(root, triesInMemory-1); err != nil {
+		log.Debug("Failed to cap snapshot tree", "root", root, "err", err)
+	}
+}
+
 // reorgs takes two blocks, an old chain and a new chain and will reconstruct the blocks and inserts them
 // to be part of the new canonical chain and accumulates potential missing transactions and post an
 // event about them
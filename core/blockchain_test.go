@@ -17,6 +17,7 @@
 package core
 
 import (
+	"bytes"
 	"fmt"
 	"math/big"
 	"math/rand"
@@ -27,11 +28,13 @@ import (
 	"github.com/haachain/go-haachain/common"
 	"github.com/haachain/go-haachain/consensus/ethash"
 	"github.com/haachain/go-haachain/core/state"
+	"github.com/haachain/go-haachain/core/state/snapshot"
 	"github.com/haachain/go-haachain/core/types"
 	"github.com/haachain/go-haachain/core/vm"
 	"github.com/haachain/go-haachain/crypto"
 	"github.com/haachain/go-haachain/haadb"
 	"github.com/haachain/go-haachain/params"
+	"github.com/haachain/go-haachain/trie"
 )
 
 // Test fork of length N starting from block i
@@ -1338,3 +1341,124 @@ func TestLargeReorgTrieGC(t *testing.T) {
 		}
 	}
 }
+
+// checkSnapshot waits for the state snapshot of the given root to be generated
+// and ensures that it matches the given accounts in the state trie.
+func checkSnapshot(t *testing.T, chain *BlockChain, root common.Hash, addrs []common.Address) {
+	snap := chain.snaps.Snapshot(root)
+	if snap == nil {
+		t.Fatalf("snapshot of root %x missing", root)
+	}
+	tr, err := trie.NewSecure(root, chain.stateCache.TrieDB(), 0)
+	if err != nil {
+		t.Fatalf("failed to open state trie %x: %v", root, err)
+	}
+	for _, addr := range addrs {
+		want, _ := tr.TryGet(addr[:])
+
+		var have []byte
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			if have, err = snap.AccountRLP(crypto.Keccak256Hash(addr[:])); err != snapshot.ErrNotCoveredYet || time.Now().After(deadline) {
+				break
+			}
+		}
+		if err != nil {
+			t.Fatalf("account %x: snapshot retrieval failed: %v", addr, err)
+		}
+		if !bytes.Equal(have, want) {
+			t.Fatalf("account %x: snapshot mismatch: have %x, want %x", addr, have, want)
+		}
+	}
+}
+
+// Tests that rewinding the chain below the disk layer of the state snapshot
+// regenerates it, and that it keeps tracking the chain afterwards.
+func TestSnapshotSetHead(t *testing.T) {
+	engine := ethash.NewFaker()
+
+	db, _ := haadb.NewMemDatabase()
+	genesis := new(Genesis).MustCommit(db)
+
+	blocks, _ := GenerateChain(params.TestChainConfig, genesis, engine, db, 2*triesInMemory, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{byte(i%2 + 1)}) })
+
+	// Import the chain into an archive node maintaining a snapshot
+	diskdb, _ := haadb.NewMemDatabase()
+	new(Genesis).MustCommit(diskdb)
+
+	chain, err := NewBlockChain(diskdb, &CacheConfig{Disabled: true, Snapshot: true}, params.TestChainConfig, engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to import chain: %v", err)
+	}
+	addrs := []common.Address{{1}, {2}}
+	checkSnapshot(t, chain, chain.CurrentBlock().Root(), addrs)
+
+	// Rewind below the disk layer and ensure the snapshot is regenerated
+	if err := chain.SetHead(10); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	if head := chain.CurrentBlock(); head.Hash() != blocks[9].Hash() {
+		t.Fatalf("head mismatch: have #%d [%x], want #%d [%x]", head.NumberU64(), head.Hash(), blocks[9].NumberU64(), blocks[9].Hash())
+	}
+	checkSnapshot(t, chain, blocks[9].Root(), addrs)
+
+	// Reimport the rewound blocks and ensure they are layered onto the snapshot
+	if _, err := chain.InsertChain(blocks[10:]); err != nil {
+		t.Fatalf("failed to reimport chain: %v", err)
+	}
+	if chain.snaps.Snapshot(blocks[len(blocks)-2].Root()) == nil {
+		t.Fatalf("snapshot rebuilt instead of extended")
+	}
+	checkSnapshot(t, chain, chain.CurrentBlock().Root(), addrs)
+}
+
+// Tests that a reorg deeper than the disk layer of the state snapshot regenerates
+// it, and that it keeps tracking the new chain afterwards.
+func TestSnapshotDeepReorg(t *testing.T) {
+	engine := ethash.NewFaker()
+
+	db, _ := haadb.NewMemDatabase()
+	genesis := new(Genesis).MustCommit(db)
+
+	original, _ := GenerateChain(params.TestChainConfig, genesis, engine, db, 2*triesInMemory, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{2}) })
+	competitor, _ := GenerateChain(params.TestChainConfig, genesis, engine, db, 2*triesInMemory+2, func(i int, b *BlockGen) { b.SetCoinbase(common.Address{3}) })
+
+	// Import the original chain into a node maintaining a snapshot
+	diskdb, _ := haadb.NewMemDatabase()
+	new(Genesis).MustCommit(diskdb)
+
+	chain, err := NewBlockChain(diskdb, &CacheConfig{TrieNodeLimit: 256 * 1024 * 1024, TrieTimeLimit: 5 * time.Minute, Snapshot: true}, params.TestChainConfig, engine, vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	if _, err := chain.InsertChain(original); err != nil {
+		t.Fatalf("failed to import original chain: %v", err)
+	}
+	addrs := []common.Address{{2}, {3}}
+	checkSnapshot(t, chain, chain.CurrentBlock().Root(), addrs)
+
+	// Reorg to the competitor chain, forking below the disk layer
+	if _, err := chain.InsertChain(competitor[:len(competitor)-1]); err != nil {
+		t.Fatalf("failed to import competitor chain: %v", err)
+	}
+	head := competitor[len(competitor)-2]
+	if have := chain.CurrentBlock(); have.Hash() != head.Hash() {
+		t.Fatalf("head mismatch: have #%d [%x], want #%d [%x]", have.NumberU64(), have.Hash(), head.NumberU64(), head.Hash())
+	}
+	checkSnapshot(t, chain, head.Root(), addrs)
+
+	// Extend the new chain and ensure it's layered onto the snapshot
+	if _, err := chain.InsertChain(competitor[len(competitor)-1:]); err != nil {
+		t.Fatalf("failed to extend competitor chain: %v", err)
+	}
+	if chain.snaps.Snapshot(head.Root()) == nil {
+		t.Fatalf("snapshot rebuilt instead of extended")
+	}
+	checkSnapshot(t, chain, chain.CurrentBlock().Root(), addrs)
+}
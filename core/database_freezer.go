@@ -0,0 +1,156 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/log"
+	"github.com/haachain/go-haachain/params"
+)
+
+const (
+	// freezerBatchLimit is the maximum number of blocks to freeze in one batch
+	// before syncing the ancient store and wiping the data from the key-value store.
+	freezerBatchLimit = 30000
+
+	// freezerRecheckInterval is the frequency to check the key-value database for
+	// chain progression that might permit new blocks to be frozen into immutable
+	// storage.
+	freezerRecheckInterval = time.Minute
+)
+
+// errNoAncientStore is returned if ancient chain data is attempted to be frozen
+// into a database not backed by an ancient store.
+var errNoAncientStore = errors.New("ancient store not available")
+
+// FreezeAncients migrates the canonical chain segment older than the given
+// threshold of blocks below the current head out of the key-value store and
+// into the ancient store of the database, returning the number of blocks moved.
+//
+// Only a limited number of blocks is migrated at once, so the method needs to be
+// called repeatedly to fully catch up with an old chain. Side chain data is left
+// in the key-value store.
+func FreezeAncients(db haadb.Database, threshold uint64) (uint64, error) {
+	store, ok := db.(haadb.AncientStore)
+	if !ok {
+		return 0, errNoAncientStore
+	}
+	frozen, err := store.Ancients()
+	if err != nil {
+		return 0, err
+	}
+	// Retrieve the freezing threshold based on the current head block
+	hash := GetHeadBlockHash(db)
+	if hash == (common.Hash{}) {
+		return 0, nil
+	}
+	head := GetBlockNumber(db, hash)
+	if head == missingNumber || head <= threshold {
+		return 0, nil
+	}
+	limit := head - threshold
+	if limit > frozen+freezerBatchLimit {
+		limit = frozen + freezerBatchLimit
+	}
+	// Append all the canonical blocks below the threshold into the ancient store
+	var hashes []common.Hash
+	for number := frozen; number < limit; number++ {
+		hash := GetCanonicalHash(db, number)
+		if hash == (common.Hash{}) {
+			return 0, fmt.Errorf("canonical hash missing, can't freeze block %d", number)
+		}
+		header := GetHeaderRLP(db, hash, number)
+		if len(header) == 0 {
+			return 0, fmt.Errorf("block header missing, can't freeze block %d", number)
+		}
+		body := GetBodyRLP(db, hash, number)
+		if len(body) == 0 {
+			return 0, fmt.Errorf("block body missing, can't freeze block %d", number)
+		}
+		receipts, _ := db.Get(blockReceiptsKey(hash, number))
+		if len(receipts) == 0 {
+			return 0, fmt.Errorf("block receipts missing, can't freeze block %d", number)
+		}
+		td, _ := db.Get(headerTDKey(hash, number))
+		if len(td) == 0 {
+			return 0, fmt.Errorf("total difficulty missing, can't freeze block %d", number)
+		}
+		if err := store.AppendAncient(number, hash[:], header, body, receipts, td); err != nil {
+			return 0, err
+		}
+		hashes = append(hashes, hash)
+	}
+	if len(hashes) == 0 {
+		return 0, nil
+	}
+	// Flush the ancient store before deleting anything from the key-value store
+	if err := store.Sync(); err != nil {
+		return 0, err
+	}
+	batch := db.NewBatch()
+	for i, hash := range hashes {
+		number := frozen + uint64(i)
+
+		batch.Delete(headerHashKey(number))
+		batch.Delete(headerKey(hash, number))
+		batch.Delete(headerTDKey(hash, number))
+		batch.Delete(blockBodyKey(hash, number))
+		batch.Delete(blockReceiptsKey(hash, number))
+
+		if batch.ValueSize() >= haadb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return 0, err
+			}
+			batch.Reset()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return 0, err
+	}
+	return uint64(len(hashes)), nil
+}
+
+// freeze is a background thread that periodically checks the blockchain for any
+// import progress and moves the immutable chain segment into the ancient store.
+func (bc *BlockChain) freeze() {
+	defer bc.wg.Done()
+
+	for {
+		start := time.Now()
+		frozen, err := FreezeAncients(bc.db, params.ImmutabilityThreshold)
+		if err != nil {
+			log.Error("Failed to freeze ancient chain segment", "err", err)
+		} else if frozen > 0 {
+			log.Info("Moved chain segment into ancient store", "blocks", frozen, "elapsed", common.PrettyDuration(time.Since(start)))
+		}
+		// If a full batch was frozen, more might be pending, don't wait
+		delay := freezerRecheckInterval
+		if frozen == freezerBatchLimit {
+			delay = 0
+		}
+		select {
+		case <-time.After(delay):
+		case <-bc.quit:
+			return
+		}
+	}
+}
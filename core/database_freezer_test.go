@@ -0,0 +1,172 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/consensus/ethash"
+	"github.com/haachain/go-haachain/core/types"
+	"github.com/haachain/go-haachain/core/vm"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/params"
+)
+
+// Tests that the canonical chain segment below the threshold is moved into the
+// ancient store, and that it's still transparently accessible afterwards.
+func TestFreezeAncients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer-test")
+	if err != nil {
+		t.Fatalf("failed to create temporary datadir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := haadb.NewLDBDatabaseWithFreezer(filepath.Join(dir, "chaindata"), 0, 0, filepath.Join(dir, "ancient"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// Write a simple canonical chain into the key-value store
+	var (
+		blocks []*types.Block
+		parent common.Hash
+	)
+	for i := 0; i < 10; i++ {
+		block := types.NewBlockWithHeader(&types.Header{
+			Number:     big.NewInt(int64(i)),
+			ParentHash: parent,
+			Extra:      []byte("test block"),
+		})
+		receipts := types.Receipts{{GasUsed: uint64(i), Logs: []*types.Log{}}}
+
+		if err := WriteBlock(db, block); err != nil {
+			t.Fatalf("failed to write block %d: %v", i, err)
+		}
+		if err := WriteTd(db, block.Hash(), block.NumberU64(), big.NewInt(int64(i+1))); err != nil {
+			t.Fatalf("failed to write td %d: %v", i, err)
+		}
+		if err := WriteBlockReceipts(db, block.Hash(), block.NumberU64(), receipts); err != nil {
+			t.Fatalf("failed to write receipts %d: %v", i, err)
+		}
+		if err := WriteCanonicalHash(db, block.Hash(), block.NumberU64()); err != nil {
+			t.Fatalf("failed to write canonical hash %d: %v", i, err)
+		}
+		blocks, parent = append(blocks, block), block.Hash()
+	}
+	if err := WriteHeadBlockHash(db, parent); err != nil {
+		t.Fatalf("failed to write head block hash: %v", err)
+	}
+	// Freeze everything below the last 4 blocks and check the split
+	frozen, err := FreezeAncients(db, 4)
+	if err != nil {
+		t.Fatalf("failed to freeze ancients: %v", err)
+	}
+	if frozen != 5 {
+		t.Fatalf("frozen block count mismatch: have %d, want %d", frozen, 5)
+	}
+	for i, block := range blocks {
+		hash, number := block.Hash(), block.NumberU64()
+
+		if have, _ := db.Has(headerKey(hash, number)); have != (i >= 5) {
+			t.Errorf("block %d: key-value header presence mismatch: have %v, want %v", i, have, i >= 5)
+		}
+		if have := GetCanonicalHash(db, number); have != hash {
+			t.Errorf("block %d: canonical hash mismatch: have %x, want %x", i, have, hash)
+		}
+		if entry := GetBlock(db, hash, number); entry == nil || entry.Hash() != hash {
+			t.Errorf("block %d: block mismatch: have %v, want %v", i, entry, block)
+		}
+		if td := GetTd(db, hash, number); td == nil || td.Cmp(big.NewInt(int64(i+1))) != 0 {
+			t.Errorf("block %d: td mismatch: have %v, want %v", i, td, i+1)
+		}
+		if receipts := GetBlockReceipts(db, hash, number); len(receipts) != 1 || receipts[0].GasUsed != uint64(i) {
+			t.Errorf("block %d: receipts mismatch: have %v", i, receipts)
+		}
+	}
+	// Non canonical blocks must not be served from the ancient store
+	if header := GetHeader(db, common.Hash{0x01}, 0); header != nil {
+		t.Fatalf("non canonical header retrieved: %v", header)
+	}
+	// Nothing more to freeze until the chain progresses
+	if frozen, err := FreezeAncients(db, 4); err != nil || frozen != 0 {
+		t.Fatalf("repeated freezing mismatch: have %d (err %v), want 0", frozen, err)
+	}
+}
+
+// Tests that blocks and headers moved into the ancient store are still reported
+// as present by the chain, while side chain data at the same height is not.
+func TestFreezeAncientsHasBlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer-test")
+	if err != nil {
+		t.Fatalf("failed to create temporary datadir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := haadb.NewLDBDatabaseWithFreezer(filepath.Join(dir, "chaindata"), 0, 0, filepath.Join(dir, "ancient"))
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	// Import a simple canonical chain
+	gspec := &Genesis{Config: params.TestChainConfig}
+	genesis := gspec.MustCommit(db)
+	blocks, _ := GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 10, nil)
+
+	chain, err := NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	if n, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert block %d: %v", n, err)
+	}
+	chain.Stop()
+
+	// Freeze everything below the last 4 blocks and reopen the chain with cold caches
+	if frozen, err := FreezeAncients(db, 4); err != nil || frozen != 6 {
+		t.Fatalf("frozen block count mismatch: have %d (err %v), want %d", frozen, err, 6)
+	}
+	chain, err = NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to reopen blockchain: %v", err)
+	}
+	defer chain.Stop()
+
+	for _, block := range blocks {
+		hash, number := block.Hash(), block.NumberU64()
+
+		if !chain.HasBlock(hash, number) {
+			t.Errorf("block %d: block not found", number)
+		}
+		if !chain.hc.HasHeader(hash, number) {
+			t.Errorf("block %d: header not found", number)
+		}
+	}
+	// Non canonical blocks must not be reported from the ancient store
+	if chain.HasBlock(common.Hash{0x01}, 1) {
+		t.Errorf("non canonical block reported present")
+	}
+	if chain.hc.HasHeader(common.Hash{0x01}, 1) {
+		t.Errorf("non canonical header reported present")
+	}
+}
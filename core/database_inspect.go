@@ -0,0 +1,219 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/state/snapshot"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/log"
+)
+
+// Categories of data reported by InspectDatabase.
+const (
+	inspectHeaders         = "Headers"
+	inspectTds             = "Total difficulties"
+	inspectCanonicalHashes = "Canonical hashes"
+	inspectBlockNumbers    = "Block number lookups"
+	inspectBodies          = "Bodies"
+	inspectReceipts        = "Receipts"
+	inspectTxLookups       = "Transaction lookups"
+	inspectBloomBits       = "Bloombits"
+	inspectTrieNodes       = "Trie nodes and codes"
+	inspectPreimages       = "Preimages"
+	inspectSnapAccounts    = "Snapshot accounts"
+	inspectSnapStorage     = "Snapshot storage"
+	inspectMetadata        = "Metadata"
+	inspectUnknown         = "Unknown"
+)
+
+// inspectCategories is the list of all the key-value store data categories, in
+// reporting order.
+var inspectCategories = []string{
+	inspectHeaders, inspectTds, inspectCanonicalHashes, inspectBlockNumbers,
+	inspectBodies, inspectReceipts, inspectTxLookups, inspectBloomBits,
+	inspectTrieNodes, inspectPreimages, inspectSnapAccounts, inspectSnapStorage,
+	inspectMetadata, inspectUnknown,
+}
+
+// DatabaseStat is the number of entries and their total size for a category of
+// data in the chain database, optionally restricted to a range of blocks.
+type DatabaseStat struct {
+	Category string             // Category of the data (e.g. headers, trie nodes)
+	Ancient  bool               // Whether the data is stored in the ancient store
+	First    uint64             // First block of the range, if broken down by blocks
+	Last     uint64             // Last block of the range, if broken down by blocks
+	Ranged   bool               // Whether the stat covers a range of blocks only
+	Count    uint64             // Number of entries in the category (and range)
+	Size     common.StorageSize // Total size of the keys and values of the entries
+}
+
+// String implements fmt.Stringer, describing the category and range of the stat.
+func (s DatabaseStat) String() string {
+	name := s.Category
+	if s.Ancient {
+		name = "Ancient " + name
+	}
+	if s.Ranged {
+		name = fmt.Sprintf("%s #%d-#%d", name, s.First, s.Last)
+	}
+	return name
+}
+
+// inspectKey classifies a database key into its data category. For entries
+// keyed by block number, the number is also returned.
+func inspectKey(key []byte) (string, uint64, bool) {
+	switch {
+	case bytes.HasPrefix(key, headerPrefix) && len(key) == len(headerPrefix)+8+common.HashLength:
+		return inspectHeaders, binary.BigEndian.Uint64(key[len(headerPrefix):]), true
+	case bytes.HasPrefix(key, headerPrefix) && len(key) == len(headerPrefix)+8+common.HashLength+len(tdSuffix) && bytes.HasSuffix(key, tdSuffix):
+		return inspectTds, binary.BigEndian.Uint64(key[len(headerPrefix):]), true
+	case bytes.HasPrefix(key, headerPrefix) && len(key) == len(headerPrefix)+8+len(numSuffix) && bytes.HasSuffix(key, numSuffix):
+		return inspectCanonicalHashes, binary.BigEndian.Uint64(key[len(headerPrefix):]), true
+	case bytes.HasPrefix(key, blockHashPrefix) && len(key) == len(blockHashPrefix)+common.HashLength:
+		return inspectBlockNumbers, 0, false
+	case bytes.HasPrefix(key, bodyPrefix) && len(key) == len(bodyPrefix)+8+common.HashLength:
+		return inspectBodies, binary.BigEndian.Uint64(key[len(bodyPrefix):]), true
+	case bytes.HasPrefix(key, blockReceiptsPrefix) && len(key) == len(blockReceiptsPrefix)+8+common.HashLength:
+		return inspectReceipts, binary.BigEndian.Uint64(key[len(blockReceiptsPrefix):]), true
+	case bytes.HasPrefix(key, lookupPrefix) && len(key) == len(lookupPrefix)+common.HashLength:
+		return inspectTxLookups, 0, false
+	case bytes.HasPrefix(key, bloomBitsPrefix) && len(key) == len(bloomBitsPrefix)+2+8+common.HashLength:
+		return inspectBloomBits, 0, false
+	case bytes.HasPrefix(key, BloomBitsIndexPrefix):
+		return inspectBloomBits, 0, false
+	case bytes.HasPrefix(key, []byte(preimagePrefix)) && len(key) == len(preimagePrefix)+common.HashLength:
+		return inspectPreimages, 0, false
+	case len(key) == common.HashLength:
+		return inspectTrieNodes, 0, false
+	case snapshot.IsAccountKey(key):
+		return inspectSnapAccounts, 0, false
+	case snapshot.IsStorageKey(key):
+		return inspectSnapStorage, 0, false
+	case bytes.HasPrefix(key, configPrefix) || snapshot.IsMetadataKey(key):
+		return inspectMetadata, 0, false
+	}
+	for _, meta := range [][]byte{headHeaderKey, headBlockKey, headFastKey, trieSyncKey, []byte("BlockchainVersion")} {
+		if bytes.Equal(key, meta) {
+			return inspectMetadata, 0, false
+		}
+	}
+	return inspectUnknown, 0, false
+}
+
+// InspectDatabase iterates over the entries of the chain database with the
+// given key prefix, starting at the given key, and reports the number and total
+// size of the entries in each data category. If the database is backed by an
+// ancient store, the sizes of the frozen tables are reported too.
+//
+// If blockRange is non-zero, the data keyed by block number is additionally
+// broken down into ranges of the given number of blocks.
+func InspectDatabase(db haadb.Database, prefix []byte, start []byte, blockRange uint64) ([]DatabaseStat, error) {
+	var (
+		totals = make(map[string]*DatabaseStat)
+		ranges = make(map[string]map[uint64]*DatabaseStat)
+		count  uint64
+		begin  = time.Now()
+		logged = time.Now()
+	)
+	for _, category := range inspectCategories {
+		totals[category] = &DatabaseStat{Category: category}
+	}
+	it := db.NewIteratorWithPrefix(prefix)
+	if len(start) > 0 {
+		it.Release()
+		it = db.NewIteratorWithStart(append(append([]byte{}, prefix...), start...))
+	}
+	defer it.Release()
+
+	for it.Next() {
+		key := it.Key()
+		if !bytes.HasPrefix(key, prefix) {
+			break
+		}
+		size := common.StorageSize(len(key) + len(it.Value()))
+		category, number, numbered := inspectKey(key)
+
+		totals[category].Count++
+		totals[category].Size += size
+
+		if numbered && blockRange > 0 {
+			if ranges[category] == nil {
+				ranges[category] = make(map[uint64]*DatabaseStat)
+			}
+			bucket := number / blockRange
+			stat := ranges[category][bucket]
+			if stat == nil {
+				stat = &DatabaseStat{Category: category, First: bucket * blockRange, Last: (bucket+1)*blockRange - 1, Ranged: true}
+				ranges[category][bucket] = stat
+			}
+			stat.Count++
+			stat.Size += size
+		}
+		count++
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Inspecting database", "count", count, "key", fmt.Sprintf("%x", key), "elapsed", common.PrettyDuration(time.Since(begin)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return nil, err
+	}
+	log.Info("Inspected database", "count", count, "elapsed", common.PrettyDuration(time.Since(begin)))
+
+	// Assemble the report, block range breakdowns following their totals
+	var stats []DatabaseStat
+	for _, category := range inspectCategories {
+		stats = append(stats, *totals[category])
+		if buckets := ranges[category]; len(buckets) > 0 {
+			ranged := make([]DatabaseStat, 0, len(buckets))
+			for _, stat := range buckets {
+				ranged = append(ranged, *stat)
+			}
+			sort.Slice(ranged, func(i, j int) bool { return ranged[i].First < ranged[j].First })
+			stats = append(stats, ranged...)
+		}
+	}
+	// Append the ancient store statistics if the chain data is partially frozen
+	if store, ok := db.(haadb.AncientReader); ok {
+		if frozen, err := store.Ancients(); err == nil {
+			for _, table := range []struct {
+				kind     string
+				category string
+			}{
+				{haadb.FreezerHeaderTable, inspectHeaders},
+				{haadb.FreezerDifficultyTable, inspectTds},
+				{haadb.FreezerHashTable, inspectCanonicalHashes},
+				{haadb.FreezerBodiesTable, inspectBodies},
+				{haadb.FreezerReceiptTable, inspectReceipts},
+			} {
+				size, err := store.AncientSize(table.kind)
+				if err != nil {
+					return nil, err
+				}
+				stats = append(stats, DatabaseStat{Category: table.category, Ancient: true, Count: frozen, Size: common.StorageSize(size)})
+			}
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/types"
+	"github.com/haachain/go-haachain/haadb"
+)
+
+// Tests that database inspection assigns the entries to the correct categories
+// and block ranges.
+func TestInspectDatabase(t *testing.T) {
+	db, _ := haadb.NewMemDatabase()
+
+	for i := uint64(0); i < 10; i++ {
+		header := &types.Header{Number: new(big.Int).SetUint64(i), Extra: []byte("test header")}
+		WriteHeader(db, header)
+		WriteCanonicalHash(db, header.Hash(), i)
+		WriteTd(db, header.Hash(), i, big.NewInt(1))
+		WriteBody(db, header.Hash(), i, &types.Body{})
+	}
+	WriteHeadBlockHash(db, common.Hash{1})
+	db.Put(common.Hash{2}.Bytes(), []byte{0x80}) // trie node
+	db.Put([]byte("junk"), []byte{0x01})
+
+	stats, err := InspectDatabase(db, nil, nil, 4)
+	if err != nil {
+		t.Fatalf("failed to inspect database: %v", err)
+	}
+	totals := make(map[string]uint64)
+	ranges := make(map[string][]uint64)
+	for _, stat := range stats {
+		if stat.Ranged {
+			ranges[stat.Category] = append(ranges[stat.Category], stat.Count)
+			continue
+		}
+		totals[stat.Category] = stat.Count
+	}
+	for category, want := range map[string]uint64{
+		inspectHeaders:         10,
+		inspectCanonicalHashes: 10,
+		inspectTds:             10,
+		inspectBodies:          10,
+		inspectBlockNumbers:    10,
+		inspectTrieNodes:       1,
+		inspectMetadata:        1,
+		inspectUnknown:         1,
+		inspectReceipts:        0,
+	} {
+		if totals[category] != want {
+			t.Errorf("%s: item count mismatch: have %d, want %d", category, totals[category], want)
+		}
+	}
+	if have := ranges[inspectHeaders]; len(have) != 3 || have[0] != 4 || have[1] != 4 || have[2] != 2 {
+		t.Errorf("header ranges mismatch: have %v, want [4 4 2]", have)
+	}
+	// Inspecting a prefix should only report the matching entries
+	stats, err = InspectDatabase(db, bodyPrefix, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to inspect database prefix: %v", err)
+	}
+	for _, stat := range stats {
+		if stat.Category != inspectBodies && stat.Count != 0 {
+			t.Errorf("%s: unexpected items in prefix inspection: %d", stat.Category, stat.Count)
+		}
+	}
+}
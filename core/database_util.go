@@ -90,9 +90,47 @@ func encodeBlockNumber(number uint64) []byte {
 	return enc
 }
 
+// readAncient retrieves an item of the given kind from the ancient store, if
+// the database is backed by one and the block was already migrated into it.
+func readAncient(db DatabaseReader, kind string, number uint64) []byte {
+	if adb, ok := db.(haadb.AncientReader); ok {
+		if data, err := adb.Ancient(kind, number); err == nil {
+			return data
+		}
+	}
+	return nil
+}
+
+// readAncientBlock retrieves an item of the given kind from the ancient store,
+// if the block with the given hash was already migrated into it. Only canonical
+// blocks are migrated, so the item is returned only if the hash matches.
+func readAncientBlock(db DatabaseReader, kind string, hash common.Hash, number uint64) []byte {
+	if data := readAncient(db, haadb.FreezerHashTable, number); !bytes.Equal(data, hash[:]) {
+		return nil
+	}
+	return readAncient(db, kind, number)
+}
+
+// hasAncientBlock checks if an item of the given kind is present in the ancient
+// store for the block with the given hash.
+func hasAncientBlock(db DatabaseReader, kind string, hash common.Hash, number uint64) bool {
+	adb, ok := db.(haadb.AncientReader)
+	if !ok {
+		return false
+	}
+	if data := readAncient(db, haadb.FreezerHashTable, number); !bytes.Equal(data, hash[:]) {
+		return false
+	}
+	has, _ := adb.HasAncient(kind, number)
+	return has
+}
+
 // GetCanonicalHash retrieves a hash assigned to a canonical block number.
 func GetCanonicalHash(db DatabaseReader, number uint64) common.Hash {
-	data, _ := db.Get(append(append(headerPrefix, encodeBlockNumber(number)...), numSuffix...))
+	data, _ := db.Get(headerHashKey(number))
+	if len(data) == 0 {
+		data = readAncient(db, haadb.FreezerHashTable, number)
+	}
 	if len(data) == 0 {
 		return common.Hash{}
 	}
@@ -161,6 +199,9 @@ func GetTrieSyncProgress(db DatabaseReader) uint64 {
 // if the header's not found.
 func GetHeaderRLP(db DatabaseReader, hash common.Hash, number uint64) rlp.RawValue {
 	data, _ := db.Get(headerKey(hash, number))
+	if len(data) == 0 {
+		data = readAncientBlock(db, haadb.FreezerHeaderTable, hash, number)
+	}
 	return data
 }
 
@@ -182,6 +223,9 @@ func GetHeader(db DatabaseReader, hash common.Hash, number uint64) *types.Header
 // GetBodyRLP retrieves the block body (transactions and uncles) in RLP encoding.
 func GetBodyRLP(db DatabaseReader, hash common.Hash, number uint64) rlp.RawValue {
 	data, _ := db.Get(blockBodyKey(hash, number))
+	if len(data) == 0 {
+		data = readAncientBlock(db, haadb.FreezerBodiesTable, hash, number)
+	}
 	return data
 }
 
@@ -189,10 +233,22 @@ func headerKey(hash common.Hash, number uint64) []byte {
 	return append(append(headerPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
 }
 
+func headerHashKey(number uint64) []byte {
+	return append(append(headerPrefix, encodeBlockNumber(number)...), numSuffix...)
+}
+
+func headerTDKey(hash common.Hash, number uint64) []byte {
+	return append(headerKey(hash, number), tdSuffix...)
+}
+
 func blockBodyKey(hash common.Hash, number uint64) []byte {
 	return append(append(bodyPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
 }
 
+func blockReceiptsKey(hash common.Hash, number uint64) []byte {
+	return append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+}
+
 // GetBody retrieves the block body (transactons, uncles) corresponding to the
 // hash, nil if none found.
 func GetBody(db DatabaseReader, hash common.Hash, number uint64) *types.Body {
@@ -211,7 +267,10 @@ func GetBody(db DatabaseReader, hash common.Hash, number uint64) *types.Body {
 // GetTd retrieves a block's total difficulty corresponding to the hash, nil if
 // none found.
 func GetTd(db DatabaseReader, hash common.Hash, number uint64) *big.Int {
-	data, _ := db.Get(append(append(append(headerPrefix, encodeBlockNumber(number)...), hash[:]...), tdSuffix...))
+	data, _ := db.Get(headerTDKey(hash, number))
+	if len(data) == 0 {
+		data = readAncientBlock(db, haadb.FreezerDifficultyTable, hash, number)
+	}
 	if len(data) == 0 {
 		return nil
 	}
@@ -246,7 +305,10 @@ func GetBlock(db DatabaseReader, hash common.Hash, number uint64) *types.Block {
 // GetBlockReceipts retrieves the receipts generated by the transactions included
 // in a block given by its hash.
 func GetBlockReceipts(db DatabaseReader, hash common.Hash, number uint64) types.Receipts {
-	data, _ := db.Get(append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash[:]...))
+	data, _ := db.Get(blockReceiptsKey(hash, number))
+	if len(data) == 0 {
+		data = readAncientBlock(db, haadb.FreezerReceiptTable, hash, number)
+	}
 	if len(data) == 0 {
 		return nil
 	}
@@ -49,6 +49,12 @@ func (gp *GasPool) Gas() uint64 {
 	return uint64(*gp)
 }
 
+// SetGas sets the amount of gas remaining in the pool, allowing the gas used by
+// a failed transaction to be rolled back.
+func (gp *GasPool) SetGas(gas uint64) {
+	*(*uint64)(gp) = gas
+}
+
 func (gp *GasPool) String() string {
 	return fmt.Sprintf("%d", *gp)
 }
@@ -368,8 +368,10 @@ func (hc *HeaderChain) HasHeader(hash common.Hash, number uint64) bool {
 	if hc.numberCache.Contains(hash) || hc.headerCache.Contains(hash) {
 		return true
 	}
-	ok, _ := hc.chainDb.Has(headerKey(hash, number))
-	return ok
+	if ok, _ := hc.chainDb.Has(headerKey(hash, number)); ok {
+		return true
+	}
+	return hasAncientBlock(hc.chainDb, haadb.FreezerHeaderTable, hash, number)
 }
 
 // GetHeaderByNumber retrieves a block header from the database by number,
@@ -21,6 +21,7 @@ import (
 	"sync"
 
 	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/state/snapshot"
 	"github.com/haachain/go-haachain/haadb"
 	"github.com/haachain/go-haachain/trie"
 	lru "github.com/hashicorp/golang-lru"
@@ -83,6 +84,29 @@ func NewDatabase(db haadb.Database) Database {
 	}
 }
 
+// NewDatabaseWithSnapshots wraps a state database with a flat state snapshot
+// tree, which the state objects opened from it use to accelerate reads and
+// update with the changes they commit.
+func NewDatabaseWithSnapshots(db Database, snaps *snapshot.Tree) Database {
+	return &snapshotDB{Database: db, snaps: snaps}
+}
+
+// snapshotter is implemented by state databases backed by a snapshot tree.
+type snapshotter interface {
+	Snapshots() *snapshot.Tree
+}
+
+// snapshotDB is a state database backed by a flat state snapshot tree.
+type snapshotDB struct {
+	Database
+	snaps *snapshot.Tree
+}
+
+// Snapshots retrieves the state snapshot tree backing the database.
+func (db *snapshotDB) Snapshots() *snapshot.Tree {
+	return db.snaps
+}
+
 type cachingDB struct {
 	db            *trie.Database
 	mu            sync.Mutex
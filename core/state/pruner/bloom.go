@@ -0,0 +1,69 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/haachain/go-haachain/common"
+)
+
+// stateBloomHashes is the number of hash functions used by the state bloom.
+// Since the keys inserted are all Keccak256 hashes, the independent 8 byte
+// chunks of a key are used as the individual hash functions.
+const stateBloomHashes = 4
+
+// stateBloom is a fixed size bloom filter used to mark the trie nodes and
+// contract codes reachable from the retained state roots. False positives
+// only mean that some dangling nodes survive the pruning, which is harmless;
+// false negatives are impossible, so no live node is ever deleted.
+type stateBloom struct {
+	bits []uint64
+	size uint64 // number of bits in the filter
+}
+
+// newStateBloom creates a bloom filter occupying the given number of megabytes.
+func newStateBloom(megabytes uint64) (*stateBloom, error) {
+	if megabytes == 0 {
+		return nil, errors.New("zero sized state bloom")
+	}
+	size := megabytes * 1024 * 1024 * 8
+	return &stateBloom{
+		bits: make([]uint64, size/64),
+		size: size,
+	}, nil
+}
+
+// add inserts a hash into the bloom filter.
+func (b *stateBloom) add(hash common.Hash) {
+	for i := 0; i < stateBloomHashes; i++ {
+		bit := binary.BigEndian.Uint64(hash[i*8:]) % b.size
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// contains reports whether the given hash may be present in the filter.
+func (b *stateBloom) contains(hash []byte) bool {
+	for i := 0; i < stateBloomHashes; i++ {
+		bit := binary.BigEndian.Uint64(hash[i*8:]) % b.size
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
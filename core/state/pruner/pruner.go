@@ -0,0 +1,169 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package pruner implements offline pruning of stale state trie nodes.
+package pruner
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/state"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/log"
+)
+
+// ErrMissingRoot is returned if a state root requested to be retained is not
+// present in the database.
+var ErrMissingRoot = errors.New("state root not found in database")
+
+// Pruner is an offline tool to delete every state trie node and contract code
+// from the database that is not reachable from a set of retained state roots.
+//
+// The pruning runs in two phases. First all the tries of the retained roots
+// are iterated and every node and contract code hash is marked in a fixed
+// size bloom filter. Afterwards the entire database is iterated and every
+// trie node or contract code that is not marked in the filter is deleted.
+// Since the filter has a bounded size, the pruning runs in bounded memory
+// regardless of the size of the state.
+//
+// The pruner must not be used while the database is open by a running node.
+type Pruner struct {
+	db    haadb.Database
+	bloom *stateBloom
+}
+
+// NewPruner creates a state pruner operating on the given database, marking
+// the live state in a bloom filter of the given size in megabytes.
+func NewPruner(db haadb.Database, bloomSize uint64) (*Pruner, error) {
+	bloom, err := newStateBloom(bloomSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Pruner{db: db, bloom: bloom}, nil
+}
+
+// Prune deletes all the trie nodes and contract codes from the database which
+// are not reachable from any of the given state roots.
+func (p *Pruner) Prune(roots []common.Hash) error {
+	if len(roots) == 0 {
+		return errors.New("no state roots to retain")
+	}
+	for _, root := range roots {
+		if ok, _ := p.db.Has(root[:]); !ok {
+			return fmt.Errorf("%v: %x", ErrMissingRoot, root)
+		}
+	}
+	start := time.Now()
+	for _, root := range roots {
+		if err := p.mark(root); err != nil {
+			return err
+		}
+	}
+	log.Info("Marked live state", "roots", len(roots), "elapsed", common.PrettyDuration(time.Since(start)))
+
+	if err := p.sweep(); err != nil {
+		return err
+	}
+	// Compact the database to actually reclaim the freed disk space
+	cstart := time.Now()
+	log.Info("Compacting database to release disk space")
+	if err := p.db.Compact(nil, nil); err != nil {
+		return err
+	}
+	log.Info("Database compaction finished", "elapsed", common.PrettyDuration(time.Since(cstart)))
+	log.Info("State pruning successful", "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// mark iterates the entire state trie of the given root, including all the
+// storage tries and contract codes, and inserts every node hash into the bloom.
+func (p *Pruner) mark(root common.Hash) error {
+	statedb, err := state.New(root, state.NewDatabase(p.db))
+	if err != nil {
+		return err
+	}
+	var (
+		nodes  int
+		start  = time.Now()
+		logged = time.Now()
+	)
+	it := state.NewNodeIterator(statedb)
+	for it.Next() {
+		// Nodes embedded in their parents have no hash, skip them
+		if it.Hash == (common.Hash{}) {
+			continue
+		}
+		p.bloom.add(it.Hash)
+		nodes++
+
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Marking live state", "root", root, "nodes", nodes, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if it.Error != nil {
+		return it.Error
+	}
+	log.Info("Marked state root", "root", root, "nodes", nodes, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// sweep iterates over the entire database and deletes all the trie nodes and
+// contract codes which were not marked as live.
+func (p *Pruner) sweep() error {
+	var (
+		count  int
+		size   common.StorageSize
+		start  = time.Now()
+		logged = time.Now()
+		batch  = p.db.NewBatch()
+	)
+	it := p.db.NewIteratorWithStart(nil)
+	defer it.Release()
+
+	for it.Next() {
+		// Trie nodes and contract codes are the only entries keyed by a plain hash
+		key := it.Key()
+		if len(key) != common.HashLength || p.bloom.contains(key) {
+			continue
+		}
+		count++
+		size += common.StorageSize(len(key) + len(it.Value()))
+		batch.Delete(key)
+
+		if batch.ValueSize() >= haadb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Pruning stale state", "nodes", count, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	log.Info("Pruned stale state", "nodes", count, "size", size, "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
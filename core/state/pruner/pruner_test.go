@@ -0,0 +1,91 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pruner
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/state"
+	"github.com/haachain/go-haachain/haadb"
+)
+
+// commitState applies the given balance changes on top of the parent root and
+// flushes the resulting state to disk.
+func commitState(t *testing.T, db haadb.Database, parent common.Hash, balances map[byte]int64) common.Hash {
+	sdb := state.NewDatabase(db)
+	statedb, err := state.New(parent, sdb)
+	if err != nil {
+		t.Fatalf("failed to open state %x: %v", parent, err)
+	}
+	for addr, balance := range balances {
+		statedb.SetBalance(common.BytesToAddress([]byte{addr}), big.NewInt(balance))
+		statedb.Sehaaate(common.BytesToAddress([]byte{addr}), common.Hash{addr}, common.Hash{byte(balance)})
+	}
+	root, err := statedb.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	if err := sdb.TrieDB().Commit(root, false); err != nil {
+		t.Fatalf("failed to flush state: %v", err)
+	}
+	return root
+}
+
+// Tests that pruning deletes the nodes only reachable from stale roots, but
+// retains every node of the live ones.
+func TestPrune(t *testing.T) {
+	db, _ := haadb.NewMemDatabase()
+
+	balances := make(map[byte]int64)
+	for i := byte(0); i < 64; i++ {
+		balances[i] = int64(i) + 1
+	}
+	stale := commitState(t, db, common.Hash{}, balances)
+
+	for i := byte(0); i < 64; i += 2 {
+		balances[i] = int64(i) + 100
+	}
+	live := commitState(t, db, stale, balances)
+
+	pruner, err := NewPruner(db, 1)
+	if err != nil {
+		t.Fatalf("failed to create pruner: %v", err)
+	}
+	if err := pruner.Prune([]common.Hash{live}); err != nil {
+		t.Fatalf("failed to prune state: %v", err)
+	}
+	// The live state must be fully iterable, the stale one must be gone
+	statedb, err := state.New(live, state.NewDatabase(db))
+	if err != nil {
+		t.Fatalf("failed to open live state: %v", err)
+	}
+	it := state.NewNodeIterator(statedb)
+	for it.Next() {
+	}
+	if it.Error != nil {
+		t.Fatalf("live state corrupted after pruning: %v", it.Error)
+	}
+	if ok, _ := db.Has(stale[:]); ok {
+		t.Fatalf("stale state root not pruned")
+	}
+	// Retaining missing roots must be rejected
+	if err := pruner.Prune([]common.Hash{stale}); err == nil {
+		t.Fatalf("pruning with missing root succeeded")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/log"
+)
+
+var (
+	snapshotRootKey      = []byte("SnapshotRoot")      // snapshotRootKey tracks the state root the disk snapshot belongs to
+	snapshotGeneratorKey = []byte("SnapshotGenerator") // snapshotGeneratorKey tracks the progress of the snapshot generation
+
+	snapshotAccountPrefix = []byte("a") // snapshotAccountPrefix + account hash -> account RLP
+	snapshotStoragePrefix = []byte("o") // snapshotStoragePrefix + account hash + storage hash -> storage value
+)
+
+// accountSnapshotKey = snapshotAccountPrefix + hash
+func accountSnapshotKey(hash common.Hash) []byte {
+	return append(append([]byte{}, snapshotAccountPrefix...), hash.Bytes()...)
+}
+
+// storageSnapshotKey = snapshotStoragePrefix + account hash + storage hash
+func storageSnapshotKey(accountHash, storageHash common.Hash) []byte {
+	return append(append(append([]byte{}, snapshotStoragePrefix...), accountHash.Bytes()...), storageHash.Bytes()...)
+}
+
+// storageSnapshotsKey = snapshotStoragePrefix + account hash
+func storageSnapshotsKey(accountHash common.Hash) []byte {
+	return append(append([]byte{}, snapshotStoragePrefix...), accountHash.Bytes()...)
+}
+
+// IsAccountKey reports whether a database key is a snapshot account entry.
+func IsAccountKey(key []byte) bool {
+	return len(key) == len(snapshotAccountPrefix)+common.HashLength && bytes.HasPrefix(key, snapshotAccountPrefix)
+}
+
+// IsStorageKey reports whether a database key is a snapshot storage entry.
+func IsStorageKey(key []byte) bool {
+	return len(key) == len(snapshotStoragePrefix)+2*common.HashLength && bytes.HasPrefix(key, snapshotStoragePrefix)
+}
+
+// IsMetadataKey reports whether a database key holds snapshot metadata.
+func IsMetadataKey(key []byte) bool {
+	return bytes.Equal(key, snapshotRootKey) || bytes.Equal(key, snapshotGeneratorKey)
+}
+
+// readSnapshotRoot retrieves the root of the block whose state is contained in
+// the persisted snapshot.
+func readSnapshotRoot(db haadb.Database) common.Hash {
+	data, _ := db.Get(snapshotRootKey)
+	if len(data) != common.HashLength {
+		return common.Hash{}
+	}
+	return common.BytesToHash(data)
+}
+
+// writeSnapshotRoot stores the root of the block whose state is contained in
+// the persisted snapshot.
+func writeSnapshotRoot(db haadb.Putter, root common.Hash) {
+	if err := db.Put(snapshotRootKey, root[:]); err != nil {
+		log.Crit("Failed to store snapshot root", "err", err)
+	}
+}
+
+// readSnapshotGenerator retrieves the account hash marker up to which the
+// snapshot was generated, along with whether the generation is in progress.
+func readSnapshotGenerator(db haadb.Database) ([]byte, bool) {
+	if ok, _ := db.Has(snapshotGeneratorKey); !ok {
+		return nil, false
+	}
+	data, _ := db.Get(snapshotGeneratorKey)
+	return append([]byte{}, data...), true
+}
+
+// writeSnapshotGenerator stores the account hash marker up to which the
+// snapshot was generated.
+func writeSnapshotGenerator(db haadb.Putter, marker []byte) {
+	if err := db.Put(snapshotGeneratorKey, marker); err != nil {
+		log.Crit("Failed to store snapshot generator", "err", err)
+	}
+}
+
+// deleteSnapshotGenerator removes the snapshot generation marker, signalling
+// that the persisted snapshot is complete.
+func deleteSnapshotGenerator(db haadb.Deleter) {
+	if err := db.Delete(snapshotGeneratorKey); err != nil {
+		log.Crit("Failed to remove snapshot generator", "err", err)
+	}
+}
+
+// iterateKeys invokes the callback for every key in the database starting with
+// the given prefix. The callback must not modify the database directly.
+func iterateKeys(db haadb.Database, prefix []byte, fn func(key []byte)) error {
+	it := db.NewIteratorWithPrefix(prefix)
+	defer it.Release()
+
+	for it.Next() {
+		fn(common.CopyBytes(it.Key()))
+	}
+	return it.Error()
+}
+
+// wipeSnapshot deletes all the persisted snapshot entries with the given prefix.
+func wipeSnapshot(db haadb.Database, prefix []byte) error {
+	batch := db.NewBatch()
+	err := iterateKeys(db, prefix, func(key []byte) {
+		batch.Delete(key)
+		if batch.ValueSize() >= haadb.IdealBatchSize {
+			batch.Write()
+			batch.Reset()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return batch.Write()
+}
@@ -0,0 +1,222 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/log"
+	"github.com/haachain/go-haachain/trie"
+	"github.com/hashicorp/golang-lru"
+)
+
+// diskLayer is a low level persistent snapshot built on top of a key-value store.
+type diskLayer struct {
+	diskdb haadb.Database // Key-value store containing the base snapshot
+	triedb *trie.Database // Trie node cache for reconstruction purposes
+	cache  *lru.Cache     // Cache to avoid hitting the disk for direct access
+
+	root  common.Hash // Root hash of the base snapshot
+	stale bool        // Signals that the layer became stale (state progressed)
+
+	genMarker []byte // Marker for the state that's indexed during initial layer generation
+	lock      sync.RWMutex
+}
+
+// newDiskLayer creates a disk layer for the given state root, caching the given
+// number of recently accessed entries in memory.
+func newDiskLayer(diskdb haadb.Database, triedb *trie.Database, cache int, root common.Hash) *diskLayer {
+	if cache < 1 {
+		cache = 1
+	}
+	lcache, _ := lru.New(cache)
+	return &diskLayer{
+		diskdb: diskdb,
+		triedb: triedb,
+		cache:  lcache,
+		root:   root,
+	}
+}
+
+// Root returns root hash for which this snapshot was made.
+func (dl *diskLayer) Root() common.Hash {
+	return dl.root
+}
+
+// Parent always returns nil as there's no layer below the disk.
+func (dl *diskLayer) Parent() snapshot {
+	return nil
+}
+
+// Stale return whether this layer has become stale (was flattened across) or if
+// it's still live.
+func (dl *diskLayer) Stale() bool {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	return dl.stale
+}
+
+// AccountRLP directly retrieves the account RLP associated with a particular
+// hash in the snapshot slim data format.
+func (dl *diskLayer) AccountRLP(hash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if err := dl.covered(hash); err != nil {
+		return nil, err
+	}
+	return dl.get(accountSnapshotKey(hash)), nil
+}
+
+// Storage directly retrieves the storage data associated with a particular hash,
+// within a particular account.
+func (dl *diskLayer) Storage(accountHash, storageHash common.Hash) ([]byte, error) {
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+
+	if err := dl.covered(accountHash); err != nil {
+		return nil, err
+	}
+	return dl.get(storageSnapshotKey(accountHash, storageHash)), nil
+}
+
+// covered checks whether the layer is still live and the given account was
+// already indexed by the snapshot generator. The caller must hold the lock.
+func (dl *diskLayer) covered(accountHash common.Hash) error {
+	if dl.stale {
+		return ErrSnapshotStale
+	}
+	if dl.genMarker != nil && bytes.Compare(accountHash[:], dl.genMarker) > 0 {
+		return ErrNotCoveredYet
+	}
+	return nil
+}
+
+// get retrieves a snapshot entry from the cache or the database, returning nil
+// for missing entries.
+func (dl *diskLayer) get(key []byte) []byte {
+	if blob, found := dl.cache.Get(string(key)); found {
+		return blob.([]byte)
+	}
+	blob, err := dl.diskdb.Get(key)
+	if err != nil || len(blob) == 0 {
+		blob = nil
+	}
+	dl.cache.Add(string(key), blob)
+	return blob
+}
+
+// Update creates a new layer on top of the existing snapshot diff tree with
+// the specified data items. Note, the maps are retained by the method to avoid
+// copying everything.
+func (dl *diskLayer) Update(blockHash common.Hash, destructs map[common.Hash]struct{}, accounts map[common.Hash][]byte, storage map[common.Hash]map[common.Hash][]byte) *diffLayer {
+	return newDiffLayer(dl, blockHash, destructs, accounts, storage)
+}
+
+// diffToDisk merges a bottom-most diff into the persistent disk layer underneath
+// it, returning a new disk layer and marking the old one stale.
+func diffToDisk(base *diskLayer, bottom *diffLayer) *diskLayer {
+	base.lock.Lock()
+	defer base.lock.Unlock()
+
+	bottom.lock.RLock()
+	defer bottom.lock.RUnlock()
+
+	base.stale = true
+	bottom.markStale()
+
+	// Accounts beyond the generation marker are not yet in the snapshot, the
+	// generator will pick up their latest state from the tries when reaching them
+	marker := base.genMarker
+	uncovered := func(hash common.Hash) bool {
+		return marker != nil && bytes.Compare(hash[:], marker) > 0
+	}
+	batch := base.diskdb.NewBatch()
+	writeSnapshotRoot(batch, bottom.root)
+
+	flush := func() {
+		if batch.ValueSize() >= haadb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				log.Crit("Failed to write state snapshot", "err", err)
+			}
+			batch.Reset()
+		}
+	}
+	// Delete all the destructed accounts along with their storage
+	for hash := range bottom.destructSet {
+		if uncovered(hash) {
+			continue
+		}
+		key := accountSnapshotKey(hash)
+		batch.Delete(key)
+		base.cache.Remove(string(key))
+
+		err := iterateKeys(base.diskdb, storageSnapshotsKey(hash), func(key []byte) {
+			batch.Delete(key)
+			base.cache.Remove(string(key))
+			flush()
+		})
+		if err != nil {
+			log.Crit("Failed to wipe destructed storage snapshot", "err", err)
+		}
+		flush()
+	}
+	// Push all updated accounts into the database
+	for hash, data := range bottom.accountData {
+		if uncovered(hash) {
+			continue
+		}
+		key := accountSnapshotKey(hash)
+		if len(data) > 0 {
+			batch.Put(key, data)
+		} else {
+			batch.Delete(key)
+		}
+		base.cache.Add(string(key), data)
+		flush()
+	}
+	// Push all the storage slots into the database
+	for accountHash, storage := range bottom.storageData {
+		if uncovered(accountHash) {
+			continue
+		}
+		for storageHash, data := range storage {
+			key := storageSnapshotKey(accountHash, storageHash)
+			if len(data) > 0 {
+				batch.Put(key, data)
+			} else {
+				batch.Delete(key)
+			}
+			base.cache.Add(string(key), data)
+			flush()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to write state snapshot", "err", err)
+	}
+	return &diskLayer{
+		diskdb:    base.diskdb,
+		triedb:    base.triedb,
+		cache:     base.cache,
+		root:      bottom.root,
+		genMarker: base.genMarker,
+	}
+}
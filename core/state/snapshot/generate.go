@@ -0,0 +1,189 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/log"
+	"github.com/haachain/go-haachain/rlp"
+	"github.com/haachain/go-haachain/trie"
+)
+
+var (
+	// emptyRoot is the known root hash of an empty trie.
+	emptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+	// generatorRetryDelay is the time to wait before retrying the generation if
+	// the state trie of the disk layer is not available.
+	generatorRetryDelay = time.Second
+)
+
+// account is the consensus representation of accounts, used to resolve the
+// storage roots during snapshot generation.
+type account struct {
+	Nonce    uint64
+	Balance  *big.Int
+	Root     common.Hash
+	CodeHash []byte
+}
+
+// generate is a background thread that iterates over the state and storage
+// tries of the current disk layer, constructing the flat state snapshot. Each
+// chunk of accounts is written atomically along with the progress marker, so
+// the generation can be resumed after a restart.
+func (t *Tree) generate() {
+	defer close(t.genDone)
+
+	var (
+		start    = time.Now()
+		logged   = time.Now()
+		accounts int
+		slots    int
+	)
+	dl := t.disklayer()
+	if len(dl.genMarker) == 0 {
+		for _, prefix := range [][]byte{snapshotAccountPrefix, snapshotStoragePrefix} {
+			if err := wipeSnapshot(t.diskdb, prefix); err != nil {
+				log.Error("Failed to wipe stale snapshot", "err", err)
+				return
+			}
+		}
+	}
+	for {
+		dl = t.disklayer()
+
+		dl.lock.RLock()
+		marker := common.CopyBytes(dl.genMarker)
+		dl.lock.RUnlock()
+
+		done, naccs, nslots, err := t.generateChunk(dl, marker)
+		switch {
+		case err == errGeneratorAborted:
+			log.Info("Aborted snapshot generation", "accounts", accounts, "slots", slots, "elapsed", common.PrettyDuration(time.Since(start)))
+			return
+
+		case err != nil:
+			// The state of the disk layer is probably not available (yet or any
+			// more), wait for the chain to progress and retry on the new layer
+			log.Debug("Snapshot generation interrupted", "root", dl.root, "err", err)
+			select {
+			case <-t.genAbort:
+				return
+			case <-time.After(generatorRetryDelay):
+			}
+			continue
+		}
+		accounts += naccs
+		slots += nslots
+
+		if done {
+			log.Info("Generated state snapshot", "accounts", accounts, "slots", slots, "elapsed", common.PrettyDuration(time.Since(start)))
+			return
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Generating state snapshot", "accounts", accounts, "slots", slots, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+}
+
+// generateChunk indexes a batch of accounts following the given marker from the
+// state trie of the given disk layer. If the layer became stale in the meantime,
+// the chunk is discarded. It returns whether the generation finished.
+func (t *Tree) generateChunk(dl *diskLayer, marker []byte) (bool, int, int, error) {
+	accTrie, err := trie.NewSecure(dl.root, t.triedb, 0)
+	if err != nil {
+		return false, 0, 0, err
+	}
+	var (
+		batch = t.diskdb.NewBatch()
+		last  []byte
+		accs  int
+		slots int
+		done  = true
+	)
+	it := trie.NewIterator(accTrie.NodeIterator(marker))
+	for it.Next() {
+		// The marker is the last indexed account, skip it when resuming
+		if len(marker) > 0 && bytes.Equal(it.Key, marker) {
+			continue
+		}
+		if batch.ValueSize() >= haadb.IdealBatchSize {
+			done = false
+			break
+		}
+		select {
+		case <-t.genAbort:
+			return false, 0, 0, errGeneratorAborted
+		default:
+		}
+		var acc account
+		if err := rlp.DecodeBytes(it.Value, &acc); err != nil {
+			return false, 0, 0, fmt.Errorf("invalid account %x: %v", it.Key, err)
+		}
+		accountHash := common.BytesToHash(it.Key)
+		batch.Put(accountSnapshotKey(accountHash), common.CopyBytes(it.Value))
+
+		if acc.Root != emptyRoot {
+			storeTrie, err := trie.NewSecure(acc.Root, t.triedb, 0)
+			if err != nil {
+				return false, 0, 0, err
+			}
+			storeIt := trie.NewIterator(storeTrie.NodeIterator(nil))
+			for storeIt.Next() {
+				batch.Put(storageSnapshotKey(accountHash, common.BytesToHash(storeIt.Key)), common.CopyBytes(storeIt.Value))
+				slots++
+			}
+			if storeIt.Err != nil {
+				return false, 0, 0, storeIt.Err
+			}
+		}
+		last = common.CopyBytes(it.Key)
+		accs++
+	}
+	if it.Err != nil {
+		return false, 0, 0, it.Err
+	}
+	// Persist the chunk only if the disk layer is still the live one, otherwise
+	// the state of some accounts might have changed since they were read
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+
+	if dl.stale {
+		return false, 0, 0, nil
+	}
+	if done {
+		deleteSnapshotGenerator(batch)
+	} else {
+		writeSnapshotGenerator(batch, last)
+	}
+	if err := batch.Write(); err != nil {
+		log.Crit("Failed to write state snapshot", "err", err)
+	}
+	if done {
+		dl.genMarker = nil
+	} else {
+		dl.genMarker = last
+	}
+	return done, accs, slots, nil
+}
@@ -0,0 +1,327 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package snapshot implements a flat, hash keyed view of the account and storage
+// state, used to accelerate state reads without traversing the tries.
+//
+// The snapshot is organised as a tree of layers. The bottom one is the disk layer,
+// persisted into the database and corresponding to a single state root. On top
+// of it, every recently imported block adds an in-memory diff layer containing
+// only the accounts and storage slots modified by it. Diff layers may fork into
+// multiple branches to support chain reorganisations, and the oldest ones are
+// periodically flattened into the disk layer to keep memory use bounded.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/log"
+	"github.com/haachain/go-haachain/trie"
+)
+
+var (
+	// ErrSnapshotStale is returned from data accessors if the underlying snapshot
+	// layer had been invalidated due to the chain progressing forward far enough
+	// to not maintain the layer's original state.
+	ErrSnapshotStale = errors.New("snapshot stale")
+
+	// ErrNotCoveredYet is returned from data accessors if the underlying snapshot
+	// is being generated currently and the requested data item is not yet in the
+	// range of accounts covered.
+	ErrNotCoveredYet = errors.New("not covered yet")
+
+	// errSnapshotCycle is returned if a snapshot is attempted to be inserted
+	// that forms a cycle in the snapshot tree.
+	errSnapshotCycle = errors.New("snapshot cycle")
+
+	// errGeneratorAborted is returned if the snapshot generation was aborted.
+	errGeneratorAborted = errors.New("snapshot generation aborted")
+)
+
+// Snapshot represents the functionality supported by a snapshot storage layer.
+type Snapshot interface {
+	// Root returns the state root for which this snapshot was made.
+	Root() common.Hash
+
+	// AccountRLP directly retrieves the consensus RLP encoding of the account
+	// associated with a particular hash in the snapshot. A nil result with no
+	// error means the account does not exist.
+	AccountRLP(hash common.Hash) ([]byte, error)
+
+	// Storage directly retrieves the storage data associated with a particular
+	// hash, within a particular account, encoded the same way as in the storage
+	// trie. A nil result with no error means the slot is empty.
+	Storage(accountHash, storageHash common.Hash) ([]byte, error)
+}
+
+// snapshot is the internal version of the snapshot data layer that supports
+// some additional methods compared to the public API.
+type snapshot interface {
+	Snapshot
+
+	// Parent returns the subsequent layer of a snapshot, or nil if the base was
+	// reached.
+	Parent() snapshot
+
+	// Update creates a new layer on top of the existing snapshot diff tree with
+	// the specified data items.
+	Update(blockRoot common.Hash, destructs map[common.Hash]struct{}, accounts map[common.Hash][]byte, storage map[common.Hash]map[common.Hash][]byte) *diffLayer
+
+	// Stale return whether this layer has become stale (was flattened across) or
+	// if it's still live.
+	Stale() bool
+}
+
+// Tree is a haachain state snapshot tree. It consists of one persistent base
+// layer backed by a key-value store, on top of which arbitrarily many in-memory
+// diff layers are topped. The memory diffs can form a tree with branching, but
+// the disk layer is singleton and common to all. If a reorg goes deeper than the
+// disk layer, everything needs to be deleted.
+//
+// The goal of a state snapshot is twofold: to allow direct access to account and
+// storage data to avoid expensive multi-level trie lookups; and to allow sorted,
+// cheap iteration of the account/storage tries for sync aid.
+type Tree struct {
+	diskdb haadb.Database           // Persistent database to store the snapshot
+	triedb *trie.Database           // In-memory cache to access the trie through
+	cache  int                      // Number of entries to cache in memory by the disk layer
+	layers map[common.Hash]snapshot // Collection of all known layers
+	lock   sync.RWMutex
+
+	genAbort chan struct{} // Channel to abort a running snapshot generation
+	genDone  chan struct{} // Channel closed when the snapshot generation terminates
+}
+
+// New attempts to load an already existing snapshot from a persistent key-value
+// store, ensuring that the head of the snapshot matches the expected one. The
+// cache is the number of snapshot entries to keep in memory for faster reads.
+//
+// If the snapshot is missing or inconsistent, the entirety is deleted and will
+// be reconstructed from scratch based on the tries in the key-value store, on a
+// background thread. Until the generation finishes, reads not yet covered by
+// the snapshot fail with ErrNotCoveredYet, signalling a fallback to the tries.
+func New(diskdb haadb.Database, triedb *trie.Database, cache int, root common.Hash) *Tree {
+	snap := &Tree{
+		diskdb: diskdb,
+		triedb: triedb,
+		cache:  cache,
+		layers: make(map[common.Hash]snapshot),
+	}
+	base := newDiskLayer(diskdb, triedb, cache, root)
+
+	switch marker, generating := readSnapshotGenerator(diskdb); {
+	case readSnapshotRoot(diskdb) != root:
+		log.Warn("Snapshot missing or stale, regenerating", "root", root)
+		writeSnapshotRoot(diskdb, root)
+		writeSnapshotGenerator(diskdb, []byte{})
+		base.genMarker = []byte{}
+
+	case generating:
+		log.Info("Resuming snapshot generation", "root", root, "marker", fmt.Sprintf("%x", marker))
+		base.genMarker = marker
+
+	default:
+		log.Info("Loaded state snapshot", "root", root)
+	}
+	snap.layers[root] = base
+
+	if base.genMarker != nil {
+		snap.startGeneration()
+	}
+	return snap
+}
+
+// Snapshot retrieves a snapshot belonging to the given block root, or nil if no
+// snapshot is maintained for that block.
+func (t *Tree) Snapshot(blockRoot common.Hash) Snapshot {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if snap, ok := t.layers[blockRoot]; ok {
+		return snap
+	}
+	return nil
+}
+
+// Update adds a new snapshot into the tree, if that can be linked to an existing
+// old parent. It is disallowed to insert a disk layer (the origin of all).
+func (t *Tree) Update(blockRoot common.Hash, parentRoot common.Hash, destructs map[common.Hash]struct{}, accounts map[common.Hash][]byte, storage map[common.Hash]map[common.Hash][]byte) error {
+	// Reject noop updates to avoid self-loops in the snapshot tree. This is a
+	// special case that can only happen for Clique networks where empty blocks
+	// don't modify the state (0 block subsidy).
+	if blockRoot == parentRoot {
+		return errSnapshotCycle
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// Skip layers already known, the same state was reached via another block
+	if _, ok := t.layers[blockRoot]; ok {
+		return nil
+	}
+	parent, ok := t.layers[parentRoot]
+	if !ok {
+		return fmt.Errorf("parent [%#x] snapshot missing", parentRoot)
+	}
+	t.layers[blockRoot] = parent.Update(blockRoot, destructs, accounts, storage)
+	return nil
+}
+
+// Cap traverses downwards the snapshot tree from a head block hash until the
+// number of allowed layers are crossed. All layers beyond the permitted number
+// are flattened downwards into the disk layer, and all the branches which do
+// not descend from the new disk layer anymore are dropped.
+func (t *Tree) Cap(root common.Hash, layers int) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	snap, ok := t.layers[root]
+	if !ok {
+		return fmt.Errorf("snapshot [%#x] missing", root)
+	}
+	// Collect the diff layers from the requested head down to the disk layer
+	var chain []*diffLayer
+	for s := snap; ; s = s.Parent() {
+		diff, ok := s.(*diffLayer)
+		if !ok {
+			break
+		}
+		chain = append(chain, diff)
+	}
+	if len(chain) <= layers {
+		return nil
+	}
+	// Flatten all the excess diff layers into the disk layer, oldest first
+	base := chain[len(chain)-1].Parent().(*diskLayer)
+	for i := len(chain) - 1; i >= layers; i-- {
+		base = diffToDisk(base, chain[i])
+	}
+	if layers > 0 {
+		chain[layers-1].setParent(base)
+	}
+	// Drop all the layers that are not reachable from the new disk layer anymore
+	for root, snap := range t.layers {
+		if !descends(snap, base) {
+			if diff, ok := snap.(*diffLayer); ok {
+				diff.markStale()
+			}
+			delete(t.layers, root)
+		}
+	}
+	t.layers[base.root] = base
+	return nil
+}
+
+// Rebuild discards all the layers of the snapshot tree and starts generating a
+// new snapshot from scratch for the given root on a background thread. It must
+// be used whenever the chain head moves to a state that cannot be linked to the
+// existing layers, e.g. after a sync, a rewind or a reorg deeper than the disk
+// layer.
+func (t *Tree) Rebuild(root common.Hash) {
+	// Abort any running generation first, it needs the tree lock to terminate
+	t.stopGeneration()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// Mark all the known layers stale so that live readers fall back to the tries
+	for _, layer := range t.layers {
+		switch layer := layer.(type) {
+		case *diskLayer:
+			layer.lock.Lock()
+			layer.stale = true
+			layer.lock.Unlock()
+
+		case *diffLayer:
+			layer.markStale()
+		}
+	}
+	log.Info("Rebuilding state snapshot", "root", root)
+	writeSnapshotRoot(t.diskdb, root)
+	writeSnapshotGenerator(t.diskdb, []byte{})
+
+	base := newDiskLayer(t.diskdb, t.triedb, t.cache, root)
+	base.genMarker = []byte{}
+	t.layers = map[common.Hash]snapshot{root: base}
+
+	t.startGeneration()
+}
+
+// Persist stops any running background generation and flattens all the diff
+// layers of the given head into the disk layer, so that the persisted snapshot
+// matches the head state after a restart. The tree must not be used afterwards.
+func (t *Tree) Persist(root common.Hash) error {
+	t.stopGeneration()
+
+	snap := t.Snapshot(root)
+	if snap == nil {
+		return fmt.Errorf("snapshot [%#x] missing", root)
+	}
+	if _, ok := snap.(*diskLayer); ok {
+		return nil
+	}
+	return t.Cap(root, 0)
+}
+
+// startGeneration starts indexing the state tries of the disk layer into the
+// snapshot on a background thread.
+func (t *Tree) startGeneration() {
+	t.genAbort = make(chan struct{})
+	t.genDone = make(chan struct{})
+	go t.generate()
+}
+
+// stopGeneration aborts the running snapshot generation, if any, and waits for
+// it to terminate.
+func (t *Tree) stopGeneration() {
+	if t.genAbort == nil {
+		return
+	}
+	close(t.genAbort)
+	<-t.genDone
+
+	t.genAbort, t.genDone = nil, nil
+}
+
+// disklayer retrieves the current disk layer of the snapshot tree.
+func (t *Tree) disklayer() *diskLayer {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	for _, snap := range t.layers {
+		for s := snap; s != nil; s = s.Parent() {
+			if disk, ok := s.(*diskLayer); ok {
+				return disk
+			}
+		}
+	}
+	return nil
+}
+
+// descends reports whether the given layer is built on top of the given disk
+// layer (or is the disk layer itself).
+func descends(snap snapshot, base *diskLayer) bool {
+	for s := snap; s != nil; s = s.Parent() {
+		if disk, ok := s.(*diskLayer); ok {
+			return disk == base
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package snapshot
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/crypto"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/rlp"
+	"github.com/haachain/go-haachain/trie"
+)
+
+// newTestTree creates a snapshot tree with a fully generated disk layer at the
+// given root, containing the given account and storage entries.
+func newTestTree(root common.Hash, accounts map[common.Hash][]byte, storage map[common.Hash]map[common.Hash][]byte) *Tree {
+	db, _ := haadb.NewMemDatabase()
+	for hash, data := range accounts {
+		db.Put(accountSnapshotKey(hash), data)
+	}
+	for accountHash, slots := range storage {
+		for storageHash, data := range slots {
+			db.Put(storageSnapshotKey(accountHash, storageHash), data)
+		}
+	}
+	writeSnapshotRoot(db, root)
+
+	triedb := trie.NewDatabase(db)
+	return &Tree{
+		diskdb: db,
+		triedb: triedb,
+		layers: map[common.Hash]snapshot{
+			root: newDiskLayer(db, triedb, 16, root),
+		},
+	}
+}
+
+// Tests that diff layers shadow the layers below them, that destructed accounts
+// hide their old storage and that unknown items are resolved from the parents.
+func TestDiffLayerLookups(t *testing.T) {
+	var (
+		acc1, acc2, acc3 = common.Hash{0x01}, common.Hash{0x02}, common.Hash{0x03}
+		slot1, slot2     = common.Hash{0x11}, common.Hash{0x12}
+	)
+	snaps := newTestTree(common.Hash{0xa0},
+		map[common.Hash][]byte{acc1: {0x01}, acc2: {0x02}},
+		map[common.Hash]map[common.Hash][]byte{acc1: {slot1: {0x11}}, acc2: {slot1: {0x21}, slot2: {0x22}}},
+	)
+	if err := snaps.Update(common.Hash{0xa1}, common.Hash{0xa0},
+		map[common.Hash]struct{}{acc2: {}},
+		map[common.Hash][]byte{acc1: {0x0a}, acc3: {0x03}},
+		map[common.Hash]map[common.Hash][]byte{acc1: {slot2: {0x1a}}, acc2: {slot2: {0x2a}}},
+	); err != nil {
+		t.Fatalf("failed to create diff layer: %v", err)
+	}
+	if err := snaps.Update(common.Hash{0xa2}, common.Hash{0xbb}, nil, nil, nil); err == nil {
+		t.Fatalf("diff layer with missing parent created")
+	}
+	snap := snaps.Snapshot(common.Hash{0xa1})
+
+	accTests := []struct {
+		hash common.Hash
+		want []byte
+	}{
+		{acc1, []byte{0x0a}},
+		{acc2, nil},
+		{acc3, []byte{0x03}},
+		{common.Hash{0x04}, nil},
+	}
+	for i, tt := range accTests {
+		if blob, err := snap.AccountRLP(tt.hash); err != nil || !bytes.Equal(blob, tt.want) {
+			t.Errorf("test %d: account mismatch: have %x (err %v), want %x", i, blob, err, tt.want)
+		}
+	}
+	slotTests := []struct {
+		account, slot common.Hash
+		want          []byte
+	}{
+		{acc1, slot1, []byte{0x11}},
+		{acc1, slot2, []byte{0x1a}},
+		{acc2, slot1, nil},
+		{acc2, slot2, []byte{0x2a}},
+	}
+	for i, tt := range slotTests {
+		if blob, err := snap.Storage(tt.account, tt.slot); err != nil || !bytes.Equal(blob, tt.want) {
+			t.Errorf("test %d: slot mismatch: have %x (err %v), want %x", i, blob, err, tt.want)
+		}
+	}
+}
+
+// Tests that capping the tree flattens the excess diff layers into the disk,
+// marks the flattened layers stale and drops the branches not descending from
+// the new disk layer.
+func TestCapFlattening(t *testing.T) {
+	acc := common.Hash{0x01}
+
+	snaps := newTestTree(common.Hash{0xa0}, map[common.Hash][]byte{acc: {0x00}}, nil)
+	for i := byte(1); i <= 3; i++ {
+		if err := snaps.Update(common.Hash{0xa0 + i}, common.Hash{0xa0 + i - 1}, nil, map[common.Hash][]byte{acc: {i}}, nil); err != nil {
+			t.Fatalf("failed to create diff layer %d: %v", i, err)
+		}
+	}
+	// Fork off a side branch from the first diff layer
+	if err := snaps.Update(common.Hash{0xb2}, common.Hash{0xa1}, nil, map[common.Hash][]byte{acc: {0xb2}}, nil); err != nil {
+		t.Fatalf("failed to create side branch: %v", err)
+	}
+	old := snaps.Snapshot(common.Hash{0xa1})
+
+	if err := snaps.Cap(common.Hash{0xa3}, 1); err != nil {
+		t.Fatalf("failed to cap snapshot tree: %v", err)
+	}
+	if n := len(snaps.layers); n != 2 {
+		t.Fatalf("layer count mismatch: have %d, want 2", n)
+	}
+	if _, ok := snaps.Snapshot(common.Hash{0xa2}).(*diskLayer); !ok {
+		t.Fatalf("flattened layer is not the disk layer")
+	}
+	if snaps.Snapshot(common.Hash{0xb2}) != nil {
+		t.Fatalf("side branch not dropped")
+	}
+	if _, err := old.AccountRLP(acc); err != ErrSnapshotStale {
+		t.Fatalf("flattened layer access error mismatch: have %v, want %v", err, ErrSnapshotStale)
+	}
+	if blob, _ := snaps.Snapshot(common.Hash{0xa3}).AccountRLP(acc); !bytes.Equal(blob, []byte{0x03}) {
+		t.Fatalf("head account mismatch: have %x, want %x", blob, []byte{0x03})
+	}
+	if blob, _ := snaps.diskdb.Get(accountSnapshotKey(acc)); !bytes.Equal(blob, []byte{0x02}) {
+		t.Fatalf("persisted account mismatch: have %x, want %x", blob, []byte{0x02})
+	}
+	if root := readSnapshotRoot(snaps.diskdb); root != (common.Hash{0xa2}) {
+		t.Fatalf("persisted root mismatch: have %x, want %x", root, common.Hash{0xa2})
+	}
+}
+
+// Tests that a missing snapshot is generated from the state tries.
+func TestGenerate(t *testing.T) {
+	db, _ := haadb.NewMemDatabase()
+	triedb := trie.NewDatabase(db)
+
+	storeTrie, _ := trie.NewSecure(common.Hash{}, triedb, 0)
+	storeTrie.Update([]byte("key"), []byte("value"))
+	storeRoot, _ := storeTrie.Commit(nil)
+	triedb.Commit(storeRoot, false)
+
+	accTrie, _ := trie.NewSecure(common.Hash{}, triedb, 0)
+	accs := map[string][]byte{}
+	for i := byte(0); i < 16; i++ {
+		acc := account{Nonce: uint64(i), Balance: big.NewInt(int64(i)), Root: emptyRoot, CodeHash: crypto.Keccak256(nil)}
+		if i%2 == 0 {
+			acc.Root = storeRoot
+		}
+		blob, _ := rlp.EncodeToBytes(acc)
+		accTrie.Update([]byte{i}, blob)
+		accs[string([]byte{i})] = blob
+	}
+	root, _ := accTrie.Commit(nil)
+	triedb.Commit(root, false)
+
+	snaps := New(db, triedb, 16, root)
+	<-snaps.genDone
+
+	snap := snaps.Snapshot(root)
+	for key, want := range accs {
+		hash := crypto.Keccak256Hash([]byte(key))
+		if blob, err := snap.AccountRLP(hash); err != nil || !bytes.Equal(blob, want) {
+			t.Errorf("account %x mismatch: have %x (err %v), want %x", key, blob, err, want)
+		}
+		slot, err := snap.Storage(hash, crypto.Keccak256Hash([]byte("key")))
+		if err != nil {
+			t.Errorf("account %x storage retrieval failed: %v", key, err)
+		}
+		if key[0]%2 == 0 && !bytes.Equal(slot, []byte("value")) {
+			t.Errorf("account %x slot mismatch: have %x, want %x", key, slot, []byte("value"))
+		}
+		if key[0]%2 == 1 && slot != nil {
+			t.Errorf("account %x unexpected slot: %x", key, slot)
+		}
+	}
+	if _, generating := readSnapshotGenerator(db); generating {
+		t.Fatalf("snapshot generator marker not removed")
+	}
+}
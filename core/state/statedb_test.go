@@ -32,7 +32,9 @@ import (
 
 	"github.com/haachain/go-haachain/common"
 	"github.com/haachain/go-haachain/core/types"
+	"github.com/haachain/go-haachain/crypto"
 	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/trie"
 )
 
 // Tests that updating a state trie does not leak any database writes prior to
@@ -117,6 +119,54 @@ func TestIntermediateLeaks(t *testing.T) {
 	}
 }
 
+// Tests that account and storage proofs generated from a committed state can be
+// verified against the state and storage roots.
+func TestProofs(t *testing.T) {
+	db, _ := haadb.NewMemDatabase()
+	state, _ := New(common.Hash{}, NewDatabase(db))
+
+	addr := common.BytesToAddress([]byte{0x01})
+	for i := byte(0); i < 16; i++ {
+		state.AddBalance(common.BytesToAddress([]byte{i}), big.NewInt(int64(i)+1))
+	}
+	state.Sehaaate(addr, common.Hash{0x02}, common.Hash{0x03})
+	root, err := state.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	state, _ = New(root, state.Database())
+
+	// Verify the proof of an existing account
+	proof, err := state.GetProof(addr)
+	if err != nil {
+		t.Fatalf("failed to generate account proof: %v", err)
+	}
+	proofDb, _ := haadb.NewMemDatabase()
+	for _, node := range proof {
+		proofDb.Put(crypto.Keccak256(node), node)
+	}
+	if val, err, _ := trie.VerifyProof(root, crypto.Keccak256(addr.Bytes()), proofDb); err != nil || val == nil {
+		t.Fatalf("account proof verification failed: val %x, err %v", val, err)
+	}
+	// Verify the proof of the storage slot against the storage root
+	proof, err = state.GetStorageProof(addr, common.Hash{0x02})
+	if err != nil {
+		t.Fatalf("failed to generate storage proof: %v", err)
+	}
+	proofDb, _ = haadb.NewMemDatabase()
+	for _, node := range proof {
+		proofDb.Put(crypto.Keccak256(node), node)
+	}
+	val, err, _ := trie.VerifyProof(state.StorageTrie(addr).Hash(), crypto.Keccak256(common.Hash{0x02}.Bytes()), proofDb)
+	if err != nil || val == nil {
+		t.Fatalf("storage proof verification failed: val %x, err %v", val, err)
+	}
+	// Storage proofs of missing accounts must be rejected
+	if _, err := state.GetStorageProof(common.BytesToAddress([]byte{0xff}), common.Hash{}); err == nil {
+		t.Fatalf("storage proof of missing account succeeded")
+	}
+}
+
 // TestCopy tests that copying a statedb object indeed makes the original and
 // the copy independent of each other. This test is a regression test against
 // https://github.com/haachain/go-haachain/pull/15549.
@@ -421,3 +471,46 @@ func (s *StateSuite) TestTouchDelete(c *check.C) {
 		c.Fatal("expected no dirty state object")
 	}
 }
+
+// Tests that access list changes are journalled and reverted along with the
+// rest of the state.
+func TestStateDBAccessList(t *testing.T) {
+	db, _ := haadb.NewMemDatabase()
+	state, _ := New(common.Hash{}, NewDatabase(db))
+
+	var (
+		sender = common.Address{0x01}
+		dst    = common.Address{0x02}
+		other  = common.Address{0x03}
+		slot   = common.Hash{0x01}
+	)
+	state.PrepareAccessList(sender, &dst, nil, types.AccessList{{Address: other, StorageKeys: []common.Hash{slot}}})
+	for _, addr := range []common.Address{sender, dst, other} {
+		if !state.AddressInAccessList(addr) {
+			t.Fatalf("address %x missing from prepared access list", addr)
+		}
+	}
+	if _, slotOk := state.SlotInAccessList(other, slot); !slotOk {
+		t.Fatalf("slot missing from prepared access list")
+	}
+	// Add a new address and a new slot, then revert them
+	snapshot := state.Snapshot()
+	state.AddAddressToAccessList(common.Address{0x04})
+	state.AddSlotToAccessList(dst, slot)
+	if _, slotOk := state.SlotInAccessList(dst, slot); !slotOk {
+		t.Fatalf("added slot missing from access list")
+	}
+	copied := state.Copy()
+	state.RevertToSnapshot(snapshot)
+
+	if state.AddressInAccessList(common.Address{0x04}) {
+		t.Errorf("reverted address still in access list")
+	}
+	if addrOk, slotOk := state.SlotInAccessList(dst, slot); !addrOk || slotOk {
+		t.Errorf("reverted slot access mismatch: address %v, slot %v", addrOk, slotOk)
+	}
+	// The copy made before the revert must retain its own access list
+	if _, slotOk := copied.SlotInAccessList(dst, slot); !slotOk {
+		t.Errorf("copied access list lost a slot on revert of the original")
+	}
+}
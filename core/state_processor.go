@@ -95,8 +95,17 @@ func ApplyTransaction(config *params.ChainConfig, bc *BlockChain, author *common
 	// Create a new environment which holds all relevant information
 	// about the transaction and calling mechanisms.
 	vmenv := vm.NewEVM(context, statedb, config, cfg)
+
+	return ApplyTransactionWithEVM(msg, config, gp, statedb, header, tx, usedGas, vmenv)
+}
+
+// ApplyTransactionWithEVM attempts to apply a transaction to the given state
+// database, executing it with the given EVM instead of one assembled from the
+// chain. This allows callers without a chain to customize the environment
+// (e.g. block hashes) the transaction is executed in.
+func ApplyTransactionWithEVM(msg types.Message, config *params.ChainConfig, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64, vmenv *vm.EVM) (*types.Receipt, uint64, error) {
 	// Apply the transaction to the current state (included in the env)
-	_, gas, failed, err := ApplyMessage(vmenv, msg, gp)
+	ret, gas, failed, err := ApplyMessage(vmenv, msg, gp)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -114,6 +123,10 @@ func ApplyTransaction(config *params.ChainConfig, bc *BlockChain, author *common
 	receipt := types.NewReceipt(root, failed, *usedGas)
 	receipt.TxHash = tx.Hash()
 	receipt.GasUsed = gas
+	// Only reverts return data from failed executions, keep it as the revert reason
+	if failed && len(ret) > 0 {
+		receipt.RevertData = common.CopyBytes(ret)
+	}
 	// if the transaction created a contract, store the creation address in the receipt.
 	if msg.To() == nil {
 		receipt.ContractAddress = crypto.CreateAddress(vmenv.Context.Origin, tx.Nonce())
@@ -22,6 +22,7 @@ import (
 	"math/big"
 
 	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/types"
 	"github.com/haachain/go-haachain/core/vm"
 	"github.com/haachain/go-haachain/log"
 	"github.com/haachain/go-haachain/params"
@@ -73,10 +74,12 @@ type Message interface {
 	Nonce() uint64
 	CheckNonce() bool
 	Data() []byte
+	AccessList() types.AccessList
 }
 
-// IntrinsicGas computes the 'intrinsic gas' for a message with the given data.
-func IntrinsicGas(data []byte, contractCreation, homestead bool) (uint64, error) {
+// IntrinsicGas computes the 'intrinsic gas' for a message with the given data
+// and access list.
+func IntrinsicGas(data []byte, accessList types.AccessList, contractCreation, homestead bool) (uint64, error) {
 	// Set the starting gas for the raw transaction
 	var gas uint64
 	if contractCreation && homestead {
@@ -105,6 +108,19 @@ func IntrinsicGas(data []byte, contractCreation, homestead bool) (uint64, error)
 		}
 		gas += z * params.TxDataZeroGas
 	}
+	// Every address and storage key in the access list is prepaid
+	if accessList != nil {
+		addresses, keys := uint64(len(accessList)), uint64(accessList.StorageKeys())
+		if (math.MaxUint64-gas)/params.TxAccessListAddressGas < addresses {
+			return 0, vm.ErrOutOfGas
+		}
+		gas += addresses * params.TxAccessListAddressGas
+
+		if (math.MaxUint64-gas)/params.TxAccessListStorageKeyGas < keys {
+			return 0, vm.ErrOutOfGas
+		}
+		gas += keys * params.TxAccessListStorageKeyGas
+	}
 	return gas, nil
 }
 
@@ -214,13 +230,17 @@ func (st *StateTransition) TransitionDb() (ret []byte, usedGas uint64, failed bo
 	contractCreation := msg.To() == nil
 
 	// Pay intrinsic gas
-	gas, err := IntrinsicGas(st.data, contractCreation, homestead)
+	gas, err := IntrinsicGas(st.data, msg.AccessList(), contractCreation, homestead)
 	if err != nil {
 		return nil, 0, false, err
 	}
 	if err = st.useGas(gas); err != nil {
 		return nil, 0, false, err
 	}
+	// Warm up the sender, the recipient, the precompiles and the access list
+	if rules := st.evm.ChainConfig().Rules(st.evm.BlockNumber); rules.IsBerlin {
+		st.state.PrepareAccessList(msg.From(), msg.To(), vm.ActivePrecompiles(rules), msg.AccessList())
+	}
 
 	var (
 		evm = st.evm
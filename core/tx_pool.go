@@ -40,6 +40,9 @@ const (
 	chainHeadChanSize = 10
 	// rmTxChanSize is the size of channel listening to RemovedTransactionEvent.
 	rmTxChanSize = 10
+	// maxReorgDepth is the depth of the deepest reorg whose discarded
+	// transactions are reinjected into the pool.
+	maxReorgDepth = 64
 )
 
 var (
@@ -78,6 +81,10 @@ var (
 	// than some meaningful limit a user might use. This is not a consensus error
 	// making the transaction invalid, rather a DOS protection.
 	ErrOversizedData = errors.New("oversized data")
+
+	// ErrPrivateTxExpired is returned if a private transaction is submitted with
+	// an expiry block the chain has already reached.
+	ErrPrivateTxExpired = errors.New("private transaction expired")
 )
 
 var (
@@ -98,6 +105,9 @@ var (
 	queuedRateLimitCounter = metrics.NewRegisteredCounter("txpool/queued/ratelimit", nil) // Dropped due to rate limiting
 	queuedNofundsCounter   = metrics.NewRegisteredCounter("txpool/queued/nofunds", nil)   // Dropped due to out-of-funds
 
+	// Metrics for the private transactions
+	privateExpiredCounter = metrics.NewRegisteredCounter("txpool/private/expired", nil)
+
 	// General tx metrics
 	invalidTxCounter     = metrics.NewRegisteredCounter("txpool/invalid", nil)
 	underpricedTxCounter = metrics.NewRegisteredCounter("txpool/underpriced", nil)
@@ -113,6 +123,13 @@ const (
 	TxStatusIncluded
 )
 
+// includedTx is the arrival time of a transaction dropped from the pool as
+// included, along with the head block it was dropped at.
+type includedTx struct {
+	time   time.Time
+	number uint64
+}
+
 // blockChain provides the state of blockchain and current gas limit to do
 // some pre checks in tx pool and event subscribers.
 type blockChain interface {
@@ -129,6 +146,9 @@ type TxPoolConfig struct {
 	Journal   string        // Journal of local transactions to survive node restarts
 	Rejournal time.Duration // Time interval to regenerate the local transaction journal
 
+	RemoteJournal      string // Snapshot of remote transactions to survive node restarts (disabled if empty)
+	RemoteJournalLimit uint64 // Maximum number of remote transactions to keep in the snapshot
+
 	PriceLimit uint64 // Minimum gas price to enforce for acceptance into the pool
 	PriceBump  uint64 // Minimum price bump percentage to replace an already existing transaction (nonce)
 
@@ -146,6 +166,8 @@ var DefaultTxPoolConfig = TxPoolConfig{
 	Journal:   "transactions.rlp",
 	Rejournal: time.Hour,
 
+	RemoteJournalLimit: 4096,
+
 	PriceLimit: 1,
 	PriceBump:  10,
 
@@ -165,6 +187,10 @@ func (config *TxPoolConfig) sanitize() TxPoolConfig {
 		log.Warn("Sanitizing invalid txpool journal time", "provided", conf.Rejournal, "updated", time.Second)
 		conf.Rejournal = time.Second
 	}
+	if conf.RemoteJournal != "" && conf.RemoteJournalLimit < 1 {
+		log.Warn("Sanitizing invalid txpool remote journal limit", "provided", conf.RemoteJournalLimit, "updated", DefaultTxPoolConfig.RemoteJournalLimit)
+		conf.RemoteJournalLimit = DefaultTxPoolConfig.RemoteJournalLimit
+	}
 	if conf.PriceLimit < 1 {
 		log.Warn("Sanitizing invalid txpool price limit", "provided", conf.PriceLimit, "updated", DefaultTxPoolConfig.PriceLimit)
 		conf.PriceLimit = DefaultTxPoolConfig.PriceLimit
@@ -202,15 +228,22 @@ type TxPool struct {
 	locals  *accountSet // Set of local transaction to exempt from eviction rules
 	journal *txJournal  // Journal of local transaction to back up to disk
 
+	remoteJournal *txRemoteJournal // Snapshot of remote transactions to back up to disk
+
 	pending map[common.Address]*txList         // All currently processable transactions
 	queue   map[common.Address]*txList         // Queued but non-processable transactions
 	beats   map[common.Address]time.Time       // Last heartbeat from each known account
 	all     map[common.Hash]*types.Transaction // All transactions to allow lookups
 	priced  *txPricedList                      // All transactions sorted by price
+	private map[common.Hash]uint64             // Transactions kept out of network propagation, mapped to their expiry block
+
+	included map[common.Hash]includedTx // Transactions recently dropped as included, to restore them on reorgs
+	head     uint64                     // Number of the head block the pool was last reset to
 
 	wg sync.WaitGroup // for shutdown sync
 
-	homestead bool
+	homestead bool // Fork indicator whhaaer we are in the homestead stage
+	berlin    bool // Fork indicator whhaaer typed transactions are accepted
 }
 
 // NewTxPool creates a new transaction pool to gather, sort and filter inbound
@@ -224,11 +257,13 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, chain block
 		config:      config,
 		chainconfig: chainconfig,
 		chain:       chain,
-		signer:      types.NewEIP155Signer(chainconfig.ChainId),
+		signer:      types.NewEIP2930Signer(chainconfig.ChainId),
 		pending:     make(map[common.Address]*txList),
 		queue:       make(map[common.Address]*txList),
 		beats:       make(map[common.Address]time.Time),
 		all:         make(map[common.Hash]*types.Transaction),
+		private:     make(map[common.Hash]uint64),
+		included:    make(map[common.Hash]includedTx),
 		chainHeadCh: make(chan ChainHeadEvent, chainHeadChanSize),
 		gasPrice:    new(big.Int).SetUint64(config.PriceLimit),
 	}
@@ -247,6 +282,14 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, chain block
 			log.Warn("Failed to rotate transaction journal", "err", err)
 		}
 	}
+	// If remote transaction journaling is enabled, reload the last snapshot
+	if config.RemoteJournal != "" {
+		pool.remoteJournal = newTxRemoteJournal(config.RemoteJournal, config.RemoteJournalLimit)
+
+		if err := pool.remoteJournal.load(pool.AddRemotes); err != nil {
+			log.Warn("Failed to load remote transaction journal", "err", err)
+		}
+	}
 	// Subscribe events from blockchain
 	pool.chainHeadSub = pool.chain.SubscribeChainHeadEvent(pool.chainHeadCh)
 
@@ -335,6 +378,11 @@ func (pool *TxPool) loop() {
 				}
 				pool.mu.Unlock()
 			}
+			if pool.remoteJournal != nil {
+				if _, err := pool.SaveRemotes(); err != nil {
+					log.Warn("Failed to save remote tx journal", "err", err)
+				}
+			}
 		}
 	}
 }
@@ -359,7 +407,7 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 		oldNum := oldHead.Number.Uint64()
 		newNum := newHead.Number.Uint64()
 
-		if depth := uint64(math.Abs(float64(oldNum) - float64(newNum))); depth > 64 {
+		if depth := uint64(math.Abs(float64(oldNum) - float64(newNum))); depth > maxReorgDepth {
 			log.Debug("Skipping deep transaction reorg", "depth", depth)
 		} else {
 			// Reorg seems shallow enough to pull in all transactions into memory
@@ -411,10 +459,29 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 	pool.pendingState = state.ManageState(statedb)
 	pool.currentMaxGas = newHead.GasLimit
 
-	// Inject any transactions discarded due to reorgs
+	// Typed transactions are only accepted once the next block is post-Berlin
+	pool.berlin = pool.chainconfig.IsBerlin(new(big.Int).Add(newHead.Number, big.NewInt(1)))
+
+	// Forget the transactions included too long ago to be reorged out
+	pool.head = newHead.Number.Uint64()
+	for hash, tx := range pool.included {
+		if tx.number+maxReorgDepth < pool.head {
+			delete(pool.included, hash)
+		}
+	}
+	// Inject any transactions discarded due to reorgs, restoring the arrival
+	// time of the ones seen before
+	for _, tx := range reinject {
+		if included, ok := pool.included[tx.Hash()]; ok {
+			tx.SetTime(included.time)
+		}
+	}
 	log.Debug("Reinjecting stale transactions", "count", len(reinject))
 	pool.addTxsLocked(reinject, false)
 
+	// Drop any private transactions that can't be included any more
+	pool.expirePrivate(newHead.Number.Uint64())
+
 	// validate the pool of pending transactions, this will remove
 	// any transactions that have been included in the block or
 	// have been invalidated because of another transaction (e.g.
@@ -443,6 +510,11 @@ func (pool *TxPool) Stop() {
 	if pool.journal != nil {
 		pool.journal.close()
 	}
+	if pool.remoteJournal != nil {
+		if _, err := pool.SaveRemotes(); err != nil {
+			log.Warn("Failed to save remote tx journal", "err", err)
+		}
+	}
 	log.Info("Transaction pool stopped")
 }
 
@@ -551,9 +623,46 @@ func (pool *TxPool) local() map[common.Address]types.Transactions {
 	return txs
 }
 
+// remote retrieves all the remote transactions currently in the pool, the
+// executable ones first. Private transactions are left out as they must never
+// outlive their expiry.
+func (pool *TxPool) remote() types.Transactions {
+	var txs types.Transactions
+	for _, lists := range []map[common.Address]*txList{pool.pending, pool.queue} {
+		for addr, list := range lists {
+			if pool.locals.contains(addr) {
+				continue
+			}
+			for _, tx := range list.Flatten() {
+				if _, ok := pool.private[tx.Hash()]; !ok {
+					txs = append(txs, tx)
+				}
+			}
+		}
+	}
+	return txs
+}
+
+// SaveRemotes regenerates the remote transaction journal with the current
+// contents of the pool, returning the number of transactions saved.
+func (pool *TxPool) SaveRemotes() (int, error) {
+	if pool.remoteJournal == nil {
+		return 0, errNoRemoteJournal
+	}
+	pool.mu.Lock()
+	txs := pool.remote()
+	pool.mu.Unlock()
+
+	return pool.remoteJournal.save(txs)
+}
+
 // validateTx checks whhaaer a transaction is valid according to the consensus
 // rules and adheres to some heuristic limits of the local node (price and size).
 func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
+	// Accept only legacy transactions until the Berlin fork activates typed ones
+	if tx.Type() != types.LegacyTxType && !pool.berlin {
+		return types.ErrTxTypeNotSupported
+	}
 	// Heuristic limit, reject transactions over 32KB to prevent DOS attacks
 	if tx.Size() > 32*1024 {
 		return ErrOversizedData
@@ -586,7 +695,7 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 	if pool.currenhaaate.GetBalance(from).Cmp(tx.Cost()) < 0 {
 		return ErrInsufficientFunds
 	}
-	intrGas, err := IntrinsicGas(tx.Data(), tx.To() == nil, pool.homestead)
+	intrGas, err := IntrinsicGas(tx.Data(), tx.AccessList(), tx.To() == nil, pool.homestead)
 	if err != nil {
 		return err
 	}
@@ -695,8 +804,11 @@ func (pool *TxPool) enqueueTx(hash common.Hash, tx *types.Transaction) (bool, er
 		pool.priced.Removed()
 		queuedReplaceCounter.Inc(1)
 	}
-	pool.all[hash] = tx
-	pool.priced.Put(tx)
+	// Only track the transaction if it's not being moved back from pending
+	if pool.all[hash] == nil {
+		pool.all[hash] = tx
+		pool.priced.Put(tx)
+	}
 	return old != nil, nil
 }
 
@@ -707,6 +819,10 @@ func (pool *TxPool) journalTx(from common.Address, tx *types.Transaction) {
 	if pool.journal == nil || !pool.locals.contains(from) {
 		return
 	}
+	// Private transactions expire, never resurrect them as public ones
+	if _, ok := pool.private[tx.Hash()]; ok {
+		return
+	}
 	if err := pool.journal.insert(tx); err != nil {
 		log.Warn("Failed to journal local transaction", "err", err)
 	}
@@ -764,6 +880,57 @@ func (pool *TxPool) AddRemote(tx *types.Transaction) error {
 	return pool.addTx(tx, false)
 }
 
+// AddPrivate enqueues a single transaction into the pool like AddLocal, but marks
+// it as private: it is kept out of network propagation (save for explicitly
+// trusted peers) and is dropped once the chain reaches the expiry block.
+func (pool *TxPool) AddPrivate(tx *types.Transaction, expiry uint64) error {
+	return pool.addPrivate(tx, expiry, !pool.config.NoLocals)
+}
+
+// AddRemotePrivate enqueues a single private transaction received from a peer
+// into the pool like AddRemote, keeping it out of network propagation (save for
+// explicitly trusted peers) until the chain reaches the expiry block.
+func (pool *TxPool) AddRemotePrivate(tx *types.Transaction, expiry uint64) error {
+	return pool.addPrivate(tx, expiry, false)
+}
+
+// addPrivate enqueues a single transaction into the pool if it is valid, marking
+// it as private until the expiry block.
+func (pool *TxPool) addPrivate(tx *types.Transaction, expiry uint64, local bool) error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if expiry <= pool.chain.CurrentBlock().NumberU64() {
+		return ErrPrivateTxExpired
+	}
+	// Don't turn an already known public transaction into a private one
+	hash := tx.Hash()
+	if pool.all[hash] != nil {
+		log.Trace("Discarding already known transaction", "hash", hash)
+		return fmt.Errorf("known transaction: %x", hash)
+	}
+	// Mark the transaction private before insertion so it's never announced
+	prev, known := pool.private[hash]
+	pool.private[hash] = expiry
+
+	replace, err := pool.add(tx, local)
+	if err != nil {
+		// Keep the expiry of a private transaction that already left the pool
+		if known {
+			pool.private[hash] = prev
+		} else {
+			delete(pool.private, hash)
+		}
+		return err
+	}
+	// If we added a new transaction, run promotion checks and return
+	if !replace {
+		from, _ := types.Sender(pool.signer, tx) // already validated
+		pool.promoteExecutables([]common.Address{from})
+	}
+	return nil
+}
+
 // AddLocals enqueues a batch of transactions into the pool if they are valid,
 // marking the senders as a local ones in the mean time, ensuring they go around
 // the local pricing constraints.
@@ -860,6 +1027,36 @@ func (pool *TxPool) Get(hash common.Hash) *types.Transaction {
 	return pool.all[hash]
 }
 
+// PrivateExpiry returns the expiry block of a transaction submitted as private,
+// which as such must not be propagated to the network, and whhaaer it is one.
+func (pool *TxPool) PrivateExpiry(hash common.Hash) (uint64, bool) {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	expiry, ok := pool.private[hash]
+	return expiry, ok
+}
+
+// expirePrivate removes all the private transactions whose expiry block has been
+// reached by the chain. Private transactions that left the pool (e.g. because
+// they were included in a block) are remembered until their expiry too, so they
+// stay private if a reorg reinjects them.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) expirePrivate(number uint64) {
+	for hash, expiry := range pool.private {
+		if expiry > number {
+			continue
+		}
+		if pool.all[hash] != nil {
+			log.Trace("Removing expired private transaction", "hash", hash, "expiry", expiry)
+			pool.removeTx(hash)
+			privateExpiredCounter.Inc(1)
+		}
+		delete(pool.private, hash)
+	}
+}
+
 // removeTx removes a single transaction from the queue, moving all subsequent
 // transactions back to the future queue.
 func (pool *TxPool) removeTx(hash common.Hash) {
@@ -877,15 +1074,14 @@ func (pool *TxPool) removeTx(hash common.Hash) {
 	// Remove the transaction from the pending lists and reset the account nonce
 	if pending := pool.pending[addr]; pending != nil {
 		if removed, invalids := pending.Remove(tx); removed {
-			// If no more transactions are left, remove the list
+			// If no more pending transactions are left, remove the list
 			if pending.Empty() {
 				delete(pool.pending, addr)
 				delete(pool.beats, addr)
-			} else {
-				// Otherwise postpone any invalidated transactions
-				for _, tx := range invalids {
-					pool.enqueueTx(tx.Hash(), tx)
-				}
+			}
+			// Postpone any invalidated transactions
+			for _, tx := range invalids {
+				pool.enqueueTx(tx.Hash(), tx)
 			}
 			// Update the account nonce if needed
 			if nonce := tx.Nonce(); pool.pendingState.GetNonce(addr) > nonce {
@@ -926,6 +1122,7 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) {
 			log.Trace("Removed old queued transaction", "hash", hash)
 			delete(pool.all, hash)
 			pool.priced.Removed()
+			pool.included[hash] = includedTx{time: tx.Time(), number: pool.head}
 		}
 		// Drop all transactions that are too costly (low balance or out of gas)
 		drops, _ := list.Filter(pool.currenhaaate.GetBalance(addr), pool.currentMaxGas)
@@ -1084,6 +1281,7 @@ func (pool *TxPool) demoteUnexecutables() {
 			log.Trace("Removed old pending transaction", "hash", hash)
 			delete(pool.all, hash)
 			pool.priced.Removed()
+			pool.included[hash] = includedTx{time: tx.Time(), number: pool.head}
 		}
 		// Drop all transactions that are too costly (low balance or out of gas), and queue any invalids back for later
 		drops, invalids := list.Filter(pool.currenhaaate.GetBalance(addr), pool.currentMaxGas)
@@ -33,6 +33,7 @@ import (
 	"github.com/haachain/go-haachain/haadb"
 	"github.com/haachain/go-haachain/event"
 	"github.com/haachain/go-haachain/params"
+	"github.com/haachain/go-haachain/rlp"
 )
 
 // testTxPoolConfig is a transaction pool configuration without stateful disk
@@ -261,6 +262,38 @@ func TestInvalidTransactions(t *testing.T) {
 	}
 }
 
+// Tests that access list transactions are only accepted after the Berlin fork
+// and that their access list is charged as intrinsic gas.
+func TestTransactionAccessListFork(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	pool.currenhaaate.AddBalance(from, big.NewInt(0xffffffffffffff))
+
+	accessTx := func(gas uint64) *types.Transaction {
+		signer := types.NewEIP2930Signer(params.TestChainConfig.ChainId)
+		accesses := types.AccessList{{Address: common.Address{0x01}}}
+		tx, _ := types.SignTx(types.NewAccessListTransaction(params.TestChainConfig.ChainId, 0, &common.Address{}, big.NewInt(100), gas, big.NewInt(1), nil, accesses), signer, key)
+		return tx
+	}
+	if err := pool.AddRemote(accessTx(100000)); err != types.ErrTxTypeNotSupported {
+		t.Fatalf("pre-Berlin error mismatch: have %v, want %v", err, types.ErrTxTypeNotSupported)
+	}
+	pool.mu.Lock()
+	pool.berlin = true
+	pool.mu.Unlock()
+
+	if err := pool.AddRemote(accessTx(params.TxGas)); err != ErrIntrinsicGas {
+		t.Fatalf("access list intrinsic gas error mismatch: have %v, want %v", err, ErrIntrinsicGas)
+	}
+	if err := pool.AddRemote(accessTx(params.TxGas + params.TxAccessListAddressGas)); err != nil {
+		t.Fatalf("failed to add access list transaction: %v", err)
+	}
+}
+
 func TestTransactionQueue(t *testing.T) {
 	t.Parallel()
 
@@ -1563,6 +1596,188 @@ func testTransactionJournaling(t *testing.T, nolocals bool) {
 	pool.Stop()
 }
 
+// Tests that remote transactions are snapshotted to disk, revalidated when the
+// snapshot is reloaded and that the snapshot size is capped.
+func TestTransactionRemoteJournaling(t *testing.T) {
+	t.Parallel()
+
+	// Create a temporary file for the snapshot
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary snapshot: %v", err)
+	}
+	journal := file.Name()
+	defer os.Remove(journal)
+
+	// Clean up the temporary file, we only need the path for now
+	file.Close()
+	os.Remove(journal)
+
+	// Create the original pool to inject transaction into the snapshot
+	db, _ := haadb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	blockchain := &testBlockChain{statedb, 1000000, new(event.Feed)}
+
+	config := testTxPoolConfig
+	config.RemoteJournal = journal
+	config.RemoteJournalLimit = 5
+
+	pool := NewTxPool(config, params.TestChainConfig, blockchain)
+
+	// Create a local account to ensure it's skipped and two remotes to be snapshotted
+	local, _ := crypto.GenerateKey()
+	remote1, _ := crypto.GenerateKey()
+	remote2, _ := crypto.GenerateKey()
+
+	pool.currenhaaate.AddBalance(crypto.PubkeyToAddress(local.PublicKey), big.NewInt(1000000000))
+	pool.currenhaaate.AddBalance(crypto.PubkeyToAddress(remote1.PublicKey), big.NewInt(1000000000))
+	pool.currenhaaate.AddBalance(crypto.PubkeyToAddress(remote2.PublicKey), big.NewInt(1000000000))
+
+	if err := pool.AddLocal(pricedTransaction(0, 100000, big.NewInt(1), local)); err != nil {
+		t.Fatalf("failed to add local transaction: %v", err)
+	}
+	for _, nonce := range []uint64{0, 1, 2, 4} {
+		if err := pool.AddRemote(pricedTransaction(nonce, 100000, big.NewInt(1), remote1)); err != nil {
+			t.Fatalf("failed to add remote transaction %d: %v", nonce, err)
+		}
+	}
+	if err := pool.AddRemote(pricedTransaction(0, 100000, big.NewInt(1), remote2)); err != nil {
+		t.Fatalf("failed to add remote transaction: %v", err)
+	}
+	pending, queued := pool.Stats()
+	if pending != 5 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 5)
+	}
+	if queued != 1 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 1)
+	}
+	if saved, err := pool.SaveRemotes(); err != nil {
+		t.Fatalf("failed to save remote transactions: %v", err)
+	} else if saved != 5 {
+		t.Fatalf("saved transactions mismatched: have %d, want %d", saved, 5)
+	}
+	// Terminate the old pool, bump a remote nonce, create a new pool and ensure only valid remotes survive
+	pool.Stop()
+	statedb.SetNonce(crypto.PubkeyToAddress(remote2.PublicKey), 1)
+	blockchain = &testBlockChain{statedb, 1000000, new(event.Feed)}
+
+	pool = NewTxPool(config, params.TestChainConfig, blockchain)
+
+	pending, queued = pool.Stats()
+	if pending != 3 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 3)
+	}
+	if queued != 1 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 1)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+	pool.Stop()
+
+	// Lower the snapshot limit and ensure only the executable head is reloaded
+	config.RemoteJournalLimit = 2
+	pool = NewTxPool(config, params.TestChainConfig, blockchain)
+
+	pending, queued = pool.Stats()
+	if pending != 2 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 2)
+	}
+	if queued != 0 {
+		t.Fatalf("queued transactions mismatched: have %d, want %d", queued, 0)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+	pool.Stop()
+
+	// Ensure saving is refused if remote journaling is disabled
+	pool = NewTxPool(testTxPoolConfig, params.TestChainConfig, blockchain)
+	defer pool.Stop()
+
+	if _, err := pool.SaveRemotes(); err != errNoRemoteJournal {
+		t.Fatalf("disabled snapshot error mismatch: have %v, want %v", err, errNoRemoteJournal)
+	}
+}
+
+// Tests that remote transactions reloaded from the journal keep the time they
+// were first seen at, instead of counting as arriving when the pool restarted.
+func TestTransactionRemoteJournalArrival(t *testing.T) {
+	t.Parallel()
+
+	// Create a temporary file for the snapshot
+	file, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary snapshot: %v", err)
+	}
+	journal := file.Name()
+	defer os.Remove(journal)
+
+	// Clean up the temporary file, we only need the path for now
+	file.Close()
+	os.Remove(journal)
+
+	// Create the original pool and add two remotes, the earlier one cheaper
+	db, _ := haadb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	blockchain := &testBlockChain{statedb, 1000000, new(event.Feed)}
+
+	config := testTxPoolConfig
+	config.RemoteJournal = journal
+
+	pool := NewTxPool(config, params.TestChainConfig, blockchain)
+
+	keys := make([]*ecdsa.PrivateKey, 3)
+	for i := 0; i < len(keys); i++ {
+		keys[i], _ = crypto.GenerateKey()
+		pool.currenhaaate.AddBalance(crypto.PubkeyToAddress(keys[i].PublicKey), big.NewInt(1000000000))
+	}
+	first := pricedTransaction(0, 100000, big.NewInt(1), keys[0])
+	if err := pool.AddRemote(first); err != nil {
+		t.Fatalf("failed to add first remote transaction: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	second := pricedTransaction(0, 100000, big.NewInt(2), keys[1])
+	if err := pool.AddRemote(second); err != nil {
+		t.Fatalf("failed to add second remote transaction: %v", err)
+	}
+	if _, err := pool.SaveRemotes(); err != nil {
+		t.Fatalf("failed to save remote transactions: %v", err)
+	}
+	pool.Stop()
+
+	// Restart the pool a bit later and add a new remote after the reload
+	time.Sleep(10 * time.Millisecond)
+	pool = NewTxPool(config, params.TestChainConfig, blockchain)
+	defer pool.Stop()
+
+	third := pricedTransaction(0, 100000, big.NewInt(3), keys[2])
+	if err := pool.AddRemote(third); err != nil {
+		t.Fatalf("failed to add third remote transaction: %v", err)
+	}
+	// Ensure the reloaded transactions kept their arrival times and thus their place
+	reloaded := make([]*types.Transaction, 0, 3)
+	for i, tx := range []*types.Transaction{first, second, third} {
+		pooled := pool.Get(tx.Hash())
+		if pooled == nil {
+			t.Fatalf("transaction %d: missing from pool", i)
+		}
+		if !pooled.Time().Equal(tx.Time()) {
+			t.Errorf("transaction %d: arrival time mismatch: have %v, want %v", i, pooled.Time(), tx.Time())
+		}
+		reloaded = append(reloaded, pooled)
+	}
+	for i := 1; i < len(reloaded); i++ {
+		if !reloaded[i-1].Time().Before(reloaded[i].Time()) {
+			t.Errorf("transaction %d: seen before transaction %d: %v >= %v", i-1, i, reloaded[i-1].Time(), reloaded[i].Time())
+		}
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
 // TestTransactionStatusCheck tests that the pool can correctly retrieve the
 // pending status of individual transactions.
 func TestTransactionStatusCheck(t *testing.T) {
@@ -1620,6 +1835,229 @@ func TestTransactionStatusCheck(t *testing.T) {
 	}
 }
 
+// Tests that removing a pending transaction moves all the subsequent ones back
+// into the future queue, even if none remain pending, without tracking them
+// twice.
+func TestTransactionRemoveRequeue(t *testing.T) {
+	t.Parallel()
+
+	for i, tt := range []struct {
+		removed uint64 // Nonce of the pending transaction to remove
+		pending int    // Number of transactions left pending
+		queued  int    // Number of transactions moved back into the queue
+	}{
+		{removed: 0, pending: 0, queued: 3},
+		{removed: 2, pending: 2, queued: 1},
+	} {
+		pool, key := setupTxPool()
+
+		account, _ := deriveSender(transaction(0, 0, key))
+		pool.currenhaaate.AddBalance(account, big.NewInt(1000000))
+
+		txs := make([]*types.Transaction, 4)
+		for nonce := range txs {
+			txs[nonce] = transaction(uint64(nonce), 100000, key)
+			if err := pool.AddRemote(txs[nonce]); err != nil {
+				t.Fatalf("test %d: failed to add transaction %d: %v", i, nonce, err)
+			}
+		}
+		pool.mu.Lock()
+		pool.removeTx(txs[tt.removed].Hash())
+		pool.mu.Unlock()
+
+		if pending, queued := pool.Stats(); pending != tt.pending || queued != tt.queued {
+			t.Errorf("test %d: pool stats mismatch: have %d pending %d queued, want %d pending %d queued", i, pending, queued, tt.pending, tt.queued)
+		}
+		if err := validateTxPoolInternals(pool); err != nil {
+			t.Errorf("test %d: pool internal state corrupted: %v", i, err)
+		}
+		pool.Stop()
+	}
+}
+
+// Tests that private transactions are tracked as such and dropped once the chain
+// reaches their expiry block.
+func TestTransactionPrivateExpiry(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	other, _ := crypto.GenerateKey()
+	for _, key := range []*ecdsa.PrivateKey{key, other} {
+		account, _ := deriveSender(transaction(0, 0, key))
+		pool.currenhaaate.AddBalance(account, big.NewInt(1000000))
+	}
+	// Private transactions with an already reached expiry must be rejected
+	if err := pool.AddPrivate(transaction(0, 100000, key), 0); err != ErrPrivateTxExpired {
+		t.Fatalf("expired private transaction error mismatch: have %v, want %v", err, ErrPrivateTxExpired)
+	}
+	private, public := transaction(0, 100000, key), transaction(0, 100000, other)
+	if err := pool.AddPrivate(private, 2); err != nil {
+		t.Fatalf("failed to add private transaction: %v", err)
+	}
+	if err := pool.AddRemote(public); err != nil {
+		t.Fatalf("failed to add public transaction: %v", err)
+	}
+	if err := pool.AddPrivate(public, 2); err == nil {
+		t.Fatalf("known public transaction turned private")
+	}
+	if expiry, ok := pool.PrivateExpiry(private.Hash()); !ok || expiry != 2 {
+		t.Errorf("private transaction expiry mismatch: have %d (private %v), want 2", expiry, ok)
+	}
+	if _, ok := pool.PrivateExpiry(public.Hash()); ok {
+		t.Errorf("public transaction marked private")
+	}
+	// Advance the chain up to the expiry block and check the private one is dropped
+	pool.lockedReset(nil, &types.Header{Number: big.NewInt(1), GasLimit: 1000000})
+	if pool.Get(private.Hash()) == nil {
+		t.Fatalf("private transaction dropped before expiry")
+	}
+	pool.lockedReset(nil, &types.Header{Number: big.NewInt(2), GasLimit: 1000000})
+	if pool.Get(private.Hash()) != nil {
+		t.Fatalf("private transaction not dropped after expiry")
+	}
+	if _, ok := pool.PrivateExpiry(private.Hash()); ok {
+		t.Errorf("expired private transaction still tracked")
+	}
+	if pending, queued := pool.Stats(); pending != 1 || queued != 0 {
+		t.Fatalf("pool stats mismatch: have %d pending %d queued, want 1 pending 0 queued", pending, queued)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that a private transaction leaving the pool by being included in a block
+// stays private if a reorg reinjects it, until its expiry block.
+func TestTransactionPrivateReinjection(t *testing.T) {
+	t.Parallel()
+
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	account, _ := deriveSender(transaction(0, 0, key))
+	pool.currenhaaate.AddBalance(account, big.NewInt(1000000))
+
+	tx := transaction(0, 100000, key)
+	if err := pool.AddPrivate(tx, 3); err != nil {
+		t.Fatalf("failed to add private transaction: %v", err)
+	}
+	// Include the transaction in a block, dropping it from the pool
+	pool.currenhaaate.SetNonce(account, 1)
+	pool.lockedReset(nil, &types.Header{Number: big.NewInt(1), GasLimit: 1000000})
+	if pool.Get(tx.Hash()) != nil {
+		t.Fatalf("included private transaction still pooled")
+	}
+	// Submitting it again must fail without forgetting that it's private
+	if err := pool.AddPrivate(tx, 5); err == nil {
+		t.Fatalf("included private transaction added again")
+	}
+	if expiry, ok := pool.PrivateExpiry(tx.Hash()); !ok || expiry != 3 {
+		t.Fatalf("included private transaction expiry mismatch: have %d (private %v), want 3", expiry, ok)
+	}
+	// Reorg the block out, reinjecting the transaction as a reset does
+	pool.currenhaaate.SetNonce(account, 0)
+	pool.lockedReset(nil, &types.Header{Number: big.NewInt(2), GasLimit: 1000000})
+
+	pool.mu.Lock()
+	pool.addTxsLocked([]*types.Transaction{tx}, false)
+	pool.mu.Unlock()
+
+	if pool.Get(tx.Hash()) == nil {
+		t.Fatalf("reinjected private transaction not pooled")
+	}
+	if _, ok := pool.PrivateExpiry(tx.Hash()); !ok {
+		t.Fatalf("reinjected private transaction turned public")
+	}
+	// Reaching the expiry block must drop it and forget about it
+	pool.lockedReset(nil, &types.Header{Number: big.NewInt(3), GasLimit: 1000000})
+	if pool.Get(tx.Hash()) != nil {
+		t.Fatalf("reinjected private transaction not dropped after expiry")
+	}
+	if _, ok := pool.PrivateExpiry(tx.Hash()); ok {
+		t.Errorf("expired private transaction still tracked")
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// reorgBlockChain is a testBlockChain serving a fixed set of blocks, so that the
+// pool can walk reorgs between them.
+type reorgBlockChain struct {
+	*testBlockChain
+	blocks map[common.Hash]*types.Block
+}
+
+func (bc *reorgBlockChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	return bc.blocks[hash]
+}
+
+// Tests that transactions reorged out of the chain are reinjected with the time
+// they were first seen at, not the time their block was reorged out.
+func TestTransactionReorgArrival(t *testing.T) {
+	t.Parallel()
+
+	db, _ := haadb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	blockchain := &reorgBlockChain{
+		testBlockChain: &testBlockChain{statedb, 1000000, new(event.Feed)},
+		blocks:         make(map[common.Hash]*types.Block),
+	}
+	pool := NewTxPool(testTxPoolConfig, params.TestChainConfig, blockchain)
+	defer pool.Stop()
+
+	key, _ := crypto.GenerateKey()
+	account := crypto.PubkeyToAddress(key.PublicKey)
+	pool.currenhaaate.AddBalance(account, big.NewInt(1000000))
+
+	tx := transaction(0, 100000, key)
+	if err := pool.AddRemote(tx); err != nil {
+		t.Fatalf("failed to add transaction: %v", err)
+	}
+	// Create a block including a freshly decoded copy of the transaction and a
+	// sibling without it
+	blob, _ := rlp.EncodeToBytes(tx)
+	time.Sleep(10 * time.Millisecond)
+
+	dec := new(types.Transaction)
+	if err := rlp.DecodeBytes(blob, dec); err != nil {
+		t.Fatalf("failed to decode transaction: %v", err)
+	}
+	if dec.Time().Equal(tx.Time()) {
+		t.Fatalf("decoded transaction already has the arrival time")
+	}
+	var (
+		parent  = types.NewBlock(&types.Header{Number: big.NewInt(0), GasLimit: 1000000}, nil, nil, nil)
+		old     = types.NewBlock(&types.Header{ParentHash: parent.Hash(), Number: big.NewInt(1), GasLimit: 1000000}, []*types.Transaction{dec}, nil, nil)
+		sibling = types.NewBlock(&types.Header{ParentHash: parent.Hash(), Number: big.NewInt(1), GasLimit: 1000000, Extra: []byte{1}}, nil, nil, nil)
+	)
+	for _, block := range []*types.Block{parent, old, sibling} {
+		blockchain.blocks[block.Hash()] = block
+	}
+	// Include the transaction, dropping it from the pool
+	pool.currenhaaate.SetNonce(account, 1)
+	pool.lockedReset(parent.Header(), old.Header())
+	if pool.Get(tx.Hash()) != nil {
+		t.Fatalf("included transaction still pooled")
+	}
+	// Reorg the block out and ensure the transaction is back with its arrival time
+	pool.currenhaaate.SetNonce(account, 0)
+	pool.lockedReset(old.Header(), sibling.Header())
+
+	pooled := pool.Get(tx.Hash())
+	if pooled == nil {
+		t.Fatalf("reorged transaction not reinjected")
+	}
+	if !pooled.Time().Equal(tx.Time()) {
+		t.Errorf("reinjected transaction arrival time mismatch: have %v, want %v", pooled.Time(), tx.Time())
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
 // Benchmarks the speed of validating the contents of the pending queue of the
 // transaction pool.
 func BenchmarkPendingDemotion100(b *testing.B)   { benchmarkPendingDemotion(b, 100) }
@@ -0,0 +1,138 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/haachain/go-haachain/core/types"
+	"github.com/haachain/go-haachain/log"
+	"github.com/haachain/go-haachain/rlp"
+)
+
+// errNoRemoteJournal is returned if the remote transactions are attempted to be
+// saved, but remote journaling is disabled.
+var errNoRemoteJournal = errors.New("remote transaction journal disabled")
+
+// remoteJournalEntry is a single transaction stored in the remote journal, along
+// with the time it was first seen locally to keep its arrival based ordering.
+type remoteJournalEntry struct {
+	Tx   *types.Transaction
+	Time uint64 // Unix time in nanoseconds
+}
+
+// txRemoteJournal is a snapshot of the remote transactions of the pool, with the
+// aim of allowing them to survive node restarts instead of waiting for the network
+// to gossip them again. Contrary to the local journal, it is not appended to as
+// transactions arrive, rather regenerated in full on every save.
+type txRemoteJournal struct {
+	path  string // Filesystem path to store the transactions at
+	limit uint64 // Maximum number of transactions to store
+}
+
+// newTxRemoteJournal creates a new remote transaction journal.
+func newTxRemoteJournal(path string, limit uint64) *txRemoteJournal {
+	return &txRemoteJournal{
+		path:  path,
+		limit: limit,
+	}
+}
+
+// load parses a remote transaction snapshot from disk, feeding its contents into
+// the specified pool. The pool is expected to validate the transactions against
+// the current state, dropping anything that became stale while offline.
+func (journal *txRemoteJournal) load(add func([]*types.Transaction) []error) error {
+	// Skip the parsing if the journal file doesn't exist at all
+	if _, err := os.Stat(journal.path); os.IsNotExist(err) {
+		return nil
+	}
+	input, err := os.Open(journal.path)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	// Parse all the transactions, stopping at the configured limit
+	var (
+		stream  = rlp.NewStream(input, 0)
+		txs     []*types.Transaction
+		failure error
+	)
+	for uint64(len(txs)) < journal.limit {
+		blob, err := stream.Raw()
+		if err != nil {
+			if err != io.EOF {
+				failure = err
+			}
+			break
+		}
+		// Snapshots without arrival times hold the bare transactions
+		var entry remoteJournalEntry
+		if err = rlp.DecodeBytes(blob, &entry); err == nil {
+			entry.Tx.SetTime(time.Unix(0, int64(entry.Time)))
+		} else {
+			entry.Tx = new(types.Transaction)
+			if err = rlp.DecodeBytes(blob, entry.Tx); err != nil {
+				failure = err
+				break
+			}
+		}
+		txs = append(txs, entry.Tx)
+	}
+	// Import the batch in one go, allowing nonce gapped ones to be reordered
+	dropped := 0
+	for _, err := range add(txs) {
+		if err != nil {
+			log.Debug("Failed to add journaled remote transaction", "err", err)
+			dropped++
+		}
+	}
+	log.Info("Loaded remote transaction journal", "transactions", len(txs), "dropped", dropped)
+
+	return failure
+}
+
+// save regenerates the remote transaction snapshot with the given transactions,
+// storing at most the configured limit of them. The number of transactions
+// written is returned.
+func (journal *txRemoteJournal) save(txs types.Transactions) (int, error) {
+	if uint64(len(txs)) > journal.limit {
+		txs = txs[:journal.limit]
+	}
+	replacement, err := os.OpenFile(journal.path+".new", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	for _, tx := range txs {
+		if err = rlp.Encode(replacement, &remoteJournalEntry{Tx: tx, Time: uint64(tx.Time().UnixNano())}); err != nil {
+			replacement.Close()
+			return 0, err
+		}
+	}
+	if err = replacement.Close(); err != nil {
+		return 0, err
+	}
+	// Replace the previous snapshot with the newly generated one
+	if err = os.Rename(journal.path+".new", journal.path); err != nil {
+		return 0, err
+	}
+	log.Info("Saved remote transaction journal", "transactions", len(txs))
+	return len(txs), nil
+}
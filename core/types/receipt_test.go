@@ -0,0 +1,70 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package types
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/rlp"
+)
+
+// Tests that the revert data of a receipt survives a storage round trip, and
+// that receipts stored before it was tracked can still be decoded.
+func TestReceiptStorageRevertData(t *testing.T) {
+	receipt := &Receipt{
+		Status:            ReceiphaaatusFailed,
+		CumulativeGasUsed: 1,
+		Logs:              []*Log{},
+		TxHash:            common.BytesToHash([]byte{0x11}),
+		GasUsed:           1,
+		RevertData:        []byte{0x08, 0xc3, 0x79, 0xa0},
+	}
+	blob, err := rlp.EncodeToBytes((*ReceiptForStorage)(receipt))
+	if err != nil {
+		t.Fatalf("failed to encode receipt: %v", err)
+	}
+	dec := new(ReceiptForStorage)
+	if err := rlp.DecodeBytes(blob, dec); err != nil {
+		t.Fatalf("failed to decode receipt: %v", err)
+	}
+	if !bytes.Equal(dec.RevertData, receipt.RevertData) {
+		t.Errorf("revert data mismatch: have %x, want %x", dec.RevertData, receipt.RevertData)
+	}
+	// Encode the receipt in the legacy format and ensure it still decodes
+	legacy, err := rlp.EncodeToBytes(&legacyReceiphaaorageRLP{
+		PoshaaateOrStatus: receiphaaatusFailedRLP,
+		CumulativeGasUsed: receipt.CumulativeGasUsed,
+		TxHash:            receipt.TxHash,
+		Logs:              []*LogForStorage{},
+		GasUsed:           receipt.GasUsed,
+	})
+	if err != nil {
+		t.Fatalf("failed to encode legacy receipt: %v", err)
+	}
+	dec = new(ReceiptForStorage)
+	if err := rlp.DecodeBytes(legacy, dec); err != nil {
+		t.Fatalf("failed to decode legacy receipt: %v", err)
+	}
+	if dec.TxHash != receipt.TxHash || dec.GasUsed != receipt.GasUsed || dec.Status != ReceiphaaatusFailed {
+		t.Errorf("legacy receipt mismatch: have %v", (*Receipt)(dec))
+	}
+	if dec.RevertData != nil {
+		t.Errorf("legacy receipt has revert data: %x", dec.RevertData)
+	}
+}
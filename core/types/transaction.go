@@ -23,6 +23,7 @@ import (
 	"io"
 	"math/big"
 	"sync/atomic"
+	"time"
 
 	"github.com/haachain/go-haachain/common"
 	"github.com/haachain/go-haachain/common/hexutil"
@@ -33,12 +34,18 @@ import (
 //go:generate gencodec -type txdata -field-override txdataMarshaling -out gen_tx_json.go
 
 var (
-	ErrInvalidSig = errors.New("invalid transaction v, r, s values")
-	errNoSigner   = errors.New("missing signing methods")
+	ErrInvalidSig         = errors.New("invalid transaction v, r, s values")
+	ErrTxTypeNotSupported = errors.New("transaction type not supported")
+	errNoSigner           = errors.New("missing signing methods")
+	errEmptyTypedTx       = errors.New("empty typed transaction bytes")
 )
 
 // deriveSigner makes a *best* guess about which signer to use.
-func deriveSigner(V *big.Int) Signer {
+func deriveSigner(tx *Transaction) Signer {
+	if tx.data.Type != LegacyTxType {
+		return NewEIP2930Signer(tx.data.ChainID)
+	}
+	V := tx.data.V
 	if V.Sign() != 0 && isProtectedV(V) {
 		return NewEIP155Signer(deriveChainId(V))
 	} else {
@@ -48,6 +55,7 @@ func deriveSigner(V *big.Int) Signer {
 
 type Transaction struct {
 	data txdata
+	time time.Time // Time first seen locally, used for arrival based ordering
 	// caches
 	hash atomic.Value
 	size atomic.Value
@@ -55,6 +63,12 @@ type Transaction struct {
 }
 
 type txdata struct {
+	// Typed transaction fields, these are not part of the legacy RLP encoding
+	// and are encoded in the transaction envelope instead.
+	Type       uint8      `json:"type"                 rlp:"-"`
+	ChainID    *big.Int   `json:"chainId,omitempty"    rlp:"-"`
+	AccessList AccessList `json:"accessList,omitempty" rlp:"-"`
+
 	AccountNonce uint64          `json:"nonce"    gencodec:"required"`
 	Price        *big.Int        `json:"gasPrice" gencodec:"required"`
 	GasLimit     uint64          `json:"gas"      gencodec:"required"`
@@ -72,6 +86,8 @@ type txdata struct {
 }
 
 type txdataMarshaling struct {
+	Type         hexutil.Uint64
+	ChainID      *hexutil.Big
 	AccountNonce hexutil.Uint64
 	Price        *hexutil.Big
 	GasLimit     hexutil.Uint64
@@ -112,16 +128,46 @@ func newTransaction(nonce uint64, to *common.Address, amount *big.Int, gasLimit
 		d.Price.Set(gasPrice)
 	}
 
-	return &Transaction{data: d}
+	return &Transaction{data: d, time: time.Now()}
+}
+
+// NewAccessListTransaction creates an unsigned EIP-2930 transaction carrying
+// an access list of the accounts and storage slots it intends to touch. A nil
+// recipient means contract creation.
+func NewAccessListTransaction(chainId *big.Int, nonce uint64, to *common.Address, amount *big.Int, gasLimit uint64, gasPrice *big.Int, data []byte, accessList AccessList) *Transaction {
+	tx := newTransaction(nonce, to, amount, gasLimit, gasPrice, data)
+	tx.data.Type = AccessListTxType
+	tx.data.ChainID = new(big.Int)
+	if chainId != nil {
+		tx.data.ChainID.Set(chainId)
+	}
+	tx.data.AccessList = accessList.copy()
+	return tx
+}
+
+// Type returns the transaction type.
+func (tx *Transaction) Type() uint8 {
+	return tx.data.Type
+}
+
+// AccessList returns the access list of the transaction, nil for legacy ones.
+func (tx *Transaction) AccessList() AccessList {
+	return tx.data.AccessList
 }
 
 // ChainId returns which chain id this transaction was signed for (if at all)
 func (tx *Transaction) ChainId() *big.Int {
+	if tx.data.Type != LegacyTxType {
+		return new(big.Int).Set(tx.data.ChainID)
+	}
 	return deriveChainId(tx.data.V)
 }
 
 // Protected returns whhaaer the transaction is protected from replay protection.
 func (tx *Transaction) Protected() bool {
+	if tx.data.Type != LegacyTxType {
+		return true
+	}
 	return isProtectedV(tx.data.V)
 }
 
@@ -134,20 +180,114 @@ func isProtectedV(V *big.Int) bool {
 	return true
 }
 
-// EncodeRLP implements rlp.Encoder
+// EncodeRLP implements rlp.Encoder. Legacy transactions are encoded as an RLP
+// list, typed transactions as an RLP string wrapping the typed envelope.
 func (tx *Transaction) EncodeRLP(w io.Writer) error {
-	return rlp.Encode(w, &tx.data)
+	if tx.data.Type == LegacyTxType {
+		return rlp.Encode(w, &tx.data)
+	}
+	enc, err := tx.encodeTyped()
+	if err != nil {
+		return err
+	}
+	return rlp.Encode(w, enc)
 }
 
 // DecodeRLP implements rlp.Decoder
 func (tx *Transaction) DecodeRLP(s *rlp.Stream) error {
-	_, size, _ := s.Kind()
-	err := s.Decode(&tx.data)
-	if err == nil {
-		tx.size.Store(common.StorageSize(rlp.ListSize(size)))
+	kind, size, err := s.Kind()
+	switch {
+	case err != nil:
+		return err
+	case kind == rlp.List:
+		// It's a legacy transaction
+		var data txdata
+		if err := s.Decode(&data); err != nil {
+			return err
+		}
+		tx.setDecoded(data, common.StorageSize(rlp.ListSize(size)))
+		return nil
+	default:
+		// It's a typed transaction wrapped into an RLP string
+		enc, err := s.Bytes()
+		if err != nil {
+			return err
+		}
+		data, err := decodeTyped(enc)
+		if err != nil {
+			return err
+		}
+		tx.setDecoded(data, common.StorageSize(rlp.ListSize(uint64(len(enc)))))
+		return nil
+	}
+}
+
+// MarshalBinary returns the canonical encoding of the transaction. For legacy
+// transactions this is the RLP encoding, for typed ones the typed envelope
+// (type byte followed by the RLP encoded payload).
+func (tx *Transaction) MarshalBinary() ([]byte, error) {
+	if tx.data.Type == LegacyTxType {
+		return rlp.EncodeToBytes(&tx.data)
+	}
+	return tx.encodeTyped()
+}
+
+// UnmarshalBinary decodes the canonical encoding of a transaction, accepting
+// both legacy RLP encoded transactions as well as typed envelopes.
+func (tx *Transaction) UnmarshalBinary(b []byte) error {
+	if len(b) > 0 && b[0] > 0x7f {
+		// It's a legacy transaction
+		var data txdata
+		if err := rlp.DecodeBytes(b, &data); err != nil {
+			return err
+		}
+		tx.setDecoded(data, common.StorageSize(len(b)))
+		return nil
+	}
+	data, err := decodeTyped(b)
+	if err != nil {
+		return err
+	}
+	tx.setDecoded(data, common.StorageSize(rlp.ListSize(uint64(len(b)))))
+	return nil
+}
+
+// encodeTyped returns the typed envelope of a non-legacy transaction.
+func (tx *Transaction) encodeTyped() ([]byte, error) {
+	switch tx.data.Type {
+	case AccessListTxType:
+		payload, err := rlp.EncodeToBytes(newAccessListTxdata(&tx.data))
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{tx.data.Type}, payload...), nil
+	default:
+		return nil, ErrTxTypeNotSupported
+	}
+}
+
+// decodeTyped decodes a typed transaction envelope.
+func decodeTyped(b []byte) (txdata, error) {
+	if len(b) == 0 {
+		return txdata{}, errEmptyTypedTx
+	}
+	switch b[0] {
+	case AccessListTxType:
+		var inner accessListTxdata
+		if err := rlp.DecodeBytes(b[1:], &inner); err != nil {
+			return txdata{}, err
+		}
+		return inner.txdata(), nil
+	default:
+		return txdata{}, ErrTxTypeNotSupported
 	}
+}
 
-	return err
+// setDecoded sets the inner transaction data and size after decoding.
+func (tx *Transaction) setDecoded(data txdata, size common.StorageSize) {
+	tx.data = data
+	tx.time = time.Now()
+	tx.size.Store(size)
 }
 
 // MarshalJSON encodes the web3 RPC transaction format.
@@ -165,16 +305,28 @@ func (tx *Transaction) UnmarshalJSON(input []byte) error {
 		return err
 	}
 	var V byte
-	if isProtectedV(dec.V) {
+	switch {
+	case dec.Type != LegacyTxType:
+		if dec.Type != AccessListTxType {
+			return ErrTxTypeNotSupported
+		}
+		if dec.ChainID == nil {
+			return errors.New("missing required field 'chainId' in typed transaction")
+		}
+		if dec.V.BitLen() > 8 {
+			return ErrInvalidSig
+		}
+		V = byte(dec.V.Uint64())
+	case isProtectedV(dec.V):
 		chainID := deriveChainId(dec.V).Uint64()
 		V = byte(dec.V.Uint64() - 35 - 2*chainID)
-	} else {
+	default:
 		V = byte(dec.V.Uint64() - 27)
 	}
 	if !crypto.ValidateSignatureValues(V, dec.R, dec.S, false) {
 		return ErrInvalidSig
 	}
-	*tx = Transaction{data: dec}
+	*tx = Transaction{data: dec, time: time.Now()}
 	return nil
 }
 
@@ -185,6 +337,16 @@ func (tx *Transaction) Value() *big.Int    { return new(big.Int).Set(tx.data.Amo
 func (tx *Transaction) Nonce() uint64      { return tx.data.AccountNonce }
 func (tx *Transaction) CheckNonce() bool   { return true }
 
+// Time returns the time when the transaction was first seen locally, either by
+// creating or by decoding it.
+func (tx *Transaction) Time() time.Time { return tx.time }
+
+// SetTime overrides the time when the transaction was first seen locally. It is
+// used to restore the arrival time of transactions decoded again after having
+// been seen before, such as the ones loaded from disk or reorged out. It must not
+// be called concurrently with Time.
+func (tx *Transaction) SetTime(t time.Time) { tx.time = t }
+
 // To returns the recipient address of the transaction.
 // It returns nil if the transaction is a contract creation.
 func (tx *Transaction) To() *common.Address {
@@ -195,13 +357,18 @@ func (tx *Transaction) To() *common.Address {
 	return &to
 }
 
-// Hash hashes the RLP encoding of tx.
-// It uniquely identifies the transaction.
+// Hash hashes the RLP encoding of tx, or the typed envelope for non-legacy
+// transactions. It uniquely identifies the transaction.
 func (tx *Transaction) Hash() common.Hash {
 	if hash := tx.hash.Load(); hash != nil {
 		return hash.(common.Hash)
 	}
-	v := rlpHash(tx)
+	var v common.Hash
+	if tx.data.Type == LegacyTxType {
+		v = rlpHash(tx)
+	} else {
+		v = prefixedRlpHash(tx.data.Type, newAccessListTxdata(&tx.data))
+	}
 	tx.hash.Store(v)
 	return v
 }
@@ -213,7 +380,7 @@ func (tx *Transaction) Size() common.StorageSize {
 		return size.(common.StorageSize)
 	}
 	c := writeCounter(0)
-	rlp.Encode(&c, &tx.data)
+	rlp.Encode(&c, tx)
 	tx.size.Store(common.StorageSize(c))
 	return common.StorageSize(c)
 }
@@ -231,6 +398,7 @@ func (tx *Transaction) AsMessage(s Signer) (Message, error) {
 		to:         tx.data.Recipient,
 		amount:     tx.data.Amount,
 		data:       tx.data.Payload,
+		accessList: tx.data.AccessList,
 		checkNonce: true,
 	}
 
@@ -246,7 +414,7 @@ func (tx *Transaction) WithSignature(signer Signer, sig []byte) (*Transaction, e
 	if err != nil {
 		return nil, err
 	}
-	cpy := &Transaction{data: tx.data}
+	cpy := &Transaction{data: tx.data, time: tx.time}
 	cpy.data.R, cpy.data.S, cpy.data.V = r, s, v
 	return cpy, nil
 }
@@ -267,7 +435,7 @@ func (tx *Transaction) String() string {
 	if tx.data.V != nil {
 		// make a best guess about the signer and use that to derive
 		// the sender.
-		signer := deriveSigner(tx.data.V)
+		signer := deriveSigner(tx)
 		if f, err := Sender(signer, tx); err != nil { // derive but don't cache
 			from = "[invalid sender: invalid sig]"
 		} else {
@@ -282,9 +450,10 @@ func (tx *Transaction) String() string {
 	} else {
 		to = fmt.Sprintf("%x", tx.data.Recipient[:])
 	}
-	enc, _ := rlp.EncodeToBytes(&tx.data)
+	enc, _ := tx.MarshalBinary()
 	return fmt.Sprintf(`
 	TX(%x)
+	Type:     %d
 	Contract: %v
 	From:     %s
 	To:       %s
@@ -299,6 +468,7 @@ func (tx *Transaction) String() string {
 	Hex:      %x
 `,
 		tx.Hash(),
+		tx.data.Type,
 		tx.data.Recipient == nil,
 		from,
 		to,
@@ -323,9 +493,10 @@ func (s Transactions) Len() int { return len(s) }
 // Swap swaps the i'th and the j'th element in s.
 func (s Transactions) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 
-// GetRlp implements Rlpable and returns the i'th element of s in rlp.
+// GetRlp implements Rlpable and returns the i'th element of s in rlp. Typed
+// transactions are returned as their raw envelope.
 func (s Transactions) GetRlp(i int) []byte {
-	enc, _ := rlp.EncodeToBytes(s[i])
+	enc, _ := s[i].MarshalBinary()
 	return enc
 }
 
@@ -446,6 +617,7 @@ type Message struct {
 	gasLimit   uint64
 	gasPrice   *big.Int
 	data       []byte
+	accessList AccessList
 	checkNonce bool
 }
 
@@ -470,3 +642,6 @@ func (m Message) Gas() uint64          { return m.gasLimit }
 func (m Message) Nonce() uint64        { return m.nonce }
 func (m Message) Data() []byte         { return m.data }
 func (m Message) CheckNonce() bool     { return m.checkNonce }
+
+// AccessList returns the access list of the message, nil for legacy ones.
+func (m Message) AccessList() AccessList { return m.accessList }
@@ -136,3 +136,39 @@ func TestChainId(t *testing.T) {
 		t.Error("expected no error")
 	}
 }
+
+func TestEIP2930Signing(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+
+	signer := NewEIP2930Signer(big.NewInt(18))
+	tx, err := SignTx(NewAccessListTransaction(big.NewInt(18), 0, &addr, new(big.Int), 0, new(big.Int), nil, AccessList{{Address: addr}}), signer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _ := tx.RawSignatureValues(); v.Uint64() > 1 {
+		t.Errorf("expected y-parity signature value, got %v", v)
+	}
+	from, err := Sender(signer, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from != addr {
+		t.Errorf("exected from and address to be equal. Got %x want %x", from, addr)
+	}
+	// Signers predating typed transactions must reject them
+	if _, err := Sender(NewEIP155Signer(big.NewInt(18)), tx); err != ErrTxTypeNotSupported {
+		t.Errorf("EIP155 signer error mismatch: have %v, want %v", err, ErrTxTypeNotSupported)
+	}
+	if _, err := Sender(NewEIP2930Signer(big.NewInt(19)), tx); err != ErrInvalidChainId {
+		t.Errorf("chain id mismatch error: have %v, want %v", err, ErrInvalidChainId)
+	}
+	// Legacy transactions are still signed the EIP155 way
+	legacy, err := SignTx(NewTransaction(0, addr, new(big.Int), 0, new(big.Int), nil), signer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from, err := Sender(NewEIP155Signer(big.NewInt(18)), legacy); err != nil || from != addr {
+		t.Errorf("legacy sender mismatch: have %x, err %v", from, err)
+	}
+}
@@ -233,3 +233,89 @@ func TestTransactionJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestAccessListTransactionEncode(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	to := common.HexToAddress("b94f5374fce5edbc8e2a8697c15331677e6ebf0b")
+	accesses := AccessList{
+		{Address: to, StorageKeys: []common.Hash{{0x01}, {0x02}}},
+		{Address: common.Address{0xaa}},
+	}
+	signer := NewEIP2930Signer(big.NewInt(18))
+	tx, err := SignTx(NewAccessListTransaction(big.NewInt(18), 3, &to, big.NewInt(10), 50000, big.NewInt(1), common.FromHex("5544"), accesses), signer, key)
+	if err != nil {
+		t.Fatalf("could not sign transaction: %v", err)
+	}
+	// The canonical encoding is the typed envelope, hashed as is
+	blob, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatalf("binary encode error: %v", err)
+	}
+	if blob[0] != AccessListTxType {
+		t.Fatalf("envelope type mismatch: have %d, want %d", blob[0], AccessListTxType)
+	}
+	if hash := crypto.Keccak256Hash(blob); tx.Hash() != hash {
+		t.Errorf("transaction hash mismatch: have %x, want %x", tx.Hash(), hash)
+	}
+	parsed := new(Transaction)
+	if err := parsed.UnmarshalBinary(blob); err != nil {
+		t.Fatalf("binary decode error: %v", err)
+	}
+	if parsed.Hash() != tx.Hash() {
+		t.Errorf("binary round trip hash mismatch: have %x, want %x", parsed.Hash(), tx.Hash())
+	}
+	// Inside RLP lists (e.g. block bodies) the envelope is wrapped into a string
+	enc, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatalf("rlp encode error: %v", err)
+	}
+	decoded, err := decodeTx(enc)
+	if err != nil {
+		t.Fatalf("rlp decode error: %v", err)
+	}
+	if decoded.Hash() != tx.Hash() {
+		t.Errorf("rlp round trip hash mismatch: have %x, want %x", decoded.Hash(), tx.Hash())
+	}
+	if decoded.Type() != AccessListTxType || decoded.ChainId().Cmp(big.NewInt(18)) != 0 {
+		t.Errorf("decoded envelope mismatch: type %d, chain id %v", decoded.Type(), decoded.ChainId())
+	}
+	if keys := decoded.AccessList().StorageKeys(); len(decoded.AccessList()) != 2 || keys != 2 {
+		t.Errorf("decoded access list mismatch: %d tuples, %d keys", len(decoded.AccessList()), keys)
+	}
+	if uint64(tx.Size()) != uint64(len(enc)) {
+		t.Errorf("transaction size mismatch: have %v, want %d", tx.Size(), len(enc))
+	}
+	// Legacy transactions should still decode from their binary encoding
+	legacy := new(Transaction)
+	if err := legacy.UnmarshalBinary(common.FromHex("f86103018207d094b94f5374fce5edbc8e2a8697c15331677e6ebf0b0a8255441ca098ff921201554726367d2be8c804a7ff89ccf285ebc57dff8ae4c44b9c19ac4aa08887321be575c8095f789dd4c743dfe42c1820f9231f98a962b210e3ac2452a3")); err != nil {
+		t.Fatalf("legacy binary decode error: %v", err)
+	}
+	if legacy.Hash() != rightvrsTx.Hash() {
+		t.Errorf("legacy binary decode hash mismatch: have %x, want %x", legacy.Hash(), rightvrsTx.Hash())
+	}
+}
+
+func TestAccessListTransactionJSON(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	signer := NewEIP2930Signer(common.Big1)
+
+	accesses := AccessList{{Address: common.Address{1}, StorageKeys: []common.Hash{{0x01}}}}
+	tx, err := SignTx(NewAccessListTransaction(common.Big1, 1, nil, common.Big0, 1, common.Big2, []byte("abcdef"), accesses), signer, key)
+	if err != nil {
+		t.Fatalf("could not sign transaction: %v", err)
+	}
+	data, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	var parsedTx *Transaction
+	if err := json.Unmarshal(data, &parsedTx); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if tx.Hash() != parsedTx.Hash() {
+		t.Errorf("parsed tx differs from original tx, want %v, got %v", tx, parsedTx)
+	}
+	if from, err := Sender(signer, parsedTx); err != nil || from != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Errorf("parsed tx sender mismatch: have %x, err %v", from, err)
+	}
+}
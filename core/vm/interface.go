@@ -62,6 +62,18 @@ type StateDB interface {
 	AddPreimage(common.Hash, []byte)
 
 	ForEachStorage(common.Address, func(common.Hash, common.Hash) bool)
+
+	// PrepareAccessList resets and warms up the EIP2929 access list at the
+	// start of a transaction.
+	PrepareAccessList(sender common.Address, dst *common.Address, precompiles []common.Address, list types.AccessList)
+	AddressInAccessList(addr common.Address) bool
+	SlotInAccessList(addr common.Address, slot common.Hash) (addressOk bool, slotOk bool)
+	// AddAddressToAccessList adds the given address to the access list. This
+	// operation is safe to perform even if the feature/fork is not active yet.
+	AddAddressToAccessList(addr common.Address)
+	// AddSlotToAccessList adds the given (address,slot) to the access list. This
+	// operation is safe to perform even if the feature/fork is not active yet.
+	AddSlotToAccessList(addr common.Address, slot common.Hash)
 }
 
 // CallContext provides a basic interface for the EVM calling conventions. The EVM EVM
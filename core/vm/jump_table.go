@@ -55,8 +55,27 @@ var (
 	homesteadInstructionSet      = NewHomesteadInstructionSet()
 	byzantiumInstructionSet      = NewByzantiumInstructionSet()
 	constantinopleInstructionSet = NewConstantinopleInstructionSet()
+	berlinInstructionSet         = NewBerlinInstructionSet()
 )
 
+// NewBerlinInstructionSet returns the frontier, homestead, byzantium,
+// constantinople and berlin instructions. Berlin reprices the state access
+// opcodes according to the EIP2929 warm/cold access rules.
+func NewBerlinInstructionSet() [256]operation {
+	instructionSet := NewConstantinopleInstructionSet()
+	instructionSet[SLOAD].gasCost = gasSLoadEIP2929
+	instructionSet[SSTORE].gasCost = gasSStoreEIP2929
+	instructionSet[BALANCE].gasCost = gasEip2929AccountCheck
+	instructionSet[EXTCODESIZE].gasCost = gasEip2929AccountCheck
+	instructionSet[EXTCODECOPY].gasCost = gasExtCodeCopyEIP2929
+	instructionSet[CALL].gasCost = gasCallEIP2929
+	instructionSet[CALLCODE].gasCost = gasCallCodeEIP2929
+	instructionSet[DELEGATECALL].gasCost = gasDelegateCallEIP2929
+	instructionSet[STATICCALL].gasCost = gasStaticCallEIP2929
+	instructionSet[SELFDESTRUCT].gasCost = gasSuicideEIP2929
+	return instructionSet
+}
+
 // NewConstantinopleInstructionSet returns the frontier, homestead
 // byzantium and contantinople instructions.
 func NewConstantinopleInstructionSet() [256]operation {
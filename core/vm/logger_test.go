@@ -17,8 +17,13 @@
 package vm
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"math/big"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/haachain/go-haachain/common"
 	"github.com/haachain/go-haachain/params"
@@ -68,3 +73,46 @@ func TeshaaoreCapture(t *testing.T) {
 		t.Errorf("expected %x, got %x", exp, logger.changedValues[contract.Address()][index])
 	}
 }
+
+func TestJSONLoggerFormat(t *testing.T) {
+	var (
+		env      = NewEVM(Context{}, &dummyStateDB{}, params.TestChainConfig, Config{})
+		out      = new(bytes.Buffer)
+		logger   = NewJSONLogger(&LogConfig{DisableMemory: true}, out)
+		mem      = NewMemory()
+		stack    = newstack()
+		contract = NewContract(&dummyContractRef{}, &dummyContractRef{}, new(big.Int), 0)
+	)
+	env.interpreter.returnData = []byte{0xde, 0xad}
+	stack.push(big.NewInt(1))
+
+	logger.CaptureState(env, 3, ADD, 100, 3, mem, stack, contract, 1, nil)
+	logger.CaptureEnd([]byte{0x01}, 21000, time.Second, errors.New("oops"))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("line count mismatch: have %d, want 2", len(lines))
+	}
+	var step map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &step); err != nil {
+		t.Fatalf("failed to decode step: %v", err)
+	}
+	for _, field := range []string{"pc", "op", "gas", "gasCost", "memSize", "stack", "returnData", "depth", "refund"} {
+		if _, ok := step[field]; !ok {
+			t.Errorf("step missing field %q: %s", field, lines[0])
+		}
+	}
+	if step["memory"] != "0x" {
+		t.Errorf("step contains disabled memory: %s", lines[0])
+	}
+	if step["returnData"] != "0xdead" {
+		t.Errorf("return data mismatch: have %v, want 0xdead", step["returnData"])
+	}
+	var end map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &end); err != nil {
+		t.Fatalf("failed to decode summary: %v", err)
+	}
+	if end["output"] != "01" || end["gasUsed"] != "0x5208" || end["error"] != "oops" {
+		t.Errorf("summary mismatch: %s", lines[1])
+	}
+}
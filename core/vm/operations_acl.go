@@ -0,0 +1,139 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/common/math"
+	"github.com/haachain/go-haachain/params"
+)
+
+// gasSLoadEIP2929 calculates dynamic gas for SLOAD according to EIP-2929. For
+// a warm slot it charges WarmStorageReadCost, for a cold one ColdSloadCost,
+// warming the slot up in the process.
+func gasSLoadEIP2929(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	slot := common.BigToHash(stack.Back(0))
+	if _, slotPresent := evm.StateDB.SlotInAccessList(contract.Address(), slot); !slotPresent {
+		// If the caller cannot afford the cost, this change will be rolled back
+		evm.StateDB.AddSlotToAccessList(contract.Address(), slot)
+		return params.ColdSloadCost, nil
+	}
+	return params.WarmStorageReadCost, nil
+}
+
+// gasSStoreEIP2929 calculates dynamic gas for SSTORE, charging the legacy
+// store cost plus a ColdSloadCost surcharge if the slot was not yet accessed.
+func gasSStoreEIP2929(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	gas, err := gasSStore(gt, evm, contract, stack, mem, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	slot := common.BigToHash(stack.Back(0))
+	if _, slotPresent := evm.StateDB.SlotInAccessList(contract.Address(), slot); !slotPresent {
+		evm.StateDB.AddSlotToAccessList(contract.Address(), slot)
+
+		var overflow bool
+		if gas, overflow = math.SafeAdd(gas, params.ColdSloadCost); overflow {
+			return 0, errGasUintOverflow
+		}
+	}
+	return gas, nil
+}
+
+// gasAccountAccessEIP2929 returns the cost of touching the account at the
+// given address, warming it up if it was cold.
+func gasAccountAccessEIP2929(evm *EVM, addr common.Address) uint64 {
+	if !evm.StateDB.AddressInAccessList(addr) {
+		evm.StateDB.AddAddressToAccessList(addr)
+		return params.ColdAccountAccessCost
+	}
+	return params.WarmStorageReadCost
+}
+
+// gasEip2929AccountCheck is the gas function for BALANCE and EXTCODESIZE
+// according to EIP-2929.
+func gasEip2929AccountCheck(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	return gasAccountAccessEIP2929(evm, common.BigToAddress(stack.Back(0))), nil
+}
+
+// gasExtCodeCopyEIP2929 implements EXTCODECOPY according to EIP-2929, the
+// account access cost replaces the flat ExtcodeCopy fee.
+func gasExtCodeCopyEIP2929(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	gt.ExtcodeCopy = gasAccountAccessEIP2929(evm, common.BigToAddress(stack.Back(0)))
+	return gasExtCodeCopy(gt, evm, contract, stack, mem, memorySize)
+}
+
+// makeCallVariantGasCallEIP2929 wraps the gas function of a call variant so
+// that the flat call fee is replaced by the EIP-2929 account access cost.
+func makeCallVariantGasCallEIP2929(oldCalculator gasFunc) gasFunc {
+	return func(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+		addr := common.BigToAddress(stack.Back(1))
+		warmAccess := evm.StateDB.AddressInAccessList(addr)
+
+		// The warm storage read cost is charged as the base call fee, the
+		// cold surcharge is deducted upfront so that the 63/64ths rule in
+		// the old calculator is applied to the remaining gas only.
+		gt.Calls = params.WarmStorageReadCost
+		coldCost := params.ColdAccountAccessCost - params.WarmStorageReadCost
+		if !warmAccess {
+			evm.StateDB.AddAddressToAccessList(addr)
+			if !contract.UseGas(coldCost) {
+				return 0, ErrOutOfGas
+			}
+		}
+		gas, err := oldCalculator(gt, evm, contract, stack, mem, memorySize)
+		if warmAccess || err != nil {
+			return gas, err
+		}
+		// The cold surcharge was already deducted, give it back so that the
+		// interpreter charges the full amount in one go.
+		contract.Gas += coldCost
+
+		var overflow bool
+		if gas, overflow = math.SafeAdd(gas, coldCost); overflow {
+			return 0, errGasUintOverflow
+		}
+		return gas, nil
+	}
+}
+
+var (
+	gasCallEIP2929         = makeCallVariantGasCallEIP2929(gasCall)
+	gasDelegateCallEIP2929 = makeCallVariantGasCallEIP2929(gasDelegateCall)
+	gasStaticCallEIP2929   = makeCallVariantGasCallEIP2929(gasStaticCall)
+	gasCallCodeEIP2929     = makeCallVariantGasCallEIP2929(gasCallCode)
+)
+
+// gasSuicideEIP2929 charges the cold account access cost on top of the legacy
+// SELFDESTRUCT cost if the beneficiary was not yet accessed.
+func gasSuicideEIP2929(gt params.GasTable, evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+	gas, err := gasSuicide(gt, evm, contract, stack, mem, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	address := common.BigToAddress(stack.Back(0))
+	if !evm.StateDB.AddressInAccessList(address) {
+		// If the caller cannot afford the cost, this change will be rolled back
+		evm.StateDB.AddAddressToAccessList(address)
+
+		var overflow bool
+		if gas, overflow = math.SafeAdd(gas, params.ColdAccountAccessCost); overflow {
+			return 0, errGasUintOverflow
+		}
+	}
+	return gas, nil
+}
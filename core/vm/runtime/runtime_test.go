@@ -20,12 +20,15 @@ import (
 	"math/big"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/haachain/go-haachain/accounts/abi"
 	"github.com/haachain/go-haachain/common"
 	"github.com/haachain/go-haachain/core/state"
 	"github.com/haachain/go-haachain/core/vm"
+	"github.com/haachain/go-haachain/crypto"
 	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/params"
 )
 
 func TestDefaults(t *testing.T) {
@@ -117,6 +120,99 @@ func TestCall(t *testing.T) {
 	}
 }
 
+// Tests that post-Berlin storage accesses are priced according to whhaaer the
+// slot was already accessed during the transaction.
+func TestColdWarmStorageAccess(t *testing.T) {
+	db, _ := haadb.NewMemDatabase()
+	state, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	address := common.HexToAddress("0x0a")
+	state.SetCode(address, []byte{
+		byte(vm.PUSH1), 0,
+		byte(vm.SLOAD),
+		byte(vm.POP),
+		byte(vm.PUSH1), 0,
+		byte(vm.SLOAD),
+		byte(vm.POP),
+	})
+	config := &params.ChainConfig{
+		ChainId:             big.NewInt(1),
+		HomesteadBlock:      new(big.Int),
+		EIP150Block:         new(big.Int),
+		EIP155Block:         new(big.Int),
+		EIP158Block:         new(big.Int),
+		ByzantiumBlock:      new(big.Int),
+		ConstantinopleBlock: new(big.Int),
+		BerlinBlock:         new(big.Int),
+	}
+	_, leftOver, err := Call(address, nil, &Config{State: state, ChainConfig: config, GasLimit: 100000})
+	if err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	// PUSH1 + cold SLOAD + POP + PUSH1 + warm SLOAD + POP
+	want := 3 + params.ColdSloadCost + 2 + 3 + params.WarmStorageReadCost + 2
+	if used := 100000 - leftOver; used != want {
+		t.Errorf("gas used mismatch: have %d, want %d", used, want)
+	}
+	if _, slotOk := state.SlotInAccessList(address, common.Hash{}); !slotOk {
+		t.Error("accessed slot missing from the access list")
+	}
+}
+
+// frameTracer is a vm.Tracer recording the call frames entered and exited.
+type frameTracer struct {
+	enters []vm.OpCode
+	exits  []error
+}
+
+func (t *frameTracer) CaptureStart(from common.Address, to common.Address, call bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+func (t *frameTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+func (t *frameTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+func (t *frameTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) error {
+	t.enters = append(t.enters, typ)
+	return nil
+}
+func (t *frameTracer) CaptureExit(output []byte, gasUsed uint64, err error) error {
+	t.exits = append(t.exits, err)
+	return nil
+}
+func (t *frameTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+// Tests that a CREATE failing on an address collision is still reported to the
+// tracer as an entered and exited call frame.
+func TestCreateCollisionTracing(t *testing.T) {
+	db, _ := haadb.NewMemDatabase()
+	state, _ := state.New(common.Hash{}, state.NewDatabase(db))
+	address := common.HexToAddress("0x0a")
+	state.SetCode(address, []byte{
+		byte(vm.PUSH1), 0,
+		byte(vm.DUP1),
+		byte(vm.DUP1),
+		byte(vm.CREATE),
+		byte(vm.STOP),
+	})
+	// Occupy the address the contract would be created at
+	state.SetNonce(crypto.CreateAddress(address, 0), 1)
+
+	tracer := new(frameTracer)
+	if _, _, err := Call(address, nil, &Config{State: state, EVMConfig: vm.Config{Debug: true, Tracer: tracer}}); err != nil {
+		t.Fatal("didn't expect error", err)
+	}
+	if len(tracer.enters) != 1 || tracer.enters[0] != vm.CREATE {
+		t.Fatalf("entered frames mismatch: have %v, want [CREATE]", tracer.enters)
+	}
+	if len(tracer.exits) != 1 || tracer.exits[0] != vm.ErrContractAddressCollision {
+		t.Fatalf("exited frames mismatch: have %v, want [%v]", tracer.exits, vm.ErrContractAddressCollision)
+	}
+}
+
 func BenchmarkCall(b *testing.B) {
 	var definition = `[{"constant":true,"inputs":[],"name":"seller","outputs":[{"name":"","type":"address"}],"type":"function"},{"constant":false,"inputs":[],"name":"abort","outputs":[],"type":"function"},{"constant":true,"inputs":[],"name":"value","outputs":[{"name":"","type":"uint256"}],"type":"function"},{"constant":false,"inputs":[],"name":"refund","outputs":[],"type":"function"},{"constant":true,"inputs":[],"name":"buyer","outputs":[{"name":"","type":"address"}],"type":"function"},{"constant":false,"inputs":[],"name":"confirmReceived","outputs":[],"type":"function"},{"constant":true,"inputs":[],"name":"state","outputs":[{"name":"","type":"uint8"}],"type":"function"},{"constant":false,"inputs":[],"name":"confirmPurchase","outputs":[],"type":"function"},{"inputs":[],"type":"constructor"},{"anonymous":false,"inputs":[],"name":"Aborted","type":"event"},{"anonymous":false,"inputs":[],"name":"PurchaseConfirmed","type":"event"},{"anonymous":false,"inputs":[],"name":"ItemReceived","type":"event"},{"anonymous":false,"inputs":[],"name":"Refunded","type":"event"}]`
 
@@ -0,0 +1,369 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/common/hexutil"
+	"github.com/haachain/go-haachain/consensus/ethash"
+	"github.com/haachain/go-haachain/core"
+	"github.com/haachain/go-haachain/core/state"
+	"github.com/haachain/go-haachain/core/types"
+	"github.com/haachain/go-haachain/core/vm"
+	"github.com/haachain/go-haachain/crypto"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/params"
+	"github.com/haachain/go-haachain/rpc"
+)
+
+// testBackend is a Backend running message calls on top of a short generated
+// chain. Only the methods needed by the tested APIs are implemented.
+type testBackend struct {
+	Backend // Nil, panics on any method not overridden below
+
+	db    haadb.Database
+	chain *core.BlockChain
+}
+
+// newTestBackend creates a chain with the given genesis allocation, extended by
+// n blocks assembled by the optional generator.
+func newTestBackend(t *testing.T, alloc core.GenesisAlloc, n int, gen func(int, *core.BlockGen)) *testBackend {
+	db, _ := haadb.NewMemDatabase()
+	genesis := &core.Genesis{Config: params.AllhaaashProtocolChanges, Alloc: alloc}
+	genesis.MustCommit(db)
+
+	chain, err := core.NewBlockChain(db, nil, genesis.Config, ethash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	if n > 0 {
+		blocks, _ := core.GenerateChain(genesis.Config, chain.Genesis(), ethash.NewFaker(), db, n, gen)
+		if _, err := chain.InsertChain(blocks); err != nil {
+			t.Fatalf("failed to insert chain: %v", err)
+		}
+	}
+	return &testBackend{db: db, chain: chain}
+}
+
+func (b *testBackend) ChainConfig() *params.ChainConfig { return b.chain.Config() }
+func (b *testBackend) ChainDb() haadb.Database          { return b.db }
+
+func (b *testBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
+	return core.GetBlockReceipts(b.db, hash, core.GetBlockNumber(b.db, hash)), nil
+}
+
+func (b *testBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Block, error) {
+	return b.chain.CurrentBlock(), nil
+}
+
+func (b *testBackend) StateAndHeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*state.StateDB, *types.Header, error) {
+	block := b.chain.CurrentBlock()
+	statedb, err := b.chain.StateAt(block.Root())
+	return statedb, block.Header(), err
+}
+
+func (b *testBackend) GetEVM(ctx context.Context, msg core.Message, state *state.StateDB, header *types.Header, vmCfg vm.Config) (*vm.EVM, func() error, error) {
+	context := core.NewEVMContext(msg, header, b.chain, nil)
+	return vm.NewEVM(context, state, b.chain.Config(), vmCfg), func() error { return nil }, nil
+}
+
+var (
+	testKey, _  = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	testAddr    = crypto.PubkeyToAddress(testKey.PublicKey)
+	testBalance = big.NewInt(1000000000000000000)
+)
+
+// gatedCode returns contract code that stops if cond leaves a non-zero value
+// on the stack, and reverts otherwise.
+func gatedCode(cond ...byte) []byte {
+	dest := byte(len(cond) + 7)
+	code := append(common.CopyBytes(cond), 0x60, dest, 0x57) // PUSH1 dest, JUMPI
+	return append(code, 0x60, 0x00, 0x80, 0xfd, 0x5b, 0x00)  // PUSH1 0, DUP1, REVERT, JUMPDEST, STOP
+}
+
+// createCheckCode returns a condition checking that a CREATE from the contract
+// at addr deploys to the address derived from the given nonce.
+func createCheckCode(addr common.Address, nonce uint64) []byte {
+	code := []byte{0x60, 0x00, 0x80, 0x80, 0xf0, 0x73} // PUSH1 0, DUP1, DUP1, CREATE, PUSH20
+	code = append(code, crypto.CreateAddress(addr, nonce).Bytes()...)
+	return append(code, 0x14) // EQ
+}
+
+func TestCallOverrides(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0xc0de")
+		// ADDRESS, BALANCE, PUSH1 0, MSTORE, PUSH1 32, PUSH1 0, RETURN
+		balanceCode = common.FromHex("0x303160005260206000f3")
+		// PUSH1 0, DUP1, DUP1, CREATE, PUSH1 0, MSTORE, PUSH1 32, PUSH1 0, RETURN
+		createCode = common.FromHex("0x60008080f060005260206000f3")
+		// PUSH1 0, SLOAD, PUSH1 0, MSTORE, PUSH1 1, SLOAD, PUSH1 32, MSTORE, PUSH1 64, PUSH1 0, RETURN
+		storageCode = common.FromHex("0x60005460005260015460205260406000f3")
+
+		slot0, slot1 = common.Hash{}, common.BigToHash(big.NewInt(1))
+		one, five    = common.BigToHash(big.NewInt(1)), common.BigToHash(big.NewInt(5))
+	)
+	balance := (*hexutil.Big)(big.NewInt(1234))
+	nonce := hexutil.Uint64(5)
+
+	tests := []struct {
+		name      string
+		code      []byte
+		storage   map[common.Hash]common.Hash
+		overrides StateOverride
+		want      []byte
+		wantErr   bool
+	}{
+		{
+			name:      "balance",
+			code:      balanceCode,
+			overrides: StateOverride{contract: {Balance: &balance}},
+			want:      common.BigToHash(big.NewInt(1234)).Bytes(),
+		},
+		{
+			name:      "nonce",
+			code:      createCode,
+			overrides: StateOverride{contract: {Nonce: &nonce}},
+			want:      common.BytesToHash(crypto.CreateAddress(contract, 5).Bytes()).Bytes(),
+		},
+		{
+			name:      "code",
+			code:      createCode,
+			overrides: StateOverride{contract: {Code: (*hexutil.Bytes)(&balanceCode)}},
+			want:      common.Hash{}.Bytes(),
+		},
+		{
+			name:      "state",
+			code:      storageCode,
+			storage:   map[common.Hash]common.Hash{slot0: one, slot1: one},
+			overrides: StateOverride{contract: {State: &map[common.Hash]common.Hash{slot1: five}}},
+			want:      append(common.Hash{}.Bytes(), five.Bytes()...),
+		},
+		{
+			name:      "stateDiff",
+			code:      storageCode,
+			storage:   map[common.Hash]common.Hash{slot0: one, slot1: one},
+			overrides: StateOverride{contract: {StateDiff: &map[common.Hash]common.Hash{slot1: five}}},
+			want:      append(one.Bytes(), five.Bytes()...),
+		},
+		{
+			name:    "invalid",
+			code:    storageCode,
+			storage: map[common.Hash]common.Hash{slot0: one},
+			overrides: StateOverride{contract: {
+				State:     &map[common.Hash]common.Hash{slot1: five},
+				StateDiff: &map[common.Hash]common.Hash{slot1: five},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		backend := newTestBackend(t, core.GenesisAlloc{
+			testAddr: {Balance: testBalance},
+			contract: {Code: tt.code, Storage: tt.storage, Balance: new(big.Int)},
+		}, 0, nil)
+		api := NewPublicBlockChainAPI(backend)
+		args := CallArgs{From: testAddr, To: &contract, Gas: 1000000}
+
+		res, err := api.Call(context.Background(), args, rpc.LatestBlockNumber, &tt.overrides)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: call succeeded with invalid override", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: call failed: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(res, tt.want) {
+			t.Errorf("%s: result mismatch: have %x, want %x", tt.name, []byte(res), tt.want)
+		}
+	}
+}
+
+func TestEstimateGasOverrides(t *testing.T) {
+	var (
+		contract = common.HexToAddress("0xc0de")
+		slot0    = common.Hash{}
+		one      = common.BigToHash(big.NewInt(1))
+
+		revertCode = common.FromHex("0x600080fd") // PUSH1 0, DUP1, REVERT
+		stopCode   = common.FromHex("0x00")       // STOP
+	)
+	balance := (*hexutil.Big)(big.NewInt(1))
+	nonce := hexutil.Uint64(5)
+
+	tests := []struct {
+		name      string
+		code      []byte
+		storage   map[common.Hash]common.Hash
+		overrides StateOverride
+		wantErr   string
+	}{
+		{
+			name:      "balance",
+			code:      gatedCode(0x30, 0x31), // ADDRESS, BALANCE
+			overrides: StateOverride{contract: {Balance: &balance}},
+		},
+		{
+			name:      "nonce",
+			code:      gatedCode(createCheckCode(contract, 5)...),
+			overrides: StateOverride{contract: {Nonce: &nonce}},
+		},
+		{
+			name:      "code",
+			code:      revertCode,
+			overrides: StateOverride{contract: {Code: (*hexutil.Bytes)(&stopCode)}},
+		},
+		{
+			name:      "state",
+			code:      gatedCode(0x60, 0x00, 0x54), // PUSH1 0, SLOAD
+			overrides: StateOverride{contract: {State: &map[common.Hash]common.Hash{slot0: one}}},
+		},
+		{
+			name:      "stateDiff",
+			code:      gatedCode(0x60, 0x00, 0x54), // PUSH1 0, SLOAD
+			overrides: StateOverride{contract: {StateDiff: &map[common.Hash]common.Hash{slot0: one}}},
+		},
+		{
+			name: "invalid",
+			code: gatedCode(0x60, 0x00, 0x54), // PUSH1 0, SLOAD
+			overrides: StateOverride{contract: {
+				State:     &map[common.Hash]common.Hash{slot0: one},
+				StateDiff: &map[common.Hash]common.Hash{slot0: one},
+			}},
+			wantErr: "account " + contract.Hex() + " has both 'state' and 'stateDiff'",
+		},
+	}
+	for _, tt := range tests {
+		backend := newTestBackend(t, core.GenesisAlloc{
+			testAddr: {Balance: testBalance},
+			contract: {Code: tt.code, Storage: tt.storage, Balance: new(big.Int)},
+		}, 0, nil)
+		api := NewPublicBlockChainAPI(backend)
+		args := CallArgs{From: testAddr, To: &contract}
+
+		// Without the overrides the execution must fail
+		if _, err := api.EstimateGas(context.Background(), args, nil); err == nil {
+			t.Errorf("%s: estimation succeeded without overrides", tt.name)
+		}
+		gas, err := api.EstimateGas(context.Background(), args, &tt.overrides)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: estimation failed: %v", tt.name, err)
+			continue
+		}
+		if uint64(gas) < params.TxGas {
+			t.Errorf("%s: estimate below intrinsic gas: %d", tt.name, gas)
+		}
+	}
+}
+
+// revertCode returns contract code reverting with the given data.
+func revertCode(data []byte) []byte {
+	size := byte(len(data))
+	code := []byte{0x60, size, 0x60, 12, 0x60, 0x00, 0x39} // PUSH1 size, PUSH1 12, PUSH1 0, CODECOPY
+	code = append(code, 0x60, size, 0x60, 0x00, 0xfd)      // PUSH1 size, PUSH1 0, REVERT
+	return append(code, data...)
+}
+
+// revertData returns the ABI encoding of an Error(string) revert reason.
+func revertData(reason string) []byte {
+	data := common.FromHex("0x08c379a0")
+	data = append(data, common.LeftPadBytes([]byte{0x20}, 32)...)
+	data = append(data, common.LeftPadBytes([]byte{byte(len(reason))}, 32)...)
+	return append(data, common.RightPadBytes([]byte(reason), 32)...)
+}
+
+// Tests that reverted calls and gas estimations return the revert data and the
+// decoded reason as a JSON-RPC error with code 3.
+func TestCallRevert(t *testing.T) {
+	contract := common.HexToAddress("0xc0de")
+	backend := newTestBackend(t, core.GenesisAlloc{
+		testAddr: {Balance: testBalance},
+		contract: {Code: revertCode(revertData("boom")), Balance: new(big.Int)},
+	}, 0, nil)
+	api := NewPublicBlockChainAPI(backend)
+
+	type dataError interface {
+		Error() string
+		ErrorCode() int
+		ErrorData() interface{}
+	}
+	check := func(method string, err error) {
+		rerr, ok := err.(dataError)
+		if !ok {
+			t.Fatalf("%s: error type mismatch: have %T (%v), want data error", method, err, err)
+		}
+		if code := rerr.ErrorCode(); code != 3 {
+			t.Errorf("%s: error code mismatch: have %d, want 3", method, code)
+		}
+		if data, want := rerr.ErrorData(), hexutil.Encode(revertData("boom")); data != want {
+			t.Errorf("%s: error data mismatch: have %v, want %v", method, data, want)
+		}
+		if msg, want := rerr.Error(), "execution reverted: boom"; msg != want {
+			t.Errorf("%s: error message mismatch: have %q, want %q", method, msg, want)
+		}
+	}
+	_, err := api.Call(context.Background(), CallArgs{From: testAddr, To: &contract, Gas: 1000000}, rpc.LatestBlockNumber, nil)
+	check("call", err)
+
+	_, err = api.EstimateGas(context.Background(), CallArgs{From: testAddr, To: &contract}, nil)
+	check("estimateGas", err)
+}
+
+// Tests that the receipts of reverted transactions carry the revert data and
+// the decoded reason.
+func TestReceiptRevertReason(t *testing.T) {
+	contract := common.HexToAddress("0xc0de")
+
+	tx, _ := types.SignTx(types.NewTransaction(0, contract, new(big.Int), 100000, big.NewInt(1), nil), types.HomesteadSigner{}, testKey)
+	backend := newTestBackend(t, core.GenesisAlloc{
+		testAddr: {Balance: testBalance},
+		contract: {Code: revertCode(revertData("boom")), Balance: new(big.Int)},
+	}, 1, func(i int, b *core.BlockGen) {
+		b.AddTx(tx)
+	})
+	api := NewPublicTransactionPoolAPI(backend, new(AddrLocker))
+
+	fields, err := api.GetTransactionReceipt(context.Background(), tx.Hash())
+	if err != nil {
+		t.Fatalf("failed to retrieve receipt: %v", err)
+	}
+	if fields == nil {
+		t.Fatalf("receipt not found")
+	}
+	if status := fields["status"]; status != hexutil.Uint(types.ReceiphaaatusFailed) {
+		t.Errorf("status mismatch: have %v, want %v", status, types.ReceiphaaatusFailed)
+	}
+	if data, ok := fields["revertData"].(hexutil.Bytes); !ok || !bytes.Equal(data, revertData("boom")) {
+		t.Errorf("revert data mismatch: have %v, want %x", fields["revertData"], revertData("boom"))
+	}
+	if reason := fields["revertReason"]; reason != "boom" {
+		t.Errorf("revert reason mismatch: have %v, want %q", reason, "boom")
+	}
+}
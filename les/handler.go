@@ -498,7 +498,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if pm.fetcher != nil && pm.fetcher.requestedID(resp.ReqID) {
 			pm.fetcher.deliverHeaders(p, resp.ReqID, resp.Headers)
 		} else {
-			err := pm.downloader.DeliverHeaders(p.id, resp.Headers)
+			err := pm.downloader.DeliverHeaders(p.id, 0, resp.Headers)
 			if err != nil {
 				log.Debug(fmt.Sprint(err))
 			}
@@ -1187,7 +1187,10 @@ func (pc *peerConnection) Head() (common.Hash, *big.Int) {
 	return pc.peer.HeadAndTd()
 }
 
-func (pc *peerConnection) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
+// RequestHeadersByHash implements downloader.LightPeer. The downloader's request
+// ID is not sent, as light requests are tracked by their own IDs; the response
+// is delivered without one and matched to the peer instead.
+func (pc *peerConnection) RequestHeadersByHash(id uint64, origin common.Hash, amount int, skip int, reverse bool) error {
 	reqID := genReqID()
 	rq := &distReq{
 		getCost: func(dp distPeer) uint64 {
@@ -1211,7 +1214,9 @@ func (pc *peerConnection) RequestHeadersByHash(origin common.Hash, amount int, s
 	return nil
 }
 
-func (pc *peerConnection) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
+// RequestHeadersByNumber implements downloader.LightPeer, ignoring the request ID
+// the same way as RequestHeadersByHash.
+func (pc *peerConnection) RequestHeadersByNumber(id uint64, origin uint64, amount int, skip int, reverse bool) error {
 	reqID := genReqID()
 	rq := &distReq{
 		getCost: func(dp distPeer) uint64 {
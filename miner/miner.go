@@ -163,6 +163,12 @@ func (self *Miner) SetExtra(extra []byte) error {
 	return nil
 }
 
+// SetOrdering sets the policy used to order the pending transactions when
+// assembling new blocks.
+func (self *Miner) SetOrdering(ordering TxOrdering) {
+	self.worker.setOrdering(ordering)
+}
+
 // Pending returns the currently pending block and associated state.
 func (self *Miner) Pending() (*types.Block, *state.StateDB) {
 	return self.worker.pending()
@@ -0,0 +1,223 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"container/heap"
+	"fmt"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/types"
+)
+
+// Names of the transaction ordering policies shipped with the miner.
+const (
+	OrderingPrice    = "price"    // Highest gas price first, the default
+	OrderingFIFO     = "fifo"     // First seen first served
+	OrderingPriority = "priority" // Whitelisted senders first, the rest by price
+)
+
+// TransactionSet is an ordered set of transactions that the worker iterates over
+// when filling a block.
+type TransactionSet interface {
+	// Peek returns the next transaction to commit, or nil if the set is exhausted.
+	Peek() *types.Transaction
+
+	// Shift replaces the current head with the next transaction from the same
+	// account.
+	Shift()
+
+	// Pop removes the current head, *not* replacing it with the next one from the
+	// same account, dropping all subsequent transactions of the account.
+	Pop()
+}
+
+// TxOrdering is the policy consulted by the worker to decide in which order the
+// pending transactions of the pool are committed into a new block.
+type TxOrdering interface {
+	// Order assembles a transaction set out of the pending transactions, grouped
+	// by account and sorted by nonce. The set must honour the nonce ordering of
+	// each individual account.
+	//
+	// Note, the input map is reowned so the caller should not interact any more
+	// with it after providing it to the policy.
+	Order(signer types.Signer, pending map[common.Address]types.Transactions) TransactionSet
+}
+
+// NewTxOrdering creates one of the built in transaction ordering policies by
+// name. An empty name selects the default price-and-nonce ordering.
+func NewTxOrdering(policy string, priority []common.Address) (TxOrdering, error) {
+	switch policy {
+	case "", OrderingPrice:
+		return NewPriceOrdering(), nil
+	case OrderingFIFO:
+		return NewFIFOOrdering(), nil
+	case OrderingPriority:
+		return NewPriorityOrdering(priority), nil
+	default:
+		return nil, fmt.Errorf("unknown transaction ordering %q", policy)
+	}
+}
+
+// priceOrdering is the default ordering policy, picking the most profitable
+// transactions first.
+type priceOrdering struct{}
+
+// NewPriceOrdering creates a transaction ordering policy that sorts the pending
+// transactions by gas price in a nonce-honouring way.
+func NewPriceOrdering() TxOrdering {
+	return priceOrdering{}
+}
+
+// Order implements TxOrdering, deferring to the price and nonce sorted set.
+func (priceOrdering) Order(signer types.Signer, pending map[common.Address]types.Transactions) TransactionSet {
+	return types.NewTransactionsByPriceAndNonce(signer, pending)
+}
+
+// fifoOrdering is an ordering policy committing transactions in the order they
+// were first seen by the local node.
+type fifoOrdering struct{}
+
+// NewFIFOOrdering creates a transaction ordering policy that sorts the pending
+// transactions by their local arrival time in a nonce-honouring way.
+func NewFIFOOrdering() TxOrdering {
+	return fifoOrdering{}
+}
+
+// Order implements TxOrdering, sorting the account heads by arrival time and
+// falling back to the gas price for transactions seen at the same time.
+func (fifoOrdering) Order(signer types.Signer, pending map[common.Address]types.Transactions) TransactionSet {
+	return newTransactionsByHeads(signer, pending, func(a, b *types.Transaction) bool {
+		if !a.Time().Equal(b.Time()) {
+			return a.Time().Before(b.Time())
+		}
+		return a.GasPrice().Cmp(b.GasPrice()) > 0
+	})
+}
+
+// priorityOrdering is an ordering policy committing transactions of a list of
+// whitelisted senders ahead of everybody else.
+type priorityOrdering struct {
+	ranks map[common.Address]int // Position of each whitelisted sender in the list
+}
+
+// NewPriorityOrdering creates a transaction ordering policy that commits the
+// transactions of the given senders first, in the order they are listed, and
+// sorts the remaining ones by gas price.
+func NewPriorityOrdering(senders []common.Address) TxOrdering {
+	ranks := make(map[common.Address]int)
+	for i, sender := range senders {
+		if _, ok := ranks[sender]; !ok {
+			ranks[sender] = i
+		}
+	}
+	return &priorityOrdering{ranks: ranks}
+}
+
+// Order implements TxOrdering, sorting the account heads by the rank of their
+// senders and falling back to the gas price for non-whitelisted ones.
+func (o *priorityOrdering) Order(signer types.Signer, pending map[common.Address]types.Transactions) TransactionSet {
+	rank := func(tx *types.Transaction) int {
+		from, _ := types.Sender(signer, tx)
+		if rank, ok := o.ranks[from]; ok {
+			return rank
+		}
+		return len(o.ranks)
+	}
+	return newTransactionsByHeads(signer, pending, func(a, b *types.Transaction) bool {
+		if ra, rb := rank(a), rank(b); ra != rb {
+			return ra < rb
+		}
+		return a.GasPrice().Cmp(b.GasPrice()) > 0
+	})
+}
+
+// txHeads is a heap of the next transaction of each account, sorted by an
+// arbitrary comparator.
+type txHeads struct {
+	txs  []*types.Transaction
+	less func(a, b *types.Transaction) bool
+}
+
+func (h *txHeads) Len() int           { return len(h.txs) }
+func (h *txHeads) Less(i, j int) bool { return h.less(h.txs[i], h.txs[j]) }
+func (h *txHeads) Swap(i, j int)      { h.txs[i], h.txs[j] = h.txs[j], h.txs[i] }
+
+func (h *txHeads) Push(x interface{}) {
+	h.txs = append(h.txs, x.(*types.Transaction))
+}
+
+func (h *txHeads) Pop() interface{} {
+	old := h.txs
+	n := len(old)
+	x := old[n-1]
+	h.txs = old[0 : n-1]
+	return x
+}
+
+// transactionsByHeads is a transaction set that returns the account heads in the
+// order defined by a comparator, while honouring the nonces of each account.
+type transactionsByHeads struct {
+	txs    map[common.Address]types.Transactions // Per account nonce-sorted list of transactions
+	heads  *txHeads                              // Next transaction for each unique account
+	signer types.Signer                          // Signer for the set of transactions
+}
+
+// newTransactionsByHeads creates a transaction set that retrieves the account
+// heads sorted by the given comparator.
+func newTransactionsByHeads(signer types.Signer, txs map[common.Address]types.Transactions, less func(a, b *types.Transaction) bool) *transactionsByHeads {
+	heads := &txHeads{
+		txs:  make([]*types.Transaction, 0, len(txs)),
+		less: less,
+	}
+	for acc, accTxs := range txs {
+		heads.txs = append(heads.txs, accTxs[0])
+		txs[acc] = accTxs[1:]
+	}
+	heap.Init(heads)
+
+	return &transactionsByHeads{
+		txs:    txs,
+		heads:  heads,
+		signer: signer,
+	}
+}
+
+// Peek implements TransactionSet, returning the next transaction in order.
+func (t *transactionsByHeads) Peek() *types.Transaction {
+	if t.heads.Len() == 0 {
+		return nil
+	}
+	return t.heads.txs[0]
+}
+
+// Shift implements TransactionSet, replacing the current head with the next one
+// from the same account.
+func (t *transactionsByHeads) Shift() {
+	acc, _ := types.Sender(t.signer, t.heads.txs[0])
+	if txs, ok := t.txs[acc]; ok && len(txs) > 0 {
+		t.heads.txs[0], t.txs[acc] = txs[0], txs[1:]
+		heap.Fix(t.heads, 0)
+	} else {
+		heap.Pop(t.heads)
+	}
+}
+
+// Pop implements TransactionSet, removing the current head without replacing it.
+func (t *transactionsByHeads) Pop() {
+	heap.Pop(t.heads)
+}
@@ -0,0 +1,204 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/types"
+	"github.com/haachain/go-haachain/crypto"
+)
+
+// orderingTester generates signed transactions for a set of test accounts.
+type orderingTester struct {
+	t      *testing.T
+	signer types.Signer
+	keys   []*ecdsa.PrivateKey
+	addrs  []common.Address
+}
+
+func newOrderingTester(t *testing.T, accounts int) *orderingTester {
+	tester := &orderingTester{
+		t:      t,
+		signer: types.HomesteadSigner{},
+	}
+	for i := 0; i < accounts; i++ {
+		key, _ := crypto.GenerateKey()
+		tester.keys = append(tester.keys, key)
+		tester.addrs = append(tester.addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	return tester
+}
+
+// tx creates a signed transaction from the given account, sleeping a bit to
+// ensure subsequent transactions have distinct arrival times.
+func (tt *orderingTester) tx(account int, nonce uint64, price int64) *types.Transaction {
+	tx, err := types.SignTx(types.NewTransaction(nonce, common.Address{}, big.NewInt(0), 21000, big.NewInt(price), nil), tt.signer, tt.keys[account])
+	if err != nil {
+		tt.t.Fatalf("failed to sign transaction: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	return tx
+}
+
+// check drains the transaction set and compares it against the expected order.
+func (tt *orderingTester) check(set TransactionSet, want []*types.Transaction) {
+	var have []*types.Transaction
+	for tx := set.Peek(); tx != nil; tx = set.Peek() {
+		have = append(have, tx)
+		set.Shift()
+	}
+	if len(have) != len(want) {
+		tt.t.Fatalf("transaction count mismatch: have %d, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if have[i].Hash() != want[i].Hash() {
+			from, _ := types.Sender(tt.signer, have[i])
+			tt.t.Errorf("transaction %d mismatch: have %x (from %x, nonce %d), want %x", i, have[i].Hash(), from, have[i].Nonce(), want[i].Hash())
+		}
+	}
+}
+
+// Tests that the default ordering sorts transactions by price while honouring
+// the nonces of individual accounts.
+func TestPriceOrdering(t *testing.T) {
+	tt := newOrderingTester(t, 3)
+
+	a0, a1 := tt.tx(0, 0, 1), tt.tx(0, 1, 5)
+	b0, b1 := tt.tx(1, 0, 3), tt.tx(1, 1, 2)
+	c0 := tt.tx(2, 0, 4)
+
+	pending := map[common.Address]types.Transactions{
+		tt.addrs[0]: {a0, a1},
+		tt.addrs[1]: {b0, b1},
+		tt.addrs[2]: {c0},
+	}
+	tt.check(NewPriceOrdering().Order(tt.signer, pending), []*types.Transaction{c0, b0, b1, a0, a1})
+}
+
+// Tests that the FIFO ordering sorts transactions by their arrival time while
+// honouring the nonces of individual accounts.
+func TestFIFOOrdering(t *testing.T) {
+	tt := newOrderingTester(t, 3)
+
+	a0 := tt.tx(0, 0, 1)
+	b0 := tt.tx(1, 0, 3)
+	c0 := tt.tx(2, 0, 5)
+	b1 := tt.tx(1, 1, 9)
+	a1 := tt.tx(0, 1, 2)
+
+	pending := map[common.Address]types.Transactions{
+		tt.addrs[0]: {a0, a1},
+		tt.addrs[1]: {b0, b1},
+		tt.addrs[2]: {c0},
+	}
+	tt.check(NewFIFOOrdering().Order(tt.signer, pending), []*types.Transaction{a0, b0, c0, b1, a1})
+}
+
+// Tests that arrival times are retained when a transaction is signed, but reset
+// when it's decoded from the network.
+func TestFIFOOrderingArrival(t *testing.T) {
+	tt := newOrderingTester(t, 2)
+
+	a0 := tt.tx(0, 0, 1)
+	b0 := tt.tx(1, 0, 1)
+
+	// Re-decode the older transaction, making it arrive last
+	blob, err := a0.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to encode transaction: %v", err)
+	}
+	arrived := new(types.Transaction)
+	if err := arrived.UnmarshalBinary(blob); err != nil {
+		t.Fatalf("failed to decode transaction: %v", err)
+	}
+	pending := map[common.Address]types.Transactions{
+		tt.addrs[0]: {arrived},
+		tt.addrs[1]: {b0},
+	}
+	tt.check(NewFIFOOrdering().Order(tt.signer, pending), []*types.Transaction{b0, arrived})
+}
+
+// Tests that the priority ordering commits whitelisted senders first in the
+// order they are listed, falling back to price ordering for everybody else.
+func TestPriorityOrdering(t *testing.T) {
+	tt := newOrderingTester(t, 4)
+
+	a0, a1 := tt.tx(0, 0, 1), tt.tx(0, 1, 1)
+	b0 := tt.tx(1, 0, 2)
+	c0 := tt.tx(2, 0, 8)
+	d0, d1 := tt.tx(3, 0, 9), tt.tx(3, 1, 7)
+
+	pending := map[common.Address]types.Transactions{
+		tt.addrs[0]: {a0, a1},
+		tt.addrs[1]: {b0},
+		tt.addrs[2]: {c0},
+		tt.addrs[3]: {d0, d1},
+	}
+	ordering := NewPriorityOrdering([]common.Address{tt.addrs[1], tt.addrs[0]})
+	tt.check(ordering.Order(tt.signer, pending), []*types.Transaction{b0, a0, a1, d0, c0, d1})
+}
+
+// Tests that popping a transaction drops all subsequent ones from the account.
+func TestOrderingPop(t *testing.T) {
+	tt := newOrderingTester(t, 2)
+
+	a0, a1 := tt.tx(0, 0, 1), tt.tx(0, 1, 1)
+	b0 := tt.tx(1, 0, 1)
+
+	pending := map[common.Address]types.Transactions{
+		tt.addrs[0]: {a0, a1},
+		tt.addrs[1]: {b0},
+	}
+	set := NewFIFOOrdering().Order(tt.signer, pending)
+	if tx := set.Peek(); tx != a0 {
+		t.Fatalf("head mismatch: have %x, want %x", tx.Hash(), a0.Hash())
+	}
+	set.Pop()
+	tt.check(set, []*types.Transaction{b0})
+}
+
+// Tests that ordering policies can be selected by name.
+func TestNewTxOrdering(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   TxOrdering
+	}{
+		{"", priceOrdering{}},
+		{OrderingPrice, priceOrdering{}},
+		{OrderingFIFO, fifoOrdering{}},
+		{OrderingPriority, &priorityOrdering{}},
+	}
+	for _, test := range tests {
+		ordering, err := NewTxOrdering(test.policy, nil)
+		if err != nil {
+			t.Errorf("policy %q: failed to create ordering: %v", test.policy, err)
+			continue
+		}
+		if reflect.TypeOf(ordering) != reflect.TypeOf(test.want) {
+			t.Errorf("policy %q: ordering mismatch: have %T, want %T", test.policy, ordering, test.want)
+		}
+	}
+	if _, err := NewTxOrdering("random", nil); err == nil {
+		t.Errorf("unknown policy accepted")
+	}
+}
@@ -66,6 +66,11 @@ type Config struct {
 	// in memory.
 	DataDir string
 
+	// DBEngine is the storage engine backing the persistent databases opened by
+	// the node. If empty, the engine of an existing database is reused, or the
+	// default LevelDB one is used for new ones.
+	DBEngine string `toml:",omitempty"`
+
 	// Configuration of peer-to-peer networking.
 	P2P p2p.Config
 
@@ -645,7 +645,28 @@ func (n *Node) OpenDatabase(name string, cache, handles int) (haadb.Database, er
 	if n.config.DataDir == "" {
 		return haadb.NewMemDatabase()
 	}
-	return haadb.NewLDBDatabase(n.config.resolvePath(name), cache, handles)
+	return haadb.NewDatabase(n.config.DBEngine, n.config.resolvePath(name), cache, handles)
+}
+
+// OpenDatabaseWithFreezer opens an existing database with the given name (or
+// creates one if no previous can be found) from within the node's data directory,
+// also attaching a chain freezer to it that moves ancient chain data from the
+// database to immutable append-only files. If the freezer path is empty, it is
+// placed inside the database directory, relative paths are resolved into the
+// data directory. If the node is ephemeral, a memory database is returned.
+func (n *Node) OpenDatabaseWithFreezer(name string, cache, handles int, freezer string) (haadb.Database, error) {
+	if n.config.DataDir == "" {
+		return haadb.NewMemDatabase()
+	}
+	root := n.config.resolvePath(name)
+
+	switch {
+	case freezer == "":
+		freezer = filepath.Join(root, "ancient")
+	case !filepath.IsAbs(freezer):
+		freezer = n.config.resolvePath(freezer)
+	}
+	return haadb.NewDatabaseWithFreezer(n.config.DBEngine, root, cache, handles, freezer)
 }
 
 // ResolvePath returns the absolute path of a resource in the instance directory.
@@ -17,6 +17,7 @@
 package node
 
 import (
+	"path/filepath"
 	"reflect"
 
 	"github.com/haachain/go-haachain/accounts"
@@ -43,7 +44,32 @@ func (ctx *ServiceContext) OpenDatabase(name string, cache int, handles int) (ha
 	if ctx.config.DataDir == "" {
 		return haadb.NewMemDatabase()
 	}
-	db, err := haadb.NewLDBDatabase(ctx.config.resolvePath(name), cache, handles)
+	db, err := haadb.NewDatabase(ctx.config.DBEngine, ctx.config.resolvePath(name), cache, handles)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// OpenDatabaseWithFreezer opens an existing database with the given name (or
+// creates one if no previous can be found) from within the node's data directory,
+// also attaching a chain freezer to it that moves ancient chain data from the
+// database to immutable append-only files. If the freezer path is empty, it is
+// placed inside the database directory, relative paths are resolved into the
+// data directory. If the node is an ephemeral one, a memory database is returned.
+func (ctx *ServiceContext) OpenDatabaseWithFreezer(name string, cache int, handles int, freezer string) (haadb.Database, error) {
+	if ctx.config.DataDir == "" {
+		return haadb.NewMemDatabase()
+	}
+	root := ctx.config.resolvePath(name)
+
+	switch {
+	case freezer == "":
+		freezer = filepath.Join(root, "ancient")
+	case !filepath.IsAbs(freezer):
+		freezer = ctx.config.resolvePath(freezer)
+	}
+	db, err := haadb.NewDatabaseWithFreezer(ctx.config.DBEngine, root, cache, handles, freezer)
 	if err != nil {
 		return nil, err
 	}
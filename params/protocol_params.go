@@ -63,6 +63,14 @@ const (
 
 	MaxCodeSize = 24576 // Maximum bytecode to permit for a contract
 
+	// Access list (EIP2929/EIP2930) gas prices
+
+	TxAccessListAddressGas    uint64 = 2400 // Per address specified in an EIP2930 access list
+	TxAccessListStorageKeyGas uint64 = 1900 // Per storage key specified in an EIP2930 access list
+	ColdAccountAccessCost     uint64 = 2600 // Cost of accessing an account not yet in the access list
+	ColdSloadCost             uint64 = 2100 // Cost of loading a storage slot not yet in the access list
+	WarmStorageReadCost       uint64 = 100  // Cost of accessing an account or storage slot already in the access list
+
 	// Precompiled contract gas prices
 
 	EcrecoverGas            uint64 = 3000   // Elliptic curve sender recovery gas price
@@ -49,6 +49,45 @@ func TestClientRequest(t *testing.T) {
 	}
 }
 
+// DataErrorService returns errors carrying a custom code and data.
+type DataErrorService struct{}
+
+type dataError struct{}
+
+func (e *dataError) Error() string          { return "data error" }
+func (e *dataError) ErrorCode() int         { return 3 }
+func (e *dataError) ErrorData() interface{} { return "0xdeadbeef" }
+
+func (s *DataErrorService) Fail() (string, error) {
+	return "", &dataError{}
+}
+
+func TestClientErrorData(t *testing.T) {
+	server := newTestServer("service", new(DataErrorService))
+	defer server.Stop()
+	client := DialInProc(server)
+	defer client.Close()
+
+	var resp string
+	err := client.Call(&resp, "service_fail")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if err.Error() != "data error" {
+		t.Errorf("wrong error message: have %q, want %q", err.Error(), "data error")
+	}
+	if code := err.(Error).ErrorCode(); code != 3 {
+		t.Errorf("wrong error code: have %d, want %d", code, 3)
+	}
+	de, ok := err.(DataError)
+	if !ok {
+		t.Fatalf("error %T does not carry data", err)
+	}
+	if data := de.ErrorData(); data != "0xdeadbeef" {
+		t.Errorf("wrong error data: have %v, want %v", data, "0xdeadbeef")
+	}
+}
+
 func TestClientBatchRequest(t *testing.T) {
 	server := newTestServer("service", new(Service))
 	defer server.Stop()
@@ -92,6 +92,14 @@ type Error interface {
 	ErrorCode() int // returns the code
 }
 
+// DataError wraps an error carrying additional data, which is delivered in
+// the data field of the JSON-RPC error object. Errors returned by callbacks
+// may implement it, along with Error to override the default error code.
+type DataError interface {
+	Error() string          // returns the message
+	ErrorData() interface{} // returns the error data
+}
+
 // ServerCodec implements reading, parsing and writing RPC messages for the server side of
 // a RPC session. Implementations must be go-routine safe since the codec can be called in
 // multiple go-routines concurrently.
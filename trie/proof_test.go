@@ -20,6 +20,7 @@ import (
 	"bytes"
 	crand "crypto/rand"
 	mrand "math/rand"
+	"sort"
 	"testing"
 	"time"
 
@@ -99,6 +100,207 @@ func mutateByte(b []byte) {
 	}
 }
 
+type entrySlice []*kv
+
+func (p entrySlice) Len() int           { return len(p) }
+func (p entrySlice) Less(i, j int) bool { return bytes.Compare(p[i].k, p[j].k) < 0 }
+func (p entrySlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// sortedEntries returns the contents of a random trie ordered by key.
+func sortedEntries(vals map[string]*kv) entrySlice {
+	var entries entrySlice
+	for _, kv := range vals {
+		entries = append(entries, kv)
+	}
+	sort.Sort(entries)
+	return entries
+}
+
+// TestRangeProof tests normal range proof with both edge proofs as the
+// existent proof. The test cases are generated randomly.
+func TestRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+	for i := 0; i < 500; i++ {
+		start := mrand.Intn(len(entries))
+		end := mrand.Intn(len(entries)-start) + start + 1
+
+		proof, _ := haadb.NewMemDatabase()
+		if err := trie.Prove(entries[start].k, 0, proof); err != nil {
+			t.Fatalf("Failed to prove the first node %v", err)
+		}
+		if err := trie.Prove(entries[end-1].k, 0, proof); err != nil {
+			t.Fatalf("Failed to prove the last node %v", err)
+		}
+		var keys, vals [][]byte
+		for i := start; i < end; i++ {
+			keys = append(keys, entries[i].k)
+			vals = append(vals, entries[i].v)
+		}
+		more, err := VerifyRangeProof(trie.Hash(), keys[0], keys[len(keys)-1], keys, vals, proof)
+		if err != nil {
+			t.Fatalf("Case %d(%d->%d) expect no error, got %v", i, start, end-1, err)
+		}
+		if more != (end < len(entries)) {
+			t.Fatalf("Case %d(%d->%d) continuation mismatch: have %v", i, start, end-1, more)
+		}
+	}
+}
+
+// TestRangeProofWithNonExistentProof tests normal range proof with two
+// non-existent edge proofs surrounding the range.
+func TestRangeProofWithNonExistentProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+	for i := 0; i < 500; i++ {
+		start := mrand.Intn(len(entries))
+		end := mrand.Intn(len(entries)-start) + start + 1
+
+		// Short circuit if the decreased key is same with the previous key
+		first := decreaseKey(common.CopyBytes(entries[start].k))
+		if start != 0 && bytes.Equal(first, entries[start-1].k) {
+			continue
+		}
+		// Short circuit if the increased key is same with the next key
+		last := increaseKey(common.CopyBytes(entries[end-1].k))
+		if end != len(entries) && bytes.Equal(last, entries[end].k) {
+			continue
+		}
+		proof, _ := haadb.NewMemDatabase()
+		if err := trie.Prove(first, 0, proof); err != nil {
+			t.Fatalf("Failed to prove the first node %v", err)
+		}
+		if err := trie.Prove(last, 0, proof); err != nil {
+			t.Fatalf("Failed to prove the last node %v", err)
+		}
+		var keys, vals [][]byte
+		for i := start; i < end; i++ {
+			keys = append(keys, entries[i].k)
+			vals = append(vals, entries[i].v)
+		}
+		if _, err := VerifyRangeProof(trie.Hash(), first, last, keys, vals, proof); err != nil {
+			t.Fatalf("Case %d(%d->%d) expect no error, got %v", i, start, end-1, err)
+		}
+	}
+}
+
+// TestBadRangeProof tests a few cases which the proof is wrong.
+// The prover is expected to detect the error.
+func TestBadRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+	for i := 0; i < 500; i++ {
+		start := mrand.Intn(len(entries))
+		end := mrand.Intn(len(entries)-start) + start + 1
+
+		proof, _ := haadb.NewMemDatabase()
+		trie.Prove(entries[start].k, 0, proof)
+		trie.Prove(entries[end-1].k, 0, proof)
+
+		var keys, vals [][]byte
+		for i := start; i < end; i++ {
+			keys = append(keys, entries[i].k)
+			vals = append(vals, entries[i].v)
+		}
+		first, last := keys[0], keys[len(keys)-1]
+		switch mrand.Intn(3) {
+		case 0:
+			// Modified value
+			index := mrand.Intn(end - start)
+			vals[index] = randBytes(20) // In theory it can't be same
+		case 1:
+			// Gapped entry slice, only meaningful with a non-edge element
+			if end-start < 3 {
+				continue
+			}
+			index := mrand.Intn(end-start-2) + 1
+			keys = append(keys[:index], keys[index+1:]...)
+			vals = append(vals[:index], vals[index+1:]...)
+		case 2:
+			// Out of order
+			if end-start < 2 {
+				continue
+			}
+			index1, index2 := mrand.Intn(end-start), mrand.Intn(end-start)
+			if index1 == index2 {
+				continue
+			}
+			keys[index1], keys[index2] = keys[index2], keys[index1]
+			vals[index1], vals[index2] = vals[index2], vals[index1]
+		}
+		if _, err := VerifyRangeProof(trie.Hash(), first, last, keys, vals, proof); err == nil {
+			t.Fatalf("%d Case %d index %d range: (%d->%d) expect error, got nil", i, start, end-1, start, end-1)
+		}
+	}
+}
+
+// TestAllElementsProof tests the range proof with all elements and no
+// proof at all. The whole leaf set must hash up to the trie root.
+func TestAllElementsProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	var keys, values [][]byte
+	for _, entry := range entries {
+		keys = append(keys, entry.k)
+		values = append(values, entry.v)
+	}
+	more, err := VerifyRangeProof(trie.Hash(), nil, nil, keys, values, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if more {
+		t.Fatal("Expected no more elements")
+	}
+	// Dropping any element must fail the verification
+	index := mrand.Intn(len(keys))
+	keys = append(keys[:index], keys[index+1:]...)
+	values = append(values[:index], values[index+1:]...)
+	if _, err := VerifyRangeProof(trie.Hash(), nil, nil, keys, values, nil); err == nil {
+		t.Fatal("Expected error for incomplete leaf set, got nil")
+	}
+}
+
+// TestEmptyRangeProof tests that an empty range past the last element is
+// accepted, while one hiding existing elements is rejected.
+func TestEmptyRangeProof(t *testing.T) {
+	trie, vals := randomTrie(4096)
+	entries := sortedEntries(vals)
+
+	last := increaseKey(common.CopyBytes(entries[len(entries)-1].k))
+	proof, _ := haadb.NewMemDatabase()
+	trie.Prove(last, 0, proof)
+	if _, err := VerifyRangeProof(trie.Hash(), last, nil, nil, nil, proof); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	first := decreaseKey(common.CopyBytes(entries[len(entries)-1].k))
+	proof, _ = haadb.NewMemDatabase()
+	trie.Prove(first, 0, proof)
+	if _, err := VerifyRangeProof(trie.Hash(), first, nil, nil, nil, proof); err == nil {
+		t.Fatal("Expected error for hidden elements, got nil")
+	}
+}
+
+func increaseKey(key []byte) []byte {
+	for i := len(key) - 1; i >= 0; i-- {
+		key[i]++
+		if key[i] != 0x0 {
+			break
+		}
+	}
+	return key
+}
+
+func decreaseKey(key []byte) []byte {
+	for i := len(key) - 1; i >= 0; i-- {
+		key[i]--
+		if key[i] != 0xff {
+			break
+		}
+	}
+	return key
+}
+
 func BenchmarkProve(b *testing.B) {
 	trie, vals := randomTrie(100)
 	var keys []string
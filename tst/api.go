@@ -198,6 +198,24 @@ func (api *PrivateMinerAPI) GetHashrate() uint64 {
 	return uint64(api.e.miner.HashRate())
 }
 
+// PrivateTxPoolAPI is the collection of haachain transaction pool related APIs
+// exposed over the private txpool endpoint.
+type PrivateTxPoolAPI struct {
+	haa *haachain
+}
+
+// NewPrivateTxPoolAPI creates a new API definition for the private transaction
+// pool methods of the haachain service.
+func NewPrivateTxPoolAPI(haa *haachain) *PrivateTxPoolAPI {
+	return &PrivateTxPoolAPI{haa: haa}
+}
+
+// Dump regenerates the remote transaction journal with the current contents of
+// the pool, returning the number of transactions saved.
+func (api *PrivateTxPoolAPI) Dump() (int, error) {
+	return api.haa.TxPool().SaveRemotes()
+}
+
 // PrivateAdminAPI is the collection of haachain full node-related APIs
 // exposed over the private admin endpoint.
 type PrivateAdminAPI struct {
@@ -152,6 +152,10 @@ func (b *haaApiBackend) SendTx(ctx context.Context, signedTx *types.Transaction)
 	return b.haa.txPool.AddLocal(signedTx)
 }
 
+func (b *haaApiBackend) SendPrivateTx(ctx context.Context, signedTx *types.Transaction, expiry uint64) error {
+	return b.haa.txPool.AddPrivate(signedTx, expiry)
+}
+
 func (b *haaApiBackend) GetPoolTransactions() (types.Transactions, error) {
 	pending, err := b.haa.txPool.Pending()
 	if err != nil {
@@ -196,6 +200,10 @@ func (b *haaApiBackend) SuggestPrice(ctx context.Context) (*big.Int, error) {
 	return b.gpo.SuggestPrice(ctx)
 }
 
+func (b *haaApiBackend) FeeHistory(ctx context.Context, blocks int, lastBlock rpc.BlockNumber, percentiles []float64) (*big.Int, [][]*big.Int, []float64, []*big.Int, error) {
+	return b.gpo.FeeHistory(ctx, blocks, lastBlock, percentiles)
+}
+
 func (b *haaApiBackend) ChainDb() haadb.Database {
 	return b.haa.ChainDb()
 }
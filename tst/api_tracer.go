@@ -17,15 +17,20 @@
 package haa
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"os"
 	"runtime"
 	"sync"
 	"time"
 
+	"github.com/haachain/go-haachain/accounts/abi"
 	"github.com/haachain/go-haachain/common"
 	"github.com/haachain/go-haachain/common/hexutil"
 	"github.com/haachain/go-haachain/core"
@@ -54,9 +59,18 @@ const (
 // TraceConfig holds extra parameters to trace functions.
 type TraceConfig struct {
 	*vm.LogConfig
-	Tracer  *string
-	Timeout *string
-	Reexec  *uint64
+	Tracer       *string
+	TracerConfig json.RawMessage // Configuration options of native tracers
+	Timeout      *string
+	Reexec       *uint64
+	Streaming    bool // Write the struct logs as JSON into a file per transaction instead of returning them
+}
+
+// StdTraceConfig holds the parameters of the standard JSON trace functions.
+type StdTraceConfig struct {
+	*vm.LogConfig
+	Reexec *uint64
+	TxHash common.Hash // Transaction to trace, or all of them if empty
 }
 
 // txTraceResult is the result of a single transaction trace.
@@ -463,6 +477,92 @@ func (api *PrivateDebugAPI) traceBlock(ctx context.Context, block *types.Block,
 	return results, nil
 }
 
+// StandardTraceBlockToFile dumps the structured logs created during the
+// execution of EVM to the local file system and returns a list of files to
+// the caller.
+func (api *PrivateDebugAPI) StandardTraceBlockToFile(ctx context.Context, hash common.Hash, config *StdTraceConfig) ([]string, error) {
+	block := api.haa.blockchain.GetBlockByHash(hash)
+	if block == nil {
+		return nil, fmt.Errorf("block #%x not found", hash)
+	}
+	return api.standardTraceBlockToFile(ctx, block, config)
+}
+
+// standardTraceBlockToFile executes the transactions of a block one after the
+// other, streaming the standard JSON trace of every one of them (or only the
+// requested one) into a separate file of a new temporary directory.
+func (api *PrivateDebugAPI) standardTraceBlockToFile(ctx context.Context, block *types.Block, config *StdTraceConfig) ([]string, error) {
+	// Create the parent state database
+	if err := api.haa.engine.VerifyHeader(api.haa.blockchain, block.Header(), true); err != nil {
+		return nil, err
+	}
+	parent := api.haa.blockchain.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, fmt.Errorf("parent %x not found", block.ParentHash())
+	}
+	var (
+		logConfig *vm.LogConfig
+		txHash    common.Hash
+		reexec    = defaultTraceReexec
+	)
+	if config != nil {
+		logConfig, txHash = config.LogConfig, config.TxHash
+		if config.Reexec != nil {
+			reexec = *config.Reexec
+		}
+	}
+	statedb, err := api.computeStateDB(parent, reexec)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", fmt.Sprintf("block_%#x-", block.Hash().Bytes()[:4]))
+	if err != nil {
+		return nil, err
+	}
+	// Execute the transactions sequentially, tracing the requested ones
+	var (
+		signer = types.MakeSigner(api.config, block.Number())
+		dumps  []string
+	)
+	for i, tx := range block.Transactions() {
+		if err := ctx.Err(); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		msg, _ := tx.AsMessage(signer)
+		vmctx := core.NewEVMContext(msg, block.Header(), api.haa.blockchain, nil)
+
+		if txHash == (common.Hash{}) || txHash == tx.Hash() {
+			dump, err := api.traceTxToFile(ctx, msg, vmctx, statedb, logConfig, dir, fmt.Sprintf("%d-%#x-", i, tx.Hash().Bytes()[:4]))
+			if err != nil {
+				os.RemoveAll(dir)
+				return nil, err
+			}
+			dumps = append(dumps, dump)
+
+			// Stop executing once the requested transaction was traced
+			if txHash != (common.Hash{}) {
+				break
+			}
+		} else {
+			vmenv := vm.NewEVM(vmctx, statedb, api.config, vm.Config{})
+			if _, _, _, err := core.ApplyMessage(vmenv, msg, new(core.GasPool).AddGas(msg.Gas())); err != nil {
+				os.RemoveAll(dir)
+				return nil, fmt.Errorf("transaction %#x failed: %v", tx.Hash(), err)
+			}
+		}
+		// Finalize the state so any modifications are written to the trie
+		statedb.Finalise(true)
+	}
+	if len(dumps) == 0 {
+		os.RemoveAll(dir)
+		if txHash != (common.Hash{}) {
+			return nil, fmt.Errorf("transaction %#x not found in block #%x", txHash, block.Hash())
+		}
+	}
+	return dumps, nil
+}
+
 // computeStateDB retrieves the state database associated with a certain block.
 // If no state is locally available for the given block, a number of blocks are
 // attempted to be reexecuted to generate the desired state.
@@ -553,45 +653,122 @@ func (api *PrivateDebugAPI) TraceTransaction(ctx context.Context, hash common.Ha
 // executes the given message in the provided environment. The return value will
 // be tracer dependent.
 func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, vmctx vm.Context, statedb *state.StateDB, config *TraceConfig) (interface{}, error) {
-	// Assemble the structured logger or the JavaScript tracer
-	var (
-		tracer vm.Tracer
-		err    error
-	)
+	// Huge traces of the struct logger are streamed to disk if requested
+	if config != nil && config.Streaming && config.Tracer == nil {
+		timeout, err := traceTimeout(config)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		return api.traceTxToFile(ctx, message, vmctx, statedb, config.LogConfig, "", "trace-")
+	}
+	tracer, cancel, err := newTracer(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	// Run the transaction with tracing enabled.
+	vmenv := vm.NewEVM(vmctx, statedb, api.config, vm.Config{Debug: true, Tracer: tracer})
+
+	ret, gas, failed, err := core.ApplyMessage(vmenv, message, new(core.GasPool).AddGas(message.Gas()))
+	if err != nil {
+		return nil, fmt.Errorf("tracing failed: %v", err)
+	}
+	return traceResult(tracer, ret, gas, failed)
+}
+
+// traceTxToFile executes the given message with the JSON logger attached,
+// streaming its standardized trace into a new file in the given directory
+// (or the system's temporary one if empty) instead of accumulating it in
+// memory. The path of the file written is returned. The execution is aborted
+// and nothing is left on disk if the context is cancelled before it finishes.
+func (api *PrivateDebugAPI) traceTxToFile(ctx context.Context, message core.Message, vmctx vm.Context, statedb *state.StateDB, config *vm.LogConfig, dir, prefix string) (string, error) {
+	dump, err := ioutil.TempFile(dir, prefix)
+	if err != nil {
+		return "", err
+	}
+	writer := bufio.NewWriter(dump)
+
+	vmenv := vm.NewEVM(vmctx, statedb, api.config, vm.Config{Debug: true, Tracer: vm.NewJSONLogger(config, writer)})
+
+	// Handle timeouts and RPC cancellations
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			vmenv.Cancel()
+		case <-done:
+		}
+	}()
+	_, _, _, err = core.ApplyMessage(vmenv, message, new(core.GasPool).AddGas(message.Gas()))
+	close(done)
+
+	switch {
+	case err != nil:
+		err = fmt.Errorf("tracing failed: %v", err)
+	case ctx.Err() == context.DeadlineExceeded:
+		err = errors.New("execution timeout")
+	case ctx.Err() != nil:
+		err = ctx.Err()
+	default:
+		err = writer.Flush()
+	}
+	if cerr := dump.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dump.Name())
+		return "", err
+	}
+	return dump.Name(), nil
+}
+
+// newTracer assembles the structured logger or the native or JavaScript tracer
+// requested by the configuration. The returned cancel function must be called
+// once tracing finished to release the timeout watchdog.
+func newTracer(ctx context.Context, config *TraceConfig) (vm.Tracer, context.CancelFunc, error) {
 	switch {
 	case config != nil && config.Tracer != nil:
 		// Define a meaningful timeout of a single transaction trace
-		timeout := defaultTraceTimeout
-		if config.Timeout != nil {
-			if timeout, err = time.ParseDuration(*config.Timeout); err != nil {
-				return nil, err
-			}
+		timeout, err := traceTimeout(config)
+		if err != nil {
+			return nil, nil, err
 		}
-		// Constuct the JavaScript tracer to execute with
-		if tracer, err = tracers.New(*config.Tracer); err != nil {
-			return nil, err
+		// Constuct the native or JavaScript tracer to execute with
+		tracer, err := tracers.NewTracer(*config.Tracer, config.TracerConfig)
+		if err != nil {
+			return nil, nil, err
 		}
 		// Handle timeouts and RPC cancellations
 		deadlineCtx, cancel := context.WithTimeout(ctx, timeout)
 		go func() {
 			<-deadlineCtx.Done()
-			tracer.(*tracers.Tracer).Stop(errors.New("execution timeout"))
+			tracer.Stop(errors.New("execution timeout"))
 		}()
-		defer cancel()
+		return tracer, cancel, nil
 
 	case config == nil:
-		tracer = vm.NewStructLogger(nil)
+		return vm.NewStructLogger(nil), func() {}, nil
 
 	default:
-		tracer = vm.NewStructLogger(config.LogConfig)
+		return vm.NewStructLogger(config.LogConfig), func() {}, nil
 	}
-	// Run the transaction with tracing enabled.
-	vmenv := vm.NewEVM(vmctx, statedb, api.config, vm.Config{Debug: true, Tracer: tracer})
+}
 
-	ret, gas, failed, err := core.ApplyMessage(vmenv, message, new(core.GasPool).AddGas(message.Gas()))
-	if err != nil {
-		return nil, fmt.Errorf("tracing failed: %v", err)
+// traceTimeout returns the amount of time a single transaction trace may run
+// for, as requested by the configuration.
+func traceTimeout(config *TraceConfig) (time.Duration, error) {
+	if config.Timeout == nil {
+		return defaultTraceTimeout, nil
 	}
+	return time.ParseDuration(*config.Timeout)
+}
+
+// traceResult formats the output of a tracer after executing a message with it.
+func traceResult(tracer vm.Tracer, ret []byte, gas uint64, failed bool) (interface{}, error) {
 	// Depending on the tracer type, format and return the output
 	switch tracer := tracer.(type) {
 	case *vm.StructLogger:
@@ -599,10 +776,10 @@ func (api *PrivateDebugAPI) traceTx(ctx context.Context, message core.Message, v
 			Gas:         gas,
 			Failed:      failed,
 			ReturnValue: fmt.Sprintf("%x", ret),
-			StructLogs:  ethapi.FormatLogs(tracer.StructLogs()),
+			StructLogs:  tracer.StructLogs(),
 		}, nil
 
-	case *tracers.Tracer:
+	case tracers.ResultTracer:
 		return tracer.GetResult()
 
 	default:
@@ -644,3 +821,261 @@ func (api *PrivateDebugAPI) computeTxEnv(blockHash common.Hash, txIndex int, ree
 	}
 	return nil, vm.Context{}, nil, fmt.Errorf("tx index %d out of range for block %x", txIndex, blockHash)
 }
+
+// BundleCall is a single message of a simulated bundle. It is either a signed
+// raw transaction, or an unsigned call with the same fields as haa_call.
+type BundleCall struct {
+	ethapi.CallArgs
+	Raw hexutil.Bytes `json:"raw"` // Signed raw transaction, the call fields are ignored if set
+}
+
+// BlockOverrides is a set of header fields to override in the block the bundle
+// is simulated in.
+type BlockOverrides struct {
+	Number   *hexutil.Big    `json:"number"`
+	Time     *hexutil.Big    `json:"timestamp"`
+	GasLimit *hexutil.Uint64 `json:"gasLimit"`
+	Coinbase *common.Address `json:"coinbase"`
+}
+
+// apply overrides the specified fields of the header.
+func (o *BlockOverrides) apply(header *types.Header) {
+	if o == nil {
+		return
+	}
+	if o.Number != nil {
+		header.Number = new(big.Int).Set(o.Number.ToInt())
+	}
+	if o.Time != nil {
+		header.Time = new(big.Int).Set(o.Time.ToInt())
+	}
+	if o.GasLimit != nil {
+		header.GasLimit = uint64(*o.GasLimit)
+	}
+	if o.Coinbase != nil {
+		header.Coinbase = *o.Coinbase
+	}
+}
+
+// bundleCallResult is the outcome of a single message of a simulated bundle.
+type bundleCallResult struct {
+	TxHash       common.Hash    `json:"txHash"`                 // Hash of the (possibly unsigned) transaction
+	GasUsed      hexutil.Uint64 `json:"gasUsed"`                // Gas used by the message, including intrinsic gas
+	ReturnValue  hexutil.Bytes  `json:"returnValue"`            // Return data, or revert data if execution failed
+	Logs         []*types.Log   `json:"logs"`                   // Logs emitted by the message
+	Failed       bool           `json:"failed"`                 // Whhaaer the EVM execution failed
+	RevertReason string         `json:"revertReason,omitempty"` // Decoded revert reason, if any
+	Error        string         `json:"error,omitempty"`        // Failure preventing the message from being applied
+	Trace        interface{}    `json:"trace,omitempty"`        // Trace results produced by the tracer, if requested
+	StateDiff    *bundleDiff    `json:"stateDiff,omitempty"`    // State changes made by the message
+}
+
+// bundleDiff is the set of state changes made by a single message of a simulated
+// bundle, holding the changed fields of each modified account before and after.
+type bundleDiff struct {
+	Pre  map[common.Address]*bundleAccount `json:"pre"`
+	Post map[common.Address]*bundleAccount `json:"post"`
+}
+
+// bundleAccount is the state of an account modified by a simulated message. Only
+// the fields that were changed by the message are filled.
+type bundleAccount struct {
+	Balance *hexutil.Big                `json:"balance,omitempty"`
+	Nonce   *hexutil.Uint64             `json:"nonce,omitempty"`
+	Code    hexutil.Bytes               `json:"code,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+// SimulateBundle executes an ordered list of calls and signed transactions on
+// top of the given block, without submitting any of them, and returns for each
+// the gas used, return data, logs and revert reason. The messages are executed
+// in a fresh block following the base one, whose header fields may be
+// overridden. If a trace config is specified, each message is traced too.
+func (api *PrivateDebugAPI) SimulateBundle(ctx context.Context, calls []BundleCall, number rpc.BlockNumber, overrides *BlockOverrides, config *TraceConfig) ([]*bundleCallResult, error) {
+	// Retrieve the base block and the state on top of it
+	var (
+		block   *types.Block
+		statedb *state.StateDB
+		err     error
+	)
+	switch number {
+	case rpc.PendingBlockNumber:
+		block, statedb = api.haa.miner.Pending()
+	case rpc.LatestBlockNumber:
+		block = api.haa.blockchain.CurrentBlock()
+	default:
+		block = api.haa.blockchain.GetBlockByNumber(uint64(number))
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block #%d not found", number)
+	}
+	if statedb == nil {
+		reexec := defaultTraceReexec
+		if config != nil && config.Reexec != nil {
+			reexec = *config.Reexec
+		}
+		if statedb, err = api.computeStateDB(block, reexec); err != nil {
+			return nil, err
+		}
+	}
+	// Assemble the header of the block to simulate the bundle in
+	header := &types.Header{
+		ParentHash: block.Hash(),
+		Coinbase:   block.Coinbase(),
+		Difficulty: block.Difficulty(),
+		Number:     new(big.Int).Add(block.Number(), common.Big1),
+		GasLimit:   block.GasLimit(),
+		Time:       new(big.Int).Add(block.Time(), common.Big1),
+	}
+	overrides.apply(header)
+
+	var (
+		signer  = types.MakeSigner(api.config, header.Number)
+		gp      = new(core.GasPool).AddGas(header.GasLimit)
+		results = make([]*bundleCallResult, len(calls))
+	)
+	for i, call := range calls {
+		tx, msg, err := call.toMessage(signer, statedb, gp.Gas())
+		if err != nil {
+			return nil, fmt.Errorf("call %d: %v", i, err)
+		}
+		statedb.Prepare(tx.Hash(), header.Hash(), i)
+
+		results[i], err = api.simulateCall(ctx, msg, header, statedb, gp, config)
+		if err != nil {
+			return nil, fmt.Errorf("call %d: %v", i, err)
+		}
+		results[i].TxHash = tx.Hash()
+		if results[i].Error == "" {
+			if results[i].Logs = statedb.GetLogs(tx.Hash()); results[i].Logs == nil {
+				results[i].Logs = []*types.Log{}
+			}
+		}
+		// Finalize the state so any modifications are visible to the next call
+		statedb.Finalise(api.config.IsEIP158(header.Number))
+	}
+	return results, nil
+}
+
+// simulateCall executes a single message of a simulated bundle, tracing it if
+// a trace config is specified.
+func (api *PrivateDebugAPI) simulateCall(ctx context.Context, msg core.Message, header *types.Header, statedb *state.StateDB, gp *core.GasPool, config *TraceConfig) (*bundleCallResult, error) {
+	var (
+		tracer   vm.Tracer
+		vmConfig vm.Config
+	)
+	if config != nil {
+		var (
+			cancel context.CancelFunc
+			err    error
+		)
+		if tracer, cancel, err = newTracer(ctx, config); err != nil {
+			return nil, err
+		}
+		defer cancel()
+
+		vmConfig = vm.Config{Debug: true, Tracer: tracer}
+	}
+	vmctx := core.NewEVMContext(msg, header, api.haa.blockchain, nil)
+	vmenv := vm.NewEVM(vmctx, statedb, api.config, vmConfig)
+
+	var (
+		pre      = statedb.Copy()
+		snapshot = statedb.Snapshot()
+		gaspool  = gp.Gas()
+	)
+	ret, gas, failed, err := core.ApplyMessage(vmenv, msg, gp)
+	if err != nil {
+		// The message could not be applied, roll back any partial changes
+		statedb.RevertToSnapshot(snapshot)
+		gp.SetGas(gaspool)
+		return &bundleCallResult{Error: err.Error()}, nil
+	}
+	result := &bundleCallResult{
+		GasUsed:     hexutil.Uint64(gas),
+		ReturnValue: ret,
+		Failed:      failed,
+		StateDiff:   diffState(pre, statedb, statedb.ModifiedSince(snapshot)),
+	}
+	if failed {
+		if reason, err := abi.UnpackRevert(ret); err == nil {
+			result.RevertReason = reason
+		}
+	}
+	if tracer != nil {
+		if result.Trace, err = traceResult(tracer, ret, gas, failed); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// diffState assembles the changes of the modified accounts and storage slots
+// between two states, omitting any account that ended up unchanged.
+func diffState(pre, post *state.StateDB, modified map[common.Address][]common.Hash) *bundleDiff {
+	diff := &bundleDiff{
+		Pre:  make(map[common.Address]*bundleAccount),
+		Post: make(map[common.Address]*bundleAccount),
+	}
+	for addr, slots := range modified {
+		var (
+			before = new(bundleAccount)
+			after  = new(bundleAccount)
+			change bool
+		)
+		if prev, next := pre.GetBalance(addr), post.GetBalance(addr); prev.Cmp(next) != 0 {
+			before.Balance, after.Balance, change = (*hexutil.Big)(prev), (*hexutil.Big)(next), true
+		}
+		if prev, next := pre.GetNonce(addr), post.GetNonce(addr); prev != next {
+			before.Nonce, after.Nonce, change = (*hexutil.Uint64)(&prev), (*hexutil.Uint64)(&next), true
+		}
+		if prev, next := pre.GetCodeHash(addr), post.GetCodeHash(addr); prev != next {
+			before.Code, after.Code, change = pre.GetCode(addr), post.GetCode(addr), true
+		}
+		for _, slot := range slots {
+			if prev, next := pre.Gehaaate(addr, slot), post.Gehaaate(addr, slot); prev != next {
+				if after.Storage == nil {
+					before.Storage, after.Storage = make(map[common.Hash]common.Hash), make(map[common.Hash]common.Hash)
+				}
+				before.Storage[slot], after.Storage[slot], change = prev, next, true
+			}
+		}
+		if change {
+			diff.Pre[addr], diff.Post[addr] = before, after
+		}
+	}
+	return diff
+}
+
+// toMessage converts the bundle call into a transaction and the message to be
+// executed. Unsigned calls are identified by the hash of the unsigned
+// transaction, using the current nonce of the sender and the given gas cap if
+// no gas limit was specified.
+func (call *BundleCall) toMessage(signer types.Signer, statedb *state.StateDB, gasCap uint64) (*types.Transaction, core.Message, error) {
+	if len(call.Raw) > 0 {
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(call.Raw); err != nil {
+			return nil, nil, err
+		}
+		msg, err := tx.AsMessage(signer)
+		if err != nil {
+			return nil, nil, err
+		}
+		return tx, msg, nil
+	}
+	var (
+		nonce = statedb.GetNonce(call.From)
+		gas   = uint64(call.Gas)
+		tx    *types.Transaction
+	)
+	if gas == 0 {
+		gas = gasCap
+	}
+	if call.To == nil {
+		tx = types.NewContractCreation(nonce, call.Value.ToInt(), gas, call.GasPrice.ToInt(), call.Data)
+	} else {
+		tx = types.NewTransaction(nonce, *call.To, call.Value.ToInt(), gas, call.GasPrice.ToInt(), call.Data)
+	}
+	msg := types.NewMessage(call.From, call.To, nonce, call.Value.ToInt(), gas, call.GasPrice.ToInt(), call.Data, false)
+	return tx, msg, nil
+}
@@ -0,0 +1,465 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package haa
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/common/hexutil"
+	"github.com/haachain/go-haachain/common/math"
+	"github.com/haachain/go-haachain/consensus/ethash"
+	"github.com/haachain/go-haachain/core"
+	"github.com/haachain/go-haachain/core/types"
+	"github.com/haachain/go-haachain/core/vm"
+	"github.com/haachain/go-haachain/crypto"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/internal/ethapi"
+	"github.com/haachain/go-haachain/params"
+	"github.com/haachain/go-haachain/rpc"
+)
+
+var (
+	tracerKey1, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	tracerKey2, _ = crypto.HexToECDSA("8a1f9a8f95be41cd7ccb6168179afb4504aefe388d1e14474d32c45c72ce7b7a")
+	tracerAddr1   = crypto.PubkeyToAddress(tracerKey1.PublicKey)
+	tracerAddr2   = crypto.PubkeyToAddress(tracerKey2.PublicKey)
+
+	// tracerStorer is a contract storing 0x2a into its first storage slot.
+	tracerStorer     = common.Address{0x0a}
+	tracerStorerCode = common.FromHex("602a60005500")
+
+	// tracerReverter is a contract reverting with the reason "boom".
+	tracerReverter     = common.Address{0x0b}
+	tracerReverterCode = common.FromHex("6064600c60003960646000fd" +
+		"08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000004" +
+		"626f6f6d00000000000000000000000000000000000000000000000000000000")
+
+	// tracerHeader is a contract returning the number, time and coinbase of
+	// the block it's executed in.
+	tracerHeader     = common.Address{0x0c}
+	tracerHeaderCode = common.FromHex("43600052426020524160405260606000f3")
+)
+
+// newTestTracerAPI creates a debug API on top of a chain of the given length,
+// with a few funded accounts and test contracts in its genesis state.
+func newTestTracerAPI(t *testing.T, n int, gen func(int, *core.BlockGen)) *PrivateDebugAPI {
+	var (
+		db, _ = haadb.NewMemDatabase()
+		gspec = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				tracerAddr1:    {Balance: big.NewInt(1000000000)},
+				tracerAddr2:    {Balance: big.NewInt(1000000000)},
+				tracerStorer:   {Balance: new(big.Int), Code: tracerStorerCode},
+				tracerReverter: {Balance: new(big.Int), Code: tracerReverterCode},
+				tracerHeader:   {Balance: new(big.Int), Code: tracerHeaderCode},
+			},
+		}
+		genesis = gspec.MustCommit(db)
+	)
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, n, gen)
+
+	chain, err := core.NewBlockChain(db, nil, gspec.Config, ethash.NewFaker(), vm.Config{})
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to import chain: %v", err)
+	}
+	return NewPrivateDebugAPI(gspec.Config, &haachain{
+		chainConfig: gspec.Config,
+		blockchain:  chain,
+		chainDb:     db,
+		engine:      ethash.NewFaker(),
+	})
+}
+
+// signedBundleCall creates a bundle call from a transaction signed with the key.
+func signedBundleCall(t *testing.T, key *ecdsa.PrivateKey, tx *types.Transaction) BundleCall {
+	signed, err := types.SignTx(tx, types.MakeSigner(params.TestChainConfig, common.Big1), key)
+	if err != nil {
+		t.Fatalf("failed to sign transaction: %v", err)
+	}
+	blob, err := signed.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to encode transaction: %v", err)
+	}
+	return BundleCall{Raw: blob}
+}
+
+// Tests that a simulated bundle reports the outcome and the state changes of
+// each message, including reverted ones, and that messages which can't be
+// applied leave neither the state nor the block gas pool modified.
+func TestSimulateBundle(t *testing.T) {
+	api := newTestTracerAPI(t, 0, nil)
+
+	calls := []BundleCall{
+		// Valid transaction modifying the storage of a contract
+		signedBundleCall(t, tracerKey1, types.NewTransaction(0, tracerStorer, new(big.Int), 50000, big.NewInt(1), nil)),
+		// Unsigned call reverting with a reason
+		{CallArgs: ethapi.CallArgs{From: tracerAddr1, To: &tracerReverter, Gas: 30000}},
+		// Invalid transaction failing after buying its gas (intrinsic gas too low)
+		signedBundleCall(t, tracerKey2, types.NewTransaction(0, tracerAddr1, big.NewInt(1), 20000, big.NewInt(1), nil)),
+		// Valid transaction only fitting into the block if the previous one was rolled back
+		signedBundleCall(t, tracerKey2, types.NewTransaction(0, tracerAddr1, big.NewInt(1), 21000, big.NewInt(1), nil)),
+	}
+	// Measure the gas used by the leading calls to cap the block gas limit
+	results, err := api.SimulateBundle(context.Background(), calls[:2], rpc.LatestBlockNumber, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to simulate leading calls: %v", err)
+	}
+	gasLimit := hexutil.Uint64(uint64(results[0].GasUsed+results[1].GasUsed) + 21000 + 10000)
+
+	results, err = api.SimulateBundle(context.Background(), calls, rpc.LatestBlockNumber, &BlockOverrides{GasLimit: &gasLimit}, nil)
+	if err != nil {
+		t.Fatalf("failed to simulate bundle: %v", err)
+	}
+	if len(results) != len(calls) {
+		t.Fatalf("result count mismatch: have %d, want %d", len(results), len(calls))
+	}
+	// The first transaction must succeed and report its state changes
+	res := results[0]
+	if res.Failed || res.Error != "" {
+		t.Fatalf("call 0: unexpected failure: failed %v, error %q", res.Failed, res.Error)
+	}
+	if post := res.StateDiff.Post[tracerStorer]; post == nil || post.Storage[common.Hash{}] != common.BigToHash(big.NewInt(0x2a)) {
+		t.Errorf("call 0: storage change not reported: %+v", post)
+	}
+	if post := res.StateDiff.Post[tracerAddr1]; post == nil || post.Nonce == nil || *post.Nonce != 1 {
+		t.Errorf("call 0: nonce change not reported: %+v", post)
+	}
+	// The second call must revert with a decoded reason and no contract changes
+	res = results[1]
+	if !res.Failed || res.RevertReason != "boom" {
+		t.Errorf("call 1: revert mismatch: failed %v, reason %q", res.Failed, res.RevertReason)
+	}
+	if _, ok := res.StateDiff.Post[tracerReverter]; ok {
+		t.Errorf("call 1: reverted contract reported as changed")
+	}
+	// The third transaction must be rejected without any state changes
+	res = results[2]
+	if res.Error == "" || res.StateDiff != nil {
+		t.Errorf("call 2: rejection mismatch: error %q, diff %+v", res.Error, res.StateDiff)
+	}
+	// The fourth transaction must see the balance and gas pool of before the rejection
+	res = results[3]
+	if res.Failed || res.Error != "" {
+		t.Fatalf("call 3: unexpected failure: failed %v, error %q", res.Failed, res.Error)
+	}
+	if pre := res.StateDiff.Pre[tracerAddr2]; pre == nil || pre.Balance == nil || pre.Balance.ToInt().Cmp(big.NewInt(1000000000)) != 0 {
+		t.Errorf("call 3: rejected transaction not rolled back: %+v", pre)
+	}
+}
+
+// Tests that header overrides are visible to the executed messages.
+func TestSimulateBundleOverrides(t *testing.T) {
+	api := newTestTracerAPI(t, 1, nil)
+
+	var (
+		number   = big.NewInt(100)
+		time     = big.NewInt(123456)
+		coinbase = common.Address{0xc0, 0xff, 0xee}
+	)
+	overrides := &BlockOverrides{
+		Number:   (*hexutil.Big)(number),
+		Time:     (*hexutil.Big)(time),
+		Coinbase: &coinbase,
+	}
+	calls := []BundleCall{
+		{CallArgs: ethapi.CallArgs{From: tracerAddr1, To: &tracerHeader, Gas: 100000, GasPrice: hexutil.Big(*big.NewInt(1))}},
+	}
+	results, err := api.SimulateBundle(context.Background(), calls, rpc.LatestBlockNumber, overrides, nil)
+	if err != nil {
+		t.Fatalf("failed to simulate bundle: %v", err)
+	}
+	res := results[0]
+	if res.Failed || res.Error != "" {
+		t.Fatalf("unexpected failure: failed %v, error %q", res.Failed, res.Error)
+	}
+	want := append(append(common.BigToHash(number).Bytes(), common.BigToHash(time).Bytes()...), coinbase.Hash().Bytes()...)
+	if !bytes.Equal(res.ReturnValue, want) {
+		t.Errorf("header fields mismatch: have %x, want %x", []byte(res.ReturnValue), want)
+	}
+	if post := res.StateDiff.Post[coinbase]; post == nil || post.Balance == nil || post.Balance.ToInt().Sign() == 0 {
+		t.Errorf("overridden coinbase not credited: %+v", post)
+	}
+}
+
+// Tests that the messages of a simulated bundle are traced if requested.
+func TestSimulateBundleTracer(t *testing.T) {
+	api := newTestTracerAPI(t, 1, nil)
+
+	tracer := "callTracer"
+	calls := []BundleCall{
+		signedBundleCall(t, tracerKey1, types.NewTransaction(0, tracerStorer, new(big.Int), 50000, big.NewInt(1), nil)),
+		{CallArgs: ethapi.CallArgs{From: tracerAddr2, To: &tracerReverter, Gas: 50000}},
+	}
+	results, err := api.SimulateBundle(context.Background(), calls, rpc.LatestBlockNumber, nil, &TraceConfig{Tracer: &tracer})
+	if err != nil {
+		t.Fatalf("failed to simulate bundle: %v", err)
+	}
+	tests := []struct {
+		to       common.Address
+		reverted bool
+	}{
+		{tracerStorer, false},
+		{tracerReverter, true},
+	}
+	for i, tt := range tests {
+		blob, ok := results[i].Trace.(json.RawMessage)
+		if !ok {
+			t.Fatalf("call %d: trace type mismatch: have %T", i, results[i].Trace)
+		}
+		var frame struct {
+			Type  string         `json:"type"`
+			To    common.Address `json:"to"`
+			Error string         `json:"error"`
+		}
+		if err := json.Unmarshal(blob, &frame); err != nil {
+			t.Fatalf("call %d: failed to decode trace: %v", i, err)
+		}
+		if frame.Type != "CALL" || frame.To != tt.to {
+			t.Errorf("call %d: trace frame mismatch: have %s to %x, want CALL to %x", i, frame.Type, frame.To, tt.to)
+		}
+		if (frame.Error != "") != tt.reverted {
+			t.Errorf("call %d: trace error mismatch: have %q, reverted %v", i, frame.Error, tt.reverted)
+		}
+	}
+}
+
+// Tests that the standard JSON traces of a block are dumped into a file per
+// transaction, or into a single file if a transaction is selected, and that no
+// leftovers remain on disk if the trace fails.
+func TestStandardTraceBlockToFile(t *testing.T) {
+	// Redirect the trace dumps into a private temporary directory
+	tmp, err := ioutil.TempDir("", "std-trace-test")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmp)
+
+	// Create a chain with a block of multiple contract calls
+	var txs []*types.Transaction
+	api := newTestTracerAPI(t, 1, func(i int, b *core.BlockGen) {
+		signer := types.MakeSigner(params.TestChainConfig, b.Number())
+		for j, call := range []struct {
+			key   *ecdsa.PrivateKey
+			nonce uint64
+			to    common.Address
+		}{
+			{tracerKey1, 0, tracerStorer},
+			{tracerKey2, 0, tracerReverter},
+			{tracerKey1, 1, tracerHeader},
+		} {
+			tx, err := types.SignTx(types.NewTransaction(call.nonce, call.to, new(big.Int), 100000, big.NewInt(1), nil), signer, call.key)
+			if err != nil {
+				t.Fatalf("failed to sign transaction %d: %v", j, err)
+			}
+			b.AddTx(tx)
+			txs = append(txs, tx)
+		}
+	})
+	block := api.haa.blockchain.CurrentBlock()
+
+	// Trace all the transactions and the middle one, ensuring the dumps are valid
+	for i, tt := range []struct {
+		config *StdTraceConfig
+		traced []int
+	}{
+		{nil, []int{0, 1, 2}},
+		{&StdTraceConfig{TxHash: txs[1].Hash()}, []int{1}},
+	} {
+		files, err := api.StandardTraceBlockToFile(context.Background(), block.Hash(), tt.config)
+		if err != nil {
+			t.Fatalf("test %d: failed to trace block: %v", i, err)
+		}
+		if len(files) != len(tt.traced) {
+			t.Fatalf("test %d: dump count mismatch: have %d, want %d", i, len(files), len(tt.traced))
+		}
+		for j, file := range files {
+			checkStandardTraceFile(t, file)
+
+			if prefix := fmt.Sprintf("%d-%#x-", tt.traced[j], txs[tt.traced[j]].Hash().Bytes()[:4]); !strings.HasPrefix(filepath.Base(file), prefix) {
+				t.Errorf("test %d, dump %d: file name mismatch: have %s, want prefix %s", i, j, filepath.Base(file), prefix)
+			}
+		}
+		os.RemoveAll(filepath.Dir(files[0]))
+	}
+	// Request an unknown transaction and ensure nothing is left behind
+	if _, err := api.StandardTraceBlockToFile(context.Background(), block.Hash(), &StdTraceConfig{TxHash: common.Hash{0xff}}); err == nil {
+		t.Fatalf("unknown transaction traced")
+	}
+	if _, err := api.StandardTraceBlockToFile(context.Background(), common.Hash{0xff}, nil); err == nil {
+		t.Fatalf("unknown block traced")
+	}
+	entries, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		t.Fatalf("failed to list temporary directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("leftover trace dumps after failure: %d entries", len(entries))
+	}
+}
+
+// checkStandardTraceFile ensures that a trace dump consists of parseable JSON
+// execution steps, followed by a closing summary line.
+func checkStandardTraceFile(t *testing.T, file string) {
+	dump, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("failed to open trace dump: %v", err)
+	}
+	defer dump.Close()
+
+	var lines []map[string]interface{}
+	for scanner := bufio.NewScanner(dump); scanner.Scan(); {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("%s: invalid JSON line %d: %v", file, len(lines), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) < 2 {
+		t.Fatalf("%s: too few lines: %d", file, len(lines))
+	}
+	for i, line := range lines[:len(lines)-1] {
+		if _, ok := line["pc"]; !ok {
+			t.Errorf("%s: line %d: missing program counter: %v", file, i, line)
+		}
+		if _, ok := line["op"]; !ok {
+			t.Errorf("%s: line %d: missing opcode: %v", file, i, line)
+		}
+	}
+	if _, ok := lines[len(lines)-1]["gasUsed"]; !ok {
+		t.Errorf("%s: missing summary line: %v", file, lines[len(lines)-1])
+	}
+}
+
+// Tests that streamed transaction traces hold the same execution steps as the
+// struct logs returned over RPC, followed by a summary line, and that a trace
+// exceeding its timeout is aborted without leaving anything on disk.
+func TestTraceTransactionStreaming(t *testing.T) {
+	// Redirect the trace dumps into a private temporary directory
+	tmp, err := ioutil.TempDir("", "stream-trace-test")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmp)
+
+	// Create a chain with a storing and a reverting contract call
+	var txs []*types.Transaction
+	api := newTestTracerAPI(t, 1, func(i int, b *core.BlockGen) {
+		signer := types.MakeSigner(params.TestChainConfig, b.Number())
+		for j, call := range []struct {
+			key *ecdsa.PrivateKey
+			to  common.Address
+		}{
+			{tracerKey1, tracerStorer},
+			{tracerKey2, tracerReverter},
+		} {
+			tx, err := types.SignTx(types.NewTransaction(0, call.to, new(big.Int), 100000, big.NewInt(1), nil), signer, call.key)
+			if err != nil {
+				t.Fatalf("failed to sign transaction %d: %v", j, err)
+			}
+			b.AddTx(tx)
+			txs = append(txs, tx)
+		}
+	})
+	for i, tx := range txs {
+		// Trace the transaction over RPC and encode its steps one per line
+		res, err := api.TraceTransaction(context.Background(), tx.Hash(), &TraceConfig{LogConfig: &vm.LogConfig{DisableStorage: true}})
+		if err != nil {
+			t.Fatalf("tx %d: failed to trace transaction: %v", i, err)
+		}
+		result := res.(*ethapi.ExecutionResult)
+
+		var want [][]byte
+		for _, log := range result.StructLogs {
+			blob, err := json.Marshal(log)
+			if err != nil {
+				t.Fatalf("tx %d: failed to encode struct log: %v", i, err)
+			}
+			want = append(want, blob)
+		}
+		// Stream the trace of the same transaction into a file and compare
+		res, err = api.TraceTransaction(context.Background(), tx.Hash(), &TraceConfig{LogConfig: &vm.LogConfig{DisableStorage: true}, Streaming: true})
+		if err != nil {
+			t.Fatalf("tx %d: failed to stream transaction trace: %v", i, err)
+		}
+		file := res.(string)
+		checkStandardTraceFile(t, file)
+
+		blob, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("tx %d: failed to read trace dump: %v", i, err)
+		}
+		have := bytes.Split(bytes.TrimSpace(blob), []byte("\n"))
+		if len(have) != len(want)+1 {
+			t.Fatalf("tx %d: line count mismatch: have %d, want %d", i, len(have), len(want)+1)
+		}
+		for j := range want {
+			if !bytes.Equal(have[j], want[j]) {
+				t.Errorf("tx %d, step %d: streamed step mismatch:\nhave %s\nwant %s", i, j, have[j], want[j])
+			}
+		}
+		var summary struct {
+			GasUsed math.HexOrDecimal64 `json:"gasUsed"`
+			Error   string              `json:"error"`
+		}
+		if err := json.Unmarshal(have[len(have)-1], &summary); err != nil {
+			t.Fatalf("tx %d: invalid summary line: %v", i, err)
+		}
+		if uint64(summary.GasUsed) != result.Gas-params.TxGas {
+			t.Errorf("tx %d: summary gas mismatch: have %d, want %d", i, summary.GasUsed, result.Gas-params.TxGas)
+		}
+		if (summary.Error != "") != result.Failed {
+			t.Errorf("tx %d: summary error mismatch: have %q, failed %v", i, summary.Error, result.Failed)
+		}
+		os.Remove(file)
+	}
+	// Stream a trace exceeding its timeout and ensure nothing is left behind
+	timeout := "0s"
+	if _, err := api.TraceTransaction(context.Background(), txs[0].Hash(), &TraceConfig{Timeout: &timeout, Streaming: true}); err == nil || err.Error() != "execution timeout" {
+		t.Fatalf("timed out trace error mismatch: have %v, want execution timeout", err)
+	}
+	entries, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		t.Fatalf("failed to list temporary directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("leftover trace dumps after timeout: %d entries", len(entries))
+	}
+}
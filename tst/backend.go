@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"path/filepath"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -146,7 +147,7 @@ func New(ctx *node.ServiceContext, config *Config) (*haachain, error) {
 	}
 	var (
 		vmConfig    = vm.Config{EnablePreimageRecording: config.EnablePreimageRecording}
-		cacheConfig = &core.CacheConfig{Disabled: config.NoPruning, TrieNodeLimit: config.TrieCache, TrieTimeLimit: config.TrieTimeout}
+		cacheConfig = &core.CacheConfig{Disabled: config.NoPruning, TrieNodeLimit: config.TrieCache, TrieTimeLimit: config.TrieTimeout, Snapshot: config.Snapshot}
 	)
 	haa.blockchain, err = core.NewBlockChain(chainDb, cacheConfig, haa.chainConfig, haa.engine, vmConfig)
 	if err != nil {
@@ -163,13 +164,24 @@ func New(ctx *node.ServiceContext, config *Config) (*haachain, error) {
 	if config.TxPool.Journal != "" {
 		config.TxPool.Journal = ctx.ResolvePath(config.TxPool.Journal)
 	}
+	if config.TxPool.RemoteJournal != "" {
+		config.TxPool.RemoteJournal = ctx.ResolvePath(config.TxPool.RemoteJournal)
+	}
 	haa.txPool = core.NewTxPool(config.TxPool, haa.chainConfig, haa.blockchain)
 
 	if haa.protocolManager, err = NewProtocolManager(haa.chainConfig, config.SyncMode, config.NetworkId, haa.eventMux, haa.txPool, haa.engine, haa.blockchain, chainDb); err != nil {
 		return nil, err
 	}
+	for _, id := range config.PrivateTxPeers {
+		haa.protocolManager.trustPrivatePeer(id)
+	}
+	ordering, err := miner.NewTxOrdering(config.MinerOrdering, config.MinerPriority)
+	if err != nil {
+		return nil, err
+	}
 	haa.miner = miner.New(haa, haa.chainConfig, haa.EventMux(), haa.engine)
 	haa.miner.SetExtra(makeExtraData(config.ExtraData))
+	haa.miner.SetOrdering(ordering)
 
 	haa.ApiBackend = &haaApiBackend{haa, nil}
 	gpoParams := config.GPO
@@ -200,11 +212,33 @@ func makeExtraData(extra []byte) []byte {
 
 // CreateDB creates the chain database.
 func CreateDB(ctx *node.ServiceContext, config *Config, name string) (haadb.Database, error) {
-	db, err := ctx.OpenDatabase(name, config.DatabaseCache, config.DatabaseHandles)
+	var (
+		db  haadb.Database
+		err error
+	)
+	switch {
+	case config.SyncMode == downloader.LightSync:
+		// Light clients don't store block bodies and receipts, skip the freezer
+		db, err = ctx.OpenDatabase(name, config.DatabaseCache, config.DatabaseHandles)
+
+	case config.NoFreezer:
+		// Refuse to detach a freezer already holding part of the chain
+		if ancient := ctx.ResolvePath(filepath.Join(name, "ancient")); ancient != "" {
+			if frozen, err := haadb.HasAncients(ancient); err != nil {
+				return nil, err
+			} else if frozen {
+				return nil, fmt.Errorf("ancient chain segments found in %s, freezer can't be disabled", ancient)
+			}
+		}
+		db, err = ctx.OpenDatabase(name, config.DatabaseCache, config.DatabaseHandles)
+
+	default:
+		db, err = ctx.OpenDatabaseWithFreezer(name, config.DatabaseCache, config.DatabaseHandles, config.DatabaseFreezer)
+	}
 	if err != nil {
 		return nil, err
 	}
-	if db, ok := db.(*haadb.LDBDatabase); ok {
+	if db, ok := db.(interface{ Meter(prefix string) }); ok {
 		db.Meter("haa/db/chaindata/")
 	}
 	return db, nil
@@ -280,6 +314,10 @@ func (s *haachain) APIs() []rpc.API {
 			Namespace: "admin",
 			Version:   "1.0",
 			Service:   NewPrivateAdminAPI(s),
+		}, {
+			Namespace: "txpool",
+			Version:   "1.0",
+			Service:   NewPrivateTxPoolAPI(s),
 		}, {
 			Namespace: "debug",
 			Version:   "1.0",
@@ -30,6 +30,7 @@ import (
 	"github.com/haachain/go-haachain/core"
 	"github.com/haachain/go-haachain/haa/downloader"
 	"github.com/haachain/go-haachain/haa/gasprice"
+	"github.com/haachain/go-haachain/p2p/discover"
 	"github.com/haachain/go-haachain/params"
 )
 
@@ -91,8 +92,11 @@ type Config struct {
 	SkipBcVersionCheck bool `toml:"-"`
 	DatabaseHandles    int  `toml:"-"`
 	DatabaseCache      int
+	DatabaseFreezer    string
+	NoFreezer          bool // Whether to keep ancient chain segments in the database
 	TrieCache          int
 	TrieTimeout        time.Duration
+	Snapshot           bool // Whether to maintain a flat state snapshot
 
 	// Mining-related options
 	haaerbase    common.Address `toml:",omitempty"`
@@ -100,11 +104,15 @@ type Config struct {
 	ExtraData    []byte         `toml:",omitempty"`
 	GasPrice     *big.Int
 
+	MinerOrdering string           `toml:",omitempty"` // Transaction ordering policy (price, fifo or priority)
+	MinerPriority []common.Address `toml:",omitempty"` // Senders committed first by the priority ordering
+
 	// haaash options
 	haaash ethash.Config
 
 	// Transaction pool options
-	TxPool core.TxPoolConfig
+	TxPool         core.TxPoolConfig
+	PrivateTxPeers []discover.NodeID `toml:",omitempty"` // Trusted peers allowed to receive private transactions
 
 	// Gas Price Oracle options
 	GPO gasprice.Config
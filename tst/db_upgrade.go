@@ -44,13 +44,18 @@ func upgradeDeduplicateData(db haadb.Database) func() error {
 		db.Put(deduplicateData, []byte{42})
 		return nil
 	}
+	// Only LevelDB databases can predate the lookup entries
+	ldb, ok := db.(*haadb.LDBDatabase)
+	if !ok {
+		return nil
+	}
 	// Start the deduplication upgrade on a new goroutine
 	log.Warn("Upgrading database to use lookup entries")
 	stop := make(chan chan error)
 
 	go func() {
 		// Create an iterator to read the entire database and covert old lookup entires
-		it := db.(*haadb.LDBDatabase).NewIterator()
+		it := ldb.NewIterator()
 		defer func() {
 			if it != nil {
 				it.Release()
@@ -100,7 +105,7 @@ func upgradeDeduplicateData(db haadb.Database) func() error {
 			converted++
 			if converted%100000 == 0 {
 				it.Release()
-				it = db.(*haadb.LDBDatabase).NewIterator()
+				it = ldb.NewIterator()
 				it.Seek(key)
 
 				log.Info("Deduplicating database entries", "deduped", converted)
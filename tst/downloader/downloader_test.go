@@ -28,6 +28,7 @@ import (
 	"github.com/haachain/go-haachain/common"
 	"github.com/haachain/go-haachain/consensus/ethash"
 	"github.com/haachain/go-haachain/core"
+	"github.com/haachain/go-haachain/core/state"
 	"github.com/haachain/go-haachain/core/types"
 	"github.com/haachain/go-haachain/crypto"
 	"github.com/haachain/go-haachain/haadb"
@@ -492,7 +493,7 @@ func (dlp *downloadTesterPeer) Head() (common.Hash, *big.Int) {
 // RequestHeadersByHash constructs a GetBlockHeaders function based on a hashed
 // origin; associated with a particular peer in the download tester. The returned
 // function can be used to retrieve batches of headers from the particular peer.
-func (dlp *downloadTesterPeer) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
+func (dlp *downloadTesterPeer) RequestHeadersByHash(id uint64, origin common.Hash, amount int, skip int, reverse bool) error {
 	// Find the canonical number of the hash
 	dlp.dl.lock.RLock()
 	number := uint64(0)
@@ -505,13 +506,13 @@ func (dlp *downloadTesterPeer) RequestHeadersByHash(origin common.Hash, amount i
 	dlp.dl.lock.RUnlock()
 
 	// Use the absolute header fetcher to satisfy the query
-	return dlp.RequestHeadersByNumber(number, amount, skip, reverse)
+	return dlp.RequestHeadersByNumber(id, number, amount, skip, reverse)
 }
 
 // RequestHeadersByNumber constructs a GetBlockHeaders function based on a numbered
 // origin; associated with a particular peer in the download tester. The returned
 // function can be used to retrieve batches of headers from the particular peer.
-func (dlp *downloadTesterPeer) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
+func (dlp *downloadTesterPeer) RequestHeadersByNumber(id uint64, origin uint64, amount int, skip int, reverse bool) error {
 	dlp.waitDelay()
 
 	dlp.dl.lock.RLock()
@@ -529,7 +530,7 @@ func (dlp *downloadTesterPeer) RequestHeadersByNumber(origin uint64, amount int,
 	// Delay delivery a bit to allow attacks to unfold
 	go func() {
 		time.Sleep(time.Millisecond)
-		dlp.dl.downloader.DeliverHeaders(dlp.id, result)
+		dlp.dl.downloader.DeliverHeaders(dlp.id, id, result)
 	}()
 	return nil
 }
@@ -537,7 +538,7 @@ func (dlp *downloadTesterPeer) RequestHeadersByNumber(origin uint64, amount int,
 // RequestBodies constructs a getBlockBodies method associated with a particular
 // peer in the download tester. The returned function can be used to retrieve
 // batches of block bodies from the particularly requested peer.
-func (dlp *downloadTesterPeer) RequestBodies(hashes []common.Hash) error {
+func (dlp *downloadTesterPeer) RequestBodies(id uint64, hashes []common.Hash) error {
 	dlp.waitDelay()
 
 	dlp.dl.lock.RLock()
@@ -554,7 +555,7 @@ func (dlp *downloadTesterPeer) RequestBodies(hashes []common.Hash) error {
 			uncles = append(uncles, block.Uncles())
 		}
 	}
-	go dlp.dl.downloader.DeliverBodies(dlp.id, transactions, uncles)
+	go dlp.dl.downloader.DeliverBodies(dlp.id, id, transactions, uncles)
 
 	return nil
 }
@@ -562,7 +563,7 @@ func (dlp *downloadTesterPeer) RequestBodies(hashes []common.Hash) error {
 // RequestReceipts constructs a getReceipts method associated with a particular
 // peer in the download tester. The returned function can be used to retrieve
 // batches of block receipts from the particularly requested peer.
-func (dlp *downloadTesterPeer) RequestReceipts(hashes []common.Hash) error {
+func (dlp *downloadTesterPeer) RequestReceipts(id uint64, hashes []common.Hash) error {
 	dlp.waitDelay()
 
 	dlp.dl.lock.RLock()
@@ -576,7 +577,7 @@ func (dlp *downloadTesterPeer) RequestReceipts(hashes []common.Hash) error {
 			results = append(results, receipt)
 		}
 	}
-	go dlp.dl.downloader.DeliverReceipts(dlp.id, results)
+	go dlp.dl.downloader.DeliverReceipts(dlp.id, id, results)
 
 	return nil
 }
@@ -584,7 +585,7 @@ func (dlp *downloadTesterPeer) RequestReceipts(hashes []common.Hash) error {
 // RequestNodeData constructs a getNodeData method associated with a particular
 // peer in the download tester. The returned function can be used to retrieve
 // batches of node state data from the particularly requested peer.
-func (dlp *downloadTesterPeer) RequestNodeData(hashes []common.Hash) error {
+func (dlp *downloadTesterPeer) RequestNodeData(id uint64, hashes []common.Hash) error {
 	dlp.waitDelay()
 
 	dlp.dl.lock.RLock()
@@ -598,7 +599,104 @@ func (dlp *downloadTesterPeer) RequestNodeData(hashes []common.Hash) error {
 			}
 		}
 	}
-	go dlp.dl.downloader.DeliverNodeData(dlp.id, results)
+	go dlp.dl.downloader.DeliverNodeData(dlp.id, id, results)
+
+	return nil
+}
+
+// RequestAccountRange constructs a getAccountRange method associated with a
+// particular peer in the download tester. The returned function can be used to
+// retrieve consecutive accounts from the particularly requested peer.
+func (dlp *downloadTesterPeer) RequestAccountRange(id uint64, root common.Hash, origin common.Hash, limit common.Hash, bytes uint64) error {
+	dlp.waitDelay()
+
+	dlp.dl.lock.RLock()
+	defer dlp.dl.lock.RUnlock()
+
+	tr, err := trie.New(root, trie.NewDatabase(dlp.dl.peerDb))
+	if err != nil {
+		go dlp.dl.downloader.DeliverAccountRange(dlp.id, id, nil, nil, nil)
+		return nil
+	}
+	var (
+		hashes   []common.Hash
+		accounts [][]byte
+		size     uint64
+	)
+	it := trie.NewIterator(tr.NodeIterator(origin[:]))
+	for size < bytes && it.Next() {
+		hashes = append(hashes, common.BytesToHash(it.Key))
+		accounts = append(accounts, common.CopyBytes(it.Value))
+		size += uint64(common.HashLength + len(it.Value))
+
+		if hashes[len(hashes)-1].Big().Cmp(limit.Big()) >= 0 {
+			break
+		}
+	}
+	proof, _ := haadb.NewMemDatabase()
+	tr.Prove(origin[:], 0, proof)
+	if len(hashes) > 0 {
+		tr.Prove(hashes[len(hashes)-1][:], 0, proof)
+	}
+	var nodes [][]byte
+	for _, key := range proof.Keys() {
+		node, _ := proof.Get(key)
+		nodes = append(nodes, node)
+	}
+	go dlp.dl.downloader.DeliverAccountRange(dlp.id, id, hashes, accounts, nodes)
+
+	return nil
+}
+
+// RequestStorageRanges constructs a getStorageRanges method associated with a
+// particular peer in the download tester. The returned function can be used to
+// retrieve entire storage tries from the particularly requested peer.
+func (dlp *downloadTesterPeer) RequestStorageRanges(id uint64, roots []common.Hash, origin common.Hash, bytes uint64) error {
+	dlp.waitDelay()
+
+	dlp.dl.lock.RLock()
+	defer dlp.dl.lock.RUnlock()
+
+	var (
+		hashes [][]common.Hash
+		slots  [][][]byte
+	)
+	for _, root := range roots {
+		tr, err := trie.New(root, trie.NewDatabase(dlp.dl.peerDb))
+		if err != nil {
+			break
+		}
+		var (
+			keys []common.Hash
+			vals [][]byte
+		)
+		for it := trie.NewIterator(tr.NodeIterator(nil)); it.Next(); {
+			keys = append(keys, common.BytesToHash(it.Key))
+			vals = append(vals, common.CopyBytes(it.Value))
+		}
+		hashes, slots = append(hashes, keys), append(slots, vals)
+	}
+	go dlp.dl.downloader.DeliverStorageRanges(dlp.id, id, hashes, slots, nil)
+
+	return nil
+}
+
+// RequestByteCodes constructs a getByteCodes method associated with a particular
+// peer in the download tester. The returned function can be used to retrieve
+// batches of contract codes from the particularly requested peer.
+func (dlp *downloadTesterPeer) RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error {
+	dlp.waitDelay()
+
+	dlp.dl.lock.RLock()
+	defer dlp.dl.lock.RUnlock()
+
+	var codes [][]byte
+	for _, hash := range hashes {
+		if code, err := dlp.dl.peerDb.Get(hash[:]); err == nil {
+			codes = append(codes, code)
+		}
+	}
+	go dlp.dl.downloader.DeliverByteCodes(dlp.id, id, codes)
 
 	return nil
 }
@@ -665,6 +763,7 @@ func TestCanonicalSynchronisation63Fast(t *testing.T)  { testCanonicalSynchronis
 func TestCanonicalSynchronisation64Full(t *testing.T)  { testCanonicalSynchronisation(t, 64, FullSync) }
 func TestCanonicalSynchronisation64Fast(t *testing.T)  { testCanonicalSynchronisation(t, 64, FastSync) }
 func TestCanonicalSynchronisation64Light(t *testing.T) { testCanonicalSynchronisation(t, 64, LightSync) }
+func TestCanonicalSynchronisation64Snap(t *testing.T)  { testCanonicalSynchronisation(t, 64, SnapSync) }
 
 func testCanonicalSynchronisation(t *testing.T, protocol int, mode SyncMode) {
 	t.Parallel()
@@ -685,6 +784,39 @@ func testCanonicalSynchronisation(t *testing.T, protocol int, mode SyncMode) {
 	assertOwnChain(t, tester, targetBlocks+1)
 }
 
+// Tests that snap sync reconstructs the entire state of the pivot block from the
+// retrieved account ranges.
+func TestSnapSyncState(t *testing.T) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	// Create a small enough block chain to download
+	targetBlocks := blockCacheItems - 15
+	hashes, headers, blocks, receipts := tester.makeChain(targetBlocks, 0, tester.genesis, nil, false)
+
+	tester.newPeer("peer", 64, hashes, headers, blocks, receipts)
+
+	// Synchronise with the peer and make sure the pivot state is complete
+	if err := tester.sync("peer", nil, SnapSync); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, targetBlocks+1)
+
+	root := headers[hashes[fsMinFullBlocks]].Root
+	statedb, err := state.New(root, state.NewDatabase(tester.stateDb))
+	if err != nil {
+		t.Fatalf("failed to open synced state: %v", err)
+	}
+	it := state.NewNodeIterator(statedb)
+	for it.Next() {
+	}
+	if it.Error != nil {
+		t.Fatalf("synced state incomplete: %v", it.Error)
+	}
+}
+
 // Tests that if a large batch of blocks are being downloaded, it is throttled
 // until the cached blocks are retrieved.
 func TestThrottling62(t *testing.T)     { testThrottling(t, 62, FullSync) }
@@ -779,6 +911,7 @@ func TestForkedSync63Fast(t *testing.T)  { testForkedSync(t, 63, FastSync) }
 func TestForkedSync64Full(t *testing.T)  { testForkedSync(t, 64, FullSync) }
 func TestForkedSync64Fast(t *testing.T)  { testForkedSync(t, 64, FastSync) }
 func TestForkedSync64Light(t *testing.T) { testForkedSync(t, 64, LightSync) }
+func TestForkedSync64Snap(t *testing.T)  { testForkedSync(t, 64, SnapSync) }
 
 func testForkedSync(t *testing.T, protocol int, mode SyncMode) {
 	t.Parallel()
@@ -920,10 +1053,10 @@ func TestInactiveDownloader62(t *testing.T) {
 	defer tester.terminate()
 
 	// Check that neither block headers nor bodies are accepted
-	if err := tester.downloader.DeliverHeaders("bad peer", []*types.Header{}); err != errNoSyncActive {
+	if err := tester.downloader.DeliverHeaders("bad peer", 0, []*types.Header{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
-	if err := tester.downloader.DeliverBodies("bad peer", [][]*types.Transaction{}, [][]*types.Header{}); err != errNoSyncActive {
+	if err := tester.downloader.DeliverBodies("bad peer", 0, [][]*types.Transaction{}, [][]*types.Header{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
 }
@@ -937,13 +1070,13 @@ func TestInactiveDownloader63(t *testing.T) {
 	defer tester.terminate()
 
 	// Check that neither block headers nor bodies are accepted
-	if err := tester.downloader.DeliverHeaders("bad peer", []*types.Header{}); err != errNoSyncActive {
+	if err := tester.downloader.DeliverHeaders("bad peer", 0, []*types.Header{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
-	if err := tester.downloader.DeliverBodies("bad peer", [][]*types.Transaction{}, [][]*types.Header{}); err != errNoSyncActive {
+	if err := tester.downloader.DeliverBodies("bad peer", 0, [][]*types.Transaction{}, [][]*types.Header{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
-	if err := tester.downloader.DeliverReceipts("bad peer", [][]*types.Receipt{}); err != errNoSyncActive {
+	if err := tester.downloader.DeliverReceipts("bad peer", 0, [][]*types.Receipt{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
 }
@@ -955,6 +1088,7 @@ func TestCancel63Fast(t *testing.T)  { testCancel(t, 63, FastSync) }
 func TestCancel64Full(t *testing.T)  { testCancel(t, 64, FullSync) }
 func TestCancel64Fast(t *testing.T)  { testCancel(t, 64, FastSync) }
 func TestCancel64Light(t *testing.T) { testCancel(t, 64, LightSync) }
+func TestCancel64Snap(t *testing.T)  { testCancel(t, 64, SnapSync) }
 
 func testCancel(t *testing.T, protocol int, mode SyncMode) {
 	t.Parallel()
@@ -1026,6 +1160,7 @@ func TestMultiProtoSynchronisation63Fast(t *testing.T)  { testMultiProtoSync(t,
 func TestMultiProtoSynchronisation64Full(t *testing.T)  { testMultiProtoSync(t, 64, FullSync) }
 func TestMultiProtoSynchronisation64Fast(t *testing.T)  { testMultiProtoSync(t, 64, FastSync) }
 func TestMultiProtoSynchronisation64Light(t *testing.T) { testMultiProtoSync(t, 64, LightSync) }
+func TestMultiProtoSynchronisation64Snap(t *testing.T)  { testMultiProtoSync(t, 64, SnapSync) }
 
 func testMultiProtoSync(t *testing.T, protocol int, mode SyncMode) {
 	t.Parallel()
@@ -1687,33 +1822,33 @@ type floodingTestPeer struct {
 }
 
 func (ftp *floodingTestPeer) Head() (common.Hash, *big.Int) { return ftp.peer.Head() }
-func (ftp *floodingTestPeer) RequestHeadersByHash(hash common.Hash, count int, skip int, reverse bool) error {
-	return ftp.peer.RequestHeadersByHash(hash, count, skip, reverse)
+func (ftp *floodingTestPeer) RequestHeadersByHash(id uint64, hash common.Hash, count int, skip int, reverse bool) error {
+	return ftp.peer.RequestHeadersByHash(id, hash, count, skip, reverse)
 }
-func (ftp *floodingTestPeer) RequestBodies(hashes []common.Hash) error {
-	return ftp.peer.RequestBodies(hashes)
+func (ftp *floodingTestPeer) RequestBodies(id uint64, hashes []common.Hash) error {
+	return ftp.peer.RequestBodies(id, hashes)
 }
-func (ftp *floodingTestPeer) RequestReceipts(hashes []common.Hash) error {
-	return ftp.peer.RequestReceipts(hashes)
+func (ftp *floodingTestPeer) RequestReceipts(id uint64, hashes []common.Hash) error {
+	return ftp.peer.RequestReceipts(id, hashes)
 }
-func (ftp *floodingTestPeer) RequestNodeData(hashes []common.Hash) error {
-	return ftp.peer.RequestNodeData(hashes)
+func (ftp *floodingTestPeer) RequestNodeData(id uint64, hashes []common.Hash) error {
+	return ftp.peer.RequestNodeData(id, hashes)
 }
 
-func (ftp *floodingTestPeer) RequestHeadersByNumber(from uint64, count, skip int, reverse bool) error {
+func (ftp *floodingTestPeer) RequestHeadersByNumber(id uint64, from uint64, count, skip int, reverse bool) error {
 	deliveriesDone := make(chan struct{}, 500)
 	for i := 0; i < cap(deliveriesDone); i++ {
 		peer := fmt.Sprintf("fake-peer%d", i)
 		ftp.pend.Add(1)
 
 		go func() {
-			ftp.tester.downloader.DeliverHeaders(peer, []*types.Header{{}, {}, {}, {}})
+			ftp.tester.downloader.DeliverHeaders(peer, id, []*types.Header{{}, {}, {}, {}})
 			deliveriesDone <- struct{}{}
 			ftp.pend.Done()
 		}()
 	}
 	// Deliver the actual requested headers.
-	go ftp.peer.RequestHeadersByNumber(from, count, skip, reverse)
+	go ftp.peer.RequestHeadersByNumber(id, from, count, skip, reverse)
 	// None of the extra deliveries should block.
 	timeout := time.After(60 * time.Second)
 	for i := 0; i < cap(deliveriesDone); i++ {
@@ -1753,3 +1888,92 @@ func testDeliverHeadersHang(t *testing.T, protocol int, mode SyncMode) {
 		tester.downloader.peers.peers["peer"].peer.(*floodingTestPeer).pend.Wait()
 	}
 }
+
+// Tests that responses arriving late or out of order, answering a previous
+// request of the same peer, are not mistaken for the response of the request
+// currently in flight.
+func TestStaleResponses(t *testing.T) {
+	testCases := []struct {
+		protocol int
+		syncMode SyncMode
+	}{
+		{64, FullSync},
+		{64, FastSync},
+		{64, SnapSync},
+		{64, LightSync},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("protocol %d mode %v", tc.protocol, tc.syncMode), func(t *testing.T) {
+			testStaleResponses(t, tc.protocol, tc.syncMode)
+		})
+	}
+}
+
+// replayingTestPeer is a peer which, before answering any request, answers the
+// previous request of the same type again, tagged with that request's ID.
+type replayingTestPeer struct {
+	peer *downloadTesterPeer
+	last map[string]func()
+	lock sync.Mutex
+}
+
+// replay answers the last request of the given kind, and then the new one.
+func (rtp *replayingTestPeer) replay(kind string, request func() error) error {
+	rtp.lock.Lock()
+	last := rtp.last[kind]
+	rtp.last[kind] = func() { request() }
+	rtp.lock.Unlock()
+
+	if last != nil {
+		last()
+	}
+	return request()
+}
+
+func (rtp *replayingTestPeer) Head() (common.Hash, *big.Int) { return rtp.peer.Head() }
+func (rtp *replayingTestPeer) RequestHeadersByHash(id uint64, hash common.Hash, count int, skip int, reverse bool) error {
+	return rtp.replay("headers", func() error { return rtp.peer.RequestHeadersByHash(id, hash, count, skip, reverse) })
+}
+func (rtp *replayingTestPeer) RequestHeadersByNumber(id uint64, from uint64, count int, skip int, reverse bool) error {
+	return rtp.replay("headers", func() error { return rtp.peer.RequestHeadersByNumber(id, from, count, skip, reverse) })
+}
+func (rtp *replayingTestPeer) RequestBodies(id uint64, hashes []common.Hash) error {
+	return rtp.replay("bodies", func() error { return rtp.peer.RequestBodies(id, hashes) })
+}
+func (rtp *replayingTestPeer) RequestReceipts(id uint64, hashes []common.Hash) error {
+	return rtp.replay("receipts", func() error { return rtp.peer.RequestReceipts(id, hashes) })
+}
+func (rtp *replayingTestPeer) RequestNodeData(id uint64, hashes []common.Hash) error {
+	return rtp.replay("state", func() error { return rtp.peer.RequestNodeData(id, hashes) })
+}
+func (rtp *replayingTestPeer) RequestAccountRange(id uint64, root common.Hash, origin common.Hash, limit common.Hash, bytes uint64) error {
+	return rtp.replay("snap", func() error { return rtp.peer.RequestAccountRange(id, root, origin, limit, bytes) })
+}
+func (rtp *replayingTestPeer) RequestStorageRanges(id uint64, roots []common.Hash, origin common.Hash, bytes uint64) error {
+	return rtp.replay("snap", func() error { return rtp.peer.RequestStorageRanges(id, roots, origin, bytes) })
+}
+func (rtp *replayingTestPeer) RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error {
+	return rtp.replay("snap", func() error { return rtp.peer.RequestByteCodes(id, hashes, bytes) })
+}
+
+func testStaleResponses(t *testing.T, protocol int, mode SyncMode) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	// Create a small enough block chain to download
+	targetBlocks := blockCacheItems - 15
+	hashes, headers, blocks, receipts := tester.makeChain(targetBlocks, 0, tester.genesis, nil, false)
+
+	tester.newPeer("peer", protocol, hashes, headers, blocks, receipts)
+	tester.downloader.peers.peers["peer"].peer = &replayingTestPeer{
+		peer: tester.downloader.peers.peers["peer"].peer.(*downloadTesterPeer),
+		last: make(map[string]func()),
+	}
+	// Synchronise with the peer and make sure all relevant data was retrieved
+	if err := tester.sync("peer", nil, mode); err != nil {
+		t.Fatalf("failed to synchronise blocks: %v", err)
+	}
+	assertOwnChain(t, tester, targetBlocks+1)
+}
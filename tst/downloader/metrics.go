@@ -40,4 +40,7 @@ var (
 
 	stateInMeter   = metrics.NewRegisteredMeter("haa/downloader/states/in", nil)
 	stateDropMeter = metrics.NewRegisteredMeter("haa/downloader/states/drop", nil)
+
+	snapInMeter   = metrics.NewRegisteredMeter("haa/downloader/snap/in", nil)
+	snapDropMeter = metrics.NewRegisteredMeter("haa/downloader/snap/drop", nil)
 )
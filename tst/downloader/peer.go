@@ -47,17 +47,21 @@ var (
 
 // peerConnection represents an active peer from which hashes and blocks are retrieved.
 type peerConnection struct {
+	reqID uint64 // Last request ID assigned to this peer (first for 64 bit alignment)
+
 	id string // Unique identifier of the peer
 
 	headerIdle  int32 // Current header activity state of the peer (idle = 0, active = 1)
 	blockIdle   int32 // Current block activity state of the peer (idle = 0, active = 1)
 	receiptIdle int32 // Current receipt activity state of the peer (idle = 0, active = 1)
 	stateIdle   int32 // Current node data activity state of the peer (idle = 0, active = 1)
+	snapIdle    int32 // Current state range activity state of the peer (idle = 0, active = 1)
 
 	headerThroughput  float64 // Number of headers measured to be retrievable per second
 	blockThroughput   float64 // Number of blocks (bodies) measured to be retrievable per second
 	receiptThroughput float64 // Number of receipts measured to be retrievable per second
 	stateThroughput   float64 // Number of node data pieces measured to be retrievable per second
+	snapThroughput    float64 // Number of state range items measured to be retrievable per second
 
 	rtt time.Duration // Request round trip time to track responsiveness (QoS)
 
@@ -65,6 +69,7 @@ type peerConnection struct {
 	blockStarted   time.Time // Time instance when the last block (body) fetch was started
 	receiphaaarted time.Time // Time instance when the last receipt fetch was started
 	stateStarted   time.Time // Time instance when the last node data fetch was started
+	snapStarted    time.Time // Time instance when the last state range fetch was started
 
 	lacking map[common.Hash]struct{} // Set of hashes not to request (didn't have previously)
 
@@ -76,18 +81,31 @@ type peerConnection struct {
 }
 
 // LightPeer encapsulates the methods required to synchronise with a remote light peer.
+//
+// Every request carries an ID assigned by the downloader, which the peer should
+// attach to the delivery of the response. Peers unable to track requests deliver
+// with an ID of 0, in which case the response is matched to the peer instead.
 type LightPeer interface {
 	Head() (common.Hash, *big.Int)
-	RequestHeadersByHash(common.Hash, int, int, bool) error
-	RequestHeadersByNumber(uint64, int, int, bool) error
+	RequestHeadersByHash(uint64, common.Hash, int, int, bool) error
+	RequestHeadersByNumber(uint64, uint64, int, int, bool) error
 }
 
 // Peer encapsulates the methods required to synchronise with a remote full peer.
 type Peer interface {
 	LightPeer
-	RequestBodies([]common.Hash) error
-	RequestReceipts([]common.Hash) error
-	RequestNodeData([]common.Hash) error
+	RequestBodies(uint64, []common.Hash) error
+	RequestReceipts(uint64, []common.Hash) error
+	RequestNodeData(uint64, []common.Hash) error
+}
+
+// SnapPeer encapsulates the methods required to retrieve contiguous ranges of the
+// state from a remote full peer. It is optional, peers not implementing it are
+// only used for node-by-node state retrieval.
+type SnapPeer interface {
+	RequestAccountRange(id uint64, root common.Hash, origin common.Hash, limit common.Hash, bytes uint64) error
+	RequestStorageRanges(id uint64, roots []common.Hash, origin common.Hash, bytes uint64) error
+	RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error
 }
 
 // lightPeerWrapper wraps a LightPeer struct, stubbing out the Peer-only methods.
@@ -96,19 +114,19 @@ type lightPeerWrapper struct {
 }
 
 func (w *lightPeerWrapper) Head() (common.Hash, *big.Int) { return w.peer.Head() }
-func (w *lightPeerWrapper) RequestHeadersByHash(h common.Hash, amount int, skip int, reverse bool) error {
-	return w.peer.RequestHeadersByHash(h, amount, skip, reverse)
+func (w *lightPeerWrapper) RequestHeadersByHash(id uint64, h common.Hash, amount int, skip int, reverse bool) error {
+	return w.peer.RequestHeadersByHash(id, h, amount, skip, reverse)
 }
-func (w *lightPeerWrapper) RequestHeadersByNumber(i uint64, amount int, skip int, reverse bool) error {
-	return w.peer.RequestHeadersByNumber(i, amount, skip, reverse)
+func (w *lightPeerWrapper) RequestHeadersByNumber(id uint64, i uint64, amount int, skip int, reverse bool) error {
+	return w.peer.RequestHeadersByNumber(id, i, amount, skip, reverse)
 }
-func (w *lightPeerWrapper) RequestBodies([]common.Hash) error {
+func (w *lightPeerWrapper) RequestBodies(uint64, []common.Hash) error {
 	panic("RequestBodies not supported in light client mode sync")
 }
-func (w *lightPeerWrapper) RequestReceipts([]common.Hash) error {
+func (w *lightPeerWrapper) RequestReceipts(uint64, []common.Hash) error {
 	panic("RequestReceipts not supported in light client mode sync")
 }
-func (w *lightPeerWrapper) RequestNodeData([]common.Hash) error {
+func (w *lightPeerWrapper) RequestNodeData(uint64, []common.Hash) error {
 	panic("RequestNodeData not supported in light client mode sync")
 }
 
@@ -134,17 +152,25 @@ func (p *peerConnection) Reset() {
 	atomic.StoreInt32(&p.blockIdle, 0)
 	atomic.StoreInt32(&p.receiptIdle, 0)
 	atomic.StoreInt32(&p.stateIdle, 0)
+	atomic.StoreInt32(&p.snapIdle, 0)
 
 	p.headerThroughput = 0
 	p.blockThroughput = 0
 	p.receiptThroughput = 0
 	p.stateThroughput = 0
+	p.snapThroughput = 0
 
 	p.lacking = make(map[common.Hash]struct{})
 }
 
+// nextRequestID assigns a new, peer unique ID to an outgoing request, allowing
+// the response to be matched to it.
+func (p *peerConnection) nextRequestID() uint64 {
+	return atomic.AddUint64(&p.reqID, 1)
+}
+
 // FetchHeaders sends a header retrieval request to the remote peer.
-func (p *peerConnection) FetchHeaders(from uint64, count int) error {
+func (p *peerConnection) FetchHeaders(request *fetchRequest, count int) error {
 	// Sanity check the protocol version
 	if p.version < 62 {
 		panic(fmt.Sprintf("header fetch [haa/62+] requested on haa/%d", p.version))
@@ -156,7 +182,7 @@ func (p *peerConnection) FetchHeaders(from uint64, count int) error {
 	p.headerStarted = time.Now()
 
 	// Issue the header retrieval request (absolut upwards without gaps)
-	go p.peer.RequestHeadersByNumber(from, count, 0, false)
+	go p.peer.RequestHeadersByNumber(request.ID, request.From, count, 0, false)
 
 	return nil
 }
@@ -178,7 +204,7 @@ func (p *peerConnection) FetchBodies(request *fetchRequest) error {
 	for _, header := range request.Headers {
 		hashes = append(hashes, header.Hash())
 	}
-	go p.peer.RequestBodies(hashes)
+	go p.peer.RequestBodies(request.ID, hashes)
 
 	return nil
 }
@@ -200,13 +226,13 @@ func (p *peerConnection) FetchReceipts(request *fetchRequest) error {
 	for _, header := range request.Headers {
 		hashes = append(hashes, header.Hash())
 	}
-	go p.peer.RequestReceipts(hashes)
+	go p.peer.RequestReceipts(request.ID, hashes)
 
 	return nil
 }
 
 // FetchNodeData sends a node state data retrieval request to the remote peer.
-func (p *peerConnection) FetchNodeData(hashes []common.Hash) error {
+func (p *peerConnection) FetchNodeData(id uint64, hashes []common.Hash) error {
 	// Sanity check the protocol version
 	if p.version < 63 {
 		panic(fmt.Sprintf("node data fetch [haa/63+] requested on haa/%d", p.version))
@@ -217,7 +243,58 @@ func (p *peerConnection) FetchNodeData(hashes []common.Hash) error {
 	}
 	p.stateStarted = time.Now()
 
-	go p.peer.RequestNodeData(hashes)
+	go p.peer.RequestNodeData(id, hashes)
+
+	return nil
+}
+
+// FetchAccountRange sends an account range retrieval request to the remote peer.
+func (p *peerConnection) FetchAccountRange(id uint64, root common.Hash, origin common.Hash, limit common.Hash, bytes uint64) error {
+	// Sanity check the protocol version
+	if p.version < 64 {
+		panic(fmt.Sprintf("account range fetch [haa/64+] requested on haa/%d", p.version))
+	}
+	// Short circuit if the peer is already fetching
+	if !atomic.CompareAndSwapInt32(&p.snapIdle, 0, 1) {
+		return errAlreadyFetching
+	}
+	p.snapStarted = time.Now()
+
+	go p.peer.(SnapPeer).RequestAccountRange(id, root, origin, limit, bytes)
+
+	return nil
+}
+
+// FetchStorageRanges sends a storage ranges retrieval request to the remote peer.
+func (p *peerConnection) FetchStorageRanges(id uint64, roots []common.Hash, origin common.Hash, bytes uint64) error {
+	// Sanity check the protocol version
+	if p.version < 64 {
+		panic(fmt.Sprintf("storage range fetch [haa/64+] requested on haa/%d", p.version))
+	}
+	// Short circuit if the peer is already fetching
+	if !atomic.CompareAndSwapInt32(&p.snapIdle, 0, 1) {
+		return errAlreadyFetching
+	}
+	p.snapStarted = time.Now()
+
+	go p.peer.(SnapPeer).RequestStorageRanges(id, roots, origin, bytes)
+
+	return nil
+}
+
+// FetchByteCodes sends a contract code retrieval request to the remote peer.
+func (p *peerConnection) FetchByteCodes(id uint64, hashes []common.Hash, bytes uint64) error {
+	// Sanity check the protocol version
+	if p.version < 64 {
+		panic(fmt.Sprintf("bytecode fetch [haa/64+] requested on haa/%d", p.version))
+	}
+	// Short circuit if the peer is already fetching
+	if !atomic.CompareAndSwapInt32(&p.snapIdle, 0, 1) {
+		return errAlreadyFetching
+	}
+	p.snapStarted = time.Now()
+
+	go p.peer.(SnapPeer).RequestByteCodes(id, hashes, bytes)
 
 	return nil
 }
@@ -257,6 +334,13 @@ func (p *peerConnection) SetNodeDataIdle(delivered int) {
 	p.setIdle(p.stateStarted, delivered, &p.stateThroughput, &p.stateIdle)
 }
 
+// SetSnapIdle sets the peer to idle, allowing it to execute new state range
+// retrieval requests. Its estimated range retrieval throughput is updated with
+// that measured just now.
+func (p *peerConnection) SetSnapIdle(delivered int) {
+	p.setIdle(p.snapStarted, delivered, &p.snapThroughput, &p.snapIdle)
+}
+
 // setIdle sets the peer to idle, allowing it to execute new retrieval requests.
 // Its estimated retrieval throughput is updated with that measured just now.
 func (p *peerConnection) setIdle(started time.Time, delivered int, throughput *float64, idle *int32) {
@@ -280,7 +364,7 @@ func (p *peerConnection) setIdle(started time.Time, delivered int, throughput *f
 
 	p.log.Trace("Peer throughput measurements updated",
 		"hps", p.headerThroughput, "bps", p.blockThroughput,
-		"rps", p.receiptThroughput, "sps", p.stateThroughput,
+		"rps", p.receiptThroughput, "sps", p.stateThroughput, "snps", p.snapThroughput,
 		"miss", len(p.lacking), "rtt", p.rtt)
 }
 
@@ -400,7 +484,7 @@ func (ps *peerSet) Register(p *peerConnection) error {
 		return errAlreadyRegistered
 	}
 	if len(ps.peers) > 0 {
-		p.headerThroughput, p.blockThroughput, p.receiptThroughput, p.stateThroughput = 0, 0, 0, 0
+		p.headerThroughput, p.blockThroughput, p.receiptThroughput, p.stateThroughput, p.snapThroughput = 0, 0, 0, 0, 0
 
 		for _, peer := range ps.peers {
 			peer.lock.RLock()
@@ -408,12 +492,14 @@ func (ps *peerSet) Register(p *peerConnection) error {
 			p.blockThroughput += peer.blockThroughput
 			p.receiptThroughput += peer.receiptThroughput
 			p.stateThroughput += peer.stateThroughput
+			p.snapThroughput += peer.snapThroughput
 			peer.lock.RUnlock()
 		}
 		p.headerThroughput /= float64(len(ps.peers))
 		p.blockThroughput /= float64(len(ps.peers))
 		p.receiptThroughput /= float64(len(ps.peers))
 		p.stateThroughput /= float64(len(ps.peers))
+		p.snapThroughput /= float64(len(ps.peers))
 	}
 	ps.peers[p.id] = p
 	ps.lock.Unlock()
@@ -522,6 +608,24 @@ func (ps *peerSet) NodeDataIdlePeers() ([]*peerConnection, int) {
 	return ps.idlePeers(63, 64, idle, throughput)
 }
 
+// SnapIdlePeers retrieves a flat list of all the currently state-range-idle peers
+// within the active peer set, ordered by their reputation. Only peers capable of
+// serving state ranges are considered.
+func (ps *peerSet) SnapIdlePeers() ([]*peerConnection, int) {
+	idle := func(p *peerConnection) bool {
+		if _, ok := p.peer.(SnapPeer); !ok {
+			return false
+		}
+		return atomic.LoadInt32(&p.snapIdle) == 0
+	}
+	throughput := func(p *peerConnection) float64 {
+		p.lock.RLock()
+		defer p.lock.RUnlock()
+		return p.snapThroughput
+	}
+	return ps.idlePeers(64, 64, idle, throughput)
+}
+
 // idlePeers retrieves a flat list of all currently idle peers satisfying the
 // protocol version constraints, using the provided function to check idleness.
 // The resulting set of peers are sorted by their measure throughput.
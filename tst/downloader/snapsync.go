@@ -0,0 +1,654 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/state"
+	"github.com/haachain/go-haachain/core/types"
+	"github.com/haachain/go-haachain/crypto"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/log"
+	"github.com/haachain/go-haachain/rlp"
+	"github.com/haachain/go-haachain/trie"
+)
+
+const (
+	snapAccountChunks = 16         // Number of chunks to split the account trie into for concurrent retrieval
+	snapRequestBytes  = 512 * 1024 // Soft limit of the response size requested from remote peers
+	snapMaxStorage    = 128        // Maximum number of storage tries to request in one batch
+	snapMaxCodes      = 64         // Maximum number of contract codes to request in one batch
+)
+
+// emptyCodeHash is the known hash of the empty contract code.
+var emptyCodeHash = crypto.Keccak256Hash(nil)
+
+// snapReq represents a state range retrieval request, either for a range of
+// accounts, a batch of storage tries or a batch of contract codes.
+type snapReq struct {
+	id       uint64          // Request ID to match the response with
+	root     common.Hash     // State root the request was issued against
+	account  *accountTask    // Account range task to fill (nil if not an account request)
+	storage  []*storageTask  // Storage trie tasks to fill (nil if not a storage request)
+	codes    []common.Hash   // Contract code hashes to fetch (nil if not a code request)
+	timeout  time.Duration   // Maximum round trip time for this to complete
+	timer    *time.Timer     // Timer to fire when the RTT timeout expires
+	peer     *peerConnection // Peer that we're requesting from
+	response dataPack        // Response data of the peer (nil for timeouts)
+	dropped  bool            // Flag whhaaer the peer dropped off early
+}
+
+// timedOut returns if this request timed out.
+func (req *snapReq) timedOut() bool {
+	return req.response == nil
+}
+
+// accountTask is a chunk of the account trie to retrieve via consecutive ranges.
+type accountTask struct {
+	next common.Hash // Hash of the next account to retrieve
+	last common.Hash // Hash of the last account belonging to this chunk
+	done bool        // Flag whhaaer all accounts of the chunk were retrieved
+	req  *snapReq    // Pending request to fill this task (nil if idle)
+}
+
+// storageTask is a storage trie to retrieve, possibly in multiple ranges if it's
+// too large to fit into a single response.
+type storageTask struct {
+	root    common.Hash     // Root hash of the storage trie
+	next    common.Hash     // Hash of the next storage slot to retrieve
+	trie    *trie.Trie      // Storage trie being reconstructed from the ranges
+	waiters []*accountBatch // Account batches waiting for this trie to complete
+	req     *snapReq        // Pending request to fill this task (nil if idle)
+
+	bytesUncommitted int // Amount of slot data not yet flushed to disk
+}
+
+// codeTask is a contract code to retrieve.
+type codeTask struct {
+	waiters []*accountBatch // Account batches waiting for this code to arrive
+	req     *snapReq        // Pending request to fill this task (nil if idle)
+}
+
+// accountBatch is a set of verified accounts waiting for their storage tries and
+// contract codes to be retrieved before they may be inserted into the account
+// trie. Inserting them earlier would allow the healing phase to skip over them
+// (finding the account trie nodes on disk) while their content is still missing.
+type accountBatch struct {
+	hashes  []common.Hash // Account hashes (trie keys) of the batch
+	bodies  [][]byte      // RLP encoded accounts of the batch
+	pending int           // Number of storage tries and codes still missing
+}
+
+// snapSync is the scheduler retrieving the state as contiguous ranges of accounts
+// and storage slots. The retrieved ranges are verified against merkle proofs of
+// their boundaries and assembled into tries locally. Since the pivot block might
+// move during sync, the assembled tries may end up a patchwork of multiple state
+// roots, which is fixed up afterwards by healing via the node-by-node sync.
+type snapSync struct {
+	d *Downloader // Downloader instance to access and manage current peerset
+
+	root      common.Hash         // State root currently being synced
+	stateless map[string]struct{} // Peers not having the current state root
+
+	triedb  *trie.Database // Trie database to assemble the state tries into
+	accTrie *trie.Trie     // Account trie being assembled from the ranges
+	batch   haadb.Batch    // Batch to accumulate retrieved contract codes into
+
+	accountTasks []*accountTask               // Chunks of the account trie to retrieve
+	storageTasks map[common.Hash]*storageTask // Storage tries to retrieve
+	codeTasks    map[common.Hash]*codeTask    // Contract codes to retrieve
+	requests     map[*snapReq]struct{}        // Requests currently in flight
+
+	accounts uint64 // Number of accounts retrieved
+	slots    uint64 // Number of storage slots retrieved
+	codes    uint64 // Number of contract codes retrieved
+
+	bytesUncommitted int // Amount of account data not yet flushed to disk
+}
+
+// newSnapSync creates a new state range scheduler, splitting the account trie
+// into evenly sized chunks to retrieve concurrently.
+func newSnapSync(d *Downloader) *snapSync {
+	triedb := trie.NewDatabase(d.stateDB)
+	accTrie, _ := trie.New(common.Hash{}, triedb)
+
+	s := &snapSync{
+		d:            d,
+		stateless:    make(map[string]struct{}),
+		triedb:       triedb,
+		accTrie:      accTrie,
+		batch:        d.stateDB.NewBatch(),
+		storageTasks: make(map[common.Hash]*storageTask),
+		codeTasks:    make(map[common.Hash]*codeTask),
+		requests:     make(map[*snapReq]struct{}),
+	}
+	var (
+		next = new(big.Int)
+		step = new(big.Int).Div(new(big.Int).Lsh(common.Big1, 256), big.NewInt(snapAccountChunks))
+	)
+	for i := 0; i < snapAccountChunks; i++ {
+		last := new(big.Int).Sub(new(big.Int).Add(next, step), common.Big1)
+		if i == snapAccountChunks-1 {
+			last = new(big.Int).Sub(new(big.Int).Lsh(common.Big1, 256), common.Big1)
+		}
+		s.accountTasks = append(s.accountTasks, &accountTask{
+			next: common.BigToHash(next),
+			last: common.BigToHash(last),
+		})
+		next = new(big.Int).Add(last, common.Big1)
+	}
+	return s
+}
+
+// setRoot switches the scheduler over to a new state root. Already retrieved
+// ranges are retained, the remaining ones will be retrieved from the new root.
+func (s *snapSync) setRoot(root common.Hash) {
+	if s.root != root {
+		s.root = root
+		s.stateless = make(map[string]struct{})
+	}
+}
+
+// done returns whhaaer all the state ranges were retrieved.
+func (s *snapSync) done() bool {
+	for _, task := range s.accountTasks {
+		if !task.done {
+			return false
+		}
+	}
+	return len(s.storageTasks) == 0 && len(s.codeTasks) == 0
+}
+
+// servable returns whhaaer there are any connected peers that might be able to
+// serve state ranges of the current root.
+func (s *snapSync) servable() bool {
+	for _, p := range s.d.peers.AllPeers() {
+		if _, ok := p.peer.(SnapPeer); !ok || p.version < 64 {
+			continue
+		}
+		if _, ok := s.stateless[p.id]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// snapLoop is the main event loop of the state range retrieval, assigning tasks
+// to idle peers and processing their responses until all ranges are retrieved.
+// If no peers are able to serve ranges of the current root, the loop returns
+// early, leaving the rest of the state to the healing phase.
+func (s *stateSync) snapLoop() error {
+	// Listen for new peer events to assign tasks to them
+	newPeer := make(chan *peerConnection, 1024)
+	peerSub := s.d.peers.SubscribeNewPeers(newPeer)
+	defer peerSub.Unsubscribe()
+
+	// Retrieve from the current root and release any tasks left in-flight on exit
+	s.snap.setRoot(s.root)
+	defer func() {
+		for req := range s.snap.requests {
+			s.snap.revert(req)
+		}
+	}()
+	for !s.snap.done() {
+		if err := s.snap.commit(false); err != nil {
+			return err
+		}
+		s.assignSnapTasks()
+
+		if len(s.snap.requests) == 0 && !s.snap.servable() {
+			log.Warn("No peers to retrieve state ranges from, healing", "root", s.root)
+			return s.snap.commit(true)
+		}
+		// Tasks assigned, wait for somhaaing to happen
+		select {
+		case <-newPeer:
+			// New peer arrived, try to assign it download tasks
+
+		case <-s.cancel:
+			return errCancelStateFetch
+
+		case <-s.d.cancelCh:
+			return errCancelStateFetch
+
+		case req := <-s.snapDeliver:
+			// Response, disconnect or timeout triggered, process whatever arrived
+			log.Trace("Received state range response", "peer", req.peer.id, "dropped", req.dropped, "timeout", !req.dropped && req.timedOut())
+
+			delivered := 0
+			if req.response != nil {
+				delivered = req.response.Items()
+			}
+			if err := s.snap.process(req); err != nil {
+				log.Warn("State range write error", "err", err)
+				return err
+			}
+			req.peer.SetSnapIdle(delivered)
+		}
+	}
+	return s.snap.commit(true)
+}
+
+// assignSnapTasks attempts to assign new state range retrieval tasks to all the
+// idle peers that might have the current state root.
+func (s *stateSync) assignSnapTasks() {
+	peers, _ := s.d.peers.SnapIdlePeers()
+	for _, p := range peers {
+		if _, ok := s.snap.stateless[p.id]; ok {
+			continue
+		}
+		req := s.snap.fillTasks(p, s.d.requestTTL())
+		if req == nil {
+			return
+		}
+		select {
+		case s.d.trackSnapReq <- req:
+			switch {
+			case req.account != nil:
+				req.peer.log.Trace("Requesting range of accounts", "origin", req.account.next, "limit", req.account.last)
+				req.peer.FetchAccountRange(req.id, req.root, req.account.next, req.account.last, snapRequestBytes)
+
+			case len(req.storage) > 0:
+				roots := make([]common.Hash, len(req.storage))
+				for i, task := range req.storage {
+					roots[i] = task.root
+				}
+				req.peer.log.Trace("Requesting ranges of storage slots", "count", len(roots), "origin", req.storage[0].next)
+				req.peer.FetchStorageRanges(req.id, roots, req.storage[0].next, snapRequestBytes)
+
+			default:
+				req.peer.log.Trace("Requesting batch of bytecodes", "count", len(req.codes))
+				req.peer.FetchByteCodes(req.id, req.codes, snapRequestBytes)
+			}
+		case <-s.cancel:
+		case <-s.d.cancelCh:
+		}
+	}
+}
+
+// fillTasks assembles a new retrieval request for the given peer. Contract codes
+// and storage tries are preferred over new account ranges to keep the number of
+// accounts waiting for them low. Nil is returned if there's nothing to retrieve.
+func (s *snapSync) fillTasks(p *peerConnection, timeout time.Duration) *snapReq {
+	req := &snapReq{id: p.nextRequestID(), root: s.root, peer: p, timeout: timeout}
+
+	for hash, task := range s.codeTasks {
+		if len(req.codes) >= snapMaxCodes {
+			break
+		}
+		if task.req == nil {
+			task.req = req
+			req.codes = append(req.codes, hash)
+		}
+	}
+	if len(req.codes) > 0 {
+		s.requests[req] = struct{}{}
+		return req
+	}
+	for _, task := range s.storageTasks {
+		if len(req.storage) >= snapMaxStorage {
+			break
+		}
+		if task.req != nil {
+			continue
+		}
+		// The origin only applies to the first storage trie, so a partially
+		// retrieved trie needs to be requested on its own
+		if task.next != (common.Hash{}) {
+			if len(req.storage) > 0 {
+				continue
+			}
+			task.req = req
+			req.storage = append(req.storage, task)
+			break
+		}
+		task.req = req
+		req.storage = append(req.storage, task)
+	}
+	if len(req.storage) > 0 {
+		s.requests[req] = struct{}{}
+		return req
+	}
+	for _, task := range s.accountTasks {
+		if task.done || task.req != nil {
+			continue
+		}
+		task.req = req
+		req.account = task
+
+		s.requests[req] = struct{}{}
+		return req
+	}
+	return nil
+}
+
+// revert releases all the tasks assigned to a request, making them available for
+// retrieval from other peers.
+func (s *snapSync) revert(req *snapReq) {
+	delete(s.requests, req)
+
+	if req.account != nil && req.account.req == req {
+		req.account.req = nil
+	}
+	for _, task := range req.storage {
+		if task.req == req {
+			task.req = nil
+		}
+	}
+	for _, hash := range req.codes {
+		if task := s.codeTasks[hash]; task != nil && task.req == req {
+			task.req = nil
+		}
+	}
+}
+
+// process injects the response of a state range request into the scheduler,
+// releasing any tasks not fulfilled. Invalid responses result in the peer being
+// dropped, an error is only returned if the database failed.
+func (s *snapSync) process(req *snapReq) error {
+	defer s.revert(req)
+
+	if req.response == nil {
+		return nil
+	}
+	switch pack := req.response.(type) {
+	case *accountRangePack:
+		if req.account != nil {
+			return s.processAccounts(req, pack)
+		}
+	case *storageRangesPack:
+		if len(req.storage) > 0 {
+			return s.processStorage(req, pack)
+		}
+	case *byteCodesPack:
+		if len(req.codes) > 0 {
+			return s.processCodes(req, pack)
+		}
+	}
+	log.Debug("Mismatching state range response", "peer", req.peer.id, "type", fmt.Sprintf("%T", req.response))
+	s.d.dropPeer(req.peer.id)
+	return nil
+}
+
+// processAccounts verifies a range of accounts against the boundary proofs and
+// schedules the retrieval of their storage tries and contract codes.
+func (s *snapSync) processAccounts(req *snapReq, pack *accountRangePack) error {
+	task := req.account
+
+	// An empty response without proofs means the peer doesn't have the state
+	if len(pack.hashes) == 0 && len(pack.proof) == 0 {
+		log.Debug("Peer doesn't have requested state", "peer", req.peer.id, "root", req.root)
+		s.stateless[req.peer.id] = struct{}{}
+		return nil
+	}
+	keys := make([][]byte, len(pack.hashes))
+	for i, hash := range pack.hashes {
+		keys[i] = common.CopyBytes(hash[:])
+	}
+	var last []byte
+	if len(keys) > 0 {
+		last = keys[len(keys)-1]
+	}
+	cont, err := trie.VerifyRangeProof(req.root, task.next[:], last, keys, pack.accounts, proofDb(pack.proof))
+	if err != nil {
+		log.Warn("Invalid account range, dropping peer", "peer", req.peer.id, "err", err)
+		s.d.dropPeer(req.peer.id)
+		return nil
+	}
+	// Range valid, schedule the storage and code of all accounts belonging to this
+	// chunk (the peer might deliver beyond the chunk limit)
+	batch := new(accountBatch)
+	for i, hash := range pack.hashes {
+		if bytes.Compare(hash[:], task.last[:]) > 0 {
+			cont = false
+			break
+		}
+		var account state.Account
+		if err := rlp.DecodeBytes(pack.accounts[i], &account); err != nil {
+			log.Warn("Invalid account in range, dropping peer", "peer", req.peer.id, "err", err)
+			s.d.dropPeer(req.peer.id)
+			return nil
+		}
+		batch.hashes = append(batch.hashes, hash)
+		batch.bodies = append(batch.bodies, pack.accounts[i])
+
+		if account.Root != types.EmptyRootHash {
+			if ok, _ := s.d.stateDB.Has(account.Root[:]); !ok {
+				storage := s.storageTasks[account.Root]
+				if storage == nil {
+					storage = &storageTask{root: account.Root}
+					s.storageTasks[account.Root] = storage
+				}
+				storage.waiters = append(storage.waiters, batch)
+				batch.pending++
+			}
+		}
+		if hash := common.BytesToHash(account.CodeHash); hash != emptyCodeHash {
+			if ok, _ := s.d.stateDB.Has(hash[:]); !ok {
+				code := s.codeTasks[hash]
+				if code == nil {
+					code = new(codeTask)
+					s.codeTasks[hash] = code
+				}
+				code.waiters = append(code.waiters, batch)
+				batch.pending++
+			}
+		}
+	}
+	s.accounts += uint64(len(batch.hashes))
+	if batch.pending == 0 {
+		if err := s.insertAccounts(batch); err != nil {
+			return err
+		}
+	}
+	// Move the chunk forward, or mark it done if no more accounts are left in it
+	if !cont || len(batch.hashes) == 0 {
+		task.done = true
+		return nil
+	}
+	next := new(big.Int).Add(batch.hashes[len(batch.hashes)-1].Big(), common.Big1)
+	if next.Cmp(task.last.Big()) > 0 {
+		task.done = true
+		return nil
+	}
+	task.next = common.BigToHash(next)
+	return nil
+}
+
+// processStorage verifies a batch of storage ranges and assembles them into
+// their storage tries, flushing all the completed ones to disk.
+func (s *snapSync) processStorage(req *snapReq, pack *storageRangesPack) error {
+	// An empty response means the peer doesn't have the state
+	if len(pack.slots) == 0 {
+		log.Debug("Peer doesn't have requested storage", "peer", req.peer.id, "root", req.root)
+		s.stateless[req.peer.id] = struct{}{}
+		return nil
+	}
+	if len(pack.slots) > len(req.storage) || len(pack.hashes) != len(pack.slots) {
+		log.Warn("Invalid storage ranges, dropping peer", "peer", req.peer.id, "requested", len(req.storage), "delivered", len(pack.slots))
+		s.d.dropPeer(req.peer.id)
+		return nil
+	}
+	for i, hashes := range pack.hashes {
+		task := req.storage[i]
+
+		keys := make([][]byte, len(hashes))
+		for j, hash := range hashes {
+			keys[j] = common.CopyBytes(hash[:])
+		}
+		// Only the last range may be partial, all others must be the entire trie
+		var (
+			cont bool
+			err  error
+		)
+		if i == len(pack.hashes)-1 && len(pack.proof) > 0 {
+			var last []byte
+			if len(keys) > 0 {
+				last = keys[len(keys)-1]
+			}
+			cont, err = trie.VerifyRangeProof(task.root, task.next[:], last, keys, pack.slots[i], proofDb(pack.proof))
+		} else {
+			cont, err = trie.VerifyRangeProof(task.root, nil, nil, keys, pack.slots[i], nil)
+		}
+		if err != nil {
+			log.Warn("Invalid storage range, dropping peer", "peer", req.peer.id, "err", err)
+			s.d.dropPeer(req.peer.id)
+			return nil
+		}
+		// Range valid, assemble it into the storage trie
+		if task.trie == nil {
+			task.trie, _ = trie.New(common.Hash{}, s.triedb)
+		}
+		for j, key := range keys {
+			if err := task.trie.TryUpdate(key, pack.slots[i][j]); err != nil {
+				return err
+			}
+			task.bytesUncommitted += len(key) + len(pack.slots[i][j])
+		}
+		s.slots += uint64(len(keys))
+
+		if cont {
+			task.next = common.BigToHash(new(big.Int).Add(hashes[len(hashes)-1].Big(), common.Big1))
+
+			// Large storage tries may span many ranges, so flush the completed
+			// subtries to disk instead of accumulating them all in memory
+			if task.bytesUncommitted >= haadb.IdealBatchSize {
+				if err := s.commitStorage(task); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		// Storage trie complete, flush it to disk and release the waiting accounts
+		if err := s.commitStorage(task); err != nil {
+			return err
+		}
+		delete(s.storageTasks, task.root)
+		if err := s.release(task.waiters); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitStorage flushes the assembled part of a storage trie to disk. Nodes not
+// touched since the previous flush are unloaded from memory, and as slots arrive
+// in order, only the path to the last inserted slot stays resident.
+func (s *snapSync) commitStorage(task *storageTask) error {
+	root, err := task.trie.Commit(nil)
+	if err != nil {
+		return err
+	}
+	if err := s.triedb.Commit(root, false); err != nil {
+		return fmt.Errorf("DB write error: %v", err)
+	}
+	task.bytesUncommitted = 0
+	return nil
+}
+
+// processCodes verifies a batch of contract codes and queues them up for writing
+// into the database.
+func (s *snapSync) processCodes(req *snapReq, pack *byteCodesPack) error {
+	// An empty response means the peer doesn't have the codes
+	if len(pack.codes) == 0 {
+		log.Debug("Peer doesn't have requested codes", "peer", req.peer.id)
+		s.stateless[req.peer.id] = struct{}{}
+		return nil
+	}
+	for _, code := range pack.codes {
+		hash := crypto.Keccak256Hash(code)
+
+		task := s.codeTasks[hash]
+		if task == nil {
+			continue
+		}
+		s.batch.Put(hash[:], code)
+		s.bytesUncommitted += len(code)
+		s.codes++
+
+		delete(s.codeTasks, hash)
+		if err := s.release(task.waiters); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// release notifies a set of account batches that one of their dependencies was
+// retrieved, inserting them into the account trie if nothing else is missing.
+func (s *snapSync) release(batches []*accountBatch) error {
+	for _, batch := range batches {
+		if batch.pending--; batch.pending == 0 {
+			if err := s.insertAccounts(batch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// insertAccounts inserts a batch of complete accounts into the account trie.
+func (s *snapSync) insertAccounts(batch *accountBatch) error {
+	for i, hash := range batch.hashes {
+		if err := s.accTrie.TryUpdate(hash[:], batch.bodies[i]); err != nil {
+			return err
+		}
+		s.bytesUncommitted += common.HashLength + len(batch.bodies[i])
+	}
+	return nil
+}
+
+// commit flushes the retrieved contract codes and the assembled account trie to
+// disk if enough data accumulated (or if forced). Codes are written first, since
+// any accounts in the trie may only reference data already on disk.
+func (s *snapSync) commit(force bool) error {
+	if !force && s.bytesUncommitted < haadb.IdealBatchSize {
+		return nil
+	}
+	start := time.Now()
+	if err := s.batch.Write(); err != nil {
+		return fmt.Errorf("DB write error: %v", err)
+	}
+	s.batch.Reset()
+
+	root, err := s.accTrie.Commit(nil)
+	if err != nil {
+		return err
+	}
+	if err := s.triedb.Commit(root, false); err != nil {
+		return fmt.Errorf("DB write error: %v", err)
+	}
+	s.bytesUncommitted = 0
+
+	log.Info("Imported new state ranges", "accounts", s.accounts, "slots", s.slots, "codes", s.codes, "storage", len(s.storageTasks), "elapsed", common.PrettyDuration(time.Since(start)))
+	return nil
+}
+
+// proofDb converts a list of merkle proof nodes into a database keyed by the
+// hashes of the nodes.
+func proofDb(proof [][]byte) *haadb.MemDatabase {
+	db, _ := haadb.NewMemDatabase()
+	for _, node := range proof {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	return db
+}
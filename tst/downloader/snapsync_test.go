@@ -0,0 +1,116 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package downloader
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/crypto"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/trie"
+)
+
+// Tests that a storage trie retrieved in multiple ranges is flushed to disk as
+// the ranges arrive, instead of being held in memory until it's complete.
+func TestSnapStorageFlush(t *testing.T) {
+	// Create a storage trie large enough to need multiple flushes
+	srcdb, _ := haadb.NewMemDatabase()
+	src, _ := trie.New(common.Hash{}, trie.NewDatabase(srcdb))
+	for i := uint64(0); i < 4096; i++ {
+		var value [32]byte
+		binary.BigEndian.PutUint64(value[24:], i+1)
+		src.Update(crypto.Keccak256(value[:]), value[:])
+	}
+	root, _ := src.Commit(nil)
+
+	var (
+		keys [][]byte
+		vals [][]byte
+	)
+	for it := trie.NewIterator(src.NodeIterator(nil)); it.Next(); {
+		keys, vals = append(keys, common.CopyBytes(it.Key)), append(vals, common.CopyBytes(it.Value))
+	}
+	// Deliver the storage trie in ranges of 512 slots
+	db, _ := haadb.NewMemDatabase()
+	s := newSnapSync(&Downloader{stateDB: db})
+
+	task := &storageTask{root: root}
+	s.storageTasks[root] = task
+
+	flushes := 0
+	for start := 0; start < len(keys); start += 512 {
+		pack := &storageRangesPack{hashes: make([][]common.Hash, 1), slots: [][][]byte{vals[start : start+512]}}
+		for _, key := range keys[start : start+512] {
+			pack.hashes[0] = append(pack.hashes[0], common.BytesToHash(key))
+		}
+		proof, _ := haadb.NewMemDatabase()
+		src.Prove(task.next[:], 0, proof)
+		src.Prove(keys[start+511], 0, proof)
+		for _, key := range proof.Keys() {
+			node, _ := proof.Get(key)
+			pack.proof = append(pack.proof, node)
+		}
+		if err := s.processStorage(&snapReq{storage: []*storageTask{task}}, pack); err != nil {
+			t.Fatalf("range %d: failed to process storage: %v", start/512, err)
+		}
+		if start+512 == len(keys) {
+			break
+		}
+		// Anything flushed must be a complete trie on disk, holding everything
+		// retrieved so far unless data is still pending
+		if task.bytesUncommitted >= haadb.IdealBatchSize {
+			t.Fatalf("range %d: %d bytes left unflushed", start/512, task.bytesUncommitted)
+		}
+		if task.bytesUncommitted > 0 {
+			continue
+		}
+		partial, err := trie.New(task.trie.Hash(), trie.NewDatabase(db))
+		if err != nil {
+			t.Fatalf("range %d: flushed trie missing: %v", start/512, err)
+		}
+		slots := 0
+		it := trie.NewIterator(partial.NodeIterator(nil))
+		for it.Next() {
+			slots++
+		}
+		if it.Err != nil {
+			t.Fatalf("range %d: flushed trie incomplete: %v", start/512, it.Err)
+		}
+		if slots != start+512 {
+			t.Fatalf("range %d: flushed slot count mismatch: have %d, want %d", start/512, slots, start+512)
+		}
+		flushes++
+	}
+	if flushes == 0 {
+		t.Fatalf("partial storage trie never flushed")
+	}
+	if _, ok := s.storageTasks[root]; ok {
+		t.Fatalf("completed storage task still pending")
+	}
+	synced, err := trie.New(root, trie.NewDatabase(db))
+	if err != nil {
+		t.Fatalf("synced trie missing: %v", err)
+	}
+	it := trie.NewIterator(synced.NodeIterator(nil))
+	for it.Next() {
+	}
+	if it.Err != nil {
+		t.Fatalf("synced trie incomplete: %v", it.Err)
+	}
+}
@@ -34,6 +34,7 @@ import (
 // stateReq represents a batch of state fetch requests groupped toghaaer into
 // a single data retrieval network packet.
 type stateReq struct {
+	id       uint64                     // Request ID to match the response with
 	items    []common.Hash              // Hashes of the state items to download
 	tasks    map[common.Hash]*stateTask // Download tasks to track previous attempts
 	timeout  time.Duration              // Maximum round trip time for this to complete
@@ -80,6 +81,8 @@ func (d *Downloader) stateFetcher() {
 			}
 		case <-d.stateCh:
 			// Ignore state responses while no sync is running.
+		case <-d.snapCh:
+			// Ignore state range responses while no sync is running.
 		case <-d.quitCh:
 			return
 		}
@@ -93,6 +96,10 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 		active   = make(map[string]*stateReq) // Currently in-flight requests
 		finished []*stateReq                  // Completed or failed requests
 		timeout  = make(chan *stateReq)       // Timed out active requests
+
+		snapActive   = make(map[string]*snapReq) // Currently in-flight state range requests
+		snapFinished []*snapReq                  // Completed or failed state range requests
+		snapTimeout  = make(chan *snapReq)       // Timed out active state range requests
 	)
 	defer func() {
 		// Cancel active request timers on exit. Also set peers to idle so they're
@@ -101,6 +108,10 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 			req.timer.Stop()
 			req.peer.SetNodeDataIdle(len(req.items))
 		}
+		for _, req := range snapActive {
+			req.timer.Stop()
+			req.peer.SetSnapIdle(0)
+		}
 	}()
 	// Run the state sync.
 	go s.run()
@@ -116,11 +127,18 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 		var (
 			deliverReq   *stateReq
 			deliverReqCh chan *stateReq
+
+			deliverSnapReq   *snapReq
+			deliverSnapReqCh chan *snapReq
 		)
 		if len(finished) > 0 {
 			deliverReq = finished[0]
 			deliverReqCh = s.deliver
 		}
+		if len(snapFinished) > 0 {
+			deliverSnapReq = snapFinished[0]
+			deliverSnapReqCh = s.snapDeliver
+		}
 
 		select {
 		// The stateSync lifecycle:
@@ -137,12 +155,18 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 			finished[len(finished)-1] = nil
 			finished = finished[:len(finished)-1]
 
+		case deliverSnapReqCh <- deliverSnapReq:
+			// Shift out the first request, but also set the emptied slot to nil for GC
+			copy(snapFinished, snapFinished[1:])
+			snapFinished[len(snapFinished)-1] = nil
+			snapFinished = snapFinished[:len(snapFinished)-1]
+
 		// Handle incoming state packs:
 		case pack := <-d.stateCh:
 			// Discard any data not requested (or previsouly timed out)
 			req := active[pack.PeerId()]
-			if req == nil {
-				log.Debug("Unrequested node data", "peer", pack.PeerId(), "len", pack.Items())
+			if req == nil || (pack.RequestId() != 0 && pack.RequestId() != req.id) {
+				log.Debug("Unrequested node data", "peer", pack.PeerId(), "id", pack.RequestId(), "len", pack.Items())
 				continue
 			}
 			// Finalize the request and queue up for processing
@@ -152,19 +176,38 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 			finished = append(finished, req)
 			delete(active, pack.PeerId())
 
-			// Handle dropped peer connections:
-		case p := <-peerDrop:
-			// Skip if no request is currently pending
-			req := active[p.id]
-			if req == nil {
+		// Handle incoming state range packs:
+		case pack := <-d.snapCh:
+			// Discard any data not requested (or previsouly timed out)
+			req := snapActive[pack.PeerId()]
+			if req == nil || (pack.RequestId() != 0 && pack.RequestId() != req.id) {
+				log.Debug("Unrequested state range", "peer", pack.PeerId(), "id", pack.RequestId(), "len", pack.Items())
 				continue
 			}
 			// Finalize the request and queue up for processing
 			req.timer.Stop()
-			req.dropped = true
+			req.response = pack
 
-			finished = append(finished, req)
-			delete(active, p.id)
+			snapFinished = append(snapFinished, req)
+			delete(snapActive, pack.PeerId())
+
+			// Handle dropped peer connections:
+		case p := <-peerDrop:
+			// Finalize any pending requests and queue up for processing
+			if req := active[p.id]; req != nil {
+				req.timer.Stop()
+				req.dropped = true
+
+				finished = append(finished, req)
+				delete(active, p.id)
+			}
+			if req := snapActive[p.id]; req != nil {
+				req.timer.Stop()
+				req.dropped = true
+
+				snapFinished = append(snapFinished, req)
+				delete(snapActive, p.id)
+			}
 
 		// Handle timed-out requests:
 		case req := <-timeout:
@@ -205,6 +248,37 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 				}
 			})
 			active[req.peer.id] = req
+
+		// Handle timed-out state range requests:
+		case req := <-snapTimeout:
+			// Ignore stale timeouts, same as for node data requests
+			if snapActive[req.peer.id] != req {
+				continue
+			}
+			// Move the timed out ranges back into the download queue
+			snapFinished = append(snapFinished, req)
+			delete(snapActive, req.peer.id)
+
+		// Track outgoing state range requests:
+		case req := <-d.trackSnapReq:
+			// Same as for node data requests, never silently overwrite a request
+			// assigned to a peer that reconnected in the meantime
+			if old := snapActive[req.peer.id]; old != nil {
+				log.Warn("Busy peer assigned new state range fetch", "peer", old.peer.id)
+
+				old.timer.Stop()
+				old.dropped = true
+
+				snapFinished = append(snapFinished, old)
+			}
+			// Start a timer to notify the sync loop if the peer stalled.
+			req.timer = time.AfterFunc(req.timeout, func() {
+				select {
+				case snapTimeout <- req:
+				case <-s.done:
+				}
+			})
+			snapActive[req.peer.id] = req
 		}
 	}
 }
@@ -212,7 +286,11 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 // stateSync schedules requests for downloading a particular state trie defined
 // by a given state root.
 type stateSync struct {
-	d *Downloader // Downloader instance to access and manage current peerset
+	d    *Downloader // Downloader instance to access and manage current peerset
+	root common.Hash // State root currently being synced
+
+	snap        *snapSync     // State range scheduler to run before healing (nil for node-by-node sync)
+	snapDeliver chan *snapReq // Delivery channel multiplexing state range responses
 
 	sched  *trie.TrieSync             // State trie sync scheduler defining the tasks
 	keccak hash.Hash                  // Keccak256 hasher to verify deliveries with
@@ -237,21 +315,41 @@ type stateTask struct {
 // newStateSync creates a new state trie download scheduler. This method does not
 // yet start the sync. The user needs to call run to initiate.
 func newStateSync(d *Downloader, root common.Hash) *stateSync {
-	return &stateSync{
-		d:       d,
-		sched:   state.NewStateSync(root, d.stateDB),
-		keccak:  sha3.NewKeccak256(),
-		tasks:   make(map[common.Hash]*stateTask),
-		deliver: make(chan *stateReq),
-		cancel:  make(chan struct{}),
-		done:    make(chan struct{}),
+	s := &stateSync{
+		d:           d,
+		root:        root,
+		snapDeliver: make(chan *snapReq),
+		sched:       state.NewStateSync(root, d.stateDB),
+		keccak:      sha3.NewKeccak256(),
+		tasks:       make(map[common.Hash]*stateTask),
+		deliver:     make(chan *stateReq),
+		cancel:      make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	// In snap sync mode, download the bulk of the state as ranges first. The range
+	// scheduler is shared between pivot moves to retain already downloaded data.
+	if d.mode == SnapSync {
+		if d.snapSync == nil {
+			d.snapSync = newSnapSync(d)
+		}
+		s.snap = d.snapSync
 	}
+	return s
 }
 
 // run starts the task assignment and response processing loop, blocking until
 // it finishes, and finally notifying any goroutines waiting for the loop to
 // finish.
 func (s *stateSync) run() {
+	if s.snap != nil {
+		if s.err = s.snapLoop(); s.err != nil {
+			close(s.done)
+			return
+		}
+		// The state ranges were downloaded, possibly for older pivots too. Reschedule
+		// the trie sync to heal whatever is still missing from the current root.
+		s.sched = state.NewStateSync(s.root, s.d.stateDB)
+	}
 	s.err = s.loop()
 	close(s.done)
 }
@@ -341,7 +439,7 @@ func (s *stateSync) assignTasks() {
 	for _, p := range peers {
 		// Assign a batch of fetches proportional to the estimated latency/bandwidth
 		cap := p.NodeDataCapacity(s.d.requestRTT())
-		req := &stateReq{peer: p, timeout: s.d.requestTTL()}
+		req := &stateReq{id: p.nextRequestID(), peer: p, timeout: s.d.requestTTL()}
 		s.fillTasks(cap, req)
 
 		// If the peer was assigned tasks to fetch, send the network request
@@ -349,7 +447,7 @@ func (s *stateSync) assignTasks() {
 			req.peer.log.Trace("Requesting new batch of data", "type", "state", "count", len(req.items))
 			select {
 			case s.d.trackStateReq <- req:
-				req.peer.FetchNodeData(req.items)
+				req.peer.FetchNodeData(req.id, req.items)
 			case <-s.cancel:
 			case <-s.d.cancelCh:
 			}
@@ -46,11 +46,13 @@ var (
 // blockRetrievalFn is a callback type for retrieving a block from the local chain.
 type blockRetrievalFn func(common.Hash) *types.Block
 
-// headerRequesterFn is a callback type for sending a header retrieval request.
-type headerRequesterFn func(common.Hash) error
+// headerRequesterFn is a callback type for sending a header retrieval request,
+// tagged with the ID the response should be filtered with.
+type headerRequesterFn func(uint64, common.Hash) error
 
-// bodyRequesterFn is a callback type for sending a body retrieval request.
-type bodyRequesterFn func([]common.Hash) error
+// bodyRequesterFn is a callback type for sending a body retrieval request, tagged
+// with the ID the response should be filtered with.
+type bodyRequesterFn func(uint64, []common.Hash) error
 
 // headerVerifierFn is a callback type to verify a block's header for fast propagation.
 type headerVerifierFn func(header *types.Header) error
@@ -79,11 +81,15 @@ type announce struct {
 
 	fetchHeader headerRequesterFn // Fetcher function to retrieve the header of an announced block
 	fetchBodies bodyRequesterFn   // Fetcher function to retrieve the body of an announced block
+
+	headerReq uint64 // ID of the request the header was fetched with
+	bodyReq   uint64 // ID of the request the body was fetched with
 }
 
 // headerFilterTask represents a batch of headers needing fetcher filtering.
 type headerFilterTask struct {
 	peer    string          // The source peer of block headers
+	reqID   uint64          // ID of the request answered (0 = unknown)
 	headers []*types.Header // Collection of headers to filter
 	time    time.Time       // Arrival time of the headers
 }
@@ -92,6 +98,7 @@ type headerFilterTask struct {
 // needing fetcher filtering.
 type bodyFilterTask struct {
 	peer         string                 // The source peer of block bodies
+	reqID        uint64                 // ID of the request answered (0 = unknown)
 	transactions [][]*types.Transaction // Collection of transactions per block bodies
 	uncles       [][]*types.Header      // Collection of uncles per block bodies
 	time         time.Time              // Arrival time of the blocks' contents
@@ -117,6 +124,8 @@ type Fetcher struct {
 	done chan common.Hash
 	quit chan struct{}
 
+	reqID uint64 // Last ID assigned to a header or body request
+
 	// Announce states
 	announces  map[string]int              // Per peer announce counts to prevent memory exhaustion
 	announced  map[common.Hash][]*announce // Announced blocks, scheduled for fetching
@@ -219,9 +228,10 @@ func (f *Fetcher) Enqueue(peer string, block *types.Block) error {
 }
 
 // FilterHeaders extracts all the headers that were explicitly requested by the fetcher,
-// returning those that should be handled differently.
-func (f *Fetcher) FilterHeaders(peer string, headers []*types.Header, time time.Time) []*types.Header {
-	log.Trace("Filtering headers", "peer", peer, "headers", len(headers))
+// returning those that should be handled differently. If the request ID is not
+// zero, only headers requested with that ID are extracted.
+func (f *Fetcher) FilterHeaders(peer string, reqID uint64, headers []*types.Header, time time.Time) []*types.Header {
+	log.Trace("Filtering headers", "peer", peer, "id", reqID, "headers", len(headers))
 
 	// Send the filter channel to the fetcher
 	filter := make(chan *headerFilterTask)
@@ -233,7 +243,7 @@ func (f *Fetcher) FilterHeaders(peer string, headers []*types.Header, time time.
 	}
 	// Request the filtering of the header list
 	select {
-	case filter <- &headerFilterTask{peer: peer, headers: headers, time: time}:
+	case filter <- &headerFilterTask{peer: peer, reqID: reqID, headers: headers, time: time}:
 	case <-f.quit:
 		return nil
 	}
@@ -247,9 +257,10 @@ func (f *Fetcher) FilterHeaders(peer string, headers []*types.Header, time time.
 }
 
 // FilterBodies extracts all the block bodies that were explicitly requested by
-// the fetcher, returning those that should be handled differently.
-func (f *Fetcher) FilterBodies(peer string, transactions [][]*types.Transaction, uncles [][]*types.Header, time time.Time) ([][]*types.Transaction, [][]*types.Header) {
-	log.Trace("Filtering bodies", "peer", peer, "txs", len(transactions), "uncles", len(uncles))
+// the fetcher, returning those that should be handled differently. If the request
+// ID is not zero, only bodies requested with that ID are extracted.
+func (f *Fetcher) FilterBodies(peer string, reqID uint64, transactions [][]*types.Transaction, uncles [][]*types.Header, time time.Time) ([][]*types.Transaction, [][]*types.Header) {
+	log.Trace("Filtering bodies", "peer", peer, "id", reqID, "txs", len(transactions), "uncles", len(uncles))
 
 	// Send the filter channel to the fetcher
 	filter := make(chan *bodyFilterTask)
@@ -261,7 +272,7 @@ func (f *Fetcher) FilterBodies(peer string, transactions [][]*types.Transaction,
 	}
 	// Request the filtering of the body list
 	select {
-	case filter <- &bodyFilterTask{peer: peer, transactions: transactions, uncles: uncles, time: time}:
+	case filter <- &bodyFilterTask{peer: peer, reqID: reqID, transactions: transactions, uncles: uncles, time: time}:
 	case <-f.quit:
 		return nil, nil
 	}
@@ -383,15 +394,21 @@ func (f *Fetcher) loop() {
 			for peer, hashes := range request {
 				log.Trace("Fetching scheduled headers", "peer", peer, "list", hashes)
 
+				// Tag each header request with a fresh ID to match the response against
+				ids := make([]uint64, len(hashes))
+				for i, hash := range hashes {
+					f.reqID++
+					ids[i], f.fetching[hash].headerReq = f.reqID, f.reqID
+				}
 				// Create a closure of the fetch and schedule in on a new thread
 				fetchHeader, hashes := f.fetching[hashes[0]].fetchHeader, hashes
 				go func() {
 					if f.fetchingHook != nil {
 						f.fetchingHook(hashes)
 					}
-					for _, hash := range hashes {
+					for i, hash := range hashes {
 						headerFetchMeter.Mark(1)
-						fetchHeader(hash) // Suboptimal, but protocol doesn't allow batch header retrievals
+						fetchHeader(ids[i], hash) // Suboptimal, but protocol doesn't allow batch header retrievals
 					}
 				}()
 			}
@@ -417,12 +434,17 @@ func (f *Fetcher) loop() {
 			for peer, hashes := range request {
 				log.Trace("Fetching scheduled bodies", "peer", peer, "list", hashes)
 
+				// Tag the body request with a fresh ID to match the response against
+				f.reqID++
+				for _, hash := range hashes {
+					f.completing[hash].bodyReq = f.reqID
+				}
 				// Create a closure of the fetch and schedule in on a new thread
 				if f.completingHook != nil {
 					f.completingHook(hashes)
 				}
 				bodyFetchMeter.Mark(int64(len(hashes)))
-				go f.completing[hashes[0]].fetchBodies(hashes)
+				go f.completing[hashes[0]].fetchBodies(f.reqID, hashes)
 			}
 			// Schedule the next fetch if blocks are still pending
 			f.rescheduleComplete(completeTimer)
@@ -446,7 +468,7 @@ func (f *Fetcher) loop() {
 				hash := header.Hash()
 
 				// Filter fetcher-requested headers from other synchronisation algorithms
-				if announce := f.fetching[hash]; announce != nil && announce.origin == task.peer && f.fetched[hash] == nil && f.completing[hash] == nil && f.queued[hash] == nil {
+				if announce := f.fetching[hash]; announce != nil && announce.origin == task.peer && (task.reqID == 0 || task.reqID == announce.headerReq) && f.fetched[hash] == nil && f.completing[hash] == nil && f.queued[hash] == nil {
 					// If the delivered header does not match the promised number, drop the announcer
 					if header.Number.Uint64() != announce.number {
 						log.Trace("Invalid block number fetched", "peer", announce.origin, "hash", header.Hash(), "announced", announce.number, "provided", header.Number)
@@ -525,7 +547,7 @@ func (f *Fetcher) loop() {
 						txnHash := types.DeriveSha(types.Transactions(task.transactions[i]))
 						uncleHash := types.CalcUncleHash(task.uncles[i])
 
-						if txnHash == announce.header.TxHash && uncleHash == announce.header.UncleHash && announce.origin == task.peer {
+						if txnHash == announce.header.TxHash && uncleHash == announce.header.UncleHash && announce.origin == task.peer && (task.reqID == 0 || task.reqID == announce.bodyReq) {
 							// Mark the body matched, reassemble if still unknown
 							matched = true
 
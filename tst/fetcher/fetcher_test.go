@@ -160,14 +160,14 @@ func (f *fetcherTester) makeHeaderFetcher(peer string, blocks map[common.Hash]*t
 		closure[hash] = block
 	}
 	// Create a function that return a header from the closure
-	return func(hash common.Hash) error {
+	return func(id uint64, hash common.Hash) error {
 		// Gather the blocks to return
 		headers := make([]*types.Header, 0, 1)
 		if block, ok := closure[hash]; ok {
 			headers = append(headers, block.Header())
 		}
 		// Return on a new thread
-		go f.fetcher.FilterHeaders(peer, headers, time.Now().Add(drift))
+		go f.fetcher.FilterHeaders(peer, id, headers, time.Now().Add(drift))
 
 		return nil
 	}
@@ -180,7 +180,7 @@ func (f *fetcherTester) makeBodyFetcher(peer string, blocks map[common.Hash]*typ
 		closure[hash] = block
 	}
 	// Create a function that returns blocks from the closure
-	return func(hashes []common.Hash) error {
+	return func(id uint64, hashes []common.Hash) error {
 		// Gather the block bodies to return
 		transactions := make([][]*types.Transaction, 0, len(hashes))
 		uncles := make([][]*types.Header, 0, len(hashes))
@@ -192,7 +192,7 @@ func (f *fetcherTester) makeBodyFetcher(peer string, blocks map[common.Hash]*typ
 			}
 		}
 		// Return on a new thread
-		go f.fetcher.FilterBodies(peer, transactions, uncles, time.Now().Add(drift))
+		go f.fetcher.FilterBodies(peer, id, transactions, uncles, time.Now().Add(drift))
 
 		return nil
 	}
@@ -316,13 +316,13 @@ func testConcurrentAnnouncements(t *testing.T, protocol int) {
 	secondBodyFetcher := tester.makeBodyFetcher("second", blocks, 0)
 
 	counter := uint32(0)
-	firstHeaderWrapper := func(hash common.Hash) error {
+	firstHeaderWrapper := func(id uint64, hash common.Hash) error {
 		atomic.AddUint32(&counter, 1)
-		return firstHeaderFetcher(hash)
+		return firstHeaderFetcher(id, hash)
 	}
-	secondHeaderWrapper := func(hash common.Hash) error {
+	secondHeaderWrapper := func(id uint64, hash common.Hash) error {
 		atomic.AddUint32(&counter, 1)
-		return secondHeaderFetcher(hash)
+		return secondHeaderFetcher(id, hash)
 	}
 	// Iteratively announce blocks until all are imported
 	imported := make(chan *types.Block)
@@ -393,13 +393,13 @@ func testPendingDeduplication(t *testing.T, protocol int) {
 
 	delay := 50 * time.Millisecond
 	counter := uint32(0)
-	headerWrapper := func(hash common.Hash) error {
+	headerWrapper := func(id uint64, hash common.Hash) error {
 		atomic.AddUint32(&counter, 1)
 
 		// Simulate a long running fetch
 		go func() {
 			time.Sleep(delay)
-			headerFetcher(hash)
+			headerFetcher(id, hash)
 		}()
 		return nil
 	}
@@ -684,6 +684,88 @@ func testEmptyBlockShortCircuit(t *testing.T, protocol int) {
 	verifyImportDone(t, imported)
 }
 
+// Tests that concurrent header and body requests to the same peer are matched
+// to their responses by request ID, even if the responses arrive out of order,
+// and that a response is not accepted for a different request.
+func TestOutOfOrderResponses(t *testing.T) {
+	// Create two competing blocks with contents, both requiring body retrievals
+	hashesA, blocksA := makeChain(1, 0, genesis)
+	hashesB, blocksB := makeChain(1, 1, genesis)
+	blockA, blockB := blocksA[hashesA[0]], blocksB[hashesB[0]]
+
+	// Create a peer recording the requests instead of answering them
+	type request struct {
+		id     uint64
+		hashes []common.Hash
+	}
+	headerReqs, bodyReqs := make(chan request, 2), make(chan request, 2)
+	headerFetcher := func(id uint64, hash common.Hash) error {
+		headerReqs <- request{id, []common.Hash{hash}}
+		return nil
+	}
+	bodyFetcher := func(id uint64, hashes []common.Hash) error {
+		bodyReqs <- request{id, hashes}
+		return nil
+	}
+	waitRequest := func(reqs chan request, kind string) request {
+		select {
+		case req := <-reqs:
+			return req
+		case <-time.After(time.Second):
+			t.Fatalf("%s request timeout", kind)
+		}
+		return request{}
+	}
+	tester := newTester()
+
+	imported := make(chan *types.Block, 2)
+	tester.fetcher.importedHook = func(block *types.Block) { imported <- block }
+
+	// Announce both blocks and gather the concurrent header requests
+	tester.fetcher.Notify("valid", blockA.Hash(), 1, time.Now().Add(-arriveTimeout), headerFetcher, bodyFetcher)
+	tester.fetcher.Notify("valid", blockB.Hash(), 1, time.Now().Add(-arriveTimeout), headerFetcher, bodyFetcher)
+
+	headerIDs := make(map[common.Hash]uint64)
+	for i := 0; i < 2; i++ {
+		req := waitRequest(headerReqs, "header")
+		headerIDs[req.hashes[0]] = req.id
+	}
+	if headerIDs[blockA.Hash()] == headerIDs[blockB.Hash()] {
+		t.Fatalf("header requests share ID %d", headerIDs[blockA.Hash()])
+	}
+	// Answer the request of A with the header of B, it must not be accepted
+	if unknown := tester.fetcher.FilterHeaders("valid", headerIDs[blockA.Hash()], []*types.Header{blockB.Header()}, time.Now()); len(unknown) != 1 {
+		t.Fatalf("header accepted for the wrong request")
+	}
+	// Answer the header requests in reverse order, waiting for the body request
+	// of B to go out before answering A so that both are in flight concurrently
+	if unknown := tester.fetcher.FilterHeaders("valid", headerIDs[blockB.Hash()], []*types.Header{blockB.Header()}, time.Now()); len(unknown) != 0 {
+		t.Fatalf("header of B not accepted")
+	}
+	reqB := waitRequest(bodyReqs, "body")
+	if unknown := tester.fetcher.FilterHeaders("valid", headerIDs[blockA.Hash()], []*types.Header{blockA.Header()}, time.Now()); len(unknown) != 0 {
+		t.Fatalf("header of A not accepted")
+	}
+	reqA := waitRequest(bodyReqs, "body")
+	if reqA.id == reqB.id {
+		t.Fatalf("body requests share ID %d", reqA.id)
+	}
+	// Answer the request of B with the body of A, it must not be accepted
+	if txs, _ := tester.fetcher.FilterBodies("valid", reqB.id, [][]*types.Transaction{blockA.Transactions()}, [][]*types.Header{blockA.Uncles()}, time.Now()); len(txs) != 1 {
+		t.Fatalf("body accepted for the wrong request")
+	}
+	verifyImportEvent(t, imported, false)
+
+	// Answer the body requests in reverse order and ensure both blocks are imported
+	if txs, _ := tester.fetcher.FilterBodies("valid", reqB.id, [][]*types.Transaction{blockB.Transactions()}, [][]*types.Header{blockB.Uncles()}, time.Now()); len(txs) != 0 {
+		t.Fatalf("body of B not accepted")
+	}
+	if txs, _ := tester.fetcher.FilterBodies("valid", reqA.id, [][]*types.Transaction{blockA.Transactions()}, [][]*types.Header{blockA.Uncles()}, time.Now()); len(txs) != 0 {
+		t.Fatalf("body of A not accepted")
+	}
+	verifyImportCount(t, imported, 2)
+}
+
 // Tests that a peer is unable to use unbounded memory with sending infinite
 // block announcements to a node, but that even in the face of such an attack,
 // the fetcher remains operational.
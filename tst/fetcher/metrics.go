@@ -40,4 +40,13 @@ var (
 	headerFilterOutMeter = metrics.NewRegisteredMeter("haa/fetcher/filter/headers/out", nil)
 	bodyFilterInMeter    = metrics.NewRegisteredMeter("haa/fetcher/filter/bodies/in", nil)
 	bodyFilterOutMeter   = metrics.NewRegisteredMeter("haa/fetcher/filter/bodies/out", nil)
+
+	txAnnounceInMeter    = metrics.NewRegisteredMeter("haa/fetcher/prop/txannounces/in", nil)
+	txAnnounceKnownMeter = metrics.NewRegisteredMeter("haa/fetcher/prop/txannounces/known", nil)
+	txAnnounceDOSMeter   = metrics.NewRegisteredMeter("haa/fetcher/prop/txannounces/dos", nil)
+	txBroadcastInMeter   = metrics.NewRegisteredMeter("haa/fetcher/prop/txbroadcasts/in", nil)
+
+	txRequestOutMeter     = metrics.NewRegisteredMeter("haa/fetcher/fetch/transactions/out", nil)
+	txRequestTimeoutMeter = metrics.NewRegisteredMeter("haa/fetcher/fetch/transactions/timeout", nil)
+	txReplyInMeter        = metrics.NewRegisteredMeter("haa/fetcher/fetch/transactions/in", nil)
 )
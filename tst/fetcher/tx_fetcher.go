@@ -0,0 +1,374 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package fetcher
+
+import (
+	"time"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/types"
+	"github.com/haachain/go-haachain/log"
+)
+
+const (
+	txArriveTimeout = 500 * time.Millisecond // Time allowance for an announced transaction to be broadcast before it is explicitly requested
+	txFetchTimeout  = 5 * time.Second        // Maximum allotted time to return an explicitly requested transaction
+	maxTxAnnounces  = 4096                   // Maximum number of unique transactions a peer may have announced
+	MaxTxRetrievals = 256                    // Maximum number of transactions to request from a peer at once
+)
+
+// txHasFn is a callback type for checking whhaaer a transaction is already known.
+type txHasFn func(common.Hash) bool
+
+// txAddFn is a callback type for injecting a batch of transactions into the pool.
+type txAddFn func([]*types.Transaction) []error
+
+// txRequestFn is a callback type for sending a transaction retrieval request.
+type txRequestFn func(peer string, hashes []common.Hash) error
+
+// txAnnounce is the hash notification of the availability of a batch of new
+// transactions in the network.
+type txAnnounce struct {
+	origin string        // Identifier of the peer originating the notification
+	hashes []common.Hash // Hashes of the transactions being announced
+}
+
+// txDelivery is a batch of transactions delivered by a peer, either broadcast
+// or as the reply to an explicit request.
+type txDelivery struct {
+	origin string        // Identifier of the peer delivering the transactions
+	hashes []common.Hash // Hashes of the transactions delivered
+	direct bool          // Whhaaer this is the reply to an explicit request
+}
+
+// txRequest is an in-flight transaction retrieval request to a peer.
+type txRequest struct {
+	hashes []common.Hash // Hashes of the transactions requested
+	time   time.Time     // Timestamp of the request
+}
+
+// TxFetcher is responsible for retrieving new transactions based on hash
+// announcements. Announced transactions are given some time to arrive via
+// direct broadcasts, after which they are explicitly requested from one of the
+// peers that announced them, retrying others if a request times out.
+type TxFetcher struct {
+	// Various event channels
+	notify  chan *txAnnounce
+	cleanup chan *txDelivery
+	drop    chan string
+	quit    chan struct{}
+
+	// Announce states
+	waiting   map[common.Hash]time.Time           // Announced transactions waiting for a broadcast, with the first announce time
+	announces map[string]map[common.Hash]struct{} // Transactions announced by each peer, not yet retrieved
+	announced map[common.Hash]map[string]struct{} // Peers that announced each transaction not yet retrieved
+	fetching  map[common.Hash]string              // Transactions currently being retrieved, with the peer asked
+	requests  map[string]*txRequest               // In-flight retrieval request of each peer
+
+	// Callbacks
+	hasTx    txHasFn     // Checks whhaaer a transaction is already known locally
+	addTxs   txAddFn     // Injects a batch of transactions into the pool
+	fetchTxs txRequestFn // Requests a batch of transactions from a peer
+
+	arriveTimeout time.Duration // Time allowance before requesting an announced transaction
+	fetchTimeout  time.Duration // Time allowance for a peer to deliver requested transactions
+}
+
+// NewTxFetcher creates a transaction fetcher to retrieve transactions based on
+// hash announcements.
+func NewTxFetcher(hasTx txHasFn, addTxs txAddFn, fetchTxs txRequestFn) *TxFetcher {
+	return &TxFetcher{
+		notify:        make(chan *txAnnounce),
+		cleanup:       make(chan *txDelivery),
+		drop:          make(chan string),
+		quit:          make(chan struct{}),
+		waiting:       make(map[common.Hash]time.Time),
+		announces:     make(map[string]map[common.Hash]struct{}),
+		announced:     make(map[common.Hash]map[string]struct{}),
+		fetching:      make(map[common.Hash]string),
+		requests:      make(map[string]*txRequest),
+		hasTx:         hasTx,
+		addTxs:        addTxs,
+		fetchTxs:      fetchTxs,
+		arriveTimeout: txArriveTimeout,
+		fetchTimeout:  txFetchTimeout,
+	}
+}
+
+// Start boots up the announcement based transaction retrieval, accepting and
+// processing hash notifications until termination requested.
+func (f *TxFetcher) Start() {
+	go f.loop()
+}
+
+// Stop terminates the announcement based transaction retrieval, canceling all
+// pending operations.
+func (f *TxFetcher) Stop() {
+	close(f.quit)
+}
+
+// Notify announces the fetcher of the potential availability of a batch of new
+// transactions in the network.
+func (f *TxFetcher) Notify(peer string, hashes []common.Hash) error {
+	// Skip any transactions already known, no need to schedule them
+	unknown := make([]common.Hash, 0, len(hashes))
+	for _, hash := range hashes {
+		if !f.hasTx(hash) {
+			unknown = append(unknown, hash)
+		}
+	}
+	txAnnounceInMeter.Mark(int64(len(hashes)))
+	txAnnounceKnownMeter.Mark(int64(len(hashes) - len(unknown)))
+
+	if len(unknown) == 0 {
+		return nil
+	}
+	select {
+	case f.notify <- &txAnnounce{origin: peer, hashes: unknown}:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// Enqueue injects a batch of transactions received from a peer into the pool,
+// and marks them as retrieved. If direct is set, the batch is the reply to an
+// explicit request made by the fetcher.
+func (f *TxFetcher) Enqueue(peer string, txs []*types.Transaction, direct bool) error {
+	if direct {
+		txReplyInMeter.Mark(int64(len(txs)))
+	} else {
+		txBroadcastInMeter.Mark(int64(len(txs)))
+	}
+	f.addTxs(txs)
+
+	hashes := make([]common.Hash, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.Hash()
+	}
+	select {
+	case f.cleanup <- &txDelivery{origin: peer, hashes: hashes, direct: direct}:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// Drop removes all the announcements of a disconnected peer, rescheduling the
+// transactions it was asked for to other peers.
+func (f *TxFetcher) Drop(peer string) error {
+	select {
+	case f.drop <- peer:
+		return nil
+	case <-f.quit:
+		return errTerminated
+	}
+}
+
+// Loop is the main fetcher loop, checking and processing various notification
+// events.
+func (f *TxFetcher) loop() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-f.quit:
+			// Fetcher terminating, abort all operations
+			return
+
+		case ann := <-f.notify:
+			// Transactions were announced, make sure the peer isn't DOSing us
+			announces := f.announces[ann.origin]
+			if announces == nil {
+				announces = make(map[common.Hash]struct{})
+				f.announces[ann.origin] = announces
+			}
+			now := time.Now()
+			for _, hash := range ann.hashes {
+				if _, ok := announces[hash]; ok {
+					continue
+				}
+				if len(announces) >= maxTxAnnounces {
+					log.Debug("Peer exceeded outstanding tx announces", "peer", ann.origin, "limit", maxTxAnnounces)
+					txAnnounceDOSMeter.Mark(1)
+					break
+				}
+				announces[hash] = struct{}{}
+				if f.announced[hash] == nil {
+					// First announcement, give it some time to arrive via broadcast
+					f.announced[hash] = make(map[string]struct{})
+					f.waiting[hash] = now
+				}
+				f.announced[hash][ann.origin] = struct{}{}
+			}
+
+		case delivery := <-f.cleanup:
+			// Transactions arrived, stop tracking any of them
+			for _, hash := range delivery.hashes {
+				f.forgetHash(hash)
+			}
+			// If it was a reply, the peer doesn't have the ones it didn't send
+			if req := f.requests[delivery.origin]; delivery.direct && req != nil {
+				delete(f.requests, delivery.origin)
+				for _, hash := range req.hashes {
+					if f.fetching[hash] == delivery.origin {
+						delete(f.fetching, hash)
+						f.forgetAnnounce(delivery.origin, hash)
+					}
+				}
+			}
+
+		case peer := <-f.drop:
+			// Peer disconnected, reschedule anything it was asked for
+			if req := f.requests[peer]; req != nil {
+				delete(f.requests, peer)
+				for _, hash := range req.hashes {
+					if f.fetching[hash] == peer {
+						delete(f.fetching, hash)
+					}
+				}
+			}
+			for hash := range f.announces[peer] {
+				f.forgetAnnounce(peer, hash)
+			}
+
+		case <-timer.C:
+			// Announced transactions not broadcast in time need explicit retrieval
+			now := time.Now()
+			for hash, announced := range f.waiting {
+				if now.Sub(announced) >= f.arriveTimeout {
+					delete(f.waiting, hash)
+				}
+			}
+			// Requests not answered in time are retried from other peers
+			for peer, req := range f.requests {
+				if now.Sub(req.time) < f.fetchTimeout {
+					continue
+				}
+				log.Debug("Transaction retrieval timed out", "peer", peer, "count", len(req.hashes))
+				txRequestTimeoutMeter.Mark(int64(len(req.hashes)))
+
+				delete(f.requests, peer)
+				for _, hash := range req.hashes {
+					if f.fetching[hash] == peer {
+						delete(f.fetching, hash)
+						f.forgetAnnounce(peer, hash)
+					}
+				}
+			}
+		}
+		// Request any transactions due from idle peers and wait for the next deadline
+		f.schedule()
+		f.reschedule(timer)
+	}
+}
+
+// schedule requests all the announced transactions that had time to arrive via
+// broadcasts but didn't, from the idle peers that announced them. Each
+// transaction is only retrieved from a single peer at a time.
+func (f *TxFetcher) schedule() {
+	for peer, announces := range f.announces {
+		if _, busy := f.requests[peer]; busy {
+			continue
+		}
+		var hashes []common.Hash
+		for hash := range announces {
+			if len(hashes) >= MaxTxRetrievals {
+				break
+			}
+			if _, waiting := f.waiting[hash]; waiting {
+				continue
+			}
+			if _, fetching := f.fetching[hash]; fetching {
+				continue
+			}
+			hashes = append(hashes, hash)
+		}
+		if len(hashes) == 0 {
+			continue
+		}
+		for _, hash := range hashes {
+			f.fetching[hash] = peer
+		}
+		f.requests[peer] = &txRequest{hashes: hashes, time: time.Now()}
+		txRequestOutMeter.Mark(int64(len(hashes)))
+
+		go func(peer string, hashes []common.Hash) {
+			if err := f.fetchTxs(peer, hashes); err != nil {
+				log.Debug("Failed to request transactions", "peer", peer, "err", err)
+			}
+		}(peer, hashes)
+	}
+}
+
+// reschedule resets the timer to the earliest announce or request deadline.
+func (f *TxFetcher) reschedule(timer *time.Timer) {
+	var earliest time.Time
+	for _, announced := range f.waiting {
+		if deadline := announced.Add(f.arriveTimeout); earliest.IsZero() || deadline.Before(earliest) {
+			earliest = deadline
+		}
+	}
+	for _, req := range f.requests {
+		if deadline := req.time.Add(f.fetchTimeout); earliest.IsZero() || deadline.Before(earliest) {
+			earliest = deadline
+		}
+	}
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	if !earliest.IsZero() {
+		timer.Reset(time.Until(earliest))
+	}
+}
+
+// forgetAnnounce removes the announcement of a transaction by a peer, dropping
+// the transaction altogether if no other peer announced it.
+func (f *TxFetcher) forgetAnnounce(peer string, hash common.Hash) {
+	if announces := f.announces[peer]; announces != nil {
+		delete(announces, hash)
+		if len(announces) == 0 {
+			delete(f.announces, peer)
+		}
+	}
+	if announced := f.announced[hash]; announced != nil {
+		delete(announced, peer)
+		if len(announced) == 0 {
+			f.forgetHash(hash)
+		}
+	}
+}
+
+// forgetHash removes all traces of a transaction from the fetcher's internal
+// state. In-flight requests are left alone, their replies handled as usual.
+func (f *TxFetcher) forgetHash(hash common.Hash) {
+	for peer := range f.announced[hash] {
+		if announces := f.announces[peer]; announces != nil {
+			delete(announces, hash)
+			if len(announces) == 0 {
+				delete(f.announces, peer)
+			}
+		}
+	}
+	delete(f.announced, hash)
+	delete(f.waiting, hash)
+	delete(f.fetching, hash)
+}
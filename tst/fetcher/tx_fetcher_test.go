@@ -0,0 +1,216 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package fetcher
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/types"
+)
+
+// txFetcherTester is a test simulator for mocking out the transaction pool and
+// the peers being requested transactions from.
+type txFetcherTester struct {
+	fetcher *TxFetcher
+
+	pool     map[common.Hash]*types.Transaction // Transactions injected into the pool
+	requests chan *txRequestEvent               // Retrieval requests made by the fetcher
+	lock     sync.RWMutex
+}
+
+// txRequestEvent is a retrieval request made by the fetcher to a peer.
+type txRequestEvent struct {
+	peer   string
+	hashes []common.Hash
+}
+
+// newTxFetcherTester creates a new transaction fetcher test mocker, with short
+// timeouts to speed up the tests.
+func newTxFetcherTester() *txFetcherTester {
+	tester := &txFetcherTester{
+		pool:     make(map[common.Hash]*types.Transaction),
+		requests: make(chan *txRequestEvent, 16),
+	}
+	tester.fetcher = NewTxFetcher(tester.hasTx, tester.addTxs, tester.fetchTxs)
+	tester.fetcher.arriveTimeout = 50 * time.Millisecond
+	tester.fetcher.fetchTimeout = 200 * time.Millisecond
+	tester.fetcher.Start()
+
+	return tester
+}
+
+// hasTx checks whhaaer a transaction was injected into the pool.
+func (f *txFetcherTester) hasTx(hash common.Hash) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	_, ok := f.pool[hash]
+	return ok
+}
+
+// addTxs injects a batch of transactions into the pool.
+func (f *txFetcherTester) addTxs(txs []*types.Transaction) []error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, tx := range txs {
+		f.pool[tx.Hash()] = tx
+	}
+	return make([]error, len(txs))
+}
+
+// fetchTxs records a transaction retrieval request.
+func (f *txFetcherTester) fetchTxs(peer string, hashes []common.Hash) error {
+	f.requests <- &txRequestEvent{peer: peer, hashes: hashes}
+	return nil
+}
+
+// expectRequest waits for a retrieval request to the given peer.
+func (f *txFetcherTester) expectRequest(t *testing.T, peer string, hashes ...common.Hash) {
+	select {
+	case req := <-f.requests:
+		if req.peer != peer {
+			t.Fatalf("request peer mismatch: have %s, want %s", req.peer, peer)
+		}
+		if len(req.hashes) != len(hashes) {
+			t.Fatalf("request size mismatch: have %d, want %d", len(req.hashes), len(hashes))
+		}
+		requested := make(map[common.Hash]bool)
+		for _, hash := range req.hashes {
+			requested[hash] = true
+		}
+		for _, hash := range hashes {
+			if !requested[hash] {
+				t.Fatalf("transaction %x not requested", hash)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("request to %s not made", peer)
+	}
+}
+
+// expectNoRequest makes sure no retrieval request is made for a while.
+func (f *txFetcherTester) expectNoRequest(t *testing.T, wait time.Duration) {
+	select {
+	case req := <-f.requests:
+		t.Fatalf("unexpected request to %s: %x", req.peer, req.hashes)
+	case <-time.After(wait):
+	}
+}
+
+// makeTxs creates a batch of dummy transactions.
+func makeTxs(n int) []*types.Transaction {
+	txs := make([]*types.Transaction, n)
+	for i := 0; i < n; i++ {
+		txs[i] = types.NewTransaction(uint64(i), common.Address{}, big.NewInt(0), 0, big.NewInt(0), nil)
+	}
+	return txs
+}
+
+// Tests that announced transactions are requested from a single peer once they
+// didn't arrive via broadcast, and are not requested if they did.
+func TestTxFetcherAnnounceAndBroadcast(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	txs := makeTxs(2)
+
+	// Announce both transactions from two peers, broadcast one of them
+	tester.fetcher.Notify("A", []common.Hash{txs[0].Hash(), txs[1].Hash()})
+	tester.fetcher.Notify("B", []common.Hash{txs[0].Hash(), txs[1].Hash()})
+	tester.fetcher.Enqueue("B", txs[:1], false)
+
+	// Only the missing one should be requested, and only from one of the peers
+	select {
+	case req := <-tester.requests:
+		if len(req.hashes) != 1 || req.hashes[0] != txs[1].Hash() {
+			t.Fatalf("requested transactions mismatch: have %x, want [%x]", req.hashes, txs[1].Hash())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("missing transaction not requested")
+	}
+	tester.expectNoRequest(t, 100*time.Millisecond)
+}
+
+// Tests that known transactions are never scheduled for retrieval.
+func TestTxFetcherSkipKnown(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	txs := makeTxs(1)
+	tester.addTxs(txs)
+
+	tester.fetcher.Notify("A", []common.Hash{txs[0].Hash()})
+	tester.expectNoRequest(t, 150*time.Millisecond)
+}
+
+// Tests that timed out requests are retried from other peers that announced
+// the same transactions.
+func TestTxFetcherTimeout(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	txs := makeTxs(1)
+	tester.fetcher.Notify("A", []common.Hash{txs[0].Hash()})
+	tester.expectRequest(t, "A", txs[0].Hash())
+
+	tester.fetcher.Notify("B", []common.Hash{txs[0].Hash()})
+	tester.expectRequest(t, "B", txs[0].Hash())
+
+	// Once delivered, nothing else should be requested
+	tester.fetcher.Enqueue("B", txs, true)
+	tester.expectNoRequest(t, 300*time.Millisecond)
+}
+
+// Tests that transactions missing from a reply, or requested from a dropped
+// peer, are rescheduled to other peers.
+func TestTxFetcherPartialReplyAndDrop(t *testing.T) {
+	tester := newTxFetcherTester()
+	defer tester.fetcher.Stop()
+
+	txs := makeTxs(2)
+	hashes := []common.Hash{txs[0].Hash(), txs[1].Hash()}
+
+	tester.fetcher.Notify("A", hashes[:1])
+	tester.expectRequest(t, "A", hashes[0])
+
+	// Peer B announces both, but should only be asked for the second one
+	tester.fetcher.Notify("B", hashes)
+	tester.expectRequest(t, "B", hashes[1])
+
+	// Peer B delivers the second, A drops: the first is rescheduled to B
+	tester.fetcher.Enqueue("B", txs[1:], true)
+	tester.fetcher.Drop("A")
+	tester.expectRequest(t, "B", hashes[0])
+
+	// Peer B doesn't have it either and nobody else announced it
+	tester.fetcher.Enqueue("B", nil, true)
+	tester.expectNoRequest(t, 100*time.Millisecond)
+
+	// A new announcement of the first should be requested from C
+	tester.fetcher.Notify("C", hashes[:1])
+	tester.expectRequest(t, "C", hashes[0])
+
+	// Missing from the reply of C, rescheduled to D
+	tester.fetcher.Notify("D", hashes[:1])
+	tester.fetcher.Enqueue("C", nil, true)
+	tester.expectRequest(t, "D", hashes[0])
+}
@@ -0,0 +1,246 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package gasprice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/haachain/go-haachain/core/types"
+	"github.com/haachain/go-haachain/rpc"
+)
+
+const (
+	// maxFeeHistory is the maximum number of blocks that can be retrieved for
+	// a fee history request.
+	maxFeeHistory = 1024
+
+	// maxBlockFetchers is the maximum number of blocks retrieved concurrently
+	// for a fee history request.
+	maxBlockFetchers = 4
+)
+
+var (
+	errInvalidPercentile = errors.New("invalid reward percentile")
+	errRequestBeyondHead = errors.New("request beyond head block")
+)
+
+// txGasAndPrice is the gas used and the gas price of a single transaction.
+type txGasAndPrice struct {
+	gasUsed  uint64
+	gasPrice *big.Int
+}
+
+type txsByGasPrice []txGasAndPrice
+
+func (s txsByGasPrice) Len() int           { return len(s) }
+func (s txsByGasPrice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s txsByGasPrice) Less(i, j int) bool { return s[i].gasPrice.Cmp(s[j].gasPrice) < 0 }
+
+// percentiles returns the gas prices at the given percentiles of the gas used
+// by the transactions. The transactions are sorted in place. Zero prices are
+// returned for all percentiles if there are no transactions.
+func (s txsByGasPrice) percentiles(percents []float64) []*big.Int {
+	prices := make([]*big.Int, len(percents))
+	if len(s) == 0 {
+		for i := range prices {
+			prices[i] = new(big.Int)
+		}
+		return prices
+	}
+	sort.Sort(s)
+
+	var total uint64
+	for _, tx := range s {
+		total += tx.gasUsed
+	}
+	var (
+		idx = 0
+		sum = s[0].gasUsed
+	)
+	for i, p := range percents {
+		threshold := uint64(float64(total) * p / 100)
+		for sum < threshold && idx < len(s)-1 {
+			idx++
+			sum += s[idx].gasUsed
+		}
+		prices[i] = new(big.Int).Set(s[idx].gasPrice)
+	}
+	return prices
+}
+
+// blockFees is the fee history of a single block.
+type blockFees struct {
+	number       uint64     // Number of the block
+	gasPrices    []*big.Int // Gas prices at the requested percentiles
+	gasUsedRatio float64    // Ratio of the gas used to the gas limit
+	err          error      // Error encountered while retrieving the block
+}
+
+// FeeHistory returns the gas used ratio and the gas prices at the requested
+// percentiles of gas used for up to maxFeeHistory blocks ending with lastBlock,
+// along with the oldest block included. The percentiles must be monotonically
+// increasing values between 0 and 100. Each transaction is weighted by the gas
+// it used, so the 50th percentile is the price at which half of the gas in the
+// block was bought.
+//
+// The prices at the same percentiles of the currently pending transactions are
+// returned too, weighted by their gas limits, which can be used as an estimate
+// of the price required for inclusion in the next block. They are nil if the
+// pool is empty.
+func (gpo *Oracle) FeeHistory(ctx context.Context, blocks int, lastBlock rpc.BlockNumber, percents []float64) (*big.Int, [][]*big.Int, []float64, []*big.Int, error) {
+	if blocks < 1 {
+		return new(big.Int), nil, []float64{}, nil, nil
+	}
+	if blocks > maxFeeHistory {
+		blocks = maxFeeHistory
+	}
+	for i, p := range percents {
+		if p < 0 || p > 100 {
+			return nil, nil, nil, nil, fmt.Errorf("%v: %f", errInvalidPercentile, p)
+		}
+		if i > 0 && p < percents[i-1] {
+			return nil, nil, nil, nil, fmt.Errorf("%v: #%d:%f > #%d:%f", errInvalidPercentile, i-1, percents[i-1], i, p)
+		}
+	}
+	// Resolve the range of blocks to retrieve, the pending block is not
+	// included as its receipts are not available
+	head, err := gpo.backend.HeaderByNumber(ctx, rpc.LatestBlockNumber)
+	if head == nil {
+		return nil, nil, nil, nil, err
+	}
+	last := head.Number.Uint64()
+	if lastBlock >= 0 {
+		if uint64(lastBlock) > last {
+			return nil, nil, nil, nil, fmt.Errorf("%v: requested %d, head %d", errRequestBeyondHead, lastBlock, last)
+		}
+		last = uint64(lastBlock)
+	}
+	if uint64(blocks) > last+1 {
+		blocks = int(last + 1)
+	}
+	oldest := last + 1 - uint64(blocks)
+
+	// Retrieve the blocks with a bounded number of fetchers and assemble their
+	// fees in order
+	var (
+		numbers = make(chan uint64, blocks)
+		results = make(chan blockFees, blocks)
+		quit    = make(chan struct{})
+	)
+	defer close(quit)
+
+	for number := oldest; number <= last; number++ {
+		numbers <- number
+	}
+	close(numbers)
+
+	fetchers := maxBlockFetchers
+	if fetchers > blocks {
+		fetchers = blocks
+	}
+	for i := 0; i < fetchers; i++ {
+		go func() {
+			for number := range numbers {
+				select {
+				case <-quit:
+					return
+				default:
+					results <- gpo.getBlockFees(ctx, number, percents)
+				}
+			}
+		}()
+	}
+	var (
+		prices = make([][]*big.Int, blocks)
+		ratios = make([]float64, blocks)
+	)
+	for i := 0; i < blocks; i++ {
+		res := <-results
+		if res.err != nil {
+			return nil, nil, nil, nil, res.err
+		}
+		prices[res.number-oldest] = res.gasPrices
+		ratios[res.number-oldest] = res.gasUsedRatio
+	}
+	if len(percents) == 0 {
+		prices = nil
+	}
+	// Estimate the prices from the transactions waiting in the pool
+	pending, err := gpo.pendingFees(percents)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return new(big.Int).SetUint64(oldest), prices, ratios, pending, nil
+}
+
+// getBlockFees calculates the gas used ratio and the gas prices at the given
+// percentiles of the gas used by a block.
+func (gpo *Oracle) getBlockFees(ctx context.Context, number uint64, percents []float64) blockFees {
+	block, err := gpo.backend.BlockByNumber(ctx, rpc.BlockNumber(number))
+	if block == nil {
+		if err == nil {
+			err = fmt.Errorf("block #%d not found", number)
+		}
+		return blockFees{number: number, err: err}
+	}
+	fees := blockFees{number: number}
+	if limit := block.GasLimit(); limit > 0 {
+		fees.gasUsedRatio = float64(block.GasUsed()) / float64(limit)
+	}
+	if len(percents) == 0 {
+		return fees
+	}
+	var receipts types.Receipts
+	if len(block.Transactions()) > 0 {
+		if receipts, err = gpo.backend.GetReceipts(ctx, block.Hash()); err != nil {
+			return blockFees{number: number, err: err}
+		}
+		if len(receipts) != len(block.Transactions()) {
+			return blockFees{number: number, err: fmt.Errorf("receipts of block #%d not found", number)}
+		}
+	}
+	txs := make(txsByGasPrice, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		txs[i] = txGasAndPrice{gasUsed: receipts[i].GasUsed, gasPrice: tx.GasPrice()}
+	}
+	fees.gasPrices = txs.percentiles(percents)
+	return fees
+}
+
+// pendingFees calculates the gas prices at the given percentiles of the gas
+// limits of the transactions in the pool, or nil if the pool is empty.
+func (gpo *Oracle) pendingFees(percents []float64) ([]*big.Int, error) {
+	if len(percents) == 0 {
+		return nil, nil
+	}
+	pending, err := gpo.backend.GetPoolTransactions()
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+	txs := make(txsByGasPrice, len(pending))
+	for i, tx := range pending {
+		txs[i] = txGasAndPrice{gasUsed: tx.Gas(), gasPrice: tx.GasPrice()}
+	}
+	return txs.percentiles(percents), nil
+}
@@ -0,0 +1,227 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package gasprice
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/types"
+	"github.com/haachain/go-haachain/internal/ethapi"
+	"github.com/haachain/go-haachain/rpc"
+)
+
+// testBackend serves a chain of blocks each holding a single transaction priced
+// at one wei above the block number and using a tenth of the block gas limit.
+// Only the methods needed by the fee history are implemented.
+type testBackend struct {
+	ethapi.Backend // Nil, panics on any method not overridden below
+
+	blocks   []*types.Block
+	receipts map[common.Hash]types.Receipts
+	pending  types.Transactions
+
+	active int32 // Number of block retrievals in progress
+	peak   int32 // Highest number of concurrent block retrievals
+}
+
+func newTestBackend(head uint64, pending types.Transactions) *testBackend {
+	backend := &testBackend{
+		receipts: make(map[common.Hash]types.Receipts),
+		pending:  pending,
+	}
+	for number := uint64(0); number <= head; number++ {
+		header := &types.Header{
+			Number:   new(big.Int).SetUint64(number),
+			GasLimit: 210000,
+			GasUsed:  21000,
+		}
+		txs := []*types.Transaction{types.NewTransaction(number, common.Address{}, new(big.Int), 21000, new(big.Int).SetUint64(number+1), nil)}
+		receipts := []*types.Receipt{{CumulativeGasUsed: 21000, GasUsed: 21000}}
+
+		block := types.NewBlock(header, txs, nil, receipts)
+		backend.blocks = append(backend.blocks, block)
+		backend.receipts[block.Hash()] = receipts
+	}
+	return backend
+}
+
+func (b *testBackend) block(number rpc.BlockNumber) *types.Block {
+	if number < 0 {
+		return b.blocks[len(b.blocks)-1]
+	}
+	if int(number) >= len(b.blocks) {
+		return nil
+	}
+	return b.blocks[number]
+}
+
+func (b *testBackend) HeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Header, error) {
+	if block := b.block(number); block != nil {
+		return block.Header(), nil
+	}
+	return nil, nil
+}
+
+func (b *testBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Block, error) {
+	active := atomic.AddInt32(&b.active, 1)
+	defer atomic.AddInt32(&b.active, -1)
+
+	for peak := atomic.LoadInt32(&b.peak); active > peak; peak = atomic.LoadInt32(&b.peak) {
+		if atomic.CompareAndSwapInt32(&b.peak, peak, active) {
+			break
+		}
+	}
+	return b.block(number), nil
+}
+
+func (b *testBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
+	return b.receipts[hash], nil
+}
+
+func (b *testBackend) GetPoolTransactions() (types.Transactions, error) {
+	return b.pending, nil
+}
+
+// Tests that the gas price percentiles are weighted by the gas used.
+func TestGasPricePercentiles(t *testing.T) {
+	txs := txsByGasPrice{
+		{gasUsed: 70, gasPrice: big.NewInt(3)},
+		{gasUsed: 10, gasPrice: big.NewInt(1)},
+		{gasUsed: 20, gasPrice: big.NewInt(2)},
+	}
+	percents := []float64{0, 10, 25, 50, 100}
+	want := []int64{1, 1, 2, 3, 3}
+
+	prices := txs.percentiles(percents)
+	if len(prices) != len(want) {
+		t.Fatalf("price count mismatch: have %d, want %d", len(prices), len(want))
+	}
+	for i, price := range prices {
+		if price.Int64() != want[i] {
+			t.Errorf("percentile %v: price mismatch: have %v, want %v", percents[i], price, want[i])
+		}
+	}
+	// Empty blocks should report zero prices
+	for i, price := range (txsByGasPrice{}).percentiles(percents) {
+		if price.Sign() != 0 {
+			t.Errorf("percentile %v: empty block price mismatch: have %v, want 0", percents[i], price)
+		}
+	}
+}
+
+// Tests that the fee history range is resolved and clamped correctly, and that
+// the block fees are assembled in order.
+func TestFeeHistory(t *testing.T) {
+	pool := types.Transactions{
+		types.NewTransaction(0, common.Address{}, new(big.Int), 30000, big.NewInt(5), nil),
+		types.NewTransaction(1, common.Address{}, new(big.Int), 10000, big.NewInt(7), nil),
+	}
+	tests := []struct {
+		head     uint64
+		pool     types.Transactions
+		blocks   int
+		last     rpc.BlockNumber
+		percents []float64
+		oldest   uint64
+		count    int
+		pending  []int64
+		err      error
+	}{
+		// Regular ranges ending at the head or an older block
+		{head: 100, blocks: 4, last: rpc.LatestBlockNumber, percents: []float64{50}, oldest: 97, count: 4},
+		{head: 100, blocks: 4, last: 50, percents: []float64{0, 100}, oldest: 47, count: 4},
+		{head: 100, blocks: 4, last: 50, oldest: 47, count: 4},
+
+		// Ranges that need to be clamped at the genesis or the history limit
+		{head: 100, blocks: 10, last: 3, percents: []float64{50}, oldest: 0, count: 4},
+		{head: 1100, blocks: 2000, last: rpc.LatestBlockNumber, percents: []float64{50}, oldest: 77, count: maxFeeHistory},
+
+		// Requests that can't be served
+		{head: 100, blocks: 4, last: 101, err: errRequestBeyondHead},
+		{head: 100, blocks: 4, last: rpc.LatestBlockNumber, percents: []float64{101}, err: errInvalidPercentile},
+		{head: 100, blocks: 4, last: rpc.LatestBlockNumber, percents: []float64{50, 10}, err: errInvalidPercentile},
+		{head: 100, blocks: 0, last: rpc.LatestBlockNumber, percents: []float64{50}, oldest: 0, count: 0},
+
+		// The pending block resolves to the head, with the prices estimated
+		// from the pool weighted by gas limit
+		{head: 100, pool: pool, blocks: 2, last: rpc.PendingBlockNumber, percents: []float64{0, 50, 90}, oldest: 99, count: 2, pending: []int64{5, 5, 7}},
+		{head: 100, blocks: 2, last: rpc.PendingBlockNumber, percents: []float64{50}, oldest: 99, count: 2},
+		{head: 100, pool: pool, blocks: 2, last: rpc.PendingBlockNumber, oldest: 99, count: 2},
+	}
+	for i, tt := range tests {
+		backend := newTestBackend(tt.head, tt.pool)
+		oracle := NewOracle(backend, Config{})
+
+		oldest, prices, ratios, pending, err := oracle.FeeHistory(context.Background(), tt.blocks, tt.last, tt.percents)
+		if tt.err != nil {
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err.Error()) {
+				t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: failed to retrieve fee history: %v", i, err)
+			continue
+		}
+		if oldest.Uint64() != tt.oldest {
+			t.Errorf("test %d: oldest block mismatch: have %v, want %d", i, oldest, tt.oldest)
+		}
+		if ratios == nil || len(ratios) != tt.count {
+			t.Errorf("test %d: gas used ratio count mismatch: have %d (nil %v), want %d", i, len(ratios), ratios == nil, tt.count)
+			continue
+		}
+		for j, ratio := range ratios {
+			if ratio != 0.1 {
+				t.Errorf("test %d, block %d: gas used ratio mismatch: have %v, want 0.1", i, tt.oldest+uint64(j), ratio)
+			}
+		}
+		if len(tt.percents) == 0 || tt.count == 0 {
+			if prices != nil {
+				t.Errorf("test %d: unexpected gas prices: %v", i, prices)
+			}
+		} else {
+			if len(prices) != tt.count {
+				t.Fatalf("test %d: gas price count mismatch: have %d, want %d", i, len(prices), tt.count)
+			}
+			for j, block := range prices {
+				want := int64(tt.oldest) + int64(j) + 1
+				for k, price := range block {
+					if price.Int64() != want {
+						t.Errorf("test %d, block %d, percentile %v: gas price mismatch: have %v, want %d", i, tt.oldest+uint64(j), tt.percents[k], price, want)
+					}
+				}
+			}
+		}
+		if len(pending) != len(tt.pending) {
+			t.Errorf("test %d: pending price count mismatch: have %d, want %d", i, len(pending), len(tt.pending))
+			continue
+		}
+		for j, price := range pending {
+			if price.Int64() != tt.pending[j] {
+				t.Errorf("test %d, percentile %v: pending price mismatch: have %v, want %d", i, tt.percents[j], price, tt.pending[j])
+			}
+		}
+		if peak := atomic.LoadInt32(&backend.peak); peak > maxBlockFetchers {
+			t.Errorf("test %d: concurrent block retrievals exceed limit: have %d, want at most %d", i, peak, maxBlockFetchers)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/haachain/go-haachain/core"
 	"github.com/haachain/go-haachain/haa/downloader"
 	"github.com/haachain/go-haachain/haa/gasprice"
+	"github.com/haachain/go-haachain/p2p/discover"
 )
 
 var _ = (*configMarshaling)(nil)
@@ -25,12 +26,17 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		SkipBcVersionCheck      bool `toml:"-"`
 		DatabaseHandles         int  `toml:"-"`
 		DatabaseCache           int
+		DatabaseFreezer         string
+		NoFreezer               bool
 		haaerbase               common.Address `toml:",omitempty"`
 		MinerThreads            int            `toml:",omitempty"`
 		ExtraData               hexutil.Bytes  `toml:",omitempty"`
 		GasPrice                *big.Int
+		MinerOrdering           string           `toml:",omitempty"`
+		MinerPriority           []common.Address `toml:",omitempty"`
 		haaash                  ethash.Config
 		TxPool                  core.TxPoolConfig
+		PrivateTxPeers          []discover.NodeID `toml:",omitempty"`
 		GPO                     gasprice.Config
 		EnablePreimageRecording bool
 		DocRoot                 string `toml:"-"`
@@ -44,12 +50,17 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.SkipBcVersionCheck = c.SkipBcVersionCheck
 	enc.DatabaseHandles = c.DatabaseHandles
 	enc.DatabaseCache = c.DatabaseCache
+	enc.DatabaseFreezer = c.DatabaseFreezer
+	enc.NoFreezer = c.NoFreezer
 	enc.haaerbase = c.haaerbase
 	enc.MinerThreads = c.MinerThreads
 	enc.ExtraData = c.ExtraData
 	enc.GasPrice = c.GasPrice
+	enc.MinerOrdering = c.MinerOrdering
+	enc.MinerPriority = c.MinerPriority
 	enc.haaash = c.haaash
 	enc.TxPool = c.TxPool
+	enc.PrivateTxPeers = c.PrivateTxPeers
 	enc.GPO = c.GPO
 	enc.EnablePreimageRecording = c.EnablePreimageRecording
 	enc.DocRoot = c.DocRoot
@@ -66,12 +77,17 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		SkipBcVersionCheck      *bool `toml:"-"`
 		DatabaseHandles         *int  `toml:"-"`
 		DatabaseCache           *int
+		DatabaseFreezer         *string
+		NoFreezer               *bool
 		haaerbase               *common.Address `toml:",omitempty"`
 		MinerThreads            *int            `toml:",omitempty"`
 		ExtraData               *hexutil.Bytes  `toml:",omitempty"`
 		GasPrice                *big.Int
+		MinerOrdering           *string          `toml:",omitempty"`
+		MinerPriority           []common.Address `toml:",omitempty"`
 		haaash                  *ethash.Config
 		TxPool                  *core.TxPoolConfig
+		PrivateTxPeers          []discover.NodeID `toml:",omitempty"`
 		GPO                     *gasprice.Config
 		EnablePreimageRecording *bool
 		DocRoot                 *string `toml:"-"`
@@ -104,6 +120,12 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.DatabaseCache != nil {
 		c.DatabaseCache = *dec.DatabaseCache
 	}
+	if dec.DatabaseFreezer != nil {
+		c.DatabaseFreezer = *dec.DatabaseFreezer
+	}
+	if dec.NoFreezer != nil {
+		c.NoFreezer = *dec.NoFreezer
+	}
 	if dec.haaerbase != nil {
 		c.haaerbase = *dec.haaerbase
 	}
@@ -116,12 +138,21 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.GasPrice != nil {
 		c.GasPrice = dec.GasPrice
 	}
+	if dec.MinerOrdering != nil {
+		c.MinerOrdering = *dec.MinerOrdering
+	}
+	if dec.MinerPriority != nil {
+		c.MinerPriority = dec.MinerPriority
+	}
 	if dec.haaash != nil {
 		c.haaash = *dec.haaash
 	}
 	if dec.TxPool != nil {
 		c.TxPool = *dec.TxPool
 	}
+	if dec.PrivateTxPeers != nil {
+		c.PrivateTxPeers = dec.PrivateTxPeers
+	}
 	if dec.GPO != nil {
 		c.GPO = *dec.GPO
 	}
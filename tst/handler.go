@@ -17,6 +17,7 @@
 package haa
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -35,11 +36,13 @@ import (
 	"github.com/haachain/go-haachain/haa/fetcher"
 	"github.com/haachain/go-haachain/haadb"
 	"github.com/haachain/go-haachain/event"
+	"github.com/haachain/go-haachain/light"
 	"github.com/haachain/go-haachain/log"
 	"github.com/haachain/go-haachain/p2p"
 	"github.com/haachain/go-haachain/p2p/discover"
 	"github.com/haachain/go-haachain/params"
 	"github.com/haachain/go-haachain/rlp"
+	"github.com/haachain/go-haachain/trie"
 )
 
 const (
@@ -63,10 +66,23 @@ func errResp(code errCode, format string, v ...interface{}) error {
 	return fmt.Errorf("%v - %v", code, fmt.Sprintf(format, v...))
 }
 
+// proofBlobs flattens a set of merkle proof nodes into a list of blobs suitable
+// for sending over the wire.
+func proofBlobs(proof *light.NodeSet) [][]byte {
+	nodes := proof.NodeList()
+
+	blobs := make([][]byte, len(nodes))
+	for i, node := range nodes {
+		blobs[i] = node
+	}
+	return blobs
+}
+
 type ProtocolManager struct {
 	networkId uint64
 
 	fastSync  uint32 // Flag whhaaer fast sync is enabled (gets disabled if we already have blocks)
+	snapSync  uint32 // Flag whhaaer fast sync should use snap state retrieval
 	acceptTxs uint32 // Flag whhaaer we're considered synchronised (enables transaction processing)
 
 	txpool      txPool
@@ -76,8 +92,12 @@ type ProtocolManager struct {
 
 	downloader *downloader.Downloader
 	fetcher    *fetcher.Fetcher
+	txFetcher  *fetcher.TxFetcher
 	peers      *peerSet
 
+	privatePeers map[discover.NodeID]bool // Trusted peers allowed to receive private transactions
+	privateLock  sync.RWMutex             // Protects the set of trusted private peers
+
 	SubProtocols []p2p.Protocol
 
 	eventMux      *event.TypeMux
@@ -101,30 +121,34 @@ type ProtocolManager struct {
 func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, networkId uint64, mux *event.TypeMux, txpool txPool, engine consensus.Engine, blockchain *core.BlockChain, chaindb haadb.Database) (*ProtocolManager, error) {
 	// Create the protocol manager with the base fields
 	manager := &ProtocolManager{
-		networkId:   networkId,
-		eventMux:    mux,
-		txpool:      txpool,
-		blockchain:  blockchain,
-		chainconfig: config,
-		peers:       newPeerSet(),
-		newPeerCh:   make(chan *peer),
-		noMorePeers: make(chan struct{}),
-		txsyncCh:    make(chan *txsync),
-		quitSync:    make(chan struct{}),
-	}
-	// Figure out whhaaer to allow fast sync or not
-	if mode == downloader.FastSync && blockchain.CurrentBlock().NumberU64() > 0 {
+		networkId:    networkId,
+		eventMux:     mux,
+		txpool:       txpool,
+		blockchain:   blockchain,
+		chainconfig:  config,
+		peers:        newPeerSet(),
+		newPeerCh:    make(chan *peer),
+		noMorePeers:  make(chan struct{}),
+		txsyncCh:     make(chan *txsync),
+		quitSync:     make(chan struct{}),
+		privatePeers: make(map[discover.NodeID]bool),
+	}
+	// Figure out whhaaer to allow fast (or snap) sync or not
+	if (mode == downloader.FastSync || mode == downloader.SnapSync) && blockchain.CurrentBlock().NumberU64() > 0 {
 		log.Warn("Blockchain not empty, fast sync disabled")
 		mode = downloader.FullSync
 	}
-	if mode == downloader.FastSync {
+	if mode == downloader.FastSync || mode == downloader.SnapSync {
 		manager.fastSync = uint32(1)
 	}
+	if mode == downloader.SnapSync {
+		manager.snapSync = uint32(1)
+	}
 	// Initiate a sub-protocol for every implemented version we can handle
 	manager.SubProtocols = make([]p2p.Protocol, 0, len(ProtocolVersions))
 	for i, version := range ProtocolVersions {
 		// Skip protocol version if incompatible with the mode of operation
-		if mode == downloader.FastSync && version < haa63 {
+		if (mode == downloader.FastSync || mode == downloader.SnapSync) && version < haa63 {
 			continue
 		}
 		// Compatible; initialise the sub-protocol
@@ -178,6 +202,18 @@ func NewProtocolManager(config *params.ChainConfig, mode downloader.SyncMode, ne
 	}
 	manager.fetcher = fetcher.New(blockchain.GetBlockByHash, validator, manager.BroadcastBlock, heighter, inserter, manager.removePeer)
 
+	hasTx := func(hash common.Hash) bool {
+		return txpool.Get(hash) != nil
+	}
+	fetchTxs := func(id string, hashes []common.Hash) error {
+		p := manager.peers.Peer(id)
+		if p == nil {
+			return errNotRegistered
+		}
+		return p.RequestTxs(hashes)
+	}
+	manager.txFetcher = fetcher.NewTxFetcher(hasTx, txpool.AddRemotes, fetchTxs)
+
 	return manager, nil
 }
 
@@ -191,6 +227,7 @@ func (pm *ProtocolManager) removePeer(id string) {
 
 	// Unregister the peer from the downloader and haachain peer set
 	pm.downloader.UnregisterPeer(id)
+	pm.txFetcher.Drop(id)
 	if err := pm.peers.Unregister(id); err != nil {
 		log.Error("Peer removal failed", "peer", id, "err", err)
 	}
@@ -207,6 +244,7 @@ func (pm *ProtocolManager) Start(maxPeers int) {
 	pm.txCh = make(chan core.TxPreEvent, txChanSize)
 	pm.txSub = pm.txpool.SubscribeTxPreEvent(pm.txCh)
 	go pm.txBroadcastLoop()
+	pm.txFetcher.Start()
 
 	// broadcast mined blocks
 	pm.minedBlockSub = pm.eventMux.Subscribe(core.NewMinedBlockEvent{})
@@ -229,6 +267,7 @@ func (pm *ProtocolManager) Stop() {
 
 	// Quit fetcher, txsyncLoop.
 	close(pm.quitSync)
+	pm.txFetcher.Stop()
 
 	// Disconnect existing sessions.
 	// This also closes the gate for any new registrations on the peer set.
@@ -288,7 +327,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	// If we're DAO hard-fork aware, validate any remote peer with regard to the hard-fork
 	if daoBlock := pm.chainconfig.DAOForkBlock; daoBlock != nil {
 		// Request the peer's DAO fork header for extra-data validation
-		if err := p.RequestHeadersByNumber(daoBlock.Uint64(), 1, 0, false); err != nil {
+		if err := p.requestHeadersByNumber(ownerHandler, 0, daoBlock.Uint64(), 1, 0, false); err != nil {
 			return err
 		}
 		// Start a timer to disconnect if the peer doesn't reply in time
@@ -326,6 +365,30 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 	}
 	defer msg.Discard()
 
+	// From haa/66 onwards requests and responses are wrapped with a request ID,
+	// unwrap them and make sure responses answer one of our pending requests.
+	// The owner's ID of the answered request is handed over with the response,
+	// zero meaning the response is to be matched by the peer only.
+	var (
+		reqID   uint64
+		owner   requestOwner
+		ownerID uint64
+	)
+	if p.version >= haa66 && (isRequestMsg(msg.Code) || isResponseMsg(msg.Code)) {
+		var packet requestPacket
+		if err := msg.Decode(&packet); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		reqID = packet.RequestId
+		msg.Payload, msg.Size = bytes.NewReader(packet.Payload), uint32(len(packet.Payload))
+
+		if isResponseMsg(msg.Code) {
+			var ok bool
+			if owner, ownerID, ok = p.fulfilRequest(reqID, msg.Code); !ok {
+				return errResp(ErrUnrequestedResponse, "msg %v, id %d", msg.Code, reqID)
+			}
+		}
+	}
 	// Handle the message depending on its contents
 	switch {
 	case msg.Code == StatusMsg:
@@ -409,7 +472,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				query.Origin.Number += query.Skip + 1
 			}
 		}
-		return p.SendBlockHeaders(headers)
+		return p.SendBlockHeaders(reqID, headers)
 
 	case msg.Code == BlockHeadersMsg:
 		// A batch of headers arrived to one of our previous requests
@@ -417,48 +480,34 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if err := msg.Decode(&headers); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
-		// If no headers were received, but we're expending a DAO fork check, maybe it's that
-		if len(headers) == 0 && p.forkDrop != nil {
-			// Possibly an empty reply to the fork header checks, sanity check TDs
-			verifyDAO := true
-
-			// If we already have a DAO header, we can check the peer's TD against it. If
-			// the peer's ahead of this, it too must have a reply to the DAO check
-			if daoHeader := pm.blockchain.GetHeaderByNumber(pm.chainconfig.DAOForkBlock.Uint64()); daoHeader != nil {
-				if _, td := p.Head(); td.Cmp(pm.blockchain.GetTd(daoHeader.Hash(), daoHeader.Number.Uint64())) >= 0 {
-					verifyDAO = false
+		// On haa/66 the request ID tells exactly who's waiting for the headers
+		if p.version >= haa66 {
+			switch owner {
+			case ownerHandler:
+				if _, err := pm.handleDAOChallenge(p, headers); err != nil {
+					return err
+				}
+			case ownerFetcher:
+				pm.fetcher.FilterHeaders(p.id, ownerID, headers, time.Now())
+			default:
+				if err := pm.downloader.DeliverHeaders(p.id, ownerID, headers); err != nil {
+					log.Debug("Failed to deliver headers", "err", err)
 				}
 			}
-			// If we're seemingly on the same chain, disable the drop timer
-			if verifyDAO {
-				p.Log().Debug("Seems to be on the same side of the DAO fork")
-				p.forkDrop.Stop()
-				p.forkDrop = nil
-				return nil
-			}
+			break
+		}
+		// If it's a potential reply to the DAO fork check, validate it
+		if done, err := pm.handleDAOChallenge(p, headers); done {
+			return err
 		}
 		// Filter out any explicitly requested headers, deliver the rest to the downloader
 		filter := len(headers) == 1
 		if filter {
-			// If it's a potential DAO fork check, validate against the rules
-			if p.forkDrop != nil && pm.chainconfig.DAOForkBlock.Cmp(headers[0].Number) == 0 {
-				// Disable the fork drop timer
-				p.forkDrop.Stop()
-				p.forkDrop = nil
-
-				// Validate the header and either drop the peer or continue
-				if err := misc.VerifyDAOHeaderExtraData(pm.chainconfig, headers[0]); err != nil {
-					p.Log().Debug("Verified to be on the other side of the DAO fork, dropping")
-					return err
-				}
-				p.Log().Debug("Verified to be on the same side of the DAO fork")
-				return nil
-			}
 			// Irrelevant of the fork checks, send the header to the fetcher just in case
-			headers = pm.fetcher.FilterHeaders(p.id, headers, time.Now())
+			headers = pm.fetcher.FilterHeaders(p.id, 0, headers, time.Now())
 		}
 		if len(headers) > 0 || !filter {
-			err := pm.downloader.DeliverHeaders(p.id, headers)
+			err := pm.downloader.DeliverHeaders(p.id, 0, headers)
 			if err != nil {
 				log.Debug("Failed to deliver headers", "err", err)
 			}
@@ -489,7 +538,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(data)
 			}
 		}
-		return p.SendBlockBodiesRLP(bodies)
+		return p.SendBlockBodiesRLP(reqID, bodies)
 
 	case msg.Code == BlockBodiesMsg:
 		// A batch of block bodies arrived to one of our previous requests
@@ -505,13 +554,22 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			trasactions[i] = body.Transactions
 			uncles[i] = body.Uncles
 		}
+		// On haa/66 the request ID tells exactly who's waiting for the bodies
+		if p.version >= haa66 {
+			if owner == ownerFetcher {
+				pm.fetcher.FilterBodies(p.id, ownerID, trasactions, uncles, time.Now())
+			} else if err := pm.downloader.DeliverBodies(p.id, ownerID, trasactions, uncles); err != nil {
+				log.Debug("Failed to deliver bodies", "err", err)
+			}
+			break
+		}
 		// Filter out any explicitly requested bodies, deliver the rest to the downloader
 		filter := len(trasactions) > 0 || len(uncles) > 0
 		if filter {
-			trasactions, uncles = pm.fetcher.FilterBodies(p.id, trasactions, uncles, time.Now())
+			trasactions, uncles = pm.fetcher.FilterBodies(p.id, 0, trasactions, uncles, time.Now())
 		}
 		if len(trasactions) > 0 || len(uncles) > 0 || !filter {
-			err := pm.downloader.DeliverBodies(p.id, trasactions, uncles)
+			err := pm.downloader.DeliverBodies(p.id, 0, trasactions, uncles)
 			if err != nil {
 				log.Debug("Failed to deliver bodies", "err", err)
 			}
@@ -542,7 +600,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(entry)
 			}
 		}
-		return p.SendNodeData(data)
+		return p.SendNodeData(reqID, data)
 
 	case p.version >= haa63 && msg.Code == NodeDataMsg:
 		// A batch of node state data arrived to one of our previous requests
@@ -551,7 +609,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
 		// Deliver all to the downloader
-		if err := pm.downloader.DeliverNodeData(p.id, data); err != nil {
+		if err := pm.downloader.DeliverNodeData(p.id, ownerID, data); err != nil {
 			log.Debug("Failed to deliver node state data", "err", err)
 		}
 
@@ -589,7 +647,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(encoded)
 			}
 		}
-		return p.SendReceiptsRLP(receipts)
+		return p.SendReceiptsRLP(reqID, receipts)
 
 	case p.version >= haa63 && msg.Code == ReceiptsMsg:
 		// A batch of receipts arrived to one of our previous requests
@@ -598,10 +656,200 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
 		// Deliver all to the downloader
-		if err := pm.downloader.DeliverReceipts(p.id, receipts); err != nil {
+		if err := pm.downloader.DeliverReceipts(p.id, ownerID, receipts); err != nil {
 			log.Debug("Failed to deliver receipts", "err", err)
 		}
 
+	case p.version >= haa64 && msg.Code == GetAccountRangeMsg:
+		// Decode the account range retrieval message
+		var req getAccountRangeData
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if req.Bytes > softResponseLimit {
+			req.Bytes = softResponseLimit
+		}
+		// Retrieve the requested state, returning an empty range if it's unavailable
+		tr, err := trie.New(req.Root, pm.blockchain.StateCache().TrieDB())
+		if err != nil {
+			return p.SendAccountRange(reqID, nil, nil)
+		}
+		// Gather accounts until the range end or the size limit is reached
+		var (
+			accounts []*accountData
+			size     uint64
+			last     common.Hash
+		)
+		it := trie.NewIterator(tr.NodeIterator(req.Origin[:]))
+		for size < req.Bytes && it.Next() {
+			hash := common.BytesToHash(it.Key)
+			accounts = append(accounts, &accountData{Hash: hash, Body: common.CopyBytes(it.Value)})
+			size += uint64(common.HashLength + len(it.Value))
+			last = hash
+
+			if bytes.Compare(hash[:], req.Limit[:]) >= 0 {
+				break
+			}
+		}
+		if it.Err != nil {
+			return p.SendAccountRange(reqID, nil, nil)
+		}
+		// Prove the boundaries of the range so the requester can verify it
+		proof := light.NewNodeSet()
+		if err := tr.Prove(req.Origin[:], 0, proof); err != nil {
+			log.Warn("Failed to prove account range", "origin", req.Origin, "err", err)
+			return p.SendAccountRange(reqID, nil, nil)
+		}
+		if last != (common.Hash{}) {
+			if err := tr.Prove(last[:], 0, proof); err != nil {
+				log.Warn("Failed to prove account range", "last", last, "err", err)
+				return p.SendAccountRange(reqID, nil, nil)
+			}
+		}
+		return p.SendAccountRange(reqID, accounts, proofBlobs(proof))
+
+	case p.version >= haa64 && msg.Code == AccountRangeMsg:
+		// A range of accounts arrived to one of our previous requests
+		var res accountRangeData
+		if err := msg.Decode(&res); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		hashes := make([]common.Hash, len(res.Accounts))
+		accounts := make([][]byte, len(res.Accounts))
+		for i, account := range res.Accounts {
+			hashes[i], accounts[i] = account.Hash, account.Body
+		}
+		// Deliver all to the downloader
+		if err := pm.downloader.DeliverAccountRange(p.id, ownerID, hashes, accounts, res.Proof); err != nil {
+			log.Debug("Failed to deliver account range", "err", err)
+		}
+
+	case p.version >= haa64 && msg.Code == GetStorageRangesMsg:
+		// Decode the storage ranges retrieval message
+		var req getStorageRangesData
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if req.Bytes > softResponseLimit {
+			req.Bytes = softResponseLimit
+		}
+		// Gather storage slots trie by trie until the size limit is reached
+		var (
+			slots [][]*storageData
+			proof *light.NodeSet
+			size  uint64
+		)
+		for i, root := range req.Roots {
+			// Don't start a new storage trie if the budget is already exhausted
+			if size >= req.Bytes {
+				break
+			}
+			tr, err := trie.New(root, pm.blockchain.StateCache().TrieDB())
+			if err != nil {
+				break
+			}
+			var origin common.Hash
+			if i == 0 {
+				origin = req.Origin
+			}
+			var (
+				storage []*storageData
+				last    common.Hash
+				abort   bool
+			)
+			it := trie.NewIterator(tr.NodeIterator(origin[:]))
+			for it.Next() {
+				if size >= req.Bytes && len(storage) > 0 {
+					abort = true
+					break
+				}
+				hash := common.BytesToHash(it.Key)
+				storage = append(storage, &storageData{Hash: hash, Body: common.CopyBytes(it.Value)})
+				size += uint64(common.HashLength + len(it.Value))
+				last = hash
+			}
+			if it.Err != nil {
+				break
+			}
+			slots = append(slots, storage)
+
+			// If the range is partial (either end), prove its boundaries and stop
+			if origin != (common.Hash{}) || abort {
+				proof = light.NewNodeSet()
+				if err := tr.Prove(origin[:], 0, proof); err != nil {
+					log.Warn("Failed to prove storage range", "origin", origin, "err", err)
+					return p.SendStorageRanges(reqID, nil, nil)
+				}
+				if last != (common.Hash{}) {
+					if err := tr.Prove(last[:], 0, proof); err != nil {
+						log.Warn("Failed to prove storage range", "last", last, "err", err)
+						return p.SendStorageRanges(reqID, nil, nil)
+					}
+				}
+				break
+			}
+		}
+		if proof == nil {
+			return p.SendStorageRanges(reqID, slots, nil)
+		}
+		return p.SendStorageRanges(reqID, slots, proofBlobs(proof))
+
+	case p.version >= haa64 && msg.Code == StorageRangesMsg:
+		// A batch of storage ranges arrived to one of our previous requests
+		var res storageRangesData
+		if err := msg.Decode(&res); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		hashes := make([][]common.Hash, len(res.Slots))
+		slots := make([][][]byte, len(res.Slots))
+		for i, storage := range res.Slots {
+			hashes[i] = make([]common.Hash, len(storage))
+			slots[i] = make([][]byte, len(storage))
+			for j, slot := range storage {
+				hashes[i][j], slots[i][j] = slot.Hash, slot.Body
+			}
+		}
+		// Deliver all to the downloader
+		if err := pm.downloader.DeliverStorageRanges(p.id, ownerID, hashes, slots, res.Proof); err != nil {
+			log.Debug("Failed to deliver storage ranges", "err", err)
+		}
+
+	case p.version >= haa64 && msg.Code == GetByteCodesMsg:
+		// Decode the bytecode retrieval message
+		var req getByteCodesData
+		if err := msg.Decode(&req); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		if req.Bytes > softResponseLimit {
+			req.Bytes = softResponseLimit
+		}
+		// Gather contract codes until the fetch or network limits is reached
+		var (
+			codes [][]byte
+			size  uint64
+		)
+		for _, hash := range req.Hashes {
+			if size >= req.Bytes || len(codes) >= downloader.MaxStateFetch {
+				break
+			}
+			if code, err := pm.blockchain.TrieNode(hash); err == nil {
+				codes = append(codes, code)
+				size += uint64(len(code))
+			}
+		}
+		return p.SendByteCodes(reqID, codes)
+
+	case p.version >= haa64 && msg.Code == ByteCodesMsg:
+		// A batch of contract codes arrived to one of our previous requests
+		var codes [][]byte
+		if err := msg.Decode(&codes); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Deliver all to the downloader
+		if err := pm.downloader.DeliverByteCodes(p.id, ownerID, codes); err != nil {
+			log.Debug("Failed to deliver bytecodes", "err", err)
+		}
+
 	case msg.Code == NewBlockHashesMsg:
 		var announces newBlockHashesData
 		if err := msg.Decode(&announces); err != nil {
@@ -619,7 +867,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			}
 		}
 		for _, block := range unknown {
-			pm.fetcher.Notify(p.id, block.Hash, block.Number, time.Now(), p.RequestOneHeader, p.RequestBodies)
+			pm.fetcher.Notify(p.id, block.Hash, block.Number, time.Now(), p.RequestOneHeader, p.RequestFetcherBodies)
 		}
 
 	case msg.Code == NewBlockMsg:
@@ -671,7 +919,103 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			}
 			p.MarkTransaction(tx.Hash())
 		}
-		pm.txpool.AddRemotes(txs)
+		pm.txFetcher.Enqueue(p.id, txs, false)
+
+	case p.version >= haa66 && msg.Code == PrivateTransactionsMsg:
+		// Private transactions arrived, make sure we have a valid and fresh chain to handle them
+		if atomic.LoadUint32(&pm.acceptTxs) == 0 {
+			break
+		}
+		var txs []*privateTxData
+		if err := msg.Decode(&txs); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		for i, tx := range txs {
+			if tx == nil || tx.Tx == nil {
+				return errResp(ErrDecode, "private transaction %d is nil", i)
+			}
+			p.MarkTransaction(tx.Tx.Hash())
+		}
+		// Deliver them straight to the pool, keeping them out of gossip
+		for _, tx := range txs {
+			if err := pm.txpool.AddRemotePrivate(tx.Tx, tx.Expiry); err != nil {
+				p.Log().Trace("Failed to add private transaction", "hash", tx.Tx.Hash(), "err", err)
+			}
+		}
+
+	case p.version >= haa65 && msg.Code == NewPooledTransactionHashesMsg:
+		// New transactions were announced, make sure we have a valid and fresh chain to handle them
+		if atomic.LoadUint32(&pm.acceptTxs) == 0 {
+			break
+		}
+		var hashes []common.Hash
+		if err := msg.Decode(&hashes); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		// Mark the hashes as present at the remote node and schedule the retrieval
+		for _, hash := range hashes {
+			p.MarkTransaction(hash)
+		}
+		pm.txFetcher.Notify(p.id, hashes)
+
+	case p.version >= haa65 && msg.Code == GetPooledTransactionsMsg:
+		// Decode the retrieval message
+		msgStream := rlp.NewStream(msg.Payload, uint64(msg.Size))
+		if _, err := msgStream.List(); err != nil {
+			return err
+		}
+		// Gather transactions until the fetch or network limits is reached
+		var (
+			hash   common.Hash
+			bytes  int
+			hashes []common.Hash
+			txs    []rlp.RawValue
+		)
+		for bytes < softResponseLimit && len(txs) < fetcher.MaxTxRetrievals {
+			// Retrieve the hash of the next transaction
+			if err := msgStream.Decode(&hash); err == rlp.EOL {
+				break
+			} else if err != nil {
+				return errResp(ErrDecode, "msg %v: %v", msg, err)
+			}
+			// Retrieve the requested transaction, skipping if unknown to us or private.
+			// Even trusted peers don't get private ones, as the reply would make them
+			// public on their side.
+			tx := pm.txpool.Get(hash)
+			if tx == nil {
+				continue
+			}
+			if _, private := pm.txpool.PrivateExpiry(hash); private {
+				continue
+			}
+			encoded, err := rlp.EncodeToBytes(tx)
+			if err != nil {
+				log.Error("Failed to encode transaction", "err", err)
+				continue
+			}
+			hashes = append(hashes, hash)
+			txs = append(txs, encoded)
+			bytes += len(encoded)
+		}
+		return p.SendPooledTransactionsRLP(reqID, hashes, txs)
+
+	case p.version >= haa65 && msg.Code == PooledTransactionsMsg:
+		// Transactions arrived to one of our previous requests
+		if atomic.LoadUint32(&pm.acceptTxs) == 0 {
+			break
+		}
+		var txs []*types.Transaction
+		if err := msg.Decode(&txs); err != nil {
+			return errResp(ErrDecode, "msg %v: %v", msg, err)
+		}
+		for i, tx := range txs {
+			// Validate and mark the remote transaction
+			if tx == nil {
+				return errResp(ErrDecode, "transaction %d is nil", i)
+			}
+			p.MarkTransaction(tx.Hash())
+		}
+		pm.txFetcher.Enqueue(p.id, txs, true)
 
 	default:
 		return errResp(ErrInvalidMsgCode, "%v", msg.Code)
@@ -679,6 +1023,51 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 	return nil
 }
 
+// handleDAOChallenge checks whhaaer a batch of headers is the reply to the DAO
+// fork challenge, validating the peer against the fork rules if so. The returned
+// flag reports whhaaer the headers were consumed by the challenge.
+func (pm *ProtocolManager) handleDAOChallenge(p *peer, headers []*types.Header) (bool, error) {
+	if p.forkDrop == nil {
+		return false, nil
+	}
+	// If no headers were received, but we're expending a DAO fork check, maybe it's that
+	if len(headers) == 0 {
+		// Possibly an empty reply to the fork header checks, sanity check TDs
+		verifyDAO := true
+
+		// If we already have a DAO header, we can check the peer's TD against it. If
+		// the peer's ahead of this, it too must have a reply to the DAO check
+		if daoHeader := pm.blockchain.GetHeaderByNumber(pm.chainconfig.DAOForkBlock.Uint64()); daoHeader != nil {
+			if _, td := p.Head(); td.Cmp(pm.blockchain.GetTd(daoHeader.Hash(), daoHeader.Number.Uint64())) >= 0 {
+				verifyDAO = false
+			}
+		}
+		// If we're seemingly on the same chain, disable the drop timer
+		if verifyDAO {
+			p.Log().Debug("Seems to be on the same side of the DAO fork")
+			p.forkDrop.Stop()
+			p.forkDrop = nil
+			return true, nil
+		}
+		return false, nil
+	}
+	// If it's a potential DAO fork check, validate against the rules
+	if len(headers) == 1 && pm.chainconfig.DAOForkBlock.Cmp(headers[0].Number) == 0 {
+		// Disable the fork drop timer
+		p.forkDrop.Stop()
+		p.forkDrop = nil
+
+		// Validate the header and either drop the peer or continue
+		if err := misc.VerifyDAOHeaderExtraData(pm.chainconfig, headers[0]); err != nil {
+			p.Log().Debug("Verified to be on the other side of the DAO fork, dropping")
+			return true, err
+		}
+		p.Log().Debug("Verified to be on the same side of the DAO fork")
+		return true, nil
+	}
+	return false, nil
+}
+
 // BroadcastBlock will either propagate a block to a subset of it's peers, or
 // will only announce it's availability (depending what's requested).
 func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
@@ -713,15 +1102,57 @@ func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
 }
 
 // BroadcastTx will propagate a transaction to all peers which are not known to
-// already have the given transaction.
+// already have the given transaction. The transaction is sent in full to the
+// square root of the peers, and only announced to the rest of them, who can
+// retrieve it on demand. Peers not supporting announcements receive it in full.
 func (pm *ProtocolManager) BroadcastTx(hash common.Hash, tx *types.Transaction) {
 	// Broadcast transaction to a batch of peers not knowing about it
 	peers := pm.peers.PeersWithoutTx(hash)
-	//FIXME include this again: peers = peers[:int(math.Sqrt(float64(len(peers))))]
-	for _, peer := range peers {
-		peer.SendTransactions(types.Transactions{tx})
+
+	// Private transactions are only ever sent directly to trusted peers, which
+	// must support relaying them as such
+	if expiry, private := pm.txpool.PrivateExpiry(hash); private {
+		var sent int
+		for _, peer := range peers {
+			if peer.version >= haa66 && pm.isPrivatePeer(peer.ID()) {
+				peer.SendPrivateTransactions([]*privateTxData{{Tx: tx, Expiry: expiry}})
+				sent++
+			}
+		}
+		log.Trace("Forwarded private transaction", "hash", hash, "recipients", sent)
+		return
+	}
+	direct := int(math.Sqrt(float64(len(peers))))
+
+	var sent, announced int
+	for i, peer := range peers {
+		if i < direct || peer.version < haa65 {
+			peer.SendTransactions(types.Transactions{tx})
+			sent++
+		} else {
+			peer.SendPooledTransactionHashes([]common.Hash{hash})
+			announced++
+		}
 	}
-	log.Trace("Broadcast transaction", "hash", hash, "recipients", len(peers))
+	log.Trace("Broadcast transaction", "hash", hash, "recipients", sent, "announced", announced)
+}
+
+// trustPrivatePeer allows the peer with the given node ID to receive private
+// transactions.
+func (pm *ProtocolManager) trustPrivatePeer(id discover.NodeID) {
+	pm.privateLock.Lock()
+	defer pm.privateLock.Unlock()
+
+	pm.privatePeers[id] = true
+}
+
+// isPrivatePeer reports whhaaer the peer with the given node ID is trusted with
+// private transactions.
+func (pm *ProtocolManager) isPrivatePeer(id discover.NodeID) bool {
+	pm.privateLock.RLock()
+	defer pm.privateLock.RUnlock()
+
+	return pm.privatePeers[id]
 }
 
 // Mined broadcast loop
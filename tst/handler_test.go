@@ -35,6 +35,7 @@ import (
 	"github.com/haachain/go-haachain/event"
 	"github.com/haachain/go-haachain/p2p"
 	"github.com/haachain/go-haachain/params"
+	"github.com/haachain/go-haachain/trie"
 )
 
 // Tests that protocol versions and modes of operations are matched up properly.
@@ -47,6 +48,7 @@ func TestProtocolCompatibility(t *testing.T) {
 	}{
 		{61, downloader.FullSync, true}, {62, downloader.FullSync, true}, {63, downloader.FullSync, true},
 		{61, downloader.FastSync, false}, {62, downloader.FastSync, false}, {63, downloader.FastSync, true},
+		{61, downloader.SnapSync, false}, {62, downloader.SnapSync, false}, {63, downloader.SnapSync, true}, {64, downloader.SnapSync, true},
 	}
 	// Make sure anything we screw up is restored
 	backup := ProtocolVersions
@@ -448,6 +450,65 @@ func testGetReceipt(t *testing.T, protocol int) {
 	}
 }
 
+// Tests that account ranges can be retrieved from a remote state trie and that
+// they are verifiable by their boundary proofs.
+func TestGetAccountRange64(t *testing.T) { testGetAccountRange(t, 64) }
+
+func testGetAccountRange(t *testing.T, protocol int) {
+	// Create a chain spreading some haaer over a few fresh accounts
+	signer := types.HomesteadSigner{}
+	generator := func(i int, block *core.BlockGen) {
+		tx, _ := types.SignTx(types.NewTransaction(block.TxNonce(testBank), common.Address{byte(i + 1)}, big.NewInt(1000), params.TxGas, nil, nil), signer, testBankKey)
+		block.AddTx(tx)
+	}
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 8, generator, nil)
+	peer, _ := newTestPeer("peer", protocol, pm, true)
+	defer peer.close()
+
+	root := pm.blockchain.CurrentBlock().Root()
+	limit := common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
+
+	// Request the entire account trie, and a range starting halfway through
+	for i, origin := range []common.Hash{{}, common.HexToHash("0x8000000000000000000000000000000000000000000000000000000000000000")} {
+		p2p.Send(peer.app, GetAccountRangeMsg, &getAccountRangeData{Root: root, Origin: origin, Limit: limit, Bytes: softResponseLimit})
+		msg, err := peer.app.ReadMsg()
+		if err != nil {
+			t.Fatalf("test %d: failed to read account range response: %v", i, err)
+		}
+		if msg.Code != AccountRangeMsg {
+			t.Fatalf("test %d: response packet code mismatch: have %x, want %x", i, msg.Code, AccountRangeMsg)
+		}
+		var res accountRangeData
+		if err := msg.Decode(&res); err != nil {
+			t.Fatalf("test %d: failed to decode account range: %v", i, err)
+		}
+		// The full trie contains the bank, the coinbase and the 8 funded accounts
+		if i == 0 && len(res.Accounts) != 10 {
+			t.Errorf("test %d: account count mismatch: have %d, want %d", i, len(res.Accounts), 10)
+		}
+		// Verify the range against the boundary proofs
+		proof, _ := haadb.NewMemDatabase()
+		for _, node := range res.Proof {
+			proof.Put(crypto.Keccak256(node), node)
+		}
+		keys, values := make([][]byte, len(res.Accounts)), make([][]byte, len(res.Accounts))
+		for j, account := range res.Accounts {
+			keys[j], values[j] = common.CopyBytes(account.Hash[:]), account.Body
+		}
+		var last []byte
+		if len(keys) > 0 {
+			last = keys[len(keys)-1]
+		}
+		cont, err := trie.VerifyRangeProof(root, origin[:], last, keys, values, proof)
+		if err != nil {
+			t.Fatalf("test %d: failed to verify account range: %v", i, err)
+		}
+		if cont {
+			t.Errorf("test %d: more accounts reported beyond the full range", i)
+		}
+	}
+}
+
 // Tests that post haa protocol handshake, DAO fork-enabled clients also execute
 // a DAO "challenge" verifying each others' DAO fork headers to ensure they're on
 // compatible chains.
@@ -88,9 +88,10 @@ func newTestProtocolManagerMust(t *testing.T, mode downloader.SyncMode, blocks i
 
 // testTxPool is a fake, helper transaction pool for testing purposes
 type testTxPool struct {
-	txFeed event.Feed
-	pool   []*types.Transaction        // Collection of all transactions
-	added  chan<- []*types.Transaction // Notification channel for new transactions
+	txFeed  event.Feed
+	pool    []*types.Transaction        // Collection of all transactions
+	private map[common.Hash]uint64      // Transactions marked private, mapped to their expiry
+	added   chan<- []*types.Transaction // Notification channel for new transactions
 
 	lock sync.RWMutex // Protects the transaction pool
 }
@@ -108,6 +109,46 @@ func (p *testTxPool) AddRemotes(txs []*types.Transaction) []error {
 	return make([]error, len(txs))
 }
 
+// Get retrieves the transaction from the pool with the given hash.
+func (p *testTxPool) Get(hash common.Hash) *types.Transaction {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	for _, tx := range p.pool {
+		if tx.Hash() == hash {
+			return tx
+		}
+	}
+	return nil
+}
+
+// AddRemotePrivate appends a private transaction to the pool, and notifies any
+// listeners if the addition channel is non nil
+func (p *testTxPool) AddRemotePrivate(tx *types.Transaction, expiry uint64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.private == nil {
+		p.private = make(map[common.Hash]uint64)
+	}
+	p.private[tx.Hash()] = expiry
+	p.pool = append(p.pool, tx)
+	if p.added != nil {
+		p.added <- []*types.Transaction{tx}
+	}
+	return nil
+}
+
+// PrivateExpiry returns the expiry block of the transaction with the given hash
+// and whhaaer it is marked private.
+func (p *testTxPool) PrivateExpiry(hash common.Hash) (uint64, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	expiry, ok := p.private[hash]
+	return expiry, ok
+}
+
 // Pending returns all the transactions known to the pool
 func (p *testTxPool) Pending() (map[common.Address]types.Transactions, error) {
 	p.lock.RLock()
@@ -42,6 +42,29 @@ const (
 	handshakeTimeout = 5 * time.Second
 )
 
+// requestTTL is the maximum time to wait for the response of a haa/66 request.
+// It is a variable to allow tests to shorten it.
+var requestTTL = 2 * time.Minute
+
+// requestOwner identifies the component that issued a request, allowing the
+// response to be dispatched back to it on haa/66.
+type requestOwner int
+
+const (
+	ownerDownloader requestOwner = iota // Request issued by the chain downloader
+	ownerFetcher                        // Request issued by the block fetcher
+	ownerTxFetcher                      // Request issued by the transaction fetcher
+	ownerHandler                        // Request issued by the protocol manager (DAO challenge)
+)
+
+// pendingRequest is a haa/66 request that is still waiting for its response.
+type pendingRequest struct {
+	code  uint64       // Message code of the expected response
+	owner requestOwner // Component waiting for the response
+	id    uint64       // ID the owner assigned to the request (0 = none)
+	timer *time.Timer  // Timer dropping the request if not answered in time
+}
+
 // PeerInfo represents a short summary of the haachain sub-protocol metadata known
 // about a connected peer.
 type PeerInfo struct {
@@ -65,6 +88,10 @@ type peer struct {
 
 	knownTxs    *set.Set // Set of transaction hashes known to be known by this peer
 	knownBlocks *set.Set // Set of block hashes known to be known by this peer
+
+	pending map[uint64]*pendingRequest // Requests waiting for a response, tracked by ID (haa/66)
+	reqID   uint64                     // Last request ID sent to the peer (haa/66)
+	reqLock sync.Mutex                 // Mutex protecting the pending requests
 }
 
 func newPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
@@ -77,6 +104,7 @@ func newPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter) *peer {
 		id:          fmt.Sprintf("%x", id[:8]),
 		knownTxs:    set.New(),
 		knownBlocks: set.New(),
+		pending:     make(map[uint64]*pendingRequest),
 	}
 }
 
@@ -139,6 +167,35 @@ func (p *peer) SendTransactions(txs types.Transactions) error {
 	return p2p.Send(p.rw, TxMsg, txs)
 }
 
+// SendPrivateTransactions sends private transactions to a trusted peer and
+// includes the hashes in its transaction hash set for future reference.
+func (p *peer) SendPrivateTransactions(txs []*privateTxData) error {
+	for _, tx := range txs {
+		p.knownTxs.Add(tx.Tx.Hash())
+	}
+	return p2p.Send(p.rw, PrivateTransactionsMsg, txs)
+}
+
+// SendPooledTransactionHashes announces the availability of a batch of
+// transactions through their hashes, and includes the hashes in the peer's
+// transaction hash set for future reference.
+func (p *peer) SendPooledTransactionHashes(hashes []common.Hash) error {
+	for _, hash := range hashes {
+		p.knownTxs.Add(hash)
+	}
+	return p2p.Send(p.rw, NewPooledTransactionHashesMsg, hashes)
+}
+
+// SendPooledTransactionsRLP sends a batch of requested transactions to the peer
+// from an already RLP encoded format, and includes their hashes in the peer's
+// transaction hash set for future reference.
+func (p *peer) SendPooledTransactionsRLP(id uint64, hashes []common.Hash, txs []rlp.RawValue) error {
+	for _, hash := range hashes {
+		p.knownTxs.Add(hash)
+	}
+	return p.respond(id, PooledTransactionsMsg, txs)
+}
+
 // SendNewBlockHashes announces the availability of a number of blocks through
 // a hash notification.
 func (p *peer) SendNewBlockHashes(hashes []common.Hash, numbers []uint64) error {
@@ -160,72 +217,222 @@ func (p *peer) SendNewBlock(block *types.Block, td *big.Int) error {
 }
 
 // SendBlockHeaders sends a batch of block headers to the remote peer.
-func (p *peer) SendBlockHeaders(headers []*types.Header) error {
-	return p2p.Send(p.rw, BlockHeadersMsg, headers)
+func (p *peer) SendBlockHeaders(id uint64, headers []*types.Header) error {
+	return p.respond(id, BlockHeadersMsg, headers)
 }
 
 // SendBlockBodies sends a batch of block contents to the remote peer.
-func (p *peer) SendBlockBodies(bodies []*blockBody) error {
-	return p2p.Send(p.rw, BlockBodiesMsg, blockBodiesData(bodies))
+func (p *peer) SendBlockBodies(id uint64, bodies []*blockBody) error {
+	return p.respond(id, BlockBodiesMsg, blockBodiesData(bodies))
 }
 
 // SendBlockBodiesRLP sends a batch of block contents to the remote peer from
 // an already RLP encoded format.
-func (p *peer) SendBlockBodiesRLP(bodies []rlp.RawValue) error {
-	return p2p.Send(p.rw, BlockBodiesMsg, bodies)
+func (p *peer) SendBlockBodiesRLP(id uint64, bodies []rlp.RawValue) error {
+	return p.respond(id, BlockBodiesMsg, bodies)
 }
 
 // SendNodeDataRLP sends a batch of arbitrary internal data, corresponding to the
 // hashes requested.
-func (p *peer) SendNodeData(data [][]byte) error {
-	return p2p.Send(p.rw, NodeDataMsg, data)
+func (p *peer) SendNodeData(id uint64, data [][]byte) error {
+	return p.respond(id, NodeDataMsg, data)
 }
 
 // SendReceiptsRLP sends a batch of transaction receipts, corresponding to the
 // ones requested from an already RLP encoded format.
-func (p *peer) SendReceiptsRLP(receipts []rlp.RawValue) error {
-	return p2p.Send(p.rw, ReceiptsMsg, receipts)
+func (p *peer) SendReceiptsRLP(id uint64, receipts []rlp.RawValue) error {
+	return p.respond(id, ReceiptsMsg, receipts)
+}
+
+// SendAccountRange sends a batch of consecutive accounts along with the merkle
+// proofs of the range boundaries.
+func (p *peer) SendAccountRange(id uint64, accounts []*accountData, proof [][]byte) error {
+	return p.respond(id, AccountRangeMsg, &accountRangeData{Accounts: accounts, Proof: proof})
+}
+
+// SendStorageRanges sends a batch of storage slot ranges, proving the last one
+// if it's incomplete.
+func (p *peer) SendStorageRanges(id uint64, slots [][]*storageData, proof [][]byte) error {
+	return p.respond(id, StorageRangesMsg, &storageRangesData{Slots: slots, Proof: proof})
+}
+
+// SendByteCodes sends a batch of contract bytecodes, corresponding to the hashes
+// requested.
+func (p *peer) SendByteCodes(id uint64, codes [][]byte) error {
+	return p.respond(id, ByteCodesMsg, codes)
 }
 
 // RequestOneHeader is a wrapper around the header query functions to fetch a
 // single header. It is used solely by the fetcher.
-func (p *peer) RequestOneHeader(hash common.Hash) error {
+func (p *peer) RequestOneHeader(id uint64, hash common.Hash) error {
 	p.Log().Debug("Fetching single header", "hash", hash)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: hash}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
+	return p.request(ownerFetcher, id, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: hash}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
 }
 
 // RequestHeadersByHash fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the hash of an origin block.
-func (p *peer) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
+func (p *peer) RequestHeadersByHash(id uint64, origin common.Hash, amount int, skip int, reverse bool) error {
 	p.Log().Debug("Fetching batch of headers", "count", amount, "fromhash", origin, "skip", skip, "reverse", reverse)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+	return p.request(ownerDownloader, id, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
 }
 
 // RequestHeadersByNumber fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the number of an origin block.
-func (p *peer) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
+func (p *peer) RequestHeadersByNumber(id uint64, origin uint64, amount int, skip int, reverse bool) error {
+	return p.requestHeadersByNumber(ownerDownloader, id, origin, amount, skip, reverse)
+}
+
+// requestHeadersByNumber is the implementation of RequestHeadersByNumber, also
+// used by the protocol manager to issue the DAO fork challenge.
+func (p *peer) requestHeadersByNumber(owner requestOwner, id uint64, origin uint64, amount int, skip int, reverse bool) error {
 	p.Log().Debug("Fetching batch of headers", "count", amount, "fromnum", origin, "skip", skip, "reverse", reverse)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+	return p.request(owner, id, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
 }
 
 // RequestBodies fetches a batch of blocks' bodies corresponding to the hashes
 // specified.
-func (p *peer) RequestBodies(hashes []common.Hash) error {
+func (p *peer) RequestBodies(id uint64, hashes []common.Hash) error {
+	return p.requestBodies(ownerDownloader, id, hashes)
+}
+
+// RequestFetcherBodies is a variant of RequestBodies used solely by the fetcher,
+// so that the response can be dispatched to it on haa/66.
+func (p *peer) RequestFetcherBodies(id uint64, hashes []common.Hash) error {
+	return p.requestBodies(ownerFetcher, id, hashes)
+}
+
+// requestBodies is the implementation of RequestBodies, tracking the request on
+// behalf of the given owner.
+func (p *peer) requestBodies(owner requestOwner, id uint64, hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of block bodies", "count", len(hashes))
-	return p2p.Send(p.rw, GetBlockBodiesMsg, hashes)
+	return p.request(owner, id, GetBlockBodiesMsg, hashes)
 }
 
 // RequestNodeData fetches a batch of arbitrary data from a node's known state
 // data, corresponding to the specified hashes.
-func (p *peer) RequestNodeData(hashes []common.Hash) error {
+func (p *peer) RequestNodeData(id uint64, hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of state data", "count", len(hashes))
-	return p2p.Send(p.rw, GetNodeDataMsg, hashes)
+	return p.request(ownerDownloader, id, GetNodeDataMsg, hashes)
 }
 
 // RequestReceipts fetches a batch of transaction receipts from a remote node.
-func (p *peer) RequestReceipts(hashes []common.Hash) error {
+func (p *peer) RequestReceipts(id uint64, hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of receipts", "count", len(hashes))
-	return p2p.Send(p.rw, GetReceiptsMsg, hashes)
+	return p.request(ownerDownloader, id, GetReceiptsMsg, hashes)
+}
+
+// RequestTxs fetches a batch of transactions from a remote node's pool,
+// corresponding to the hashes it announced.
+func (p *peer) RequestTxs(hashes []common.Hash) error {
+	p.Log().Debug("Fetching batch of transactions", "count", len(hashes))
+	return p.request(ownerTxFetcher, 0, GetPooledTransactionsMsg, hashes)
+}
+
+// RequestAccountRange fetches a batch of consecutive accounts from the account
+// trie rooted at root, starting with origin and stopping at limit.
+func (p *peer) RequestAccountRange(id uint64, root common.Hash, origin common.Hash, limit common.Hash, bytes uint64) error {
+	p.Log().Debug("Fetching range of accounts", "root", root, "origin", origin, "limit", limit, "bytes", common.StorageSize(bytes))
+	return p.request(ownerDownloader, id, GetAccountRangeMsg, &getAccountRangeData{Root: root, Origin: origin, Limit: limit, Bytes: bytes})
+}
+
+// RequestStorageRanges fetches the storage slots of a batch of storage tries,
+// starting at origin within the first one.
+func (p *peer) RequestStorageRanges(id uint64, roots []common.Hash, origin common.Hash, bytes uint64) error {
+	p.Log().Debug("Fetching ranges of storage slots", "count", len(roots), "origin", origin, "bytes", common.StorageSize(bytes))
+	return p.request(ownerDownloader, id, GetStorageRangesMsg, &getStorageRangesData{Roots: roots, Origin: origin, Bytes: bytes})
+}
+
+// RequestByteCodes fetches a batch of contract bytecodes corresponding to the
+// specified code hashes.
+func (p *peer) RequestByteCodes(id uint64, hashes []common.Hash, bytes uint64) error {
+	p.Log().Debug("Fetching batch of bytecodes", "count", len(hashes), "bytes", common.StorageSize(bytes))
+	return p.request(ownerDownloader, id, GetByteCodesMsg, &getByteCodesData{Hashes: hashes, Bytes: bytes})
+}
+
+// request sends a request message to the remote peer on behalf of an owner, which
+// identifies the request by the given ID. From haa/66 onwards the request is sent
+// with a fresh wire ID, tracked until the reply arrives so that it can be handed
+// back to the owner along with the owner's own ID.
+func (p *peer) request(owner requestOwner, id uint64, code uint64, data interface{}) error {
+	if p.version < haa66 {
+		return p2p.Send(p.rw, code, data)
+	}
+	payload, err := rlp.EncodeToBytes(data)
+	if err != nil {
+		return err
+	}
+	reqID := p.trackRequest(owner, id, responseCodes[code])
+	if err := p2p.Send(p.rw, code, &requestPacket{RequestId: reqID, Payload: payload}); err != nil {
+		p.fulfilRequest(reqID, responseCodes[code])
+		return err
+	}
+	return nil
+}
+
+// respond sends a reply message to the remote peer, wrapping it with the ID of
+// the request being answered from haa/66 onwards.
+func (p *peer) respond(id uint64, code uint64, data interface{}) error {
+	if p.version < haa66 {
+		return p2p.Send(p.rw, code, data)
+	}
+	payload, err := rlp.EncodeToBytes(data)
+	if err != nil {
+		return err
+	}
+	return p2p.Send(p.rw, code, &requestPacket{RequestId: id, Payload: payload})
+}
+
+// trackRequest assigns the next wire ID of the peer to an outgoing request,
+// recording the expected response, its owner and the owner's ID. Requests not
+// answered in time are dropped, any late response to them being considered
+// unsolicited.
+func (p *peer) trackRequest(owner requestOwner, ownerID uint64, code uint64) uint64 {
+	p.reqLock.Lock()
+	defer p.reqLock.Unlock()
+
+	p.reqID++
+	id := p.reqID
+
+	req := &pendingRequest{code: code, owner: owner, id: ownerID}
+	req.timer = time.AfterFunc(requestTTL, func() {
+		p.reqLock.Lock()
+		defer p.reqLock.Unlock()
+
+		if p.pending[id] == req {
+			delete(p.pending, id)
+		}
+	})
+	p.pending[id] = req
+	return id
+}
+
+// fulfilRequest marks a pending request as answered by a response with the given
+// message code, returning the owner waiting for it and the ID the owner assigned
+// to the request. False is returned if no such request is pending, i.e. the
+// response is unsolicited.
+func (p *peer) fulfilRequest(id uint64, code uint64) (requestOwner, uint64, bool) {
+	p.reqLock.Lock()
+	defer p.reqLock.Unlock()
+
+	req, ok := p.pending[id]
+	if !ok || req.code != code {
+		return 0, 0, false
+	}
+	req.timer.Stop()
+	delete(p.pending, id)
+	return req.owner, req.id, true
+}
+
+// dropRequests stops tracking all the pending requests of the peer, releasing
+// their expiration timers.
+func (p *peer) dropRequests() {
+	p.reqLock.Lock()
+	defer p.reqLock.Unlock()
+
+	for id, req := range p.pending {
+		req.timer.Stop()
+		delete(p.pending, id)
+	}
 }
 
 // Handshake executes the haa protocol handshake, negotiating version number,
@@ -334,9 +541,11 @@ func (ps *peerSet) Unregister(id string) error {
 	ps.lock.Lock()
 	defer ps.lock.Unlock()
 
-	if _, ok := ps.peers[id]; !ok {
+	p, ok := ps.peers[id]
+	if !ok {
 		return errNotRegistered
 	}
+	p.dropRequests()
 	delete(ps.peers, id)
 	return nil
 }
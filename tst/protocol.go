@@ -32,16 +32,19 @@ import (
 const (
 	haa62 = 62
 	haa63 = 63
+	haa64 = 64
+	haa65 = 65
+	haa66 = 66
 )
 
 // Official short name of the protocol used during capability negotiation.
 var ProtocolName = "haa"
 
 // Supported versions of the haa protocol (first is primary).
-var ProtocolVersions = []uint{haa63, haa62}
+var ProtocolVersions = []uint{haa66, haa65, haa64, haa63, haa62}
 
 // Number of implemented message corresponding to different protocol versions.
-var ProtocolLengths = []uint64{17, 8}
+var ProtocolLengths = []uint64{24, 23, 23, 17, 8}
 
 const ProtocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
 
@@ -57,13 +60,59 @@ const (
 	BlockBodiesMsg     = 0x06
 	NewBlockMsg        = 0x07
 
+	// Protocol messages belonging to haa/65
+	NewPooledTransactionHashesMsg = 0x08
+	GetPooledTransactionsMsg      = 0x09
+	PooledTransactionsMsg         = 0x0a
+
 	// Protocol messages belonging to haa/63
 	GetNodeDataMsg = 0x0d
 	NodeDataMsg    = 0x0e
 	GetReceiptsMsg = 0x0f
 	ReceiptsMsg    = 0x10
+
+	// Protocol messages belonging to haa/64
+	GetAccountRangeMsg  = 0x11
+	AccountRangeMsg     = 0x12
+	GetStorageRangesMsg = 0x13
+	StorageRangesMsg    = 0x14
+	GetByteCodesMsg     = 0x15
+	ByteCodesMsg        = 0x16
+
+	// Protocol messages belonging to haa/66
+	PrivateTransactionsMsg = 0x17
 )
 
+// responseCodes maps the request messages to the messages answering them. From
+// haa/66 onwards both are wrapped into a requestPacket, carrying the ID of the
+// request so that responses can be matched to the requests they answer.
+var responseCodes = map[uint64]uint64{
+	GetBlockHeadersMsg:       BlockHeadersMsg,
+	GetBlockBodiesMsg:        BlockBodiesMsg,
+	GetPooledTransactionsMsg: PooledTransactionsMsg,
+	GetNodeDataMsg:           NodeDataMsg,
+	GetReceiptsMsg:           ReceiptsMsg,
+	GetAccountRangeMsg:       AccountRangeMsg,
+	GetStorageRangesMsg:      StorageRangesMsg,
+	GetByteCodesMsg:          ByteCodesMsg,
+}
+
+// isRequestMsg reports whhaaer the message code is a request expecting a reply.
+func isRequestMsg(code uint64) bool {
+	_, ok := responseCodes[code]
+	return ok
+}
+
+// isResponseMsg reports whhaaer the message code is a reply to a request.
+func isResponseMsg(code uint64) bool {
+	for _, response := range responseCodes {
+		if response == code {
+			return true
+		}
+	}
+	return false
+}
+
 type errCode int
 
 const (
@@ -76,6 +125,7 @@ const (
 	ErrNoStatusMsg
 	ErrExtraStatusMsg
 	ErrSuspendedPeer
+	ErrUnrequestedResponse
 )
 
 func (e errCode) String() string {
@@ -93,12 +143,26 @@ var errorToString = map[int]string{
 	ErrNoStatusMsg:             "No status message",
 	ErrExtraStatusMsg:          "Extra status message",
 	ErrSuspendedPeer:           "Suspended peer",
+	ErrUnrequestedResponse:     "Unrequested response",
 }
 
 type txPool interface {
 	// AddRemotes should add the given transactions to the pool.
 	AddRemotes([]*types.Transaction) []error
 
+	// Get should return the transaction with the given hash, or nil if the
+	// pool doesn't contain it.
+	Get(hash common.Hash) *types.Transaction
+
+	// AddRemotePrivate should add the given transaction to the pool, keeping
+	// it private until the chain reaches the expiry block.
+	AddRemotePrivate(tx *types.Transaction, expiry uint64) error
+
+	// PrivateExpiry should return the expiry block of the transaction with the
+	// given hash and whhaaer it is private, and as such must only be sent to
+	// trusted peers.
+	PrivateExpiry(hash common.Hash) (uint64, bool)
+
 	// Pending should return pending transactions.
 	// The slice should be modifiable by the caller.
 	Pending() (map[common.Address]types.Transactions, error)
@@ -117,6 +181,13 @@ type statusData struct {
 	GenesisBlock    common.Hash
 }
 
+// requestPacket is the haa/66 network packet wrapping requests and responses,
+// tagging them with the ID of the request.
+type requestPacket struct {
+	RequestId uint64
+	Payload   rlp.RawValue // Original message content, as encoded before haa/66
+}
+
 // newBlockHashesData is the network packet for the block announcements.
 type newBlockHashesData []struct {
 	Hash   common.Hash // Hash of one particular block being announced
@@ -181,3 +252,58 @@ type blockBody struct {
 
 // blockBodiesData is the network packet for block content distribution.
 type blockBodiesData []*blockBody
+
+// getAccountRangeData represents an account range query.
+type getAccountRangeData struct {
+	Root   common.Hash // Root hash of the account trie to serve
+	Origin common.Hash // Hash of the first account to retrieve
+	Limit  common.Hash // Hash of the last account to retrieve
+	Bytes  uint64      // Soft limit at which to stop returning data
+}
+
+// accountRangeData is the network packet for an account range, along with the
+// merkle proofs of its two boundaries.
+type accountRangeData struct {
+	Accounts []*accountData // List of consecutive accounts from the trie
+	Proof    [][]byte       // List of trie nodes proving the account range
+}
+
+// accountData represents a single account in a query response.
+type accountData struct {
+	Hash common.Hash  // Hash of the account
+	Body rlp.RawValue // RLP encoded body of the account
+}
+
+// getStorageRangesData represents a storage slot range query over a batch of
+// storage tries. The origin only applies to the first trie.
+type getStorageRangesData struct {
+	Roots  []common.Hash // Root hashes of the storage tries to serve
+	Origin common.Hash   // Hash of the first storage slot to retrieve
+	Bytes  uint64        // Soft limit at which to stop returning data
+}
+
+// storageRangesData is the network packet for a batch of storage slot ranges.
+// The proof is only attached if the last range is incomplete.
+type storageRangesData struct {
+	Slots [][]*storageData // Lists of consecutive storage slots for the requested tries
+	Proof [][]byte         // Merkle proofs for the *last* slot range, if it's incomplete
+}
+
+// storageData represents a single storage slot in a query response.
+type storageData struct {
+	Hash common.Hash // Hash of the storage slot
+	Body []byte      // Data content of the slot
+}
+
+// getByteCodesData represents a contract bytecode query.
+type getByteCodesData struct {
+	Hashes []common.Hash // Code hashes to retrieve the code for
+	Bytes  uint64        // Soft limit at which to stop returning data
+}
+
+// privateTxData represents a single private transaction relayed to a trusted
+// peer, along with the block it must be dropped at.
+type privateTxData struct {
+	Tx     *types.Transaction
+	Expiry uint64
+}
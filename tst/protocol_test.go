@@ -19,6 +19,7 @@ package haa
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -123,6 +124,7 @@ func testRecvTransactions(t *testing.T, protocol int) {
 // This test checks that pending transactions are sent.
 func TestSendTransactions62(t *testing.T) { testSendTransactions(t, 62) }
 func TestSendTransactions63(t *testing.T) { testSendTransactions(t, 63) }
+func TestSendTransactions65(t *testing.T) { testSendTransactions(t, 65) }
 
 func testSendTransactions(t *testing.T, protocol int) {
 	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
@@ -146,18 +148,28 @@ func testSendTransactions(t *testing.T, protocol int) {
 			seen[tx.Hash()] = false
 		}
 		for n := 0; n < len(alltxs) && !t.Failed(); {
-			var txs []*types.Transaction
+			var hashes []common.Hash
 			msg, err := p.app.ReadMsg()
-			if err != nil {
+			switch {
+			case err != nil:
 				t.Errorf("%v: read error: %v", p.Peer, err)
-			} else if msg.Code != TxMsg {
+			case protocol < haa65 && msg.Code != TxMsg:
 				t.Errorf("%v: got code %d, want TxMsg", p.Peer, msg.Code)
+			case protocol >= haa65 && msg.Code != NewPooledTransactionHashesMsg:
+				t.Errorf("%v: got code %d, want NewPooledTransactionHashesMsg", p.Peer, msg.Code)
 			}
-			if err := msg.Decode(&txs); err != nil {
+			if protocol < haa65 {
+				var txs []*types.Transaction
+				if err := msg.Decode(&txs); err != nil {
+					t.Errorf("%v: %v", p.Peer, err)
+				}
+				for _, tx := range txs {
+					hashes = append(hashes, tx.Hash())
+				}
+			} else if err := msg.Decode(&hashes); err != nil {
 				t.Errorf("%v: %v", p.Peer, err)
 			}
-			for _, tx := range txs {
-				hash := tx.Hash()
+			for _, hash := range hashes {
 				seentx, want := seen[hash]
 				if seentx {
 					t.Errorf("%v: got tx more than once: %x", p.Peer, hash)
@@ -221,3 +233,299 @@ func TestGetBlockHeadersDataEncodeDecode(t *testing.T) {
 		}
 	}
 }
+
+// This test checks that announced transactions are retrieved from the peer and
+// added to the local pool.
+func TestRecvTransactionAnnounces65(t *testing.T) {
+	txAdded := make(chan []*types.Transaction)
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, txAdded)
+	pm.acceptTxs = 1 // mark synced to accept transactions
+	p, _ := newTestPeer("peer", haa65, pm, true)
+	defer pm.Stop()
+	defer p.close()
+
+	tx := newTestTransaction(testAccount, 0, 0)
+	if err := p2p.Send(p.app, NewPooledTransactionHashesMsg, []common.Hash{tx.Hash()}); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	// The transaction wasn't broadcast, so it should be explicitly requested
+	if err := p2p.ExpectMsg(p.app, GetPooledTransactionsMsg, []common.Hash{tx.Hash()}); err != nil {
+		t.Fatalf("transaction request mismatch: %v", err)
+	}
+	if err := p2p.Send(p.app, PooledTransactionsMsg, []interface{}{tx}); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	select {
+	case added := <-txAdded:
+		if len(added) != 1 {
+			t.Errorf("wrong number of added transactions: got %d, want 1", len(added))
+		} else if added[0].Hash() != tx.Hash() {
+			t.Errorf("added wrong tx hash: got %v, want %v", added[0].Hash(), tx.Hash())
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("no TxPreEvent received within 2 seconds")
+	}
+}
+
+// This test checks that pooled transactions can be retrieved by their hashes,
+// skipping the ones unknown.
+func TestGetPooledTransactions65(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	txs := []*types.Transaction{newTestTransaction(testAccount, 0, 0), newTestTransaction(testAccount, 1, 0)}
+	pm.txpool.AddRemotes(txs)
+
+	p, _ := newTestPeer("peer", haa65, pm, true)
+	defer p.close()
+
+	// Drain the announcements of the pending transactions
+	if err := p2p.ExpectMsg(p.app, NewPooledTransactionHashesMsg, []common.Hash{txs[0].Hash(), txs[1].Hash()}); err != nil {
+		t.Fatalf("transaction announce mismatch: %v", err)
+	}
+	unknown := common.Hash{0x01}
+	if err := p2p.Send(p.app, GetPooledTransactionsMsg, []common.Hash{txs[1].Hash(), unknown, txs[0].Hash()}); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	if err := p2p.ExpectMsg(p.app, PooledTransactionsMsg, []*types.Transaction{txs[1], txs[0]}); err != nil {
+		t.Fatalf("pooled transactions mismatch: %v", err)
+	}
+}
+
+// This test checks that haa/66 requests are answered with responses wrapped in
+// the ID of the request.
+func TestGetBlockHeaders66(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 4, nil, nil)
+	peer, _ := newTestPeer("peer", haa66, pm, true)
+	defer pm.Stop()
+	defer peer.close()
+
+	query, _ := rlp.EncodeToBytes(&getBlockHeadersData{Origin: hashOrNumber{Number: 1}, Amount: 2})
+	headers, _ := rlp.EncodeToBytes([]*types.Header{
+		pm.blockchain.GetHeaderByNumber(1),
+		pm.blockchain.GetHeaderByNumber(2),
+	})
+	for _, id := range []uint64{0, 1234, 1234} {
+		if err := p2p.Send(peer.app, GetBlockHeadersMsg, &requestPacket{RequestId: id, Payload: query}); err != nil {
+			t.Fatalf("send error: %v", err)
+		}
+		if err := p2p.ExpectMsg(peer.app, BlockHeadersMsg, &requestPacket{RequestId: id, Payload: headers}); err != nil {
+			t.Fatalf("id %d: headers mismatch: %v", id, err)
+		}
+	}
+}
+
+// This test checks that haa/66 responses are accepted only once and only for
+// requests that were actually made, dropping the peer otherwise.
+func TestUnrequestedResponse66(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	peer, errc := newTestPeer("peer", haa66, pm, true)
+	defer pm.Stop()
+	defer peer.close()
+
+	// Request some bodies and answer it with the ID of the request
+	hashes := []common.Hash{{0x01}, {0x02}}
+	go peer.peer.RequestBodies(1, hashes)
+
+	msg, err := peer.app.ReadMsg()
+	if err != nil {
+		t.Fatalf("failed to read request: %v", err)
+	}
+	if msg.Code != GetBlockBodiesMsg {
+		t.Fatalf("request code mismatch: have %d, want %d", msg.Code, GetBlockBodiesMsg)
+	}
+	var request requestPacket
+	if err := msg.Decode(&request); err != nil {
+		t.Fatalf("failed to decode request: %v", err)
+	}
+	var requested []common.Hash
+	if err := rlp.DecodeBytes(request.Payload, &requested); err != nil {
+		t.Fatalf("failed to decode request payload: %v", err)
+	}
+	if fmt.Sprint(requested) != fmt.Sprint(hashes) {
+		t.Fatalf("requested hashes mismatch: have %x, want %x", requested, hashes)
+	}
+	empty, _ := rlp.EncodeToBytes([]*blockBody{})
+	if err := p2p.Send(peer.app, BlockBodiesMsg, &requestPacket{RequestId: request.RequestId, Payload: empty}); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if peers := pm.peers.Len(); peers != 1 {
+		t.Fatalf("peer count mismatch: have %d, want %d", peers, 1)
+	}
+	// Replaying the response is unsolicited and should drop the peer
+	go p2p.Send(peer.app, BlockBodiesMsg, &requestPacket{RequestId: request.RequestId, Payload: empty})
+
+	select {
+	case err := <-errc:
+		want := errResp(ErrUnrequestedResponse, "msg %v, id %d", BlockBodiesMsg, request.RequestId)
+		if err == nil || err.Error() != want.Error() {
+			t.Fatalf("error mismatch: have %v, want %v", err, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("peer not dropped on unsolicited response")
+	}
+}
+
+// This test checks that haa/66 requests are sent with sequential per-peer IDs,
+// that responses are handed back with the ID of the owner's request and that
+// unanswered requests expire.
+func TestRequestTracking66(t *testing.T) {
+	defer func(ttl time.Duration) { requestTTL = ttl }(requestTTL)
+	requestTTL = 100 * time.Millisecond
+
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	peer, _ := newTestPeer("peer", haa66, pm, true)
+	defer pm.Stop()
+	defer peer.close()
+
+	readRequest := func() uint64 {
+		msg, err := peer.app.ReadMsg()
+		if err != nil {
+			t.Fatalf("failed to read request: %v", err)
+		}
+		defer msg.Discard()
+
+		var request requestPacket
+		if err := msg.Decode(&request); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		return request.RequestId
+	}
+	// Issue requests of two owners, using the same owner ID for both
+	hashes := []common.Hash{{0x01}}
+
+	go peer.peer.RequestBodies(7, hashes)
+	first := readRequest()
+
+	go peer.peer.RequestFetcherBodies(7, hashes)
+	second := readRequest()
+
+	if second != first+1 {
+		t.Fatalf("request ID not sequential: have %d, want %d", second, first+1)
+	}
+	// Answering the second request must report the fetcher's ID
+	owner, id, ok := peer.peer.fulfilRequest(second, BlockBodiesMsg)
+	if !ok || owner != ownerFetcher || id != 7 {
+		t.Fatalf("fulfilled request mismatch: have (%v, %d, %v), want (%v, %d, %v)", owner, id, ok, ownerFetcher, 7, true)
+	}
+	// The first request is never answered and must expire by itself
+	time.Sleep(3 * requestTTL)
+
+	if _, _, ok := peer.peer.fulfilRequest(first, BlockBodiesMsg); ok {
+		t.Fatalf("expired request fulfilled")
+	}
+	peer.peer.reqLock.Lock()
+	pending := len(peer.peer.pending)
+	peer.peer.reqLock.Unlock()
+
+	if pending != 0 {
+		t.Fatalf("pending request count mismatch: have %d, want %d", pending, 0)
+	}
+}
+
+// This test checks that private transactions are only ever relayed to trusted
+// peers, both during the initial sync and when broadcast, and always as private
+// ones so they aren't gossiped any further.
+func TestPrivateTransactions(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	txs := make([]*types.Transaction, 5)
+	for nonce := range txs {
+		txs[nonce] = newTestTransaction(testAccount, uint64(nonce), 0)
+	}
+	pool := pm.txpool.(*testTxPool)
+	pool.private = map[common.Hash]uint64{txs[1].Hash(): 10, txs[2].Hash(): 10}
+	pool.AddRemotes(txs[:2])
+
+	// Connect a trusted and an untrusted peer, only the trusted sees private ones
+	var (
+		genesis = pm.blockchain.Genesis()
+		head    = pm.blockchain.CurrentHeader()
+		td      = pm.blockchain.GetTd(head.Hash(), head.Number.Uint64())
+	)
+	trusted, _ := newTestPeer("trusted", haa66, pm, false)
+	defer trusted.close()
+	pm.trustPrivatePeer(trusted.ID())
+	trusted.handshake(t, td, head.Hash(), genesis.Hash())
+
+	if err := p2p.ExpectMsg(trusted.app, PrivateTransactionsMsg, []*privateTxData{{Tx: txs[1], Expiry: 10}}); err != nil {
+		t.Fatalf("trusted peer private sync mismatch: %v", err)
+	}
+	if err := p2p.ExpectMsg(trusted.app, NewPooledTransactionHashesMsg, []common.Hash{txs[0].Hash()}); err != nil {
+		t.Fatalf("trusted peer public sync mismatch: %v", err)
+	}
+	untrusted, _ := newTestPeer("untrusted", haa63, pm, true)
+	defer untrusted.close()
+
+	if err := p2p.ExpectMsg(untrusted.app, TxMsg, txs[:1]); err != nil {
+		t.Fatalf("untrusted peer sync mismatch: %v", err)
+	}
+	// Private transactions must not be served on request, not even to trusted
+	// peers, as they would be public on their side
+	request, _ := rlp.EncodeToBytes([]common.Hash{txs[1].Hash(), txs[0].Hash()})
+	if err := p2p.Send(trusted.app, GetPooledTransactionsMsg, &requestPacket{RequestId: 1, Payload: request}); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	response, _ := rlp.EncodeToBytes([]*types.Transaction{txs[0]})
+	if err := p2p.ExpectMsg(trusted.app, PooledTransactionsMsg, &requestPacket{RequestId: 1, Payload: response}); err != nil {
+		t.Fatalf("pooled transactions mismatch: %v", err)
+	}
+	// Broadcast a private and a public transaction, the private one should only
+	// reach the trusted peer. The sends block until read, so broadcast in the
+	// background and check the two peers concurrently.
+	go func() {
+		pm.BroadcastTx(txs[2].Hash(), txs[2])
+		pm.BroadcastTx(txs[3].Hash(), txs[3])
+	}()
+	errc := make(chan error, 2)
+	go func() {
+		if err := p2p.ExpectMsg(trusted.app, PrivateTransactionsMsg, []*privateTxData{{Tx: txs[2], Expiry: 10}}); err != nil {
+			errc <- fmt.Errorf("trusted peer private broadcast mismatch: %v", err)
+			return
+		}
+		// The public transaction is either sent in full or announced
+		msg, err := trusted.app.ReadMsg()
+		if err != nil {
+			errc <- fmt.Errorf("trusted peer public broadcast failed: %v", err)
+			return
+		}
+		msg.Discard()
+		if msg.Code != TxMsg && msg.Code != NewPooledTransactionHashesMsg {
+			errc <- fmt.Errorf("trusted peer public broadcast code mismatch: have %d, want %d or %d", msg.Code, TxMsg, NewPooledTransactionHashesMsg)
+			return
+		}
+		errc <- nil
+	}()
+	go func() {
+		if err := p2p.ExpectMsg(untrusted.app, TxMsg, txs[3:4]); err != nil {
+			errc <- fmt.Errorf("untrusted peer public broadcast mismatch: %v", err)
+			return
+		}
+		errc <- nil
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errc:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("broadcast not received within 2 seconds")
+		}
+	}
+	// Private transactions relayed by a peer must stay private in the pool
+	atomic.StoreUint32(&pm.acceptTxs, 1)
+	if err := p2p.Send(trusted.app, PrivateTransactionsMsg, []*privateTxData{{Tx: txs[4], Expiry: 20}}); err != nil {
+		t.Fatalf("send error: %v", err)
+	}
+	for start := time.Now(); pool.Get(txs[4].Hash()) == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("relayed private transaction not pooled")
+		}
+	}
+	if expiry, ok := pool.PrivateExpiry(txs[4].Hash()); !ok || expiry != 20 {
+		t.Fatalf("relayed private transaction expiry mismatch: have %d (private %v), want 20", expiry, ok)
+	}
+}
@@ -38,30 +38,45 @@ const (
 )
 
 type txsync struct {
-	p   *peer
-	txs []*types.Transaction
+	p       *peer
+	txs     []*types.Transaction
+	private []*privateTxData
 }
 
 // syncTransactions starts sending all currently pending transactions to the given peer.
 func (pm *ProtocolManager) syncTransactions(p *peer) {
-	var txs types.Transactions
+	var (
+		txs     types.Transactions
+		private []*privateTxData
+		trusted = p.version >= haa66 && pm.isPrivatePeer(p.ID())
+	)
 	pending, _ := pm.txpool.Pending()
 	for _, batch := range pending {
-		txs = append(txs, batch...)
+		for _, tx := range batch {
+			// Private transactions are only relayed to trusted peers, as such
+			if expiry, ok := pm.txpool.PrivateExpiry(tx.Hash()); ok {
+				if trusted {
+					private = append(private, &privateTxData{Tx: tx, Expiry: expiry})
+				}
+				continue
+			}
+			txs = append(txs, tx)
+		}
 	}
-	if len(txs) == 0 {
+	if len(txs) == 0 && len(private) == 0 {
 		return
 	}
 	select {
-	case pm.txsyncCh <- &txsync{p, txs}:
+	case pm.txsyncCh <- &txsync{p, txs, private}:
 	case <-pm.quitSync:
 	}
 }
 
 // txsyncLoop takes care of the initial transaction sync for each new
 // connection. When a new peer appears, we relay all currently pending
-// transactions. In order to minimise egress bandwidth usage, we send
-// the transactions in small packs to one peer at a time.
+// transactions, or only announce them if the peer supports haa/65. In order
+// to minimise egress bandwidth usage, we send the transactions in small packs
+// to one peer at a time.
 func (pm *ProtocolManager) txsyncLoop() {
 	var (
 		pending = make(map[discover.NodeID]*txsync)
@@ -72,13 +87,30 @@ func (pm *ProtocolManager) txsyncLoop() {
 
 	// send starts a sending a pack of transactions from the sync.
 	send := func(s *txsync) {
+		// Private transactions are sent in full, all in a pack of their own
+		if len(s.private) > 0 {
+			private := s.private
+			s.private = nil
+			if len(s.txs) == 0 {
+				delete(pending, s.p.ID())
+			}
+			sending = true
+			pack.p = s.p
+			s.p.Log().Trace("Sending batch of private transactions", "count", len(private))
+			go func() { done <- pack.p.SendPrivateTransactions(private) }()
+			return
+		}
 		// Fill pack with transactions up to the target size.
 		size := common.StorageSize(0)
 		pack.p = s.p
 		pack.txs = pack.txs[:0]
 		for i := 0; i < len(s.txs) && size < txsyncPackSize; i++ {
 			pack.txs = append(pack.txs, s.txs[i])
-			size += s.txs[i].Size()
+			if s.p.version >= haa65 {
+				size += common.HashLength
+			} else {
+				size += s.txs[i].Size()
+			}
 		}
 		// Remove the transactions that will be sent.
 		s.txs = s.txs[:copy(s.txs, s.txs[len(pack.txs):])]
@@ -86,8 +118,18 @@ func (pm *ProtocolManager) txsyncLoop() {
 			delete(pending, s.p.ID())
 		}
 		// Send the pack in the background.
-		s.p.Log().Trace("Sending batch of transactions", "count", len(pack.txs), "bytes", size)
 		sending = true
+		if s.p.version >= haa65 {
+			// Peers supporting announcements retrieve the transactions on demand
+			hashes := make([]common.Hash, len(pack.txs))
+			for i, tx := range pack.txs {
+				hashes[i] = tx.Hash()
+			}
+			s.p.Log().Trace("Announcing batch of transactions", "count", len(hashes), "bytes", size)
+			go func() { done <- pack.p.SendPooledTransactionHashes(hashes) }()
+			return
+		}
+		s.p.Log().Trace("Sending batch of transactions", "count", len(pack.txs), "bytes", size)
 		go func() { done <- pack.p.SendTransactions(pack.txs) }()
 	}
 
@@ -179,6 +221,9 @@ func (pm *ProtocolManager) synchronise(peer *peer) {
 	if atomic.LoadUint32(&pm.fastSync) == 1 {
 		// Fast sync was explicitly requested, and explicitly granted
 		mode = downloader.FastSync
+		if atomic.LoadUint32(&pm.snapSync) == 1 {
+			mode = downloader.SnapSync
+		}
 	} else if currentBlock.NumberU64() == 0 && pm.blockchain.CurrentFastBlock().NumberU64() > 0 {
 		// The database seems empty as the current block is the genesis. Yet the fast
 		// block is ahead, so fast sync was enabled for this node at a certain point.
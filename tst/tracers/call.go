@@ -0,0 +1,293 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/haachain/go-haachain/accounts/abi"
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/common/hexutil"
+	"github.com/haachain/go-haachain/core/vm"
+)
+
+// callFrame is a single internal call reported by the call tracer. The field
+// order matches the output of the JavaScript callTracer.
+type callFrame struct {
+	Type    string          `json:"type"`
+	From    *common.Address `json:"from,omitempty"`
+	To      *common.Address `json:"to,omitempty"`
+	Value   *hexutil.Big    `json:"value,omitempty"`
+	Gas     *hexutil.Uint64 `json:"gas,omitempty"`
+	GasUsed *hexutil.Uint64 `json:"gasUsed,omitempty"`
+	Input   *hexutil.Bytes  `json:"input,omitempty"`
+	Output  *hexutil.Bytes  `json:"output,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Reason  string          `json:"revertReason,omitempty"`
+	Time    string          `json:"time,omitempty"`
+	Calls   []*callFrame    `json:"calls,omitempty"`
+
+	gasIn   uint64 // Gas available before the call opcode executed
+	gasCost uint64 // Gas charged by the call opcode itself
+	outOff  int64  // Memory offset of the call's return data
+	outLen  int64  // Memory length of the call's return data
+}
+
+// callTracer is a native implementation of the JavaScript callTracer, which
+// extracts and reports all the internal calls made by a transaction.
+type callTracer struct {
+	callstack []*callFrame // Current recursive call stack of the EVM execution
+	descended bool         // Whhaaer we've just descended into an inner call
+
+	ctx callFrame // Outer transaction context gathered at start and end
+
+	interrupt uint32 // Atomic flag to signal execution interruption
+	reason    error  // Textual reason for the interruption
+}
+
+// newCallTracer creates a native call tracer. It accepts no configuration.
+func newCallTracer(config json.RawMessage) (ResultTracer, error) {
+	return &callTracer{callstack: []*callFrame{{}}}, nil
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *callTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.ctx.Type = "CALL"
+	if create {
+		t.ctx.Type = "CREATE"
+	}
+	t.ctx.From, t.ctx.To = &from, &to
+	t.ctx.Input = (*hexutil.Bytes)(&input)
+	t.ctx.Gas = (*hexutil.Uint64)(&gas)
+	t.ctx.Value = (*hexutil.Big)(new(big.Int).Set(value))
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *callTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		return nil
+	}
+	// Capture any errors immediately
+	if err != nil {
+		t.fault(err)
+		return nil
+	}
+	switch op {
+	case vm.CREATE:
+		// If a new contract is being created, add to the call stack
+		inOff := peekStack(stack, 1).Int64()
+		inEnd := inOff + peekStack(stack, 2).Int64()
+
+		from := contract.Address()
+		input := hexutil.Bytes(sliceMemory(memory, inOff, inEnd))
+		t.callstack = append(t.callstack, &callFrame{
+			Type:    op.String(),
+			From:    &from,
+			Input:   &input,
+			Value:   (*hexutil.Big)(new(big.Int).Set(peekStack(stack, 0))),
+			gasIn:   gas,
+			gasCost: cost,
+		})
+		t.descended = true
+		return nil
+
+	case vm.SELFDESTRUCT:
+		// If a contract is being self destructed, gather that as a subcall too
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, &callFrame{Type: op.String()})
+		return nil
+
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		// If a new method invocation is being done, add to the call stack
+		to := common.BigToAddress(peekStack(stack, 1))
+		if isPrecompiled(to) {
+			return nil
+		}
+		off := 1
+		if op == vm.DELEGATECALL || op == vm.STATICCALL {
+			off = 0
+		}
+		inOff := peekStack(stack, 2+off).Int64()
+		inEnd := inOff + peekStack(stack, 3+off).Int64()
+
+		from := contract.Address()
+		input := hexutil.Bytes(sliceMemory(memory, inOff, inEnd))
+		call := &callFrame{
+			Type:    op.String(),
+			From:    &from,
+			To:      &to,
+			Input:   &input,
+			gasIn:   gas,
+			gasCost: cost,
+			outOff:  peekStack(stack, 4+off).Int64(),
+			outLen:  peekStack(stack, 5+off).Int64(),
+		}
+		if op != vm.DELEGATECALL && op != vm.STATICCALL {
+			call.Value = (*hexutil.Big)(new(big.Int).Set(peekStack(stack, 2)))
+		}
+		t.callstack = append(t.callstack, call)
+		t.descended = true
+		return nil
+	}
+	// If we've just descended into an inner call, retrieve it's true allowance. We
+	// need to extract if from within the call as there may be funky gas dynamics
+	// with regard to requested and actually given gas (2300 stipend, 63/64 rule).
+	if t.descended {
+		if depth >= len(t.callstack) {
+			t.callstack[len(t.callstack)-1].Gas = (*hexutil.Uint64)(&gas)
+		}
+		t.descended = false
+	}
+	// If an existing call is returning with a revert, flag the error and
+	// decode the revert reason if the contract supplied one
+	if op == vm.REVERT {
+		call := t.callstack[len(t.callstack)-1]
+		call.Error = "execution reverted"
+
+		off := peekStack(stack, 0).Int64()
+		if reason, err := abi.UnpackRevert(sliceMemory(memory, off, off+peekStack(stack, 1).Int64())); err == nil {
+			call.Reason = reason
+		}
+		return nil
+	}
+	t.exit(env, gas, depth, stack, memory)
+	return nil
+}
+
+// exit pops the topmost call off the stack if execution returned from it into
+// the parent context, filling in the outcome of the call.
+func (t *callTracer) exit(env *vm.EVM, gas uint64, depth int, stack *vm.Stack, memory *vm.Memory) {
+	if depth != len(t.callstack)-1 {
+		return
+	}
+	// Pop off the last call and get the execution results
+	call := t.callstack[len(t.callstack)-1]
+	t.callstack = t.callstack[:len(t.callstack)-1]
+
+	if call.Type == vm.CREATE.String() {
+		// If the call was a contract creation, retrieve the new address and code
+		gasUsed := call.gasIn - call.gasCost - gas
+		call.GasUsed = (*hexutil.Uint64)(&gasUsed)
+
+		if ret := peekStack(stack, 0); ret.Sign() != 0 {
+			to := common.BigToAddress(ret)
+			output := hexutil.Bytes(env.StateDB.GetCode(to))
+			call.To, call.Output = &to, &output
+		} else if call.Error == "" {
+			call.Error = "internal failure"
+		}
+	} else {
+		// If the call was a plain method invocation, retrieve the result
+		if call.Gas != nil {
+			gasUsed := call.gasIn - call.gasCost + uint64(*call.Gas) - gas
+			call.GasUsed = (*hexutil.Uint64)(&gasUsed)
+
+			if ret := peekStack(stack, 0); ret.Sign() != 0 {
+				output := hexutil.Bytes(sliceMemory(memory, call.outOff, call.outOff+call.outLen))
+				call.Output = &output
+			} else if call.Error == "" {
+				call.Error = "internal failure"
+			}
+		}
+	}
+	parent := t.callstack[len(t.callstack)-1]
+	parent.Calls = append(parent.Calls, call)
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *callTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		return nil
+	}
+	t.fault(err)
+	return nil
+}
+
+// fault pops the topmost call off the stack, flagging it with the given error,
+// unless the call already failed (e.g. reverted) before.
+func (t *callTracer) fault(err error) {
+	if t.callstack[len(t.callstack)-1].Error != "" {
+		return
+	}
+	call := t.callstack[len(t.callstack)-1]
+	t.callstack = t.callstack[:len(t.callstack)-1]
+
+	call.Error = err.Error()
+	if call.Gas != nil {
+		gasUsed := *call.Gas
+		call.GasUsed = &gasUsed
+	}
+	// Flatten the failed call into its caller
+	if len(t.callstack) > 0 {
+		parent := t.callstack[len(t.callstack)-1]
+		parent.Calls = append(parent.Calls, call)
+		return
+	}
+	t.callstack = append(t.callstack, call)
+}
+
+// CaptureEnter is called when the EVM enters a new internal call frame.
+func (t *callTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureExit is called when the EVM returns from an internal call frame.
+func (t *callTracer) CaptureExit(output []byte, gasUsed uint64, err error) error {
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *callTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	t.ctx.Output = (*hexutil.Bytes)(&output)
+	t.ctx.GasUsed = (*hexutil.Uint64)(&gasUsed)
+	t.ctx.Time = d.String()
+
+	if err != nil {
+		t.ctx.Error = err.Error()
+	}
+	return nil
+}
+
+// GetResult returns the outer call with all the internal calls nested within,
+// or the reason of the interruption if tracing was stopped.
+func (t *callTracer) GetResult() (json.RawMessage, error) {
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		return nil, t.reason
+	}
+	result := t.ctx
+	result.Calls = t.callstack[0].Calls
+
+	if t.callstack[0].Error != "" {
+		result.Error = t.callstack[0].Error
+		result.Reason = t.callstack[0].Reason
+	}
+	if result.Error != "" {
+		result.Output = nil
+	}
+	return json.Marshal(&result)
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *callTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/core/vm"
+	"github.com/haachain/go-haachain/log"
+)
+
+// ResultTracer is a vm.Tracer which can be interrupted midway and which
+// assembles its findings into a JSON result after execution finishes. Both the
+// JavaScript Tracer and the native Go tracers implement it.
+type ResultTracer interface {
+	vm.Tracer
+
+	// GetResult returns the JSON encoded outcome of the tracing, or any error
+	// accumulated during execution.
+	GetResult() (json.RawMessage, error)
+
+	// Stop terminates execution of the tracer at the first opportune moment.
+	Stop(err error)
+}
+
+// native contains the natively implemented tracers by name. They shadow the
+// JavaScript tracers of the same name, producing identical output in a
+// fraction of the time.
+var native = map[string]func(config json.RawMessage) (ResultTracer, error){
+	"callTracer":     newCallTracer,
+	"prestateTracer": newPrestateTracer,
+	"4byteTracer":    newFourByteTracer,
+}
+
+// NewTracer creates a tracer for the given name or code. If a native tracer is
+// registered under the name it is used with the supplied configuration,
+// otherwise the code is handed over to the JavaScript engine.
+func NewTracer(code string, config json.RawMessage) (ResultTracer, error) {
+	if ctor, ok := native[code]; ok {
+		return ctor(config)
+	}
+	tracer, err := New(code)
+	if err != nil {
+		return nil, err
+	}
+	return tracer, nil
+}
+
+// isPrecompiled reports whhaaer the address belongs to a precompiled contract,
+// matching the check exposed to the JavaScript tracers.
+func isPrecompiled(addr common.Address) bool {
+	_, ok := vm.PrecompiledContractsByzantium[addr]
+	return ok
+}
+
+// peekStack returns the nth-from-the-top element of the stack, or zero if the
+// stack is not deep enough.
+func peekStack(stack *vm.Stack, n int) *big.Int {
+	data := stack.Data()
+	if len(data) <= n {
+		log.Warn("Tracer accessed out of bound stack", "size", len(data), "index", n)
+		return new(big.Int)
+	}
+	return data[len(data)-n-1]
+}
+
+// sliceMemory returns the requested range of memory, or nil if the range is
+// not fully available.
+func sliceMemory(memory *vm.Memory, begin, end int64) []byte {
+	if begin < 0 || begin > end || int64(memory.Len()) < end {
+		log.Warn("Tracer accessed out of bound memory", "available", memory.Len(), "offset", begin, "size", end-begin)
+		return nil
+	}
+	return memory.Get(begin, end-begin)
+}
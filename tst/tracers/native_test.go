@@ -0,0 +1,242 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/common/hexutil"
+	"github.com/haachain/go-haachain/core"
+	"github.com/haachain/go-haachain/core/types"
+	"github.com/haachain/go-haachain/core/vm"
+	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/rlp"
+	"github.com/haachain/go-haachain/tests"
+)
+
+// runTracerTestcase executes the transaction of the given call tracer test case
+// with the tracer attached, returning the sender of the transaction.
+func runTracerTestcase(t *testing.T, file string, tracer ResultTracer) common.Address {
+	blob, err := ioutil.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatalf("failed to read testcase: %v", err)
+	}
+	test := new(callTracerTest)
+	if err := json.Unmarshal(blob, test); err != nil {
+		t.Fatalf("failed to parse testcase: %v", err)
+	}
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(common.FromHex(test.Input), tx); err != nil {
+		t.Fatalf("failed to parse testcase input: %v", err)
+	}
+	signer := types.MakeSigner(test.Genesis.Config, new(big.Int).SetUint64(uint64(test.Context.Number)))
+	origin, _ := signer.Sender(tx)
+
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Origin:      origin,
+		Coinbase:    test.Context.Miner,
+		BlockNumber: new(big.Int).SetUint64(uint64(test.Context.Number)),
+		Time:        new(big.Int).SetUint64(uint64(test.Context.Time)),
+		Difficulty:  (*big.Int)(test.Context.Difficulty),
+		GasLimit:    uint64(test.Context.GasLimit),
+		GasPrice:    tx.GasPrice(),
+	}
+	db, _ := haadb.NewMemDatabase()
+	statedb := tests.MakePreState(db, test.Genesis.Alloc)
+
+	evm := vm.NewEVM(context, statedb, test.Genesis.Config, vm.Config{Debug: true, Tracer: tracer})
+	msg, err := tx.AsMessage(signer)
+	if err != nil {
+		t.Fatalf("failed to prepare transaction for tracing: %v", err)
+	}
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(tx.Gas()))
+	if _, _, _, err = st.TransitionDb(); err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+	return origin
+}
+
+// Tests that the native prestate tracer reassembles the genesis allocation the
+// call tracer test cases were exported with.
+func TestNativePrestateTracer(t *testing.T) {
+	files, err := ioutil.ReadDir("testdata")
+	if err != nil {
+		t.Fatalf("failed to retrieve tracer test suite: %v", err)
+	}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "call_tracer_") {
+			continue
+		}
+		file := file // capture range variable
+		t.Run(camel(strings.TrimSuffix(strings.TrimPrefix(file.Name(), "call_tracer_"), ".json")), func(t *testing.T) {
+			t.Parallel()
+
+			tracer, err := NewTracer("prestateTracer", nil)
+			if err != nil {
+				t.Fatalf("failed to create prestate tracer: %v", err)
+			}
+			sender := runTracerTestcase(t, file.Name(), tracer)
+			res, err := tracer.GetResult()
+			if err != nil {
+				t.Fatalf("failed to retrieve trace result: %v", err)
+			}
+			have := make(map[common.Address]*prestateAccount)
+			if err := json.Unmarshal(res, &have); err != nil {
+				t.Fatalf("failed to unmarshal trace result: %v", err)
+			}
+			blob, err := ioutil.ReadFile(filepath.Join("testdata", file.Name()))
+			if err != nil {
+				t.Fatalf("failed to read testcase: %v", err)
+			}
+			test := new(callTracerTest)
+			if err := json.Unmarshal(blob, test); err != nil {
+				t.Fatalf("failed to parse testcase: %v", err)
+			}
+			// The sender balance is reported after the gas purchase, same as with
+			// the JavaScript tracer, the rest must match the exported prestate
+			for addr, account := range have {
+				want, ok := test.Genesis.Alloc[addr]
+				if !ok {
+					t.Errorf("account %x not in prestate", addr)
+					continue
+				}
+				if addr != sender && account.Balance.ToInt().Cmp(want.Balance) != 0 {
+					t.Errorf("account %x balance mismatch: have %v, want %v", addr, account.Balance.ToInt(), want.Balance)
+				}
+				if account.Nonce != want.Nonce {
+					t.Errorf("account %x nonce mismatch: have %d, want %d", addr, account.Nonce, want.Nonce)
+				}
+				if !bytes.Equal(account.Code, want.Code) {
+					t.Errorf("account %x code mismatch: have %x, want %x", addr, account.Code, want.Code)
+				}
+				for key, val := range account.Storage {
+					if want.Storage[key] != val {
+						t.Errorf("account %x slot %x mismatch: have %x, want %x", addr, key, val, want.Storage[key])
+					}
+				}
+			}
+		})
+	}
+}
+
+// Tests that the native tracers produce the exact same output as their
+// JavaScript counterparts over all the call tracer test cases.
+func TestNativeTracersMatchJavaScript(t *testing.T) {
+	files, err := ioutil.ReadDir("testdata")
+	if err != nil {
+		t.Fatalf("failed to retrieve tracer test suite: %v", err)
+	}
+	for _, name := range []string{"4byteTracer"} {
+		for _, file := range files {
+			if !strings.HasPrefix(file.Name(), "call_tracer_") {
+				continue
+			}
+			name, file := name, file // capture range variables
+			t.Run(name+"/"+camel(strings.TrimSuffix(strings.TrimPrefix(file.Name(), "call_tracer_"), ".json")), func(t *testing.T) {
+				t.Parallel()
+
+				jsTracer, err := New(name)
+				if err != nil {
+					t.Fatalf("failed to create JavaScript tracer: %v", err)
+				}
+				runTracerTestcase(t, file.Name(), jsTracer)
+				want, err := jsTracer.GetResult()
+				if err != nil {
+					t.Fatalf("failed to retrieve JavaScript trace result: %v", err)
+				}
+				nativeTracer, err := NewTracer(name, nil)
+				if err != nil {
+					t.Fatalf("failed to create native tracer: %v", err)
+				}
+				runTracerTestcase(t, file.Name(), nativeTracer)
+				have, err := nativeTracer.GetResult()
+				if err != nil {
+					t.Fatalf("failed to retrieve native trace result: %v", err)
+				}
+				var haveObj, wantObj interface{}
+				if err := json.Unmarshal(have, &haveObj); err != nil {
+					t.Fatalf("failed to unmarshal native trace result: %v", err)
+				}
+				if err := json.Unmarshal(want, &wantObj); err != nil {
+					t.Fatalf("failed to unmarshal JavaScript trace result: %v", err)
+				}
+				if !reflect.DeepEqual(haveObj, wantObj) {
+					t.Fatalf("trace mismatch: have %s, want %s", have, want)
+				}
+			})
+		}
+	}
+}
+
+// Tests that the prestate tracer in diff mode reports the modified fields of
+// the touched accounts only.
+func TestPrestateTracerDiffMode(t *testing.T) {
+	tracer, err := NewTracer("prestateTracer", json.RawMessage(`{"diffMode": true}`))
+	if err != nil {
+		t.Fatalf("failed to create prestate tracer: %v", err)
+	}
+	sender := runTracerTestcase(t, "call_tracer_simple.json", tracer)
+
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	var diff struct {
+		Pre  map[common.Address]*prestateAccount `json:"pre"`
+		Post map[common.Address]*diffAccount     `json:"post"`
+	}
+	if err := json.Unmarshal(res, &diff); err != nil {
+		t.Fatalf("failed to unmarshal trace result: %v", err)
+	}
+	pre, post := diff.Pre[sender], diff.Post[sender]
+	if pre == nil || post == nil {
+		t.Fatalf("sender missing from diff: pre %v, post %v", pre, post)
+	}
+	if post.Nonce == nil || *post.Nonce != pre.Nonce+1 {
+		t.Errorf("sender nonce mismatch: pre %d, post %v", pre.Nonce, post.Nonce)
+	}
+	if post.Code != nil {
+		t.Errorf("unmodified code reported: %x", post.Code)
+	}
+	// The value receiver of the internal call must be credited
+	receiver := common.HexToAddress("0x0024f658a46fbb89d8ac105e98d7ac7cbbaf27c5")
+	if diff.Post[receiver] == nil || diff.Post[receiver].Balance == nil {
+		t.Fatalf("receiver balance change missing from diff")
+	}
+	credit := new(big.Int).Set(diff.Post[receiver].Balance.ToInt())
+	if prev := diff.Pre[receiver]; prev != nil {
+		credit.Sub(credit, prev.Balance.ToInt())
+	}
+	if want := hexutil.MustDecodeBig("0x6f05b59d3b20000"); credit.Cmp(want) != 0 {
+		t.Errorf("receiver credit mismatch: have %v, want %v", credit, want)
+	}
+	for addr, account := range diff.Post {
+		if account.Balance == nil && account.Nonce == nil && account.Code == nil && len(account.Storage) == 0 {
+			t.Errorf("unmodified account %x reported", addr)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/common/hexutil"
+	"github.com/haachain/go-haachain/core/vm"
+	"github.com/haachain/go-haachain/crypto"
+)
+
+// prestateAccount is the state of a single account prior to the execution of
+// the traced transaction.
+type prestateAccount struct {
+	Balance *hexutil.Big                `json:"balance"`
+	Nonce   uint64                      `json:"nonce"`
+	Code    hexutil.Bytes               `json:"code"`
+	Storage map[common.Hash]common.Hash `json:"storage"`
+}
+
+// diffAccount is the state of a single account in diff mode, where only the
+// fields modified by the transaction are reported.
+type diffAccount struct {
+	Balance *hexutil.Big                `json:"balance,omitempty"`
+	Nonce   *uint64                     `json:"nonce,omitempty"`
+	Code    hexutil.Bytes               `json:"code,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+// prestateConfig are the configuration options of the prestate tracer.
+type prestateConfig struct {
+	DiffMode bool `json:"diffMode"` // Report the pre and post state of modified accounts only
+}
+
+// prestateTracer is a native implementation of the JavaScript prestateTracer,
+// which outputs sufficient information to create a local execution of the
+// transaction from a custom assembled genesis block. In diff mode it reports
+// the state of the touched accounts both before and after the transaction.
+type prestateTracer struct {
+	config prestateConfig
+
+	db       vm.StateDB                                  // State database to pull the accounts from
+	prestate map[common.Address]*prestateAccount         // Genesis allocation being built
+	created  map[common.Address]bool                     // Accounts not existing prior to the transaction
+	slots    map[common.Address]map[common.Hash]struct{} // Storage slots accessed by the transaction
+
+	create bool           // Whhaaer the outer call is a contract creation
+	from   common.Address // Sender of the outer call
+	to     common.Address // Recipient of the outer call
+	value  *big.Int       // Value transferred by the outer call
+
+	interrupt uint32 // Atomic flag to signal execution interruption
+	reason    error  // Textual reason for the interruption
+}
+
+// newPrestateTracer creates a native prestate tracer, optionally configured
+// with a JSON encoded prestateConfig.
+func newPrestateTracer(config json.RawMessage) (ResultTracer, error) {
+	t := &prestateTracer{
+		prestate: make(map[common.Address]*prestateAccount),
+		created:  make(map[common.Address]bool),
+		slots:    make(map[common.Address]map[common.Hash]struct{}),
+	}
+	if len(config) > 0 {
+		if err := json.Unmarshal(config, &t.config); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// lookupAccount injects the specified account into the prestate.
+func (t *prestateTracer) lookupAccount(addr common.Address) {
+	if _, ok := t.prestate[addr]; ok {
+		return
+	}
+	if !t.db.Exist(addr) {
+		t.created[addr] = true
+	}
+	t.prestate[addr] = &prestateAccount{
+		Balance: (*hexutil.Big)(new(big.Int).Set(t.db.GetBalance(addr))),
+		Nonce:   t.db.GetNonce(addr),
+		Code:    t.db.GetCode(addr),
+		Storage: make(map[common.Hash]common.Hash),
+	}
+}
+
+// lookupStorage injects the specified storage entry of the given account into
+// the prestate. Empty slots are not reported.
+func (t *prestateTracer) lookupStorage(addr common.Address, key common.Hash) {
+	if _, ok := t.slots[addr][key]; ok {
+		return
+	}
+	if t.slots[addr] == nil {
+		t.slots[addr] = make(map[common.Hash]struct{})
+	}
+	t.slots[addr][key] = struct{}{}
+
+	if val := t.db.Gehaaate(addr, key); val != (common.Hash{}) {
+		t.prestate[addr].Storage[key] = val
+	}
+}
+
+// CaptureStart implements the Tracer interface to initialize the tracing operation.
+func (t *prestateTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.create, t.from, t.to = create, from, to
+	t.value = new(big.Int).Set(value)
+	return nil
+}
+
+// CaptureState implements the Tracer interface to trace a single step of VM execution.
+func (t *prestateTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		return nil
+	}
+	if t.db == nil {
+		t.db = env.StateDB
+		t.lookupAccount(contract.Address())
+
+		// In diff mode the sender needs to be captured before any refunds
+		if t.config.DiffMode {
+			t.lookupAccount(t.from)
+		}
+	}
+	switch op {
+	case vm.EXTCODECOPY, vm.EXTCODESIZE, vm.BALANCE:
+		t.lookupAccount(common.BigToAddress(peekStack(stack, 0)))
+	case vm.CREATE:
+		from := contract.Address()
+		t.lookupAccount(crypto.CreateAddress(from, t.db.GetNonce(from)))
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		t.lookupAccount(common.BigToAddress(peekStack(stack, 1)))
+	case vm.SSTORE, vm.SLOAD:
+		t.lookupStorage(contract.Address(), common.BigToHash(peekStack(stack, 0)))
+	}
+	return nil
+}
+
+// CaptureFault implements the Tracer interface to trace an execution fault
+// while running an opcode.
+func (t *prestateTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+// CaptureEnter is called when the EVM enters a new internal call frame.
+func (t *prestateTracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+// CaptureExit is called when the EVM returns from an internal call frame.
+func (t *prestateTracer) CaptureExit(output []byte, gasUsed uint64, err error) error {
+	return nil
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *prestateTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+// GetResult returns the accounts touched by the transaction with their state
+// prior to execution, or their pre and post states in diff mode.
+func (t *prestateTracer) GetResult() (json.RawMessage, error) {
+	if atomic.LoadUint32(&t.interrupt) > 0 {
+		return nil, t.reason
+	}
+	if t.db == nil {
+		// No code was executed, there is no state to report
+		if t.config.DiffMode {
+			return json.Marshal(map[string]interface{}{"pre": t.prestate, "post": map[common.Address]*diffAccount{}})
+		}
+		return json.Marshal(t.prestate)
+	}
+	// At this point, we need to deduct the 'value' from the outer transaction,
+	// and move it back to the origin
+	t.lookupAccount(t.from)
+	t.lookupAccount(t.to)
+
+	fromBal := new(big.Int).Set(t.prestate[t.from].Balance.ToInt())
+	toBal := new(big.Int).Set(t.prestate[t.to].Balance.ToInt())
+
+	t.prestate[t.to].Balance = (*hexutil.Big)(toBal.Sub(toBal, t.value))
+	t.prestate[t.from].Balance = (*hexutil.Big)(fromBal.Add(fromBal, t.value))
+
+	t.prestate[t.from].Nonce--
+	if t.create {
+		delete(t.prestate, t.to)
+		t.created[t.to] = true
+	}
+	if !t.config.DiffMode {
+		return json.Marshal(t.prestate)
+	}
+	return json.Marshal(t.diff())
+}
+
+// diff assembles the pre and post states of all the accounts modified by the
+// transaction. Unmodified accounts and fields are omitted from the post state
+// and created accounts are omitted from the pre state.
+func (t *prestateTracer) diff() map[string]interface{} {
+	var (
+		pre  = make(map[common.Address]*prestateAccount)
+		post = make(map[common.Address]*diffAccount)
+	)
+	for addr := range t.touched() {
+		prev, existed := t.prestate[addr]
+		if !existed || t.created[addr] {
+			prev, existed = &prestateAccount{Balance: new(hexutil.Big)}, false
+		}
+		// Destructed accounts are only reported in the pre state
+		if t.db.HasSuicided(addr) {
+			if existed {
+				pre[addr] = prev
+			}
+			continue
+		}
+		var (
+			modified bool
+			account  = new(diffAccount)
+			storage  = make(map[common.Hash]common.Hash)
+		)
+		if balance := t.db.GetBalance(addr); balance.Cmp(prev.Balance.ToInt()) != 0 {
+			account.Balance, modified = (*hexutil.Big)(new(big.Int).Set(balance)), true
+		}
+		if nonce := t.db.GetNonce(addr); nonce != prev.Nonce {
+			account.Nonce, modified = &nonce, true
+		}
+		if code := t.db.GetCode(addr); !bytes.Equal(code, prev.Code) {
+			account.Code, modified = code, true
+		}
+		for key := range t.slots[addr] {
+			val := t.db.Gehaaate(addr, key)
+			if val == prev.Storage[key] {
+				continue
+			}
+			if account.Storage == nil {
+				account.Storage = make(map[common.Hash]common.Hash)
+			}
+			if val != (common.Hash{}) {
+				account.Storage[key] = val
+			}
+			if old, ok := prev.Storage[key]; ok {
+				storage[key] = old
+			}
+			modified = true
+		}
+		if !modified {
+			continue
+		}
+		post[addr] = account
+		if existed {
+			pre[addr] = &prestateAccount{
+				Balance: prev.Balance,
+				Nonce:   prev.Nonce,
+				Code:    prev.Code,
+				Storage: storage,
+			}
+		}
+	}
+	return map[string]interface{}{"pre": pre, "post": post}
+}
+
+// touched returns the set of all accounts accessed by the transaction, including
+// the ones created and since removed from the prestate.
+func (t *prestateTracer) touched() map[common.Address]struct{} {
+	set := make(map[common.Address]struct{})
+	for addr := range t.prestate {
+		set[addr] = struct{}{}
+	}
+	for addr := range t.created {
+		set[addr] = struct{}{}
+	}
+	return set
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *prestateTracer) Stop(err error) {
+	t.reason = err
+	atomic.StoreUint32(&t.interrupt, 1)
+}
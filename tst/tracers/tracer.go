@@ -274,6 +274,93 @@ func (cw *contractWrapper) pushObject(vm *duktape.Context) {
 	vm.PutPropString(obj, "getInput")
 }
 
+// frameWrapper provides a JavaScript wrapper around an internal call frame
+// being entered.
+type frameWrapper struct {
+	typ   vm.OpCode
+	from  common.Address
+	to    common.Address
+	input []byte
+	gas   uint64
+	value *big.Int
+}
+
+// pushObject assembles a JSVM object wrapping a swappable call frame and pushes
+// it onto the VM stack.
+func (fw *frameWrapper) pushObject(vm *duktape.Context) {
+	obj := vm.PushObject()
+
+	vm.PushGoFunction(func(ctx *duktape.Context) int { ctx.PushString(fw.typ.String()); return 1 })
+	vm.PutPropString(obj, "getType")
+
+	vm.PushGoFunction(func(ctx *duktape.Context) int {
+		copy(makeSlice(ctx.PushFixedBuffer(20), 20), fw.from[:])
+		return 1
+	})
+	vm.PutPropString(obj, "getFrom")
+
+	vm.PushGoFunction(func(ctx *duktape.Context) int {
+		copy(makeSlice(ctx.PushFixedBuffer(20), 20), fw.to[:])
+		return 1
+	})
+	vm.PutPropString(obj, "getTo")
+
+	vm.PushGoFunction(func(ctx *duktape.Context) int {
+		ptr := ctx.PushFixedBuffer(len(fw.input))
+		copy(makeSlice(ptr, uint(len(fw.input))), fw.input)
+		return 1
+	})
+	vm.PutPropString(obj, "getInput")
+
+	vm.PushGoFunction(func(ctx *duktape.Context) int { ctx.PushUint(uint(fw.gas)); return 1 })
+	vm.PutPropString(obj, "getGas")
+
+	// Static calls don't carry a value, report them as undefined
+	vm.PushGoFunction(func(ctx *duktape.Context) int {
+		if fw.value != nil {
+			pushBigInt(fw.value, ctx)
+		} else {
+			ctx.PushUndefined()
+		}
+		return 1
+	})
+	vm.PutPropString(obj, "getValue")
+}
+
+// frameResultWrapper provides a JavaScript wrapper around the outcome of an
+// internal call frame being exited.
+type frameResultWrapper struct {
+	output  []byte
+	gasUsed uint64
+	err     error
+}
+
+// pushObject assembles a JSVM object wrapping a swappable call frame result and
+// pushes it onto the VM stack.
+func (rw *frameResultWrapper) pushObject(vm *duktape.Context) {
+	obj := vm.PushObject()
+
+	vm.PushGoFunction(func(ctx *duktape.Context) int {
+		ptr := ctx.PushFixedBuffer(len(rw.output))
+		copy(makeSlice(ptr, uint(len(rw.output))), rw.output)
+		return 1
+	})
+	vm.PutPropString(obj, "getOutput")
+
+	vm.PushGoFunction(func(ctx *duktape.Context) int { ctx.PushUint(uint(rw.gasUsed)); return 1 })
+	vm.PutPropString(obj, "getGasUsed")
+
+	vm.PushGoFunction(func(ctx *duktape.Context) int {
+		if rw.err != nil {
+			ctx.PushString(rw.err.Error())
+		} else {
+			ctx.PushUndefined()
+		}
+		return 1
+	})
+	vm.PutPropString(obj, "getError")
+}
+
 // Tracer provides an implementation of Tracer that evaluates a Javascript
 // function for each VM execution step.
 type Tracer struct {
@@ -290,6 +377,10 @@ type Tracer struct {
 	contractWrapper *contractWrapper // Wrapper around the contract object
 	dbWrapper       *dbWrapper       // Wrapper around the VM environment
 
+	traceCallFrames    bool                // Whhaaer the tracer exposes enter() and exit()
+	frameWrapper       *frameWrapper       // Wrapper around an entered call frame
+	frameResultWrapper *frameResultWrapper // Wrapper around an exited call frame's result
+
 	pcValue    *uint   // Swappable pc value wrapped by a log accessor
 	gasValue   *uint   // Swappable gas value wrapped by a log accessor
 	costValue  *uint   // Swappable cost value wrapped by a log accessor
@@ -312,17 +403,19 @@ func New(code string) (*Tracer, error) {
 		code = tracer
 	}
 	tracer := &Tracer{
-		vm:              duktape.New(),
-		ctx:             make(map[string]interface{}),
-		opWrapper:       new(opWrapper),
-		stackWrapper:    new(stackWrapper),
-		memoryWrapper:   new(memoryWrapper),
-		contractWrapper: new(contractWrapper),
-		dbWrapper:       new(dbWrapper),
-		pcValue:         new(uint),
-		gasValue:        new(uint),
-		costValue:       new(uint),
-		depthValue:      new(uint),
+		vm:                 duktape.New(),
+		ctx:                make(map[string]interface{}),
+		opWrapper:          new(opWrapper),
+		stackWrapper:       new(stackWrapper),
+		memoryWrapper:      new(memoryWrapper),
+		contractWrapper:    new(contractWrapper),
+		dbWrapper:          new(dbWrapper),
+		frameWrapper:       new(frameWrapper),
+		frameResultWrapper: new(frameResultWrapper),
+		pcValue:            new(uint),
+		gasValue:           new(uint),
+		costValue:          new(uint),
+		depthValue:         new(uint),
 	}
 	// Set up builtins for this environment
 	tracer.vm.PushGlobalGoFunction("toHex", func(ctx *duktape.Context) int {
@@ -409,6 +502,17 @@ func New(code string) (*Tracer, error) {
 	}
 	tracer.vm.Pop()
 
+	// The call frame hooks are optional, but must be defined in pairs
+	hasEnter := tracer.vm.GetPropString(tracer.tracerObject, "enter")
+	tracer.vm.Pop()
+	hasExit := tracer.vm.GetPropString(tracer.tracerObject, "exit")
+	tracer.vm.Pop()
+
+	if hasEnter != hasExit {
+		return nil, fmt.Errorf("Trace object must expose either both or none of enter() and exit()")
+	}
+	tracer.traceCallFrames = hasEnter
+
 	// Tracer is valid, inject the big int library to access large numbers
 	tracer.vm.EvalString(bigIntegerJS)
 	tracer.vm.PutGlobalString("bigInt")
@@ -457,6 +561,12 @@ func New(code string) (*Tracer, error) {
 	tracer.dbWrapper.pushObject(tracer.vm)
 	tracer.vm.PutPropString(tracer.stateObject, "db")
 
+	tracer.frameWrapper.pushObject(tracer.vm)
+	tracer.vm.PutPropString(tracer.stateObject, "frame")
+
+	tracer.frameResultWrapper.pushObject(tracer.vm)
+	tracer.vm.PutPropString(tracer.stateObject, "frameResult")
+
 	return tracer, nil
 }
 
@@ -562,6 +672,48 @@ func (jst *Tracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost
 	return nil
 }
 
+// CaptureEnter is called when the EVM enters a new internal call frame, and
+// invokes the tracer's optional 'enter' function.
+func (jst *Tracer) CaptureEnter(typ vm.OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) error {
+	if !jst.traceCallFrames || jst.err != nil {
+		return nil
+	}
+	// If tracing was interrupted, set the error and stop
+	if atomic.LoadUint32(&jst.interrupt) > 0 {
+		jst.err = jst.reason
+		return nil
+	}
+	*jst.frameWrapper = frameWrapper{
+		typ:   typ,
+		from:  from,
+		to:    to,
+		input: input,
+		gas:   gas,
+		value: value,
+	}
+	if _, err := jst.call("enter", "frame"); err != nil {
+		jst.err = wrapError("enter", err)
+	}
+	return nil
+}
+
+// CaptureExit is called when the EVM returns from an internal call frame, and
+// invokes the tracer's optional 'exit' function.
+func (jst *Tracer) CaptureExit(output []byte, gasUsed uint64, err error) error {
+	if !jst.traceCallFrames || jst.err != nil {
+		return nil
+	}
+	*jst.frameResultWrapper = frameResultWrapper{
+		output:  output,
+		gasUsed: gasUsed,
+		err:     err,
+	}
+	if _, err := jst.call("exit", "frameResult"); err != nil {
+		jst.err = wrapError("exit", err)
+	}
+	return nil
+}
+
 // CaptureEnd is called after the call finishes to finalize the tracing.
 func (jst *Tracer) CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error {
 	jst.ctx["output"] = output
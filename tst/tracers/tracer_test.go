@@ -138,3 +138,30 @@ func TestHaltBetweenSteps(t *testing.T) {
 		t.Errorf("Expected timeout error, got %v", err)
 	}
 }
+
+func TestEnterExit(t *testing.T) {
+	// The call frame hooks must be defined in pairs
+	if _, err := New("{step: function() {}, fault: function() {}, result: function() { return null; }, enter: function() {}}"); err == nil {
+		t.Fatal("tracer creation should've failed without exit() method")
+	}
+	tracer, err := New(`{
+		depth: 0, frames: [],
+		step: function() {}, fault: function() {},
+		enter: function(frame) { this.depth++; this.frames.push(frame.getType() + " " + toHex(frame.getTo())); },
+		exit: function(res) { this.depth--; this.frames.push(res.getError() === undefined ? "ok" : res.getError()); },
+		result: function() { return {depth: this.depth, frames: this.frames}; }
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTracerTestcase(t, "call_tracer_delegatecall.json", tracer)
+
+	ret, err := tracer.GetResult()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"depth":0,"frames":["CALL 0x13204f5d64c28326fd7bd05fd4ea855302d7f2ff","DELEGATECALL 0x42b02b5deeb78f34cd5ac896473b63e6c99a71a2","ok","ok"]}`
+	if string(ret) != want {
+		t.Errorf("frame mismatch: have %s, want %s", ret, want)
+	}
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/haachain/go-haachain/core/types"
 	"github.com/haachain/go-haachain/core/vm"
 	"github.com/haachain/go-haachain/haadb"
+	"github.com/haachain/go-haachain/params"
 	"github.com/haachain/go-haachain/rlp"
 	"github.com/haachain/go-haachain/tests"
 )
@@ -119,6 +120,16 @@ type callTracerTest struct {
 // Iterates over all the input-output datasets in the tracer test harness and
 // runs the JavaScript tracers against them.
 func TestCallTracer(t *testing.T) {
+	testCallTracer(t, func() (ResultTracer, error) { return New("callTracer") })
+}
+
+// Iterates over all the input-output datasets in the tracer test harness and
+// runs the native call tracer against them.
+func TestNativeCallTracer(t *testing.T) {
+	testCallTracer(t, func() (ResultTracer, error) { return newCallTracer(nil) })
+}
+
+func testCallTracer(t *testing.T, newTracer func() (ResultTracer, error)) {
 	files, err := ioutil.ReadDir("testdata")
 	if err != nil {
 		t.Fatalf("failed to retrieve tracer test suite: %v", err)
@@ -163,7 +174,7 @@ func TestCallTracer(t *testing.T) {
 			statedb := tests.MakePreState(db, test.Genesis.Alloc)
 
 			// Create the tracer, the EVM environment and run it
-			tracer, err := New("callTracer")
+			tracer, err := newTracer()
 			if err != nil {
 				t.Fatalf("failed to create call tracer: %v", err)
 			}
@@ -192,3 +203,67 @@ func TestCallTracer(t *testing.T) {
 		})
 	}
 }
+
+// Tests that the native call tracer decodes the Error(string) revert reason of
+// reverted calls.
+func TestNativeCallTracerRevertReason(t *testing.T) {
+	// Contract code copying an Error("boom") revert reason into memory and
+	// reverting with it
+	data := common.FromHex("0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000004" +
+		"626f6f6d00000000000000000000000000000000000000000000000000000000")
+	code := []byte{
+		byte(vm.PUSH1), byte(len(data)), byte(vm.PUSH1), 12, byte(vm.PUSH1), 0, byte(vm.CODECOPY),
+		byte(vm.PUSH1), byte(len(data)), byte(vm.PUSH1), 0, byte(vm.REVERT),
+	}
+	code = append(code, data...)
+
+	var (
+		from = common.HexToAddress("0xf00d")
+		to   = common.HexToAddress("0xc0de")
+	)
+	db, _ := haadb.NewMemDatabase()
+	statedb := tests.MakePreState(db, core.GenesisAlloc{
+		from: {Balance: big.NewInt(1000000000)},
+		to:   {Balance: new(big.Int), Code: code},
+	})
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		Origin:      from,
+		BlockNumber: new(big.Int),
+		Time:        new(big.Int),
+		Difficulty:  new(big.Int),
+		GasLimit:    1000000,
+		GasPrice:    big.NewInt(1),
+	}
+	tracer, err := newCallTracer(nil)
+	if err != nil {
+		t.Fatalf("failed to create call tracer: %v", err)
+	}
+	evm := vm.NewEVM(context, statedb, params.AllhaaashProtocolChanges, vm.Config{Debug: true, Tracer: tracer})
+
+	msg := types.NewMessage(from, &to, 0, new(big.Int), 100000, big.NewInt(1), nil, false)
+	st := core.NewStateTransition(evm, msg, new(core.GasPool).AddGas(msg.Gas()))
+	if _, _, _, err = st.TransitionDb(); err != nil {
+		t.Fatalf("failed to execute transaction: %v", err)
+	}
+	res, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to retrieve trace result: %v", err)
+	}
+	var ret struct {
+		Error  string `json:"error"`
+		Reason string `json:"revertReason"`
+	}
+	if err := json.Unmarshal(res, &ret); err != nil {
+		t.Fatalf("failed to unmarshal trace result: %v", err)
+	}
+	if ret.Error != "execution reverted" {
+		t.Errorf("error mismatch: have %q, want %q", ret.Error, "execution reverted")
+	}
+	if ret.Reason != "boom" {
+		t.Errorf("revert reason mismatch: have %q, want %q", ret.Reason, "boom")
+	}
+}
@@ -25,10 +25,10 @@ import (
 	"math/big"
 
 	"github.com/haachain/go-haachain"
+	"github.com/haachain/go-haachain/accounts/abi"
 	"github.com/haachain/go-haachain/common"
 	"github.com/haachain/go-haachain/common/hexutil"
 	"github.com/haachain/go-haachain/core/types"
-	"github.com/haachain/go-haachain/rlp"
 	"github.com/haachain/go-haachain/rpc"
 )
 
@@ -346,6 +346,70 @@ func (ec *Client) NonceAt(ctx context.Context, account common.Address, blockNumb
 	return uint64(result), err
 }
 
+// AccountResult is the merkle proof of an account along with the proofs of the
+// requested storage slots, as returned by ProofAt.
+type AccountResult struct {
+	Address      common.Address
+	AccountProof []string
+	Balance      *big.Int
+	CodeHash     common.Hash
+	Nonce        uint64
+	StorageHash  common.Hash
+	StorageProof []StorageResult
+}
+
+// StorageResult is the merkle proof of a single storage slot of an account.
+type StorageResult struct {
+	Key   string
+	Value *big.Int
+	Proof []string
+}
+
+// ProofAt returns the merkle proof of the given account and storage keys. The
+// block number can be nil, in which case the proof is taken from the latest
+// known block. The proofs can be verified against the block's state root.
+func (ec *Client) ProofAt(ctx context.Context, account common.Address, keys []string, blockNumber *big.Int) (*AccountResult, error) {
+	type storageResult struct {
+		Key   string       `json:"key"`
+		Value *hexutil.Big `json:"value"`
+		Proof []string     `json:"proof"`
+	}
+	type accountResult struct {
+		Address      common.Address  `json:"address"`
+		AccountProof []string        `json:"accountProof"`
+		Balance      *hexutil.Big    `json:"balance"`
+		CodeHash     common.Hash     `json:"codeHash"`
+		Nonce        hexutil.Uint64  `json:"nonce"`
+		StorageHash  common.Hash     `json:"storageHash"`
+		StorageProof []storageResult `json:"storageProof"`
+	}
+	// Avoid sending a null storage key list to the server
+	if keys == nil {
+		keys = []string{}
+	}
+	var res accountResult
+	if err := ec.c.CallContext(ctx, &res, "eth_getProof", account, keys, toBlockNumArg(blockNumber)); err != nil {
+		return nil, err
+	}
+	storage := make([]StorageResult, len(res.StorageProof))
+	for i, st := range res.StorageProof {
+		storage[i] = StorageResult{
+			Key:   st.Key,
+			Value: (*big.Int)(st.Value),
+			Proof: st.Proof,
+		}
+	}
+	return &AccountResult{
+		Address:      res.Address,
+		AccountProof: res.AccountProof,
+		Balance:      (*big.Int)(res.Balance),
+		CodeHash:     res.CodeHash,
+		Nonce:        uint64(res.Nonce),
+		StorageHash:  res.StorageHash,
+		StorageProof: storage,
+	}, nil
+}
+
 // Filters
 
 // FilterLogs executes a filter query.
@@ -425,18 +489,78 @@ func (ec *Client) CallContract(ctx context.Context, msg haaereum.CallMsg, blockN
 	var hex hexutil.Bytes
 	err := ec.c.CallContext(ctx, &hex, "eth_call", toCallArg(msg), toBlockNumArg(blockNumber))
 	if err != nil {
-		return nil, err
+		return nil, revertError(err)
 	}
 	return hex, nil
 }
 
+// OverrideAccount specifies the state of an account to be overridden while
+// executing a call. Zero and nil fields are left untouched, so a nonce can't
+// be overridden to zero. State replaces the entire storage of the account,
+// whereas StateDiff only overrides the given slots; only one may be set.
+type OverrideAccount struct {
+	Nonce     uint64
+	Code      []byte
+	Balance   *big.Int
+	State     map[common.Hash]common.Hash
+	StateDiff map[common.Hash]common.Hash
+}
+
+// CallContractWithOverrides executes a message call transaction like CallContract,
+// but with the state of some accounts overridden before execution. This allows
+// simulating calls against modified contract code or storage.
+func (ec *Client) CallContractWithOverrides(ctx context.Context, msg haaereum.CallMsg, blockNumber *big.Int, overrides map[common.Address]OverrideAccount) ([]byte, error) {
+	var hex hexutil.Bytes
+	err := ec.c.CallContext(ctx, &hex, "eth_call", toCallArg(msg), toBlockNumArg(blockNumber), toOverrideArg(overrides))
+	if err != nil {
+		return nil, revertError(err)
+	}
+	return hex, nil
+}
+
+// RevertError is returned by contract calls and gas estimations if the EVM
+// execution was reverted, carrying the data returned by the contract.
+type RevertError struct {
+	Reason string // Decoded Error(string) revert reason, empty if not available
+	Data   []byte // Raw revert data returned by the contract
+
+	msg string // Error message reported by the node
+}
+
+// Error implements error, returning the error message reported by the node.
+func (e *RevertError) Error() string {
+	return e.msg
+}
+
+// revertError converts an RPC error reporting a reverted execution into a
+// RevertError, returning any other error unmodified.
+func revertError(err error) error {
+	if ec, ok := err.(rpc.Error); !ok || ec.ErrorCode() != 3 {
+		return err
+	}
+	de, ok := err.(rpc.DataError)
+	if !ok {
+		return err
+	}
+	hex, ok := de.ErrorData().(string)
+	if !ok {
+		return err
+	}
+	data, derr := hexutil.Decode(hex)
+	if derr != nil {
+		return err
+	}
+	reason, _ := abi.UnpackRevert(data)
+	return &RevertError{Reason: reason, Data: data, msg: err.Error()}
+}
+
 // PendingCallContract executes a message call transaction using the EVM.
 // The state seen by the contract call is the pending state.
 func (ec *Client) PendingCallContract(ctx context.Context, msg haaereum.CallMsg) ([]byte, error) {
 	var hex hexutil.Bytes
 	err := ec.c.CallContext(ctx, &hex, "eth_call", toCallArg(msg), "pending")
 	if err != nil {
-		return nil, err
+		return nil, revertError(err)
 	}
 	return hex, nil
 }
@@ -459,7 +583,7 @@ func (ec *Client) EstimateGas(ctx context.Context, msg haaereum.CallMsg) (uint64
 	var hex hexutil.Uint64
 	err := ec.c.CallContext(ctx, &hex, "eth_estimateGas", toCallArg(msg))
 	if err != nil {
-		return 0, err
+		return 0, revertError(err)
 	}
 	return uint64(hex), nil
 }
@@ -469,7 +593,7 @@ func (ec *Client) EstimateGas(ctx context.Context, msg haaereum.CallMsg) (uint64
 // If the transaction was a contract creation use the TransactionReceipt method to get the
 // contract address after the transaction has been mined.
 func (ec *Client) SendTransaction(ctx context.Context, tx *types.Transaction) error {
-	data, err := rlp.EncodeToBytes(tx)
+	data, err := tx.MarshalBinary()
 	if err != nil {
 		return err
 	}
@@ -495,3 +619,27 @@ func toCallArg(msg haaereum.CallMsg) interface{} {
 	}
 	return arg
 }
+
+func toOverrideArg(overrides map[common.Address]OverrideAccount) interface{} {
+	arg := make(map[common.Address]map[string]interface{}, len(overrides))
+	for addr, account := range overrides {
+		override := make(map[string]interface{})
+		if account.Nonce != 0 {
+			override["nonce"] = hexutil.Uint64(account.Nonce)
+		}
+		if account.Code != nil {
+			override["code"] = hexutil.Bytes(account.Code)
+		}
+		if account.Balance != nil {
+			override["balance"] = (*hexutil.Big)(account.Balance)
+		}
+		if account.State != nil {
+			override["state"] = account.State
+		}
+		if account.StateDiff != nil {
+			override["stateDiff"] = account.StateDiff
+		}
+		arg[addr] = override
+	}
+	return arg
+}
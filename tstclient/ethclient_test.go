@@ -16,7 +16,16 @@
 
 package haaclient
 
-import "github.com/haachain/go-haachain"
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/haachain/go-haachain"
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/common/hexutil"
+	"github.com/haachain/go-haachain/rpc"
+)
 
 // Verify that Client implements the haaereum interfaces.
 var (
@@ -32,3 +41,65 @@ var (
 	// _ = haaereum.PendingStateEventer(&Client{})
 	_ = haaereum.PendingContractCaller(&Client{})
 )
+
+// testRevertData is the ABI encoding of an Error("boom") revert reason.
+var testRevertData = common.FromHex("0x08c379a0" +
+	"0000000000000000000000000000000000000000000000000000000000000020" +
+	"0000000000000000000000000000000000000000000000000000000000000004" +
+	"626f6f6d00000000000000000000000000000000000000000000000000000000")
+
+// testRevertError is an RPC error reporting a reverted execution, as returned
+// by the call APIs of a node.
+type testRevertError struct{}
+
+func (testRevertError) Error() string          { return "execution reverted: boom" }
+func (testRevertError) ErrorCode() int         { return 3 }
+func (testRevertError) ErrorData() interface{} { return hexutil.Encode(testRevertData) }
+
+// RevertingService is an RPC service reverting all calls and gas estimations.
+type RevertingService struct{}
+
+func (s *RevertingService) Call(ctx context.Context, args map[string]interface{}, block string) (hexutil.Bytes, error) {
+	return nil, testRevertError{}
+}
+
+func (s *RevertingService) EstimateGas(ctx context.Context, args map[string]interface{}) (hexutil.Uint64, error) {
+	return 0, testRevertError{}
+}
+
+// Tests that reverted calls and gas estimations return the revert data and the
+// decoded reason to the caller.
+func TestRevertError(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", new(RevertingService)); err != nil {
+		t.Fatalf("failed to register service: %v", err)
+	}
+	rpcClient := rpc.DialInProc(server)
+	defer rpcClient.Close()
+
+	client := NewClient(rpcClient)
+
+	to := common.HexToAddress("0xc0de")
+	msg := haaereum.CallMsg{To: &to}
+
+	check := func(method string, err error) {
+		rerr, ok := err.(*RevertError)
+		if !ok {
+			t.Fatalf("%s: error type mismatch: have %T (%v), want *RevertError", method, err, err)
+		}
+		if rerr.Reason != "boom" {
+			t.Errorf("%s: reason mismatch: have %q, want %q", method, rerr.Reason, "boom")
+		}
+		if !bytes.Equal(rerr.Data, testRevertData) {
+			t.Errorf("%s: data mismatch: have %x, want %x", method, rerr.Data, testRevertData)
+		}
+		if rerr.Error() != "execution reverted: boom" {
+			t.Errorf("%s: message mismatch: have %q", method, rerr.Error())
+		}
+	}
+	_, err := client.CallContract(context.Background(), msg, nil)
+	check("call", err)
+
+	_, err = client.EstimateGas(context.Background(), msg)
+	check("estimateGas", err)
+}
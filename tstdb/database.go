@@ -17,6 +17,7 @@
 package haadb
 
 import (
+	"bytes"
 	"strconv"
 	"strings"
 	"sync"
@@ -29,11 +30,14 @@ import (
 	"github.com/syndtr/goleveldb/leveldb/filter"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 var OpenFileLimit = 64
 
 type LDBDatabase struct {
+	ancientStore // Ancient chain data store (disabled if no freezer is attached)
+
 	fn string      // filename for reporting
 	db *leveldb.DB // LevelDB instance
 
@@ -87,6 +91,20 @@ func NewLDBDatabase(file string, cache int, handles int) (*LDBDatabase, error) {
 	}, nil
 }
 
+// NewLDBDatabaseWithFreezer returns a LevelDB wrapped object, backed by an
+// append-only freezer in the given directory to store ancient chain data.
+func NewLDBDatabaseWithFreezer(file string, cache int, handles int, freezer string) (*LDBDatabase, error) {
+	db, err := NewLDBDatabase(file, cache, handles)
+	if err != nil {
+		return nil, err
+	}
+	if db.freezer, err = NewFreezer(freezer); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
 // Path returns the path to the database directory.
 func (db *LDBDatabase) Path() string {
 	return db.fn
@@ -147,6 +165,25 @@ func (db *LDBDatabase) NewIterator() iterator.Iterator {
 	return db.db.NewIterator(nil, nil)
 }
 
+// NewIteratorWithStart creates a binary-alphabetical iterator over a subset of
+// database content starting at a particular initial key (or after, if it does
+// not exist).
+func (db *LDBDatabase) NewIteratorWithStart(start []byte) Iterator {
+	return db.db.NewIterator(&util.Range{Start: start}, nil)
+}
+
+// NewIteratorWithPrefix creates a binary-alphabetical iterator over a subset
+// of database content with a particular key prefix.
+func (db *LDBDatabase) NewIteratorWithPrefix(prefix []byte) Iterator {
+	return db.db.NewIterator(util.BytesPrefix(prefix), nil)
+}
+
+// Compact flattens the underlying data store for the given key range. A nil
+// start or limit is treated as the beginning or the end of the key space.
+func (db *LDBDatabase) Compact(start []byte, limit []byte) error {
+	return db.db.CompactRange(util.Range{Start: start, Limit: limit})
+}
+
 func (db *LDBDatabase) Close() {
 	// Stop the metrics collection to avoid internal database races
 	db.quitLock.Lock()
@@ -159,6 +196,11 @@ func (db *LDBDatabase) Close() {
 			db.log.Error("Metrics collection failed", "err", err)
 		}
 	}
+	if db.freezer != nil {
+		if err := db.freezer.Close(); err != nil {
+			db.log.Error("Failed to close ancient database", "err", err)
+		}
+	}
 	err := db.db.Close()
 	if err == nil {
 		db.log.Info("Database closed")
@@ -167,6 +209,15 @@ func (db *LDBDatabase) Close() {
 	}
 }
 
+// Stat returns a particular internal stat of the database. The property may be
+// given with or without the "leveldb." prefix.
+func (db *LDBDatabase) Stat(property string) (string, error) {
+	if !strings.HasPrefix(property, "leveldb.") {
+		property = "leveldb." + property
+	}
+	return db.db.GetProperty(property)
+}
+
 func (db *LDBDatabase) LDB() *leveldb.DB {
 	return db.db
 }
@@ -289,6 +340,12 @@ func (b *ldbBatch) Put(key, value []byte) error {
 	return nil
 }
 
+func (b *ldbBatch) Delete(key []byte) error {
+	b.b.Delete(key)
+	b.size += 1
+	return nil
+}
+
 func (b *ldbBatch) Write() error {
 	return b.db.Write(b.b, nil)
 }
@@ -336,6 +393,86 @@ func (dt *table) Close() {
 	// Do nothing; don't close the underlying DB.
 }
 
+// NewIteratorWithStart creates a binary-alphabetical iterator over a subset of
+// the table content starting at a particular initial key (or after, if it does
+// not exist). The returned keys are stripped of the table prefix.
+func (dt *table) NewIteratorWithStart(start []byte) Iterator {
+	it := dt.db.NewIteratorWithStart(append([]byte(dt.prefix), start...))
+	return &tableIterator{it: it, prefix: dt.prefix}
+}
+
+// NewIteratorWithPrefix creates a binary-alphabetical iterator over a subset
+// of the table content with a particular key prefix. The returned keys are
+// stripped of the table prefix.
+func (dt *table) NewIteratorWithPrefix(prefix []byte) Iterator {
+	it := dt.db.NewIteratorWithPrefix(append([]byte(dt.prefix), prefix...))
+	return &tableIterator{it: it, prefix: dt.prefix}
+}
+
+// Compact flattens the underlying data store for the given key range of the
+// table. A nil start or limit is treated as the beginning or the end of the
+// table's key space.
+func (dt *table) Compact(start []byte, limit []byte) error {
+	start = append([]byte(dt.prefix), start...)
+	if limit == nil {
+		limit = util.BytesPrefix([]byte(dt.prefix)).Limit
+	} else {
+		limit = append([]byte(dt.prefix), limit...)
+	}
+	return dt.db.Compact(start, limit)
+}
+
+// tableIterator is a wrapper around a database iterator that stops at the end
+// of the table and strips the table prefix from the returned keys.
+type tableIterator struct {
+	it     Iterator
+	prefix string
+	done   bool
+}
+
+// Next moves the iterator to the next key/value pair of the table.
+func (it *tableIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	if !it.it.Next() || !bytes.HasPrefix(it.it.Key(), []byte(it.prefix)) {
+		it.done = true
+		return false
+	}
+	return true
+}
+
+// Error returns any accumulated error of the underlying iterator.
+func (it *tableIterator) Error() error {
+	return it.it.Error()
+}
+
+// Key returns the key of the current key/value pair without the table prefix,
+// or nil if done.
+func (it *tableIterator) Key() []byte {
+	if it.done {
+		return nil
+	}
+	key := it.it.Key()
+	if key == nil {
+		return nil
+	}
+	return key[len(it.prefix):]
+}
+
+// Value returns the value of the current key/value pair, or nil if done.
+func (it *tableIterator) Value() []byte {
+	if it.done {
+		return nil
+	}
+	return it.it.Value()
+}
+
+// Release releases the resources of the underlying iterator.
+func (it *tableIterator) Release() {
+	it.it.Release()
+}
+
 type tableBatch struct {
 	batch  Batch
 	prefix string
@@ -354,6 +491,10 @@ func (tb *tableBatch) Put(key, value []byte) error {
 	return tb.batch.Put(append([]byte(tb.prefix), key...), value)
 }
 
+func (tb *tableBatch) Delete(key []byte) error {
+	return tb.batch.Delete(append([]byte(tb.prefix), key...))
+}
+
 func (tb *tableBatch) Write() error {
 	return tb.batch.Write()
 }
@@ -44,6 +44,22 @@ func newTestLDB() (*haadb.LDBDatabase, func()) {
 	}
 }
 
+func newTestLogDB() (*haadb.LogDatabase, func()) {
+	dirname, err := ioutil.TempDir(os.TempDir(), "haadb_test_")
+	if err != nil {
+		panic("failed to create test file: " + err.Error())
+	}
+	db, err := haadb.NewLogDatabase(dirname)
+	if err != nil {
+		panic("failed to create test database: " + err.Error())
+	}
+
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dirname)
+	}
+}
+
 var test_values = []string{"", "a", "1251", "\x00123\x00"}
 
 func TestLDB_PutGet(t *testing.T) {
@@ -52,6 +68,12 @@ func TestLDB_PutGet(t *testing.T) {
 	testPutGet(db, t)
 }
 
+func TestLogDB_PutGet(t *testing.T) {
+	db, remove := newTestLogDB()
+	defer remove()
+	testPutGet(db, t)
+}
+
 func TestMemoryDB_PutGet(t *testing.T) {
 	db, _ := haadb.NewMemDatabase()
 	testPutGet(db, t)
@@ -130,6 +152,12 @@ func TestLDB_ParallelPutGet(t *testing.T) {
 	testParallelPutGet(db, t)
 }
 
+func TestLogDB_ParallelPutGet(t *testing.T) {
+	db, remove := newTestLogDB()
+	defer remove()
+	testParallelPutGet(db, t)
+}
+
 func TestMemoryDB_ParallelPutGet(t *testing.T) {
 	db, _ := haadb.NewMemDatabase()
 	testParallelPutGet(db, t)
@@ -190,3 +218,69 @@ func testParallelPutGet(db haadb.Database, t *testing.T) {
 	}
 	pending.Wait()
 }
+
+func TestLDB_Iterators(t *testing.T) {
+	db, remove := newTestLDB()
+	defer remove()
+	testIterators(db, t)
+}
+
+func TestLogDB_Iterators(t *testing.T) {
+	db, remove := newTestLogDB()
+	defer remove()
+	testIterators(db, t)
+}
+
+func TestMemoryDB_Iterators(t *testing.T) {
+	db, _ := haadb.NewMemDatabase()
+	testIterators(db, t)
+}
+
+func TestTable_Iterators(t *testing.T) {
+	db, _ := haadb.NewMemDatabase()
+
+	// Entries outside of the table must never be visible through it
+	db.Put([]byte("a"), []byte("outside"))
+	db.Put([]byte("u"), []byte("outside"))
+
+	testIterators(haadb.NewTable(db, "t"), t)
+}
+
+func testIterators(db haadb.Database, t *testing.T) {
+	keys := []string{"1", "2", "3", "10", "22", "5"}
+	for _, k := range keys {
+		if err := db.Put([]byte(k), []byte("v"+k)); err != nil {
+			t.Fatalf("put failed: %v", err)
+		}
+	}
+	tests := []struct {
+		it   haadb.Iterator
+		want []string
+	}{
+		{db.NewIteratorWithStart(nil), []string{"1", "10", "2", "22", "3", "5"}},
+		{db.NewIteratorWithStart([]byte("2")), []string{"2", "22", "3", "5"}},
+		{db.NewIteratorWithStart([]byte("4")), []string{"5"}},
+		{db.NewIteratorWithPrefix([]byte("2")), []string{"2", "22"}},
+		{db.NewIteratorWithPrefix([]byte("4")), nil},
+	}
+	for i, tt := range tests {
+		var have []string
+		for tt.it.Next() {
+			have = append(have, string(tt.it.Key()))
+			if value := string(tt.it.Value()); value != "v"+string(tt.it.Key()) {
+				t.Errorf("test %d: value mismatch for key %q: have %q", i, tt.it.Key(), value)
+			}
+		}
+		if err := tt.it.Error(); err != nil {
+			t.Errorf("test %d: iteration failed: %v", i, err)
+		}
+		tt.it.Release()
+
+		if fmt.Sprint(have) != fmt.Sprint(tt.want) {
+			t.Errorf("test %d: keys mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+	if err := db.Compact(nil, nil); err != nil {
+		t.Fatalf("compaction failed: %v", err)
+	}
+}
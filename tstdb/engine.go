@@ -0,0 +1,99 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package haadb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Persistent storage engines supported by NewDatabase.
+const (
+	// EngineLevelDB is the LevelDB based storage engine, the default one.
+	EngineLevelDB = "leveldb"
+
+	// EngineLogDB is the log-structured storage engine with an in-memory index.
+	EngineLogDB = "logdb"
+)
+
+// NewDatabase opens (or creates) a persistent database in the given directory,
+// backed by the requested storage engine. An empty engine selects the one of an
+// already existing database, or LevelDB for a new one. The cache and handles
+// allowances are only used by the LevelDB engine.
+func NewDatabase(engine string, file string, cache int, handles int) (Database, error) {
+	return NewDatabaseWithFreezer(engine, file, cache, handles, "")
+}
+
+// NewDatabaseWithFreezer opens (or creates) a persistent database the same way
+// as NewDatabase, additionally backing it by an append-only freezer in the given
+// directory to store ancient chain data. An empty freezer path disables it.
+func NewDatabaseWithFreezer(engine string, file string, cache int, handles int, freezer string) (Database, error) {
+	engine, err := resolveEngine(engine, file)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		db       Database
+		ancients *ancientStore
+	)
+	switch engine {
+	case EngineLevelDB:
+		ldb, err := NewLDBDatabase(file, cache, handles)
+		if err != nil {
+			return nil, err
+		}
+		db, ancients = ldb, &ldb.ancientStore
+
+	case EngineLogDB:
+		lgdb, err := NewLogDatabase(file)
+		if err != nil {
+			return nil, err
+		}
+		db, ancients = lgdb, &lgdb.ancientStore
+	}
+	if freezer != "" {
+		if ancients.freezer, err = NewFreezer(freezer); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// resolveEngine checks the requested storage engine against the one used by an
+// existing database in the given directory, returning the engine to open it with.
+func resolveEngine(engine string, file string) (string, error) {
+	if engine != "" && engine != EngineLevelDB && engine != EngineLogDB {
+		return "", fmt.Errorf("unknown database engine %q", engine)
+	}
+	var existing string
+	if _, err := os.Stat(filepath.Join(file, "CURRENT")); err == nil {
+		existing = EngineLevelDB
+	} else if _, err := os.Stat(filepath.Join(file, logdbDataFile)); err == nil {
+		existing = EngineLogDB
+	}
+	switch {
+	case engine == "" && existing == "":
+		return EngineLevelDB, nil
+	case engine == "":
+		return existing, nil
+	case existing != "" && existing != engine:
+		return "", fmt.Errorf("database %s uses the %s engine, cannot open with %s", file, existing, engine)
+	}
+	return engine, nil
+}
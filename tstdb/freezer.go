@@ -0,0 +1,304 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package haadb
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/haachain/go-haachain/log"
+)
+
+// Freezer table names, one for each kind of ancient chain data.
+const (
+	// FreezerHeaderTable indicates the name of the freezer header table.
+	FreezerHeaderTable = "headers"
+
+	// FreezerHashTable indicates the name of the freezer canonical hash table.
+	FreezerHashTable = "hashes"
+
+	// FreezerBodiesTable indicates the name of the freezer block body table.
+	FreezerBodiesTable = "bodies"
+
+	// FreezerReceiptTable indicates the name of the freezer receipts table.
+	FreezerReceiptTable = "receipts"
+
+	// FreezerDifficultyTable indicates the name of the freezer total difficulty table.
+	FreezerDifficultyTable = "diffs"
+)
+
+// freezerTableSize defines the maximum size of a single freezer data file, above
+// which a new file is started.
+const freezerTableSize = 2 * 1000 * 1000 * 1000
+
+// freezerTables is the list of all the tables maintained by the freezer.
+var freezerTables = []string{FreezerHeaderTable, FreezerHashTable, FreezerBodiesTable, FreezerReceiptTable, FreezerDifficultyTable}
+
+var (
+	// errUnknownTable is returned if the user attempts to read from a table that
+	// is not tracked by the freezer.
+	errUnknownTable = errors.New("unknown table")
+
+	// errNoFreezer is returned when accessing ancient chain data of a database
+	// not backed by a freezer.
+	errNoFreezer = errors.New("ancient store not enabled")
+)
+
+// Freezer is an append-only database to store immutable chain data into flat
+// files. The append only nature ensures that disk writes are minimized, and the
+// flat files allow for random access to the items by their number, without any
+// key-value indexing or compaction overhead.
+//
+// Items are appended in lockstep to all the tables, one entry per block, so the
+// number of items in each table is always the number of frozen blocks.
+type Freezer struct {
+	frozen uint64 // Number of blocks already frozen (must be accessed atomically)
+
+	tables map[string]*freezerTable // Data tables for storing everything
+}
+
+// NewFreezer creates a chain freezer that moves ancient chain data into
+// append-only flat file containers.
+func NewFreezer(datadir string) (*Freezer, error) {
+	return newFreezer(datadir, freezerTableSize)
+}
+
+// newFreezer creates a chain freezer with the given maximum data file size.
+func newFreezer(datadir string, maxTableSize uint32) (*Freezer, error) {
+	freezer := &Freezer{
+		tables: make(map[string]*freezerTable),
+	}
+	for _, name := range freezerTables {
+		table, err := newTable(datadir, name, maxTableSize)
+		if err != nil {
+			freezer.Close()
+			return nil, err
+		}
+		freezer.tables[name] = table
+	}
+	if err := freezer.repair(); err != nil {
+		freezer.Close()
+		return nil, err
+	}
+	log.Info("Opened ancient database", "database", datadir, "frozen", freezer.frozen)
+	return freezer, nil
+}
+
+// HasAncients reports whether the freezer in the given directory holds any
+// ancient chain data, without opening or creating it.
+func HasAncients(datadir string) (bool, error) {
+	stat, err := os.Stat(filepath.Join(datadir, FreezerHashTable+".idx"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return stat.Size() >= indexEntrySize, nil
+}
+
+// repair truncates all data tables to the same length, dropping any items
+// appended only partially before an unclean shutdown.
+func (f *Freezer) repair() error {
+	min := uint64(math.MaxUint64)
+	for _, table := range f.tables {
+		if table.items < min {
+			min = table.items
+		}
+	}
+	for _, table := range f.tables {
+		if err := table.truncate(min); err != nil {
+			return err
+		}
+	}
+	atomic.StoreUint64(&f.frozen, min)
+	return nil
+}
+
+// Close terminates the chain freezer, closing all the data files.
+func (f *Freezer) Close() error {
+	var errs []error
+	for _, table := range f.tables {
+		if err := table.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// HasAncient returns an indicator whether the specified ancient data exists
+// in the freezer.
+func (f *Freezer) HasAncient(kind string, number uint64) (bool, error) {
+	if table := f.tables[kind]; table != nil {
+		return table.has(number), nil
+	}
+	return false, nil
+}
+
+// Ancient retrieves an ancient binary blob from the append-only immutable files.
+func (f *Freezer) Ancient(kind string, number uint64) ([]byte, error) {
+	if table := f.tables[kind]; table != nil {
+		return table.Retrieve(number)
+	}
+	return nil, errUnknownTable
+}
+
+// Ancients returns the length of the frozen items.
+func (f *Freezer) Ancients() (uint64, error) {
+	return atomic.LoadUint64(&f.frozen), nil
+}
+
+// AncientSize returns the disk size of the specified ancient table.
+func (f *Freezer) AncientSize(kind string) (uint64, error) {
+	if table := f.tables[kind]; table != nil {
+		return table.Size()
+	}
+	return 0, errUnknownTable
+}
+
+// AppendAncient injects all binary blobs belong to block at the end of the
+// append-only immutable table files.
+//
+// Notably, this function is lock free but kind of thread-safe. All out-of-order
+// injection will be rejected. But if two injections with same number happen at
+// the same time, we can get into the trouble.
+func (f *Freezer) AppendAncient(number uint64, hash, header, body, receipts, td []byte) (err error) {
+	// Rollback all inserted data if any insertion below failed to ensure
+	// the tables won't out of sync.
+	defer func() {
+		if err != nil {
+			rerr := f.repair()
+			if rerr != nil {
+				log.Crit("Failed to repair freezer", "err", rerr)
+			}
+			log.Info("Append ancient failed", "number", number, "err", err)
+		}
+	}()
+	blobs := map[string][]byte{
+		FreezerHashTable:       hash,
+		FreezerHeaderTable:     header,
+		FreezerBodiesTable:     body,
+		FreezerReceiptTable:    receipts,
+		FreezerDifficultyTable: td,
+	}
+	for _, name := range freezerTables {
+		if err := f.tables[name].Append(number, blobs[name]); err != nil {
+			return err
+		}
+	}
+	atomic.AddUint64(&f.frozen, 1) // Only modify atomically
+	return nil
+}
+
+// TruncateAncients discards any recent data above the provided threshold number.
+func (f *Freezer) TruncateAncients(items uint64) error {
+	if atomic.LoadUint64(&f.frozen) <= items {
+		return nil
+	}
+	for _, table := range f.tables {
+		if err := table.truncate(items); err != nil {
+			return err
+		}
+	}
+	atomic.StoreUint64(&f.frozen, items)
+	return nil
+}
+
+// Sync flushes all data tables to disk.
+func (f *Freezer) Sync() error {
+	var errs []error
+	for _, table := range f.tables {
+		if err := table.Sync(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// ancientStore is embedded into the persistent key-value databases to expose
+// an optionally attached freezer through the AncientStore interface.
+type ancientStore struct {
+	freezer *Freezer // Ancient chain data store (nil if disabled)
+}
+
+// HasAncient returns an indicator whether the specified ancient data exists
+// in the freezer.
+func (db *ancientStore) HasAncient(kind string, number uint64) (bool, error) {
+	if db.freezer == nil {
+		return false, errNoFreezer
+	}
+	return db.freezer.HasAncient(kind, number)
+}
+
+// Ancient retrieves an ancient binary blob from the freezer.
+func (db *ancientStore) Ancient(kind string, number uint64) ([]byte, error) {
+	if db.freezer == nil {
+		return nil, errNoFreezer
+	}
+	return db.freezer.Ancient(kind, number)
+}
+
+// Ancients returns the number of blocks frozen into the freezer.
+func (db *ancientStore) Ancients() (uint64, error) {
+	if db.freezer == nil {
+		return 0, errNoFreezer
+	}
+	return db.freezer.Ancients()
+}
+
+// AncientSize returns the disk size of the specified ancient table.
+func (db *ancientStore) AncientSize(kind string) (uint64, error) {
+	if db.freezer == nil {
+		return 0, errNoFreezer
+	}
+	return db.freezer.AncientSize(kind)
+}
+
+// AppendAncient injects all binary blobs belong to block at the end of the
+// freezer.
+func (db *ancientStore) AppendAncient(number uint64, hash, header, body, receipts, td []byte) error {
+	if db.freezer == nil {
+		return errNoFreezer
+	}
+	return db.freezer.AppendAncient(number, hash, header, body, receipts, td)
+}
+
+// TruncateAncients discards all but the first n ancient data from the freezer.
+func (db *ancientStore) TruncateAncients(n uint64) error {
+	if db.freezer == nil {
+		return errNoFreezer
+	}
+	return db.freezer.TruncateAncients(n)
+}
+
+// Sync flushes all the freezer data to disk.
+func (db *ancientStore) Sync() error {
+	if db.freezer == nil {
+		return errNoFreezer
+	}
+	return db.freezer.Sync()
+}
@@ -0,0 +1,376 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package haadb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	// errClosed is returned if an operation attempts to read from or write to the
+	// freezer table after it has already been closed.
+	errClosed = errors.New("closed")
+
+	// errOutOfBounds is returned if the item requested is not contained within the
+	// freezer table.
+	errOutOfBounds = errors.New("out of bounds")
+
+	// errOutOrderInsertion is returned if the user attempts to inject out-of-order
+	// binary blobs into the freezer.
+	errOutOrderInsertion = errors.New("the append operation is out-order")
+)
+
+// indexEntrySize is the size of a single freezer table index entry, holding the
+// number of the data file an item is stored in and its end offset within.
+const indexEntrySize = 8
+
+// indexEntry is the location of an item within the data files of a table. An
+// item starts at the end offset of the previous one, or at the beginning of the
+// file if the previous item lives in an earlier one.
+type indexEntry struct {
+	filenum uint32 // Number of the data file holding the item
+	offset  uint32 // End offset of the item within the data file
+}
+
+// unmarshal decodes a binary index entry.
+func (e *indexEntry) unmarshal(b []byte) {
+	e.filenum = binary.BigEndian.Uint32(b[:4])
+	e.offset = binary.BigEndian.Uint32(b[4:8])
+}
+
+// marshal encodes the index entry into its binary form.
+func (e *indexEntry) marshal() []byte {
+	b := make([]byte, indexEntrySize)
+	binary.BigEndian.PutUint32(b[:4], e.filenum)
+	binary.BigEndian.PutUint32(b[4:8], e.offset)
+	return b
+}
+
+// freezerTable represents a single chained data table within the freezer (e.g.
+// blocks). It consists of a sequence of data files, to which the items are
+// appended one after the other, and an index file, tracking the location of
+// each item. A new data file is started whenever the current one would grow
+// beyond the maximum file size. Items can only be appended and the table can
+// only be truncated from the end, which makes crash recovery a matter of
+// cutting off partial writes.
+type freezerTable struct {
+	items uint64 // Number of items stored in the table
+
+	path    string // Directory holding the table files
+	name    string // Name of the table, prefix of all its files
+	maxSize uint32 // Maximum size of a single data file
+
+	files     map[uint32]*os.File // Open data files of the table, by number
+	head      *os.File            // File descriptor of the data file being appended to
+	headID    uint32              // Number of the data file being appended to
+	headBytes uint32              // Size of the head data file, end offset of the last item
+	sealed    uint64              // Total size of the data files before the head
+
+	index *os.File // File descriptor for the indexEntry file of the table
+
+	lock sync.RWMutex // Mutex protecting the data file descriptors
+}
+
+// newTable opens a freezer table with the given name from the given directory,
+// creating it if it does not exist yet, and repairs any inconsistencies left
+// behind by an unclean shutdown. Data files are rolled over once they would
+// exceed maxSize bytes.
+func newTable(path string, name string, maxSize uint32) (*freezerTable, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	index, err := os.OpenFile(filepath.Join(path, name+".idx"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	tab := &freezerTable{
+		path:    path,
+		name:    name,
+		maxSize: maxSize,
+		files:   make(map[uint32]*os.File),
+		index:   index,
+	}
+	if err := tab.repair(); err != nil {
+		tab.Close()
+		return nil, err
+	}
+	return tab, nil
+}
+
+// fileName returns the name of the data file with the given number.
+func (t *freezerTable) fileName(num uint32) string {
+	return filepath.Join(t.path, fmt.Sprintf("%s.%04d.dat", t.name, num))
+}
+
+// openFile returns the data file with the given number, opening or creating it
+// if it's not open yet.
+func (t *freezerTable) openFile(num uint32) (*os.File, error) {
+	if f, ok := t.files[num]; ok {
+		return f, nil
+	}
+	f, err := os.OpenFile(t.fileName(num), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	t.files[num] = f
+	return f, nil
+}
+
+// repair cross checks the index and data files, truncating both to the last
+// item that was completely written to both of them.
+func (t *freezerTable) repair() error {
+	stat, err := t.index.Stat()
+	if err != nil {
+		return err
+	}
+	items := uint64(stat.Size()) / indexEntrySize
+
+	// Drop all the index entries pointing past the end of their data file
+	for ; items > 0; items-- {
+		entry, err := t.entry(items - 1)
+		if err != nil {
+			return err
+		}
+		stat, err := os.Stat(t.fileName(entry.filenum))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil && uint64(entry.offset) <= uint64(stat.Size()) {
+			break
+		}
+	}
+	return t.truncateTo(items)
+}
+
+// entry retrieves the location of the given item from the index file.
+func (t *freezerTable) entry(item uint64) (indexEntry, error) {
+	var (
+		blob  [indexEntrySize]byte
+		entry indexEntry
+	)
+	if _, err := t.index.ReadAt(blob[:], int64(item*indexEntrySize)); err != nil {
+		return entry, err
+	}
+	entry.unmarshal(blob[:])
+	return entry, nil
+}
+
+// truncateTo cuts the index and data files down to the given number of items,
+// deleting any data files past the one holding the last remaining item.
+func (t *freezerTable) truncateTo(items uint64) error {
+	var head indexEntry
+	if items > 0 {
+		var err error
+		if head, err = t.entry(items - 1); err != nil {
+			return err
+		}
+	}
+	if err := t.index.Truncate(int64(items * indexEntrySize)); err != nil {
+		return err
+	}
+	// Remove all the data files after the new head, stopping at the first gap
+	for num := head.filenum + 1; ; num++ {
+		if f, ok := t.files[num]; ok {
+			f.Close()
+			delete(t.files, num)
+		}
+		if err := os.Remove(t.fileName(num)); err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return err
+		}
+	}
+	data, err := t.openFile(head.filenum)
+	if err != nil {
+		return err
+	}
+	if err := data.Truncate(int64(head.offset)); err != nil {
+		return err
+	}
+	// Open all the older data files and sum up their sizes
+	var sealed uint64
+	for num := uint32(0); num < head.filenum; num++ {
+		f, err := t.openFile(num)
+		if err != nil {
+			return err
+		}
+		stat, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		sealed += uint64(stat.Size())
+	}
+	t.items, t.sealed = items, sealed
+	t.head, t.headID, t.headBytes = data, head.filenum, head.offset
+	return nil
+}
+
+// truncate discards any recent data above the provided threshold number.
+func (t *freezerTable) truncate(items uint64) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.index == nil {
+		return errClosed
+	}
+	if t.items <= items {
+		return nil
+	}
+	return t.truncateTo(items)
+}
+
+// Append injects a binary blob at the end of the freezer table. The item number
+// is a precautionary parameter to ensure data correctness, but the table will
+// reject already existing data.
+func (t *freezerTable) Append(item uint64, blob []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.index == nil {
+		return errClosed
+	}
+	if t.items != item {
+		return fmt.Errorf("%v: have %d, want %d", errOutOrderInsertion, item, t.items)
+	}
+	// Start a new data file if the item doesn't fit into the current one
+	if t.headBytes > 0 && uint64(t.headBytes)+uint64(len(blob)) > uint64(t.maxSize) {
+		if err := t.head.Sync(); err != nil {
+			return err
+		}
+		head, err := t.openFile(t.headID + 1)
+		if err != nil {
+			return err
+		}
+		t.sealed += uint64(t.headBytes)
+		t.head, t.headID, t.headBytes = head, t.headID+1, 0
+	}
+	// Write the data first and the index afterwards, so that an interrupted
+	// append is detected and cut off during the next repair
+	if _, err := t.head.WriteAt(blob, int64(t.headBytes)); err != nil {
+		return err
+	}
+	entry := indexEntry{filenum: t.headID, offset: t.headBytes + uint32(len(blob))}
+	if _, err := t.index.WriteAt(entry.marshal(), int64(t.items*indexEntrySize)); err != nil {
+		return err
+	}
+	t.items++
+	t.headBytes = entry.offset
+	return nil
+}
+
+// Retrieve looks up the data location of an item with the given number and
+// retrieves the raw binary blob from the data file holding it.
+func (t *freezerTable) Retrieve(item uint64) ([]byte, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.index == nil {
+		return nil, errClosed
+	}
+	if item >= t.items {
+		return nil, errOutOfBounds
+	}
+	var start indexEntry
+	if item > 0 {
+		var err error
+		if start, err = t.entry(item - 1); err != nil {
+			return nil, err
+		}
+	}
+	end, err := t.entry(item)
+	if err != nil {
+		return nil, err
+	}
+	// Items never span files, a new file means the item starts at its beginning
+	if start.filenum != end.filenum {
+		start.offset = 0
+	}
+	data, ok := t.files[end.filenum]
+	if !ok {
+		return nil, fmt.Errorf("missing data file %d", end.filenum)
+	}
+	blob := make([]byte, end.offset-start.offset)
+	if _, err := data.ReadAt(blob, int64(start.offset)); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// Size returns the total disk size of the table, the data and index files.
+func (t *freezerTable) Size() (uint64, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.index == nil {
+		return 0, errClosed
+	}
+	return t.sealed + uint64(t.headBytes) + t.items*indexEntrySize, nil
+}
+
+// has returns an indicator whether the specified number data exists in the
+// freezer table.
+func (t *freezerTable) has(number uint64) bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return number < t.items
+}
+
+// Sync pushes any pending data from memory out to disk. This is an expensive
+// operation, so use it with care.
+func (t *freezerTable) Sync() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.index == nil {
+		return errClosed
+	}
+	if err := t.head.Sync(); err != nil {
+		return err
+	}
+	return t.index.Sync()
+}
+
+// Close closes all opened files.
+func (t *freezerTable) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var errs []error
+	if t.index != nil {
+		if err := t.index.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, f := range t.files {
+		if err := f.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	t.index, t.head, t.files = nil, nil, nil
+
+	if errs != nil {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package haadb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests that items appended to the freezer can be retrieved, and that all of
+// them survive a reopening of the freezer.
+func TestFreezerAppendRetrieve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := NewFreezer(dir)
+	if err != nil {
+		t.Fatalf("failed to create freezer: %v", err)
+	}
+	for i := byte(0); i < 16; i++ {
+		blob := bytes.Repeat([]byte{i}, int(i))
+		if err := f.AppendAncient(uint64(i), blob, blob, blob, blob, blob); err != nil {
+			t.Fatalf("failed to append item %d: %v", i, err)
+		}
+	}
+	if err := f.AppendAncient(20, nil, nil, nil, nil, nil); err == nil {
+		t.Fatalf("out of order append succeeded")
+	}
+	f.Close()
+
+	if f, err = NewFreezer(dir); err != nil {
+		t.Fatalf("failed to reopen freezer: %v", err)
+	}
+	defer f.Close()
+
+	if frozen, _ := f.Ancients(); frozen != 16 {
+		t.Fatalf("frozen item count mismatch: have %d, want %d", frozen, 16)
+	}
+	for i := byte(0); i < 16; i++ {
+		for _, kind := range freezerTables {
+			blob, err := f.Ancient(kind, uint64(i))
+			if err != nil {
+				t.Fatalf("failed to retrieve %s item %d: %v", kind, i, err)
+			}
+			if want := bytes.Repeat([]byte{i}, int(i)); !bytes.Equal(blob, want) {
+				t.Fatalf("%s item %d mismatch: have %x, want %x", kind, i, blob, want)
+			}
+		}
+	}
+	if _, err := f.Ancient(FreezerHeaderTable, 16); err != errOutOfBounds {
+		t.Fatalf("out of bounds retrieval error mismatch: have %v, want %v", err, errOutOfBounds)
+	}
+	if err := f.TruncateAncients(10); err != nil {
+		t.Fatalf("failed to truncate freezer: %v", err)
+	}
+	if ok, _ := f.HasAncient(FreezerBodiesTable, 10); ok {
+		t.Fatalf("truncated item still present")
+	}
+	if err := f.AppendAncient(10, []byte{0xff}, nil, nil, nil, nil); err != nil {
+		t.Fatalf("failed to append after truncation: %v", err)
+	}
+	if blob, _ := f.Ancient(FreezerHashTable, 10); !bytes.Equal(blob, []byte{0xff}) {
+		t.Fatalf("item mismatch after truncation: have %x, want %x", blob, []byte{0xff})
+	}
+}
+
+// Tests that the tables of a freezer are repaired into a consistent state when
+// opened after an interrupted append.
+func TestFreezerRepair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	f, err := NewFreezer(dir)
+	if err != nil {
+		t.Fatalf("failed to create freezer: %v", err)
+	}
+	for i := uint64(0); i < 4; i++ {
+		if err := f.AppendAncient(i, []byte{1}, []byte{2}, []byte{3}, []byte{4}, []byte{5}); err != nil {
+			t.Fatalf("failed to append item %d: %v", i, err)
+		}
+	}
+	// Simulate a crash after the data of an item was appended to a single table
+	// and another one losing the tail of its last data item
+	f.tables[FreezerHeaderTable].Append(4, []byte{2})
+	f.Close()
+
+	if err := os.Truncate(filepath.Join(dir, FreezerBodiesTable+".0000.dat"), 3); err != nil {
+		t.Fatalf("failed to truncate data file: %v", err)
+	}
+	if f, err = NewFreezer(dir); err != nil {
+		t.Fatalf("failed to reopen freezer: %v", err)
+	}
+	defer f.Close()
+
+	if frozen, _ := f.Ancients(); frozen != 3 {
+		t.Fatalf("frozen item count mismatch: have %d, want %d", frozen, 3)
+	}
+	for _, kind := range freezerTables {
+		if ok, _ := f.HasAncient(kind, 3); ok {
+			t.Fatalf("%s: corrupted item still present", kind)
+		}
+	}
+}
+
+// Tests that the data files of a table are rolled over once they reach the
+// maximum size, and that items spread over multiple files can be retrieved and
+// truncated, also across a reopening of the freezer.
+func TestFreezerFileRollover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if frozen, err := HasAncients(dir); err != nil || frozen {
+		t.Fatalf("empty directory reported frozen data: %v, %v", frozen, err)
+	}
+	// Store 10 items of 10 bytes each in data files of at most 25 bytes
+	f, err := newFreezer(dir, 25)
+	if err != nil {
+		t.Fatalf("failed to create freezer: %v", err)
+	}
+	for i := byte(0); i < 10; i++ {
+		blob := bytes.Repeat([]byte{i}, 10)
+		if err := f.AppendAncient(uint64(i), blob, blob, blob, blob, blob); err != nil {
+			t.Fatalf("failed to append item %d: %v", i, err)
+		}
+	}
+	if size, _ := f.AncientSize(FreezerHeaderTable); size != 10*10+10*indexEntrySize {
+		t.Fatalf("table size mismatch: have %d, want %d", size, 10*10+10*indexEntrySize)
+	}
+	f.Close()
+
+	for i := 0; i < 5; i++ {
+		stat, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%s.%04d.dat", FreezerHeaderTable, i)))
+		if err != nil {
+			t.Fatalf("data file %d missing: %v", i, err)
+		}
+		if stat.Size() != 20 {
+			t.Fatalf("data file %d size mismatch: have %d, want %d", i, stat.Size(), 20)
+		}
+	}
+	if frozen, err := HasAncients(dir); err != nil || !frozen {
+		t.Fatalf("frozen data not reported: %v, %v", frozen, err)
+	}
+	if f, err = newFreezer(dir, 25); err != nil {
+		t.Fatalf("failed to reopen freezer: %v", err)
+	}
+	defer f.Close()
+
+	for i := byte(0); i < 10; i++ {
+		blob, err := f.Ancient(FreezerBodiesTable, uint64(i))
+		if err != nil {
+			t.Fatalf("failed to retrieve item %d: %v", i, err)
+		}
+		if want := bytes.Repeat([]byte{i}, 10); !bytes.Equal(blob, want) {
+			t.Fatalf("item %d mismatch: have %x, want %x", i, blob, want)
+		}
+	}
+	// Truncate into the middle of a file and ensure later ones are deleted
+	if err := f.TruncateAncients(3); err != nil {
+		t.Fatalf("failed to truncate freezer: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%s.%04d.dat", FreezerHeaderTable, 2))); !os.IsNotExist(err) {
+		t.Fatalf("truncated data file still present: %v", err)
+	}
+	if size, _ := f.AncientSize(FreezerHeaderTable); size != 3*10+3*indexEntrySize {
+		t.Fatalf("truncated table size mismatch: have %d, want %d", size, 3*10+3*indexEntrySize)
+	}
+	blob := bytes.Repeat([]byte{0xff}, 10)
+	if err := f.AppendAncient(3, blob, blob, blob, blob, blob); err != nil {
+		t.Fatalf("failed to append after truncation: %v", err)
+	}
+	if have, _ := f.Ancient(FreezerReceiptTable, 3); !bytes.Equal(have, blob) {
+		t.Fatalf("item mismatch after truncation: have %x, want %x", have, blob)
+	}
+	if have, _ := f.Ancient(FreezerReceiptTable, 2); !bytes.Equal(have, bytes.Repeat([]byte{2}, 10)) {
+		t.Fatalf("item mismatch before truncation point: have %x", have)
+	}
+}
@@ -0,0 +1,781 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package haadb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/haachain/go-haachain/common"
+	"github.com/haachain/go-haachain/log"
+	"github.com/haachain/go-haachain/metrics"
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/memdb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// logdbDataFile is the name of the append-only data log within the database
+	// directory. Its presence identifies a directory as a logdb database.
+	logdbDataFile = "DATA.log"
+
+	// logdbCompactFile is the name of the temporary data log being assembled
+	// during a compaction.
+	logdbCompactFile = "DATA.compact"
+
+	// logdbHeaderSize is the size of a record header: the CRC32 checksum and the
+	// length of the record payload.
+	logdbHeaderSize = 8
+
+	// logdbAutoCompact is the amount of stale data in the data log after which a
+	// background compaction is started, if it's also the majority of the log.
+	logdbAutoCompact = 256 * 1024 * 1024
+)
+
+// Operations encoded into the data log records.
+const (
+	logdbOpPut    byte = 0x01
+	logdbOpDelete byte = 0x02
+)
+
+// errCorruptRecord is returned if a data log record cannot be decoded.
+var errCorruptRecord = errors.New("corrupt data log record")
+
+// LogDatabase is an embedded, log-structured key-value store. All writes are
+// appended as checksummed records to a single data log, while an in-memory
+// sorted index maps every live key to the location of its value in the log.
+//
+// Reads need a single disk access and writes are purely sequential, but the
+// index of all the keys must fit into memory and is rebuilt from the data log
+// on startup. Overwritten and deleted values are reclaimed by compacting, which
+// rewrites the live records into a fresh data log.
+//
+// Note, the engine is not durable: similarly to LevelDB with its default write
+// options, individual writes are not synced to disk, only compactions and the
+// closing of the database are. A crash may lose the most recent writes, but the
+// data log is always recovered to a consistent prefix of the written records.
+type LogDatabase struct {
+	ancientStore // Ancient chain data store (disabled if no freezer is attached)
+
+	fn    string          // Directory name for reporting
+	store storage.Storage // File lock guarding the directory against concurrent use
+
+	gen         *logGeneration // Current data log and its index
+	size        int64          // Write offset at the end of the current data log
+	stale       int64          // Amount of data in the log made obsolete by newer writes
+	compactions int            // Number of compactions ran since the database was opened
+	closed      bool           // Flag whether the database was already closed
+	lock        sync.RWMutex   // Mutex protecting all the fields above
+
+	compacting  int32      // Flag whether a background compaction is running (atomic)
+	compactLock sync.Mutex // Mutex serializing compactions
+	compactHook func()     // Test hook invoked before the rewritten data log is swapped in
+
+	getTimer       metrics.Timer // Timer for measuring the database get request counts and latencies
+	putTimer       metrics.Timer // Timer for measuring the database put request counts and latencies
+	delTimer       metrics.Timer // Timer for measuring the database delete request counts and latencies
+	missMeter      metrics.Meter // Meter for measuring the missed database get requests
+	readMeter      metrics.Meter // Meter for measuring the database get request data usage
+	writeMeter     metrics.Meter // Meter for measuring the database put request data usage
+	compTimeMeter  metrics.Meter // Meter for measuring the total time spent in database compaction
+	compReadMeter  metrics.Meter // Meter for measuring the data read during compaction
+	compWriteMeter metrics.Meter // Meter for measuring the data written during compaction
+
+	log log.Logger // Contextual logger tracking the database path
+}
+
+// logGeneration is a data log file along with the index of the live values in
+// it. A generation is replaced as a whole by compaction, but is kept open until
+// all the iterators still walking it are released.
+type logGeneration struct {
+	file  *os.File  // Append-only data log
+	index *memdb.DB // Sorted index mapping keys to value locations in the log
+	refs  int32     // Number of live references (database and iterators)
+}
+
+// retain adds a new reference to the generation.
+func (g *logGeneration) retain() {
+	atomic.AddInt32(&g.refs, 1)
+}
+
+// release drops a reference to the generation, closing the data log when the
+// last one is gone.
+func (g *logGeneration) release() {
+	if atomic.AddInt32(&g.refs, -1) == 0 {
+		g.file.Close()
+	}
+}
+
+// read retrieves a value from the data log, given its location in the index.
+func (g *logGeneration) read(loc []byte) ([]byte, error) {
+	offset, length := binary.BigEndian.Uint64(loc), binary.BigEndian.Uint32(loc[8:])
+
+	value := make([]byte, length)
+	if _, err := g.file.ReadAt(value, int64(offset)); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// NewLogDatabase opens (or creates) a log-structured database in the given
+// directory, replaying the data log to rebuild the index. A torn record at the
+// end of the log, left behind by a crash, is discarded.
+func NewLogDatabase(file string) (*LogDatabase, error) {
+	logger := log.New("database", file)
+
+	store, err := storage.OpenFile(file, false)
+	if err != nil {
+		return nil, err
+	}
+	// Discard any leftovers of an interrupted compaction
+	os.Remove(filepath.Join(file, logdbCompactFile))
+
+	data, err := os.OpenFile(filepath.Join(file, logdbDataFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	db := &LogDatabase{
+		fn:    file,
+		store: store,
+		gen: &logGeneration{
+			file:  data,
+			index: memdb.New(comparer.DefaultComparer, 0),
+			refs:  1,
+		},
+		log: logger,
+	}
+	if err := db.replay(); err != nil {
+		data.Close()
+		store.Close()
+		return nil, err
+	}
+	logger.Info("Opened log-structured database", "keys", db.gen.index.Len(), "size", common.StorageSize(db.size), "stale", common.StorageSize(db.stale))
+	return db, nil
+}
+
+// replay reads through the entire data log and indexes all the records in it.
+// The log is truncated at the first record that is incomplete or corrupted.
+func (db *LogDatabase) replay() error {
+	stat, err := db.gen.file.Stat()
+	if err != nil {
+		return err
+	}
+	var (
+		total  = stat.Size()
+		offset int64
+		header = make([]byte, logdbHeaderSize)
+		reader = bufio.NewReaderSize(io.NewSectionReader(db.gen.file, 0, total), 1024*1024)
+	)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			break
+		}
+		checksum, length := binary.BigEndian.Uint32(header), int64(binary.BigEndian.Uint32(header[4:]))
+		if length == 0 || offset+logdbHeaderSize+length > total {
+			// Empty records are never written, they are unflushed zeroed pages
+			break
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(payload) != checksum || validateRecord(payload) != nil {
+			break
+		}
+		db.stale += indexRecord(db.gen.index, payload, offset+logdbHeaderSize)
+		offset += logdbHeaderSize + length
+	}
+	if offset < total {
+		db.log.Warn("Truncating corrupted data log tail", "offset", offset, "size", total)
+		if err := db.gen.file.Truncate(offset); err != nil {
+			return err
+		}
+	}
+	db.size = offset
+	return nil
+}
+
+// Path returns the path to the database directory.
+func (db *LogDatabase) Path() string {
+	return db.fn
+}
+
+// Put inserts the given value into the database.
+func (db *LogDatabase) Put(key []byte, value []byte) error {
+	// Measure the database put latency, if requested
+	if db.putTimer != nil {
+		defer db.putTimer.UpdateSince(time.Now())
+	}
+	if db.writeMeter != nil {
+		db.writeMeter.Mark(int64(len(value)))
+	}
+	return db.write(appendPutOp(nil, key, value))
+}
+
+// Has retrieves if a key is present in the database.
+func (db *LogDatabase) Has(key []byte) (bool, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if db.closed {
+		return false, errClosed
+	}
+	return db.gen.index.Contains(key), nil
+}
+
+// Get retrieves the given key if it's present in the database.
+func (db *LogDatabase) Get(key []byte) ([]byte, error) {
+	// Measure the database get latency, if requested
+	if db.getTimer != nil {
+		defer db.getTimer.UpdateSince(time.Now())
+	}
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if db.closed {
+		return nil, errClosed
+	}
+	// Retrieve the value location and increment the miss counter if not found
+	loc, err := db.gen.index.Get(key)
+	if err != nil {
+		if db.missMeter != nil {
+			db.missMeter.Mark(1)
+		}
+		return nil, errors.New("not found")
+	}
+	dat, err := db.gen.read(loc)
+	if err != nil {
+		return nil, err
+	}
+	// Otherwise update the actually retrieved amount of data
+	if db.readMeter != nil {
+		db.readMeter.Mark(int64(len(dat)))
+	}
+	return dat, nil
+}
+
+// Delete removes the key from the database.
+func (db *LogDatabase) Delete(key []byte) error {
+	// Measure the database delete latency, if requested
+	if db.delTimer != nil {
+		defer db.delTimer.UpdateSince(time.Now())
+	}
+	return db.write(appendDeleteOp(nil, key))
+}
+
+// write appends a record with the given payload to the data log and updates
+// the index with the operations contained within.
+func (db *LogDatabase) write(payload []byte) error {
+	record := make([]byte, logdbHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record, crc32.ChecksumIEEE(payload))
+	binary.BigEndian.PutUint32(record[4:], uint32(len(payload)))
+	copy(record[logdbHeaderSize:], payload)
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.closed {
+		return errClosed
+	}
+	if _, err := db.gen.file.WriteAt(record, db.size); err != nil {
+		return err
+	}
+	db.stale += indexRecord(db.gen.index, payload, db.size+logdbHeaderSize)
+	db.size += int64(len(record))
+
+	// If the data log is mostly garbage, reclaim it in the background
+	if db.stale > logdbAutoCompact && db.stale > db.size/2 {
+		if atomic.CompareAndSwapInt32(&db.compacting, 0, 1) {
+			go func() {
+				defer atomic.StoreInt32(&db.compacting, 0)
+				if err := db.Compact(nil, nil); err != nil && err != errClosed {
+					db.log.Error("Database compaction failed", "err", err)
+				}
+			}()
+		}
+	}
+	return nil
+}
+
+// NewIteratorWithStart creates a binary-alphabetical iterator over a subset of
+// database content starting at a particular initial key (or after, if it does
+// not exist).
+func (db *LogDatabase) NewIteratorWithStart(start []byte) Iterator {
+	return db.newIterator(&util.Range{Start: start})
+}
+
+// NewIteratorWithPrefix creates a binary-alphabetical iterator over a subset
+// of database content with a particular key prefix.
+func (db *LogDatabase) NewIteratorWithPrefix(prefix []byte) Iterator {
+	return db.newIterator(util.BytesPrefix(prefix))
+}
+
+// newIterator creates an iterator over the given key range of the index. The
+// iterator retains the current generation, so a concurrent compaction does not
+// invalidate it. Writes made during iteration may or may not be observed.
+func (db *LogDatabase) newIterator(slice *util.Range) Iterator {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if db.closed {
+		return &logIterator{err: errClosed}
+	}
+	db.gen.retain()
+	return &logIterator{
+		gen: db.gen,
+		it:  db.gen.index.NewIterator(slice),
+	}
+}
+
+// Compact rewrites the data log, discarding all the overwritten and deleted
+// values. Since records are stored in the order of insertion instead of by key,
+// the requested range is ignored and the entire database is compacted, unless
+// there is nothing to reclaim.
+//
+// The live values are rewritten from a snapshot of the index, so reads and writes
+// proceed meanwhile. The database is only locked to carry the writes made during
+// the rewrite over into the new data log and to swap it in.
+func (db *LogDatabase) Compact(start []byte, limit []byte) error {
+	db.compactLock.Lock()
+	defer db.compactLock.Unlock()
+
+	// Snapshot the index and retain the data log it references
+	db.lock.RLock()
+	if db.closed {
+		db.lock.RUnlock()
+		return errClosed
+	}
+	if db.stale == 0 {
+		db.lock.RUnlock()
+		return nil
+	}
+	var (
+		begin = time.Now()
+		src   = db.gen
+		end   = db.size
+		index = copyIndex(src.index)
+	)
+	src.retain()
+	db.lock.RUnlock()
+
+	defer src.release()
+
+	// Rewrite the live values of the snapshot into a new data log
+	path := filepath.Join(db.fn, logdbCompactFile)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gen, size, err := rewrite(file, src, index)
+	if err == nil && db.compactHook != nil {
+		db.compactHook()
+	}
+	// Carry over the writes made meanwhile and swap in the new data log
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if err == nil && db.closed {
+		err = errClosed
+	}
+	var stale int64
+	if err == nil {
+		size, stale, err = copyTail(src, end, db.size, gen, size)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(path, filepath.Join(db.fn, logdbDataFile))
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	// Swap in the new generation, the old one stays open for pending iterators
+	old, oldSize := db.gen, db.size
+	db.gen, db.size, db.stale = gen, size, stale
+	db.compactions++
+	old.release()
+
+	if db.compTimeMeter != nil {
+		db.compTimeMeter.Mark(int64(time.Since(begin)))
+	}
+	if db.compReadMeter != nil {
+		db.compReadMeter.Mark(oldSize)
+	}
+	if db.compWriteMeter != nil {
+		db.compWriteMeter.Mark(size)
+	}
+	db.log.Info("Compacted database", "before", common.StorageSize(oldSize), "after", common.StorageSize(size), "elapsed", common.PrettyDuration(time.Since(begin)))
+	return nil
+}
+
+// copyIndex creates a point in time copy of an index, which stays consistent
+// while the original is being modified.
+func copyIndex(index *memdb.DB) *memdb.DB {
+	cpy := memdb.New(comparer.DefaultComparer, index.Size())
+
+	it := index.NewIterator(nil)
+	defer it.Release()
+
+	for it.Next() {
+		cpy.Put(it.Key(), it.Value())
+	}
+	return cpy
+}
+
+// rewrite copies all the live values referenced by the index from the source
+// generation into the given file, batching them into records of roughly ideal
+// batch size, and returns the new generation along with its data log size.
+func rewrite(file *os.File, src *logGeneration, index *memdb.DB) (*logGeneration, int64, error) {
+	var (
+		gen = &logGeneration{
+			file:  file,
+			index: memdb.New(comparer.DefaultComparer, index.Size()),
+			refs:  1,
+		}
+		writer  = bufio.NewWriterSize(file, 1024*1024)
+		header  = make([]byte, logdbHeaderSize)
+		payload []byte
+		size    int64
+	)
+	flush := func() error {
+		binary.BigEndian.PutUint32(header, crc32.ChecksumIEEE(payload))
+		binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+		if _, err := writer.Write(header); err != nil {
+			return err
+		}
+		if _, err := writer.Write(payload); err != nil {
+			return err
+		}
+		indexRecord(gen.index, payload, size+logdbHeaderSize)
+		size += logdbHeaderSize + int64(len(payload))
+		payload = payload[:0]
+		return nil
+	}
+	it := index.NewIterator(nil)
+	defer it.Release()
+
+	for it.Next() {
+		value, err := src.read(it.Value())
+		if err != nil {
+			return nil, 0, err
+		}
+		payload = appendPutOp(payload, it.Key(), value)
+		if len(payload) >= IdealBatchSize {
+			if err := flush(); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	if len(payload) > 0 {
+		if err := flush(); err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return nil, 0, err
+	}
+	return gen, size, nil
+}
+
+// copyTail appends the records written into the source data log between the
+// given offsets to the new generation, returning its updated data log size and
+// the amount of stale data the records introduced.
+func copyTail(src *logGeneration, from, to int64, dst *logGeneration, size int64) (int64, int64, error) {
+	if from == to {
+		return size, 0, nil
+	}
+	tail := make([]byte, to-from)
+	if _, err := src.file.ReadAt(tail, from); err != nil {
+		return 0, 0, err
+	}
+	if _, err := dst.file.WriteAt(tail, size); err != nil {
+		return 0, 0, err
+	}
+	// The records were validated when written, only index them
+	var stale int64
+	for pos := int64(0); pos < int64(len(tail)); {
+		length := int64(binary.BigEndian.Uint32(tail[pos+4:]))
+		payload := tail[pos+logdbHeaderSize : pos+logdbHeaderSize+length]
+
+		stale += indexRecord(dst.index, payload, size+pos+logdbHeaderSize)
+		pos += logdbHeaderSize + length
+	}
+	return size + int64(len(tail)), stale, nil
+}
+
+// Stat returns a particular internal stat of the database. The supported ones
+// are "stats", "keys" and "size", optionally with a "logdb." prefix.
+func (db *LogDatabase) Stat(property string) (string, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if db.closed {
+		return "", errClosed
+	}
+	switch strings.TrimPrefix(property, "logdb.") {
+	case "stats":
+		return fmt.Sprintf("Keys: %d\nData log size: %v\nStale data: %v\nIndex size: %v\nCompactions: %d\n",
+			db.gen.index.Len(), common.StorageSize(db.size), common.StorageSize(db.stale),
+			common.StorageSize(db.gen.index.Size()), db.compactions), nil
+	case "keys":
+		return strconv.Itoa(db.gen.index.Len()), nil
+	case "size":
+		return strconv.FormatInt(db.size, 10), nil
+	}
+	return "", fmt.Errorf("unknown property: %s", property)
+}
+
+// Close flushes and closes the database, along with the attached freezer.
+func (db *LogDatabase) Close() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.closed {
+		return
+	}
+	db.closed = true
+
+	if db.freezer != nil {
+		if err := db.freezer.Close(); err != nil {
+			db.log.Error("Failed to close ancient database", "err", err)
+		}
+	}
+	err := db.gen.file.Sync()
+	db.gen.release()
+	db.store.Close()
+
+	if err == nil {
+		db.log.Info("Database closed")
+	} else {
+		db.log.Error("Failed to close database", "err", err)
+	}
+}
+
+// Meter configures the database metrics collectors. Unlike with LevelDB, all
+// the meters are updated inline, there is no background stats collection.
+func (db *LogDatabase) Meter(prefix string) {
+	// Short circuit metering if the metrics system is disabled
+	if !metrics.Enabled {
+		return
+	}
+	// Initialize all the metrics collector at the requested prefix
+	db.getTimer = metrics.NewRegisteredTimer(prefix+"user/gets", nil)
+	db.putTimer = metrics.NewRegisteredTimer(prefix+"user/puts", nil)
+	db.delTimer = metrics.NewRegisteredTimer(prefix+"user/dels", nil)
+	db.missMeter = metrics.NewRegisteredMeter(prefix+"user/misses", nil)
+	db.readMeter = metrics.NewRegisteredMeter(prefix+"user/reads", nil)
+	db.writeMeter = metrics.NewRegisteredMeter(prefix+"user/writes", nil)
+	db.compTimeMeter = metrics.NewRegisteredMeter(prefix+"compact/time", nil)
+	db.compReadMeter = metrics.NewRegisteredMeter(prefix+"compact/input", nil)
+	db.compWriteMeter = metrics.NewRegisteredMeter(prefix+"compact/output", nil)
+}
+
+// NewBatch creates a write-only batch that is appended to the data log as a
+// single atomic record.
+func (db *LogDatabase) NewBatch() Batch {
+	return &logBatch{db: db}
+}
+
+type logBatch struct {
+	db      *LogDatabase
+	payload []byte
+	size    int
+}
+
+func (b *logBatch) Put(key, value []byte) error {
+	b.payload = appendPutOp(b.payload, key, value)
+	b.size += len(value)
+	return nil
+}
+
+func (b *logBatch) Delete(key []byte) error {
+	b.payload = appendDeleteOp(b.payload, key)
+	b.size += 1
+	return nil
+}
+
+func (b *logBatch) Write() error {
+	if len(b.payload) == 0 {
+		return nil
+	}
+	return b.db.write(b.payload)
+}
+
+func (b *logBatch) ValueSize() int {
+	return b.size
+}
+
+func (b *logBatch) Reset() {
+	b.payload = b.payload[:0]
+	b.size = 0
+}
+
+// logIterator iterates over the index of a data log generation, loading the
+// values from disk as it goes.
+type logIterator struct {
+	gen   *logGeneration
+	it    iterator.Iterator
+	value []byte
+	err   error
+}
+
+// Next moves the iterator to the next key/value pair. It returns whether the
+// iterator is exhausted.
+func (it *logIterator) Next() bool {
+	if it.err != nil || it.it == nil || !it.it.Next() {
+		it.value = nil
+		return false
+	}
+	it.value, it.err = it.gen.read(it.it.Value())
+	return it.err == nil
+}
+
+// Error returns any accumulated error.
+func (it *logIterator) Error() error {
+	if it.err != nil {
+		return it.err
+	}
+	if it.it != nil {
+		return it.it.Error()
+	}
+	return nil
+}
+
+// Key returns the key of the current key/value pair, or nil if done.
+func (it *logIterator) Key() []byte {
+	if it.value == nil {
+		return nil
+	}
+	return it.it.Key()
+}
+
+// Value returns the value of the current key/value pair, or nil if done.
+func (it *logIterator) Value() []byte {
+	return it.value
+}
+
+// Release releases the index iterator and the data log generation.
+func (it *logIterator) Release() {
+	if it.gen != nil {
+		it.it.Release()
+		it.gen.release()
+		it.gen = nil
+	}
+	it.value = nil
+}
+
+// appendPutOp appends an encoded insertion to a record payload.
+func appendPutOp(payload []byte, key []byte, value []byte) []byte {
+	payload = append(payload, logdbOpPut)
+	payload = appendBytes(payload, key)
+	return appendBytes(payload, value)
+}
+
+// appendDeleteOp appends an encoded deletion to a record payload.
+func appendDeleteOp(payload []byte, key []byte) []byte {
+	payload = append(payload, logdbOpDelete)
+	return appendBytes(payload, key)
+}
+
+// appendBytes appends a length prefixed blob to a record payload.
+func appendBytes(payload []byte, blob []byte) []byte {
+	var size [binary.MaxVarintLen64]byte
+	payload = append(payload, size[:binary.PutUvarint(size[:], uint64(len(blob)))]...)
+	return append(payload, blob...)
+}
+
+// decodeRecord iterates over the operations encoded into a record payload,
+// invoking the callback with the key and, for insertions, the offset and length
+// of the value within the payload.
+func decodeRecord(payload []byte, onOp func(op byte, key []byte, offset int, length int)) error {
+	for pos := 0; pos < len(payload); {
+		op := payload[pos]
+		pos++
+
+		size, n := binary.Uvarint(payload[pos:])
+		if n <= 0 || uint64(len(payload)-pos-n) < size {
+			return errCorruptRecord
+		}
+		key := payload[pos+n : pos+n+int(size)]
+		pos += n + int(size)
+
+		switch op {
+		case logdbOpPut:
+			size, n := binary.Uvarint(payload[pos:])
+			if n <= 0 || uint64(len(payload)-pos-n) < size {
+				return errCorruptRecord
+			}
+			onOp(op, key, pos+n, int(size))
+			pos += n + int(size)
+
+		case logdbOpDelete:
+			onOp(op, key, 0, 0)
+
+		default:
+			return errCorruptRecord
+		}
+	}
+	return nil
+}
+
+// validateRecord checks that a record payload is well formed.
+func validateRecord(payload []byte) error {
+	return decodeRecord(payload, func(byte, []byte, int, int) {})
+}
+
+// indexRecord applies the operations of a well formed record payload, located
+// at the given data log offset, to an index. It returns the amount of data in
+// the log made obsolete by the record.
+func indexRecord(index *memdb.DB, payload []byte, base int64) int64 {
+	var stale int64
+
+	decodeRecord(payload, func(op byte, key []byte, offset int, length int) {
+		if loc, err := index.Get(key); err == nil {
+			stale += int64(len(key)) + int64(binary.BigEndian.Uint32(loc[8:]))
+		}
+		switch op {
+		case logdbOpPut:
+			loc := make([]byte, 12)
+			binary.BigEndian.PutUint64(loc, uint64(base)+uint64(offset))
+			binary.BigEndian.PutUint32(loc[8:], uint32(length))
+			index.Put(key, loc)
+
+		case logdbOpDelete:
+			// Tombstones are only needed until the next compaction
+			stale += int64(len(key)) + 1
+			index.Delete(key)
+		}
+	})
+	return stale
+}
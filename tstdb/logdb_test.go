@@ -0,0 +1,278 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-haaereum library.
+//
+// The go-haaereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-haaereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-haaereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package haadb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Tests that the content of a log database survives a reopening, and that a
+// torn record at the end of the data log is discarded.
+func TestLogDBReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewLogDatabase(dir)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	batch := db.NewBatch()
+	for i := 0; i < 100; i++ {
+		batch.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%d", i)))
+	}
+	for i := 0; i < 100; i += 2 {
+		batch.Delete([]byte(fmt.Sprintf("key-%03d", i)))
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatalf("failed to write batch: %v", err)
+	}
+	db.Put([]byte("key-001"), []byte("updated"))
+	db.Close()
+
+	// Simulate a crash in the middle of appending a record
+	file, err := os.OpenFile(filepath.Join(dir, logdbDataFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{0xde, 0xad, 0xbe, 0xef, 0x00, 0x00, 0x10})
+	file.Close()
+
+	if db, err = NewLogDatabase(dir); err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key-%03d", i))
+		want := []byte(fmt.Sprintf("value-%d", i))
+		if i == 1 {
+			want = []byte("updated")
+		}
+		value, err := db.Get(key)
+		switch {
+		case i%2 == 0 && err == nil:
+			t.Errorf("key %s: deleted item retrieved", key)
+		case i%2 == 1 && err != nil:
+			t.Errorf("key %s: failed to retrieve item: %v", key, err)
+		case i%2 == 1 && !bytes.Equal(value, want):
+			t.Errorf("key %s: value mismatch: have %q, want %q", key, value, want)
+		}
+	}
+	// The database must remain writable after the repair
+	if err := db.Put([]byte("key-002"), []byte("revived")); err != nil {
+		t.Fatalf("failed to write after repair: %v", err)
+	}
+	if value, _ := db.Get([]byte("key-002")); !bytes.Equal(value, []byte("revived")) {
+		t.Errorf("value mismatch after repair: have %q, want %q", value, "revived")
+	}
+}
+
+// Tests that compaction reclaims the stale data without losing live items, and
+// that iterators opened before the compaction remain usable.
+func TestLogDBCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewLogDatabase(dir)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 64; j++ {
+			db.Put([]byte{byte(j)}, bytes.Repeat([]byte{byte(i)}, 32))
+		}
+	}
+	for j := 0; j < 64; j += 4 {
+		db.Delete([]byte{byte(j)})
+	}
+	it := db.NewIteratorWithStart(nil)
+	defer it.Release()
+
+	before := db.size
+	if err := db.Compact(nil, nil); err != nil {
+		t.Fatalf("failed to compact database: %v", err)
+	}
+	if db.size >= before {
+		t.Errorf("data log not shrunk: before %d, after %d", before, db.size)
+	}
+	if db.stale != 0 {
+		t.Errorf("stale data after compaction: %d", db.stale)
+	}
+	check := func(db *LogDatabase) {
+		for j := 0; j < 64; j++ {
+			value, err := db.Get([]byte{byte(j)})
+			if j%4 == 0 {
+				if err == nil {
+					t.Errorf("item %d: deleted item retrieved", j)
+				}
+				continue
+			}
+			if !bytes.Equal(value, bytes.Repeat([]byte{2}, 32)) {
+				t.Errorf("item %d: value mismatch: have %x, err %v", j, value, err)
+			}
+		}
+	}
+	check(db)
+
+	// The iterator opened before the compaction must still see all live items
+	count := 0
+	for it.Next() {
+		if !bytes.Equal(it.Value(), bytes.Repeat([]byte{2}, 32)) {
+			t.Errorf("item %x: value mismatch: have %x", it.Key(), it.Value())
+		}
+		count++
+	}
+	if err := it.Error(); err != nil {
+		t.Fatalf("iteration failed: %v", err)
+	}
+	if count != 48 {
+		t.Errorf("iterated item count mismatch: have %d, want %d", count, 48)
+	}
+	// The compacted data log must be usable after a reopening too
+	db.Close()
+	if db, err = NewLogDatabase(dir); err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Close()
+	check(db)
+}
+
+// Tests that writes made while a compaction is rewriting the data log are not
+// blocked, and that they are carried over into the compacted data log.
+func TestLogDBCompactConcurrentWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := NewLogDatabase(dir)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 64; j++ {
+			db.Put([]byte{byte(j)}, bytes.Repeat([]byte{byte(i)}, 32))
+		}
+	}
+	// Overwrite, delete and insert items while the live values are rewritten
+	db.compactHook = func() {
+		done := make(chan error, 1)
+		go func() {
+			batch := db.NewBatch()
+			for j := 0; j < 64; j += 2 {
+				batch.Put([]byte{byte(j)}, bytes.Repeat([]byte{2}, 32))
+			}
+			for j := 1; j < 64; j += 4 {
+				batch.Delete([]byte{byte(j)})
+			}
+			batch.Put([]byte{64}, bytes.Repeat([]byte{2}, 32))
+			done <- batch.Write()
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("failed to write during compaction: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("write blocked by compaction")
+		}
+	}
+	if err := db.Compact(nil, nil); err != nil {
+		t.Fatalf("failed to compact database: %v", err)
+	}
+	db.compactHook = nil
+
+	if db.stale == 0 {
+		t.Errorf("stale data of the carried over writes not tracked")
+	}
+	check := func(db *LogDatabase) {
+		for j := 0; j <= 64; j++ {
+			value, err := db.Get([]byte{byte(j)})
+			switch {
+			case j%4 == 1:
+				if err == nil {
+					t.Errorf("item %d: deleted item retrieved", j)
+				}
+			case j%2 == 0:
+				if !bytes.Equal(value, bytes.Repeat([]byte{2}, 32)) {
+					t.Errorf("item %d: value mismatch: have %x, err %v", j, value, err)
+				}
+			default:
+				if !bytes.Equal(value, bytes.Repeat([]byte{1}, 32)) {
+					t.Errorf("item %d: value mismatch: have %x, err %v", j, value, err)
+				}
+			}
+		}
+	}
+	check(db)
+
+	// The carried over writes must survive a reopening too
+	db.Close()
+	if db, err = NewLogDatabase(dir); err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Close()
+	check(db)
+}
+
+// Tests that the storage engine of an existing database is detected, and that
+// opening it with a different engine is rejected.
+func TestNewDatabaseEngine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := NewDatabase("rocksdb", dir, 0, 0); err == nil {
+		t.Fatalf("unknown engine accepted")
+	}
+	db, err := NewDatabase(EngineLogDB, dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	db.Put([]byte("key"), []byte("value"))
+	db.Close()
+
+	if _, err := NewDatabase(EngineLevelDB, dir, 0, 0); err == nil {
+		t.Fatalf("engine mismatch accepted")
+	}
+	if db, err = NewDatabase("", dir, 0, 0); err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	if _, ok := db.(*LogDatabase); !ok {
+		t.Fatalf("engine mismatch: have %T, want %T", db, &LogDatabase{})
+	}
+	if value, _ := db.Get([]byte("key")); !bytes.Equal(value, []byte("value")) {
+		t.Errorf("value mismatch: have %q, want %q", value, "value")
+	}
+}